@@ -1,3 +1,3 @@
 module github.com/iulianclita/flysms
 
-go 1.12
+go 1.21
@@ -1,10 +1,20 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/iulianclita/flysms/sms"
@@ -13,24 +23,672 @@ import (
 const port = 3500
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "apply":
+			apply(os.Args[2:])
+			return
+		case "backup":
+			backup(os.Args[2:])
+			return
+		case "restore":
+			restore(os.Args[2:])
+			return
+		case "verify-audit":
+			verifyAudit(os.Args[2:])
+			return
+		}
+	}
+
+	// Log records as text or json, of the info level and above by default
+	level := slog.LevelInfo
+	if v := os.Getenv("FLYSMS_LOG_LEVEL"); v != "" {
+		if err := level.UnmarshalText([]byte(v)); err != nil {
+			log.Fatalf("Invalid log level %s", v)
+		}
+	}
+	handlerOpts := &slog.HandlerOptions{Level: level}
+	switch format := os.Getenv("FLYSMS_LOG_FORMAT"); format {
+	case "", "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, handlerOpts)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, handlerOpts)))
+	default:
+		log.Fatalf("Invalid log format %s", format)
+	}
+
 	fmt.Printf("Listening on port %d\n", port)
 
 	opts := sms.Options{
-		AccessKey: os.Getenv("MESSAGE_BIRD_ACCESSKEY"),
-		Timeout:   10 * time.Second,
+		Provider:          os.Getenv("FLYSMS_PROVIDER"),
+		AccessKey:         os.Getenv("MESSAGE_BIRD_ACCESSKEY"),
+		Timeout:           10 * time.Second,
+		DNSCacheTTL:       5 * time.Minute,
+		DialTimeout:       5 * time.Second,
+		WarmConnections:   2,
+		HeaderTimeout:     5 * time.Second,
+		BodyReadTimeout:   2 * time.Second,
+		WhatsAppChannelID: os.Getenv("MESSAGE_BIRD_WHATSAPP_CHANNEL"),
+		UserAgent:         os.Getenv("FLYSMS_USER_AGENT"),
+	}
+
+	// The extra headers of the provider requests, by provider name
+	var providerHeaders map[string]map[string]string
+	readJSONFile("FLYSMS_PROVIDER_HEADERS", &providerHeaders)
+	if opts.Provider == "" {
+		opts.Headers = providerHeaders[sms.ProviderMessageBird]
+	} else {
+		opts.Headers = providerHeaders[opts.Provider]
+	}
+
+	if os.Getenv("FLYSMS_DISABLE_HTTP2") == "true" {
+		opts.DisableHTTP2 = true
+	}
+
+	if path := os.Getenv("MESSAGE_BIRD_CA_FILE"); path != "" {
+		pool, err := sms.LoadCAFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.RootCAs = pool
+	}
+
+	if addr := os.Getenv("FLYSMS_EGRESS_ADDR"); addr != "" {
+		opts.LocalAddr = net.ParseIP(addr)
+		if opts.LocalAddr == nil {
+			log.Fatalf("Invalid egress address %s", addr)
+		}
+	}
+
+	if name := os.Getenv("FLYSMS_EGRESS_INTERFACE"); name != "" {
+		ip, err := sms.InterfaceAddr(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.LocalAddr = ip
+	}
+
+	if pins := os.Getenv("MESSAGE_BIRD_PINNED_KEYS"); pins != "" {
+		opts.PinnedKeys = strings.Split(pins, ",")
+	}
+
+	if opts.Provider == sms.ProviderTwilio {
+		opts.AccountSID = os.Getenv("TWILIO_ACCOUNT_SID")
+		opts.AccessKey = os.Getenv("TWILIO_AUTH_TOKEN")
+	}
+
+	if opts.Provider == sms.ProviderSMPP {
+		opts.BaseURL = os.Getenv("SMPP_ADDR")
+		opts.AccountSID = os.Getenv("SMPP_SYSTEM_ID")
+		opts.AccessKey = os.Getenv("SMPP_PASSWORD")
+	}
+
+	if opts.Provider == sms.ProviderSNS {
+		// The credentials come from the AWS environment
+		opts.AccessKey = ""
+		opts.Region = os.Getenv("AWS_REGION")
+	}
+
+	sender, err := sms.NewSender(opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if client, ok := sender.(*sms.Client); ok {
+		stop := client.KeepWarm(time.Minute)
+		defer stop()
 	}
 
 	cfg := sms.Config{
-		Buffer:        10,
-		ReqTimeout:    5 * time.Second,
-		ThrottleRate:  time.Second,
-		MessageClient: sms.NewClient(opts),
+		Buffer:         10,
+		ReqTimeout:     5 * time.Second,
+		ThrottleRate:   time.Second,
+		Region:         os.Getenv("FLYSMS_REGION"),
+		HedgeDelay:     500 * time.Millisecond,
+		NumberCacheTTL: time.Hour,
+		OTPLimits: []sms.RateLimit{
+			{Count: 1, Window: 30 * time.Second},
+			{Count: 5, Window: time.Hour},
+		},
+		Detector: sms.NewThresholdDetector(sms.ThresholdConfig{
+			PerRecipient: sms.RateLimit{Count: 20, Window: time.Hour},
+			PerContent:   sms.RateLimit{Count: 100, Window: time.Minute},
+		}),
+		AdminKey:       os.Getenv("FLYSMS_ADMIN_KEY"),
+		CursorKey:      os.Getenv("FLYSMS_CURSOR_KEY"),
+		LookupCacheTTL: 5 * time.Second,
+		MessageClient:  sender,
+	}
+
+	if os.Getenv("FLYSMS_DEBUG") != "" {
+		cfg.DebugCaptureTTL = 15 * time.Minute
+	}
+
+	cfg.EmailDomain = os.Getenv("FLYSMS_EMAIL_DOMAIN")
+	cfg.EmailOriginator = os.Getenv("FLYSMS_EMAIL_ORIGINATOR")
+
+	if parts := os.Getenv("FLYSMS_MAX_MESSAGE_PARTS"); parts != "" {
+		n, err := strconv.Atoi(parts)
+		if err != nil {
+			log.Fatalf("Invalid maximum of message parts %s", parts)
+		}
+		cfg.MaxMessageParts = n
+	}
+
+	// Kannel sendsms accounts, as comma separated username:password pairs
+	if users := os.Getenv("FLYSMS_SENDSMS_USERS"); users != "" {
+		cfg.SendSMSAccounts = make(map[string]string)
+		for _, user := range strings.Split(users, ",") {
+			parts := strings.SplitN(user, ":", 2)
+			if len(parts) != 2 {
+				log.Fatalf("Invalid sendsms account %q", user)
+			}
+			cfg.SendSMSAccounts[parts[0]] = parts[1]
+		}
+	}
+
+	// The provider error codes reporting a retired API, by provider name
+	readJSONFile("FLYSMS_DEPRECATED_CODES", &cfg.DeprecatedCodes)
+
+	// Alert routes of the syslog listener and of the Alertmanager webhook,
+	// and the recipient groups and team rotations they send to, as JSON files
+	readJSONFile("FLYSMS_ALERT_ROUTES", &cfg.AlertRoutes)
+	readJSONFile("FLYSMS_ALERTMANAGER_ROUTES", &cfg.AlertmanagerRoutes)
+	readJSONFile("FLYSMS_RECIPIENT_GROUPS", &cfg.RecipientGroups)
+	readJSONFile("FLYSMS_ROTATIONS", &cfg.Rotations)
+	cfg.AlertmanagerToken = os.Getenv("FLYSMS_ALERTMANAGER_TOKEN")
+
+	// Escalation policies of the alert routes, as a JSON file, and the
+	// public URL of the ack links of their messages
+	readJSONFile("FLYSMS_ESCALATION_POLICIES", &cfg.EscalationPolicies)
+	cfg.AckURL = os.Getenv("FLYSMS_ACK_URL")
+
+	// Secret the backups of /admin/backup are encrypted with
+	cfg.BackupKey = os.Getenv("FLYSMS_BACKUP_KEY")
+
+	// Redis server keeping the queued requests across restarts, with
+	// a queue name of its own for every replica sharing the server
+	if addr := os.Getenv("FLYSMS_REDIS_URL"); addr != "" {
+		store, err := sms.NewRedisQueueStore(addr, os.Getenv("FLYSMS_QUEUE_NAME"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg.QueueStore = store
+	}
+
+	// File keeping the queued requests of single node deployments
+	if path := os.Getenv("FLYSMS_QUEUE_FILE"); path != "" {
+		store, err := sms.OpenFileQueueStore(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg.QueueStore = store
+	}
+
+	// Originator rules keyed by country prefix, such as
+	// {"1": {"originator": "+14155550100"}, "1876": {}}
+	readJSONFile("FLYSMS_ORIGINATOR_RULES", &cfg.OriginatorRules)
+
+	// Payload templates of the status callbacks by URL prefix, such as
+	// {"https://hooks.slack.com/": "{\"text\": {{printf \"%s is %s\" .ID .Status | json}}}"}
+	readJSONFile("FLYSMS_CALLBACK_TEMPLATES", &cfg.CallbackTemplates)
+
+	// Internal hosts the callback_url of the messages may point to
+	if hosts := os.Getenv("FLYSMS_CALLBACK_HOSTS"); hosts != "" {
+		cfg.CallbackHosts = strings.Split(hosts, ",")
+	}
+
+	// Slack and Teams webhooks told about operational events, such as
+	// [{"kind": "slack", "url": "https://hooks.slack.com/services/..."}],
+	// and the balance below which they are warned
+	readJSONFile("FLYSMS_NOTIFICATION_SINKS", &cfg.NotificationSinks)
+	if v := os.Getenv("FLYSMS_LOW_BALANCE"); v != "" {
+		amount, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			log.Fatalf("Invalid low balance %s", v)
+		}
+		cfg.LowBalance = amount
+	}
+
+	if os.Getenv("FLYSMS_FIPS") == "true" {
+		crypto, err := sms.NewFIPSCrypto()
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg.Crypto = crypto
+	}
+
+	if path := os.Getenv("FLYSMS_AUDIT_LOG"); path != "" {
+		auditLog, err := sms.OpenAuditLog(path, cfg.Crypto)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg.AuditLog = auditLog
+	}
+
+	// OTLP/HTTP traces endpoint of an OpenTelemetry collector, such as
+	// http://localhost:4318/v1/traces
+	if endpoint := os.Getenv("FLYSMS_OTLP_ENDPOINT"); endpoint != "" {
+		cfg.SpanExporter = sms.NewOTLPExporter(endpoint, cfg.ReqTimeout)
+	}
+
+	// HTTP requests processed at once, over all routes and by route family
+	// as comma separated family=limit pairs, such as messages=200,admin=5
+	if v := os.Getenv("FLYSMS_MAX_CONCURRENT_REQUESTS"); v != "" {
+		max, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("Invalid maximum of concurrent requests %s", v)
+		}
+		cfg.MaxConcurrentRequests = max
+	}
+	if families := os.Getenv("FLYSMS_ROUTE_CONCURRENCY"); families != "" {
+		cfg.RouteConcurrency = make(map[string]int)
+		for _, family := range strings.Split(families, ",") {
+			parts := strings.SplitN(family, "=", 2)
+			if len(parts) != 2 {
+				log.Fatalf("Invalid route concurrency %q", family)
+			}
+			max, err := strconv.Atoi(parts[1])
+			if err != nil {
+				log.Fatalf("Invalid route concurrency %q", family)
+			}
+			cfg.RouteConcurrency[parts[0]] = max
+		}
+	}
+
+	// Fractions of the queues kept for the tenants, by the common name of
+	// their client certificate, such as "otp-service=0.3"
+	if tenants := os.Getenv("FLYSMS_RESERVATIONS"); tenants != "" {
+		cfg.Reservations = make(map[string]float64)
+		for _, tenant := range strings.Split(tenants, ",") {
+			parts := strings.SplitN(tenant, "=", 2)
+			if len(parts) != 2 {
+				log.Fatalf("Invalid reservation %q", tenant)
+			}
+			fraction, err := strconv.ParseFloat(parts[1], 64)
+			if err != nil {
+				log.Fatalf("Invalid reservation %q", tenant)
+			}
+			cfg.Reservations[parts[0]] = fraction
+		}
+	}
+
+	// Client connections older than this are closed after their request
+	if v := os.Getenv("FLYSMS_MAX_CONN_LIFETIME"); v != "" {
+		lifetime, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("Invalid maximum connection lifetime %s", v)
+		}
+		cfg.MaxConnLifetime = lifetime
+	}
+
+	// Period after startup over which the send rate ramps up
+	if v := os.Getenv("FLYSMS_WARM_UP"); v != "" {
+		warmUp, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("Invalid warm-up period %s", v)
+		}
+		cfg.WarmUp = warmUp
+	}
+
+	if v := os.Getenv("FLYSMS_WORKERS"); v != "" {
+		workers, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("Invalid number of workers %s", v)
+		}
+		cfg.Workers = workers
+	}
+
+	if v := os.Getenv("FLYSMS_MARKETING_BUFFER"); v != "" {
+		buffer, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("Invalid marketing buffer %s", v)
+		}
+		rate, err := time.ParseDuration(os.Getenv("FLYSMS_MARKETING_THROTTLE_RATE"))
+		if err != nil {
+			log.Fatalf("Invalid marketing throttle rate %s", os.Getenv("FLYSMS_MARKETING_THROTTLE_RATE"))
+		}
+		cfg.MarketingQueue = sms.ClassQueue{Buffer: buffer, ThrottleRate: rate, Workers: cfg.Workers}
+		if v := os.Getenv("FLYSMS_MARKETING_MAX_QUEUE_AGE"); v != "" {
+			age, err := time.ParseDuration(v)
+			if err != nil {
+				log.Fatalf("Invalid marketing maximum queue age %s", v)
+			}
+			cfg.MarketingQueue.MaxQueueAge = age
+		}
+	}
+
+	// Limits are given as count/window, such as 3/1m,20/1h
+	if limits := os.Getenv("FLYSMS_RECIPIENT_LIMITS"); limits != "" {
+		for _, limit := range strings.Split(limits, ",") {
+			parts := strings.SplitN(limit, "/", 2)
+			if len(parts) != 2 {
+				log.Fatalf("Invalid recipient limit %s", limit)
+			}
+			count, err := strconv.Atoi(parts[0])
+			if err != nil {
+				log.Fatalf("Invalid recipient limit %s", limit)
+			}
+			window, err := time.ParseDuration(parts[1])
+			if err != nil {
+				log.Fatalf("Invalid recipient limit %s", limit)
+			}
+			cfg.RecipientLimits = append(cfg.RecipientLimits, sms.RateLimit{Count: count, Window: window})
+		}
+	}
+
+	if v := os.Getenv("FLYSMS_MAX_QUEUE_AGE"); v != "" {
+		age, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("Invalid maximum queue age %s", v)
+		}
+		cfg.MaxQueueAge = age
+	}
+
+	if periods := os.Getenv("FLYSMS_REPORTS"); periods != "" {
+		cfg.Reports = strings.Split(periods, ",")
+	}
+	if v := os.Getenv("FLYSMS_PRICE_PER_PART"); v != "" {
+		price, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			log.Fatalf("Invalid price per part %s", v)
+		}
+		cfg.PricePerPart = price
+	}
+
+	if key := os.Getenv("MESSAGE_BIRD_FALLBACK_ACCESSKEY"); key != "" {
+		cfg.FallbackClient = sms.NewClient(sms.Options{
+			AccessKey: key,
+			BaseURL:   os.Getenv("MESSAGE_BIRD_FALLBACK_BASEURL"),
+			Timeout:   10 * time.Second,
+			UserAgent: opts.UserAgent,
+			Headers:   providerHeaders[sms.ProviderMessageBird],
+		})
+	}
+
+	cfg.Addr = fmt.Sprintf(":%d", port)
+
+	// Keep-alive connections idle for longer are closed
+	cfg.IdleTimeout = 2 * time.Minute
+	if v := os.Getenv("FLYSMS_IDLE_TIMEOUT"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("Invalid idle timeout %s", v)
+		}
+		cfg.IdleTimeout = timeout
+	}
+
+	// HTTPS is served with a certificate, of TLS 1.2 and above unless
+	// FLYSMS_TLS_MIN_VERSION is set, with the FLYSMS_TLS_CIPHER_SUITES
+	// names of the Go crypto/tls package when set
+	cfg.TLSCertFile = os.Getenv("FLYSMS_TLS_CERT_FILE")
+	cfg.TLSKeyFile = os.Getenv("FLYSMS_TLS_KEY_FILE")
+	if v := os.Getenv("FLYSMS_TLS_MIN_VERSION"); v != "" {
+		version, err := sms.ParseTLSVersion(v)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg.TLSMinVersion = version
+	}
+	if v := os.Getenv("FLYSMS_TLS_CIPHER_SUITES"); v != "" {
+		suites, err := sms.ParseCipherSuites(v)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg.TLSCipherSuites = suites
+	}
+
+	// The callers must present a certificate of the client CAs, naming
+	// one of FLYSMS_CLIENT_NAMES when set
+	if path := os.Getenv("FLYSMS_CLIENT_CA_FILE"); path != "" {
+		if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+			log.Fatal("Client certificates require FLYSMS_TLS_CERT_FILE and FLYSMS_TLS_KEY_FILE")
+		}
+		pool, err := sms.LoadCAFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg.ClientCAs = pool
+		if v := os.Getenv("FLYSMS_CLIENT_NAMES"); v != "" {
+			cfg.ClientNames = strings.Split(v, ",")
+		}
 	}
 
 	srv := sms.NewServer(cfg)
 	srv.Run()
 
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", port), srv); err != nil {
-		log.Fatal("Failed to start server")
+	// The self-check report is served by /readyz?verbose=1, or printed
+	// as JSON before exiting with FLYSMS_FAIL_FAST=true
+	report := srv.SelfCheck(context.Background())
+	if !report.OK && os.Getenv("FLYSMS_FAIL_FAST") == "true" {
+		enc := json.NewEncoder(os.Stderr)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+		os.Exit(1)
+	}
+	if report.OK {
+		slog.Info("Self-check passed")
+	} else {
+		slog.Error(report.String())
+	}
+
+	if addr := os.Getenv("FLYSMS_SMTP_ADDR"); addr != "" {
+		go func() {
+			log.Fatal(srv.ListenAndServeSMTP(addr))
+		}()
+	}
+
+	// Such as udp://:514 or tcp://:601
+	if addr := os.Getenv("FLYSMS_SYSLOG_ADDR"); addr != "" {
+		parts := strings.SplitN(addr, "://", 2)
+		if len(parts) != 2 {
+			log.Fatalf("Invalid syslog address %s", addr)
+		}
+		go func() {
+			log.Fatal(srv.ListenAndServeSyslog(parts[0], parts[1]))
+		}()
+	}
+
+	// RabbitMQ queue of message requests, consumed along with the HTTP
+	// requests, or instead of them with FLYSMS_HTTP_DISABLED=true
+	if addr := os.Getenv("FLYSMS_AMQP_URL"); addr != "" {
+		queue := os.Getenv("FLYSMS_AMQP_QUEUE")
+		if queue == "" {
+			queue = "flysms"
+		}
+		go func() {
+			for {
+				slog.Error("AMQP consumer stopped", "queue", queue, "error", srv.ConsumeAMQP(addr, queue))
+				time.Sleep(5 * time.Second)
+			}
+		}()
+	}
+
+	// Private address of the pprof profiles, such as 127.0.0.1:6060
+	if addr := os.Getenv("FLYSMS_PPROF_ADDR"); addr != "" {
+		go func() {
+			log.Fatal(http.ListenAndServe(addr, sms.ProfilingHandler()))
+		}()
+	}
+
+	if os.Getenv("FLYSMS_HTTP_DISABLED") == "true" {
+		select {}
+	}
+
+	if err := srv.ListenAndServe(); err != nil {
+		log.Fatalf("Failed to start server; Error: %v", err)
+	}
+}
+
+// readJSONFile decodes the JSON file named by the environment variable
+// into v, when the variable is set
+func readJSONFile(env string, v interface{}) {
+	path := os.Getenv(env)
+	if path == "" {
+		return
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		log.Fatalf("Invalid %s file %s; Error: %v", env, path, err)
+	}
+}
+
+// apply syncs the resources of a file to a running server, as in
+// flysms apply -f resources.yaml [-dry-run]
+// The file holds sms.Resources as YAML, or as JSON when not named .yaml or .yml
+func apply(args []string) {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	file := fs.String("f", "", "file of the resources")
+	addr := serverFlag(fs)
+	dryRun := fs.Bool("dry-run", false, "only show the changes")
+	fs.Parse(args)
+
+	if *file == "" {
+		log.Fatal("Missing resources file; usage: flysms apply -f resources.yaml")
+	}
+	data, err := ioutil.ReadFile(*file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	resources, err := sms.ReadResources(*file, data)
+	if err != nil {
+		log.Fatalf("Invalid resources file %s; Error: %v", *file, err)
+	}
+
+	path := "/admin/apply"
+	if *dryRun {
+		path += "?dry_run=true"
+	}
+	var result sms.ResourceChangeList
+	adminRequest(*addr, http.MethodPut, path, resources, &result)
+
+	for _, c := range result.Data {
+		fmt.Printf("%s %s %s\n", c.Action, c.Kind, c.Name)
+	}
+	fmt.Printf("%d changes\n", len(result.Data))
+}
+
+// backup writes a backup of a running server to a file, as in
+// flysms backup -o backup.json
+func backup(args []string) {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	file := fs.String("o", "", "file of the backup")
+	addr := serverFlag(fs)
+	fs.Parse(args)
+
+	if *file == "" {
+		log.Fatal("Missing backup file; usage: flysms backup -o backup.json")
+	}
+
+	var b sms.Backup
+	adminRequest(*addr, http.MethodGet, "/admin/backup", nil, &b)
+
+	data, err := json.Marshal(b)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(*file, data, 0600); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Backup of %s written to %s\n", b.Created.Format(time.RFC3339), *file)
+}
+
+// restore restores a running server from a backup file, as in
+// flysms restore -f backup.json
+// The server verifies the backup before restoring anything
+func restore(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	file := fs.String("f", "", "file of the backup")
+	addr := serverFlag(fs)
+	fs.Parse(args)
+
+	if *file == "" {
+		log.Fatal("Missing backup file; usage: flysms restore -f backup.json")
+	}
+	data, err := ioutil.ReadFile(*file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var b sms.Backup
+	if err := json.Unmarshal(data, &b); err != nil {
+		log.Fatalf("Invalid backup file %s; Error: %v", *file, err)
+	}
+
+	var result sms.BackupSummary
+	adminRequest(*addr, http.MethodPost, "/admin/restore", b, &result)
+
+	fmt.Printf("Restored %d messages, %d rotations and %d features\n",
+		result.Data.Messages, result.Data.Rotations, result.Data.Features)
+}
+
+// verifyAudit checks the hash chain of an audit log file
+func verifyAudit(args []string) {
+	fs := flag.NewFlagSet("verify-audit", flag.ExitOnError)
+	file := fs.String("f", "", "file of the audit log")
+	fs.Parse(args)
+
+	if *file == "" {
+		log.Fatal("Missing audit log file; usage: flysms verify-audit -f audit.log")
+	}
+	f, err := os.Open(*file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	n, err := sms.VerifyAuditLog(f, nil)
+	if err != nil {
+		log.Fatalf("Audit log %s was tampered with after %d records; Error: %v", *file, n, err)
+	}
+
+	fmt.Printf("Verified %d records\n", n)
+}
+
+// serverFlag adds the flag of the URL of the server the command talks to
+func serverFlag(fs *flag.FlagSet) *string {
+	return fs.String("url", fmt.Sprintf("http://localhost:%d", port), "URL of the flysms server")
+}
+
+// adminRequest calls an admin endpoint of the server, with the admin key
+// of FLYSMS_ADMIN_KEY, and decodes its response into v
+func adminRequest(addr, method, path string, payload, v interface{}) {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			log.Fatal(err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	url := strings.TrimSuffix(addr, "/") + path
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Authorization", "AdminKey "+os.Getenv("FLYSMS_ADMIN_KEY"))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var failed sms.Response
+		json.Unmarshal(data, &failed)
+		log.Fatalf("Request to %s failed with status %d; Error: %s", url, resp.StatusCode, failed.Error)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		log.Fatalf("Invalid response of %s; Error: %v", url, err)
 	}
 }
@@ -0,0 +1,28 @@
+package sms
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+const adminHeaderName = "AdminKey"
+
+// adminOnly restricts the handler to callers presenting the admin key
+// Admin endpoints are disabled when no admin key is configured
+func (s *Server) adminOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		want := adminHeaderName + " " + s.adminKey
+		got := r.Header.Get("Authorization")
+
+		if s.adminKey == "" || subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+			res := Response{
+				statusCode: http.StatusUnauthorized,
+				Error:      "Request not allowed (incorrect admin key)",
+			}
+			sendResponse(w, res)
+			return
+		}
+
+		h(w, r)
+	}
+}
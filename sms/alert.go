@@ -0,0 +1,90 @@
+package sms
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"unicode/utf8"
+)
+
+// dispatchAlert escalates the text of an alert through the escalation policy
+// of the given name, or else sends it to the recipients, and returns how many
+// of the messages were sent and how many failed
+func (s *Server) dispatchAlert(ctx context.Context, remoteAddr string, recipients []PhoneNumber, escalation, originator, text string) (int, int) {
+	if escalation != "" {
+		return s.escalate(ctx, remoteAddr, escalation, originator, text)
+	}
+
+	sent, failed := s.sendAlert(ctx, remoteAddr, recipients, Request{Originator: originator, Message: text})
+	return len(sent), failed
+}
+
+// sendAlert sends the alert to the recipients, through the same validation
+// and queue as the messages of /messages, and returns the numbers it was
+// sent to and how many of the messages failed
+// Team recipients, such as team:payments, get the member on call
+func (s *Server) sendAlert(ctx context.Context, remoteAddr string, recipients []PhoneNumber, alert Request) ([]PhoneNumber, int) {
+	if alert.Channel != channelVoice {
+		alert.Message = s.fitAlert(alert.Message)
+	}
+
+	var sent []PhoneNumber
+	failed := 0
+	for _, recipient := range recipients {
+		number, ok := s.resolveRecipient(recipient)
+		if !ok {
+			metrics.Add("alerts_failed", 1)
+			slog.Error("Could not send alert", "recipient", recipient, "error", "no rotation for the team")
+			failed++
+			continue
+		}
+
+		req := alert
+		req.Recipient = number
+
+		res, _ := s.relay(ctx, remoteAddr, nil, req)
+		if !res.Success {
+			metrics.Add("alerts_failed", 1)
+			slog.Error("Could not send alert", "recipient", recipient, "error", res.Error)
+			failed++
+			continue
+		}
+		metrics.Add("alerts_sent", 1)
+		sent = append(sent, number)
+	}
+
+	return sent, failed
+}
+
+// fitAlert cuts the text of an alert to what a message can carry,
+// all of its parts when messages can be split
+func (s *Server) fitAlert(text string) string {
+	req := Request{Channel: channelSMS, Message: text}
+	if length, limit := messageLength(&req); length <= limit {
+		return text
+	}
+
+	n := 1
+	if s.splittable(&req) {
+		n = s.maxParts
+	}
+
+	parts := splitMessage(text)
+	if len(parts) <= n {
+		return text
+	}
+
+	return strings.Join(parts[:n], "")
+}
+
+// fitAlertWith cuts the text of an alert so that the suffix,
+// such as the instructions to acknowledge it, is never cut
+func (s *Server) fitAlertWith(text, suffix string) string {
+	text = s.fitAlert(text)
+	for text != "" && s.fitAlert(text+suffix) != text+suffix {
+		_, size := utf8.DecodeLastRuneInString(text)
+		text = text[:len(text)-size]
+	}
+
+	return text + suffix
+}
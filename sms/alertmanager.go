@@ -0,0 +1,231 @@
+package sms
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultAlertmanagerTemplate = "[{{.Status}}] {{.Labels.alertname}}{{with .Labels.severity}} ({{.}}){{end}}{{with .Annotations.summary}}: {{.}}{{end}}"
+	maxAlertmanagerBytes        = 1 << 20
+)
+
+// AlertmanagerPayload is the body of the Prometheus Alertmanager webhook
+// notifications, with the alerts of a group
+type AlertmanagerPayload struct {
+	Version           string              `json:"version"`
+	GroupKey          string              `json:"groupKey"`
+	Status            string              `json:"status"`
+	Receiver          string              `json:"receiver"`
+	GroupLabels       map[string]string   `json:"groupLabels"`
+	CommonLabels      map[string]string   `json:"commonLabels"`
+	CommonAnnotations map[string]string   `json:"commonAnnotations"`
+	ExternalURL       string              `json:"externalURL"`
+	Alerts            []AlertmanagerAlert `json:"alerts"`
+}
+
+// AlertmanagerAlert is an alert of an Alertmanager notification,
+// and the data of the Alertmanager route templates
+type AlertmanagerAlert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+// AlertmanagerRoute sends the Alertmanager alerts it matches to its
+// recipients and to the members of its recipient groups, and escalates
+// the firing ones through the escalation policy of the given name
+// An alert matches when it has every one of the labels, such as
+// {"severity": "critical"}; a route without labels matches any alert
+// Template is a text/template executed with the AlertmanagerAlert
+// to build the message
+type AlertmanagerRoute struct {
+	Labels     map[string]string `json:"labels"`
+	Recipients []PhoneNumber     `json:"recipients"`
+	Groups     []string          `json:"groups"`
+	Escalation string            `json:"escalation,omitempty"`
+	Originator string            `json:"originator"`
+	Template   string            `json:"template"`
+}
+
+// alertmanagerRoute is an Alertmanager route ready to match alerts,
+// with the members of its groups among its recipients
+type alertmanagerRoute struct {
+	AlertmanagerRoute
+	tmpl *template.Template
+}
+
+// compileAlertmanagerRoutes checks the Alertmanager routes against the
+// recipient groups and escalation policies and prepares them
+func compileAlertmanagerRoutes(routes []AlertmanagerRoute, groups map[string][]PhoneNumber, escalations *escalationStore) ([]alertmanagerRoute, error) {
+	compiled := make([]alertmanagerRoute, 0, len(routes))
+	for i, route := range routes {
+		r := alertmanagerRoute{AlertmanagerRoute: route}
+		if r.Originator == "" {
+			r.Originator = defaultAlertOriginator
+		}
+
+		seen := make(map[PhoneNumber]bool)
+		var recipients []PhoneNumber
+		add := func(numbers []PhoneNumber) {
+			for _, n := range numbers {
+				if !seen[n] {
+					seen[n] = true
+					recipients = append(recipients, n)
+				}
+			}
+		}
+		add(route.Recipients)
+		for _, name := range route.Groups {
+			members, ok := groups[name]
+			if !ok {
+				return nil, fmt.Errorf("Alertmanager route %d has unknown recipient group %q", i, name)
+			}
+			add(members)
+		}
+		if len(recipients) == 0 && r.Escalation == "" {
+			return nil, fmt.Errorf("Alertmanager route %d has no recipients", i)
+		}
+		if _, ok := escalations.policy(r.Escalation); r.Escalation != "" && !ok {
+			return nil, fmt.Errorf("Alertmanager route %d has unknown escalation policy %q", i, r.Escalation)
+		}
+		r.Recipients = recipients
+
+		text := r.Template
+		if text == "" {
+			text = defaultAlertmanagerTemplate
+		}
+		tmpl, err := template.New(fmt.Sprintf("alertmanager%d", i)).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("Alertmanager route %d has invalid template; Error: %v", i, err)
+		}
+		r.tmpl = tmpl
+
+		compiled = append(compiled, r)
+	}
+
+	return compiled, nil
+}
+
+// matches reports whether the alert has every label of the route
+func (r *alertmanagerRoute) matches(a AlertmanagerAlert) bool {
+	for name, value := range r.Labels {
+		if a.Labels[name] != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// alertmanagerWebhook is the HTTP handler of /webhooks/alertmanager,
+// receiving the notifications of a Prometheus Alertmanager webhook receiver
+// Every alert is sent to the recipients of the first of
+// Config.AlertmanagerRoutes it matches, through the same validation and
+// queue as the messages of /messages
+// Alertmanager retries the notifications answered with a server error,
+// which are the ones none of the messages could be sent for
+func (s *Server) alertmanagerWebhook() http.HandlerFunc {
+	routes, err := compileAlertmanagerRoutes(s.amRoutes, s.groups, s.escalations)
+	if err != nil {
+		slog.Warn("Alertmanager webhook disabled", "error", err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var res Response
+		if s.amToken != "" {
+			want := "Bearer " + s.amToken
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(want)) != 1 {
+				res = Response{
+					statusCode: http.StatusUnauthorized,
+					Error:      "Request not allowed (incorrect bearer token)",
+				}
+				sendResponse(w, res)
+				return
+			}
+		}
+
+		if err != nil {
+			res = Response{
+				statusCode: http.StatusInternalServerError,
+				Error:      "Internal error (invalid alertmanager routes)",
+			}
+			sendResponse(w, res)
+			return
+		}
+
+		var payload AlertmanagerPayload
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxAlertmanagerBytes)).Decode(&payload); err != nil {
+			res = Response{
+				statusCode: http.StatusBadRequest,
+				Error:      "Bad request (invalid payload json structure)",
+			}
+			sendResponse(w, res)
+			return
+		}
+
+		sent, failed := 0, 0
+		for _, alert := range payload.Alerts {
+			n, f := s.sendAlertmanagerAlert(r.Context(), routes, r.RemoteAddr, alert)
+			sent, failed = sent+n, failed+f
+		}
+
+		if sent == 0 && failed > 0 {
+			res = Response{
+				statusCode: http.StatusBadGateway,
+				Error:      fmt.Sprintf("Bad gateway (none of %d alert messages could be sent)", failed),
+			}
+			sendResponse(w, res)
+			return
+		}
+
+		res = Response{
+			statusCode: http.StatusOK,
+			Success:    true,
+		}
+		sendResponse(w, res)
+	}
+}
+
+// sendAlertmanagerAlert sends the alert to the recipients of its route
+// and returns how many of the messages were sent and how many failed
+func (s *Server) sendAlertmanagerAlert(ctx context.Context, routes []alertmanagerRoute, remoteAddr string, alert AlertmanagerAlert) (int, int) {
+	var route *alertmanagerRoute
+	for i := range routes {
+		if routes[i].matches(alert) {
+			route = &routes[i]
+			break
+		}
+	}
+	if route == nil {
+		metrics.Add("alerts_unrouted", 1)
+		return 0, 0
+	}
+
+	var text strings.Builder
+	if err := route.tmpl.Execute(&text, alert); err != nil {
+		metrics.Add("alerts_failed", 1)
+		slog.Error("Could not execute alertmanager template", "error", err)
+		return 0, 1
+	}
+
+	// Resolved alerts need no acknowledgment
+	escalation := route.Escalation
+	if alert.Status == "resolved" {
+		escalation = ""
+	}
+
+	return s.dispatchAlert(ctx, remoteAddr, route.Recipients, escalation, route.Originator, text.String())
+}
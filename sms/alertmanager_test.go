@@ -0,0 +1,104 @@
+package sms_test
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/smstest"
+)
+
+func TestServer_alertmanagerWebhook(t *testing.T) {
+	groups := map[string][]sms.PhoneNumber{
+		"dba":   {"31611111111", "31622222222"},
+		"infra": {"31633333333"},
+	}
+	routes := []sms.AlertmanagerRoute{
+		{Labels: map[string]string{"severity": "critical", "team": "dba"}, Groups: []string{"dba", "infra"}},
+		{Labels: map[string]string{"severity": "warning"}, Recipients: []sms.PhoneNumber{"+31644444444"}, Originator: "Prometheus", Template: "{{.Labels.alertname}} {{.Status}}"},
+	}
+
+	alert := func(status, alertname, severity, team string) string {
+		return fmt.Sprintf(`{"status": %q, "labels": {"alertname": %q, "severity": %q, "team": %q}, "annotations": {"summary": "Replication lag is high"}}`, status, alertname, severity, team)
+	}
+
+	tests := map[string]struct {
+		routes       []sms.AlertmanagerRoute
+		payload      string
+		wantStatus   int
+		wantMessages []string
+	}{
+		"Critical alert to groups": {
+			routes:     routes,
+			payload:    fmt.Sprintf(`{"version": "4", "status": "firing", "alerts": [%s]}`, alert("firing", "ReplicationLag", "critical", "dba")),
+			wantStatus: http.StatusOK,
+			wantMessages: []string{
+				"31611111111 flysms: [firing] ReplicationLag (critical): Replication lag is high",
+				"31622222222 flysms: [firing] ReplicationLag (critical): Replication lag is high",
+				"31633333333 flysms: [firing] ReplicationLag (critical): Replication lag is high",
+			},
+		},
+
+		"Alerts routed by severity": {
+			routes: routes,
+			payload: fmt.Sprintf(`{"version": "4", "status": "resolved", "alerts": [%s, %s]}`,
+				alert("resolved", "DiskFilling", "warning", "infra"), alert("resolved", "Watchdog", "none", "infra")),
+			wantStatus:   http.StatusOK,
+			wantMessages: []string{"+31644444444 Prometheus: DiskFilling resolved"},
+		},
+
+		"Invalid payload": {
+			routes:     routes,
+			payload:    `{"alerts": {}}`,
+			wantStatus: http.StatusBadRequest,
+		},
+
+		"Unknown recipient group": {
+			routes:     []sms.AlertmanagerRoute{{Groups: []string{"dev"}}},
+			payload:    fmt.Sprintf(`{"version": "4", "status": "firing", "alerts": [%s]}`, alert("firing", "ReplicationLag", "critical", "dba")),
+			wantStatus: http.StatusInternalServerError,
+		},
+
+		"Messages refused": {
+			routes:     []sms.AlertmanagerRoute{{Recipients: []sms.PhoneNumber{"123"}}},
+			payload:    fmt.Sprintf(`{"version": "4", "status": "firing", "alerts": [%s]}`, alert("firing", "ReplicationLag", "critical", "dba")),
+			wantStatus: http.StatusBadGateway,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := smstest.NewServer(t, sms.Config{
+				AlertmanagerRoutes: tc.routes,
+				RecipientGroups:    groups,
+			})
+
+			w := srv.SendRequest(t, http.MethodPost, "/webhooks/alertmanager", tc.payload)
+			if w.Code != tc.wantStatus {
+				t.Fatalf("Status code was %d; want %d", w.Code, tc.wantStatus)
+			}
+
+			var got []string
+			for _, ev := range srv.Provider.Events() {
+				got = append(got, fmt.Sprintf("%s %s: %s", ev.Request.Recipient, ev.Request.Originator, ev.Request.Message))
+			}
+			if len(got) != len(tc.wantMessages) || len(got) > 0 && !reflect.DeepEqual(got, tc.wantMessages) {
+				t.Errorf("Provider got %q; want %q", got, tc.wantMessages)
+			}
+		})
+	}
+}
+
+func TestServer_alertmanagerWebhookToken(t *testing.T) {
+	srv := smstest.NewServer(t, sms.Config{
+		AlertmanagerRoutes: []sms.AlertmanagerRoute{{Recipients: []sms.PhoneNumber{"31612345678"}}},
+		AlertmanagerToken:  "secret",
+	})
+
+	w := srv.Do(http.MethodPost, "/webhooks/alertmanager", `{"version": "4", "alerts": []}`)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Status code was %d; want %d", w.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,178 @@
+package sms_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/smstest"
+)
+
+// settlement is how the consumer settled a delivery
+type settlement struct {
+	tag     uint64
+	ack     bool
+	requeue bool
+}
+
+// fakeBroker is an AMQP broker delivering the given bodies to
+// the first consumer, and reporting how they were settled
+type fakeBroker struct {
+	net.Listener
+	settled chan settlement
+}
+
+func newFakeBroker(t *testing.T, bodies ...string) *fakeBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	b := &fakeBroker{Listener: l, settled: make(chan settlement, len(bodies))}
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b.serve(conn, bodies)
+	}()
+
+	return b
+}
+
+func (b *fakeBroker) serve(conn net.Conn, bodies []string) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(conn, header); err != nil || string(header) != "AMQP\x00\x00\x09\x01" {
+		return
+	}
+
+	// Connection.Start, with no server properties
+	writeAMQPMethod(conn, 0, 10, 10, []byte{0, 9, 0, 0, 0, 0, 0, 0, 0, 5, 'P', 'L', 'A', 'I', 'N', 0, 0, 0, 5, 'e', 'n', '_', 'U', 'S'})
+	readAMQPMethod(conn)
+	// Connection.Tune
+	writeAMQPMethod(conn, 0, 10, 30, []byte{0, 0, 0, 2, 0, 0, 0, 0})
+	readAMQPMethod(conn)
+	readAMQPMethod(conn)
+	writeAMQPMethod(conn, 0, 10, 41, []byte{0})
+	readAMQPMethod(conn)
+	writeAMQPMethod(conn, 1, 20, 11, []byte{0, 0, 0, 0})
+	readAMQPMethod(conn)
+	writeAMQPMethod(conn, 1, 60, 11, nil)
+	readAMQPMethod(conn)
+	writeAMQPMethod(conn, 1, 60, 21, []byte{1, 'c'})
+
+	for i, body := range bodies {
+		tag := make([]byte, 8)
+		binary.BigEndian.PutUint64(tag, uint64(i+1))
+		deliver := append([]byte{1, 'c'}, tag...)
+		deliver = append(deliver, 0, 0, 6, 'f', 'l', 'y', 's', 'm', 's')
+		writeAMQPMethod(conn, 1, 60, 60, deliver)
+
+		size := make([]byte, 8)
+		binary.BigEndian.PutUint64(size, uint64(len(body)))
+		writeAMQPFrame(conn, 2, 1, append(append([]byte{0, 60, 0, 0}, size...), 0, 0))
+		writeAMQPFrame(conn, 3, 1, []byte(body))
+	}
+
+	for {
+		class, method, args, err := readAMQPMethod(conn)
+		if err != nil {
+			return
+		}
+		if class != 60 || len(args) < 9 {
+			continue
+		}
+		b.settled <- settlement{
+			tag:     binary.BigEndian.Uint64(args[:8]),
+			ack:     method == 80,
+			requeue: method == 90 && args[8] == 1,
+		}
+	}
+}
+
+func writeAMQPFrame(conn net.Conn, typ byte, channel uint16, payload []byte) {
+	frame := []byte{typ, byte(channel >> 8), byte(channel), 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(frame[3:], uint32(len(payload)))
+	frame = append(append(frame, payload...), 0xce)
+	conn.Write(frame)
+}
+
+func writeAMQPMethod(conn net.Conn, channel, class, method uint16, args []byte) {
+	writeAMQPFrame(conn, 1, channel, append([]byte{byte(class >> 8), byte(class), byte(method >> 8), byte(method)}, args...))
+}
+
+func readAMQPMethod(conn net.Conn) (uint16, uint16, []byte, error) {
+	head := make([]byte, 7)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return 0, 0, nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(head[3:])+1)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return 0, 0, nil, err
+	}
+	if head[0] != 1 || len(payload) < 5 {
+		return 0, 0, nil, errors.New("not a method frame")
+	}
+
+	return binary.BigEndian.Uint16(payload[0:2]), binary.BigEndian.Uint16(payload[2:4]), payload[4 : len(payload)-1], nil
+}
+
+func TestServer_ConsumeAMQP(t *testing.T) {
+	tests := map[string]struct {
+		body        string
+		failWith    error
+		wantAck     bool
+		wantRequeue bool
+	}{
+		"Sent message": {
+			body:    `{"recipient": "+31612345678", "originator": "MessageBird", "message": "This is a test message"}`,
+			wantAck: true,
+		},
+		"Invalid payload": {
+			body: `{"recipient": `,
+		},
+		"Invalid recipient": {
+			body: `{"recipient": "1", "originator": "MessageBird", "message": "This is a test message"}`,
+		},
+		"Provider failure": {
+			body:        `{"recipient": "+31612345678", "originator": "MessageBird", "message": "This is a test message"}`,
+			failWith:    errors.New("connection refused"),
+			wantRequeue: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			broker := newFakeBroker(t, tc.body)
+			defer broker.Close()
+
+			srv := smstest.NewServer(t, sms.Config{})
+			if tc.failWith != nil {
+				srv.Provider.FailWith(tc.failWith)
+			}
+			go srv.ConsumeAMQP("amqp://guest:guest@"+broker.Addr().String()+"/", "flysms")
+
+			// Deliveries wait for the throttle, and the failed ones
+			// for a while before they are requeued
+			timeout := time.After(time.Second)
+			for {
+				select {
+				case got := <-broker.settled:
+					if got.tag != 1 || got.ack != tc.wantAck || got.requeue != tc.wantRequeue {
+						t.Errorf("Delivery was settled as %+v; want ack %t and requeue %t", got, tc.wantAck, tc.wantRequeue)
+					}
+					return
+				case <-timeout:
+					t.Fatal("Delivery was not settled")
+				case <-time.After(5 * time.Millisecond):
+					srv.Clock.Advance(time.Second)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package sms
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Verdict is the decision of an anomaly detector about a message
+type Verdict int
+
+const (
+	// VerdictAllow lets the message go through
+	VerdictAllow Verdict = iota
+	// VerdictQuarantine holds the message back as suspicious
+	VerdictQuarantine
+)
+
+// SendEvent describes a message that is about to be queued for sending
+// Time is when the message was received, according to the server clock
+type SendEvent struct {
+	Recipient   PhoneNumber
+	Originator  string
+	ContentHash string
+	Caller      string
+	Time        time.Time
+}
+
+// AnomalyDetector inspects send events and flags suspicious traffic
+// Implementations must be safe for concurrent use
+type AnomalyDetector interface {
+	Inspect(ev SendEvent) (Verdict, string)
+}
+
+// ThresholdConfig holds the limits of the threshold detector
+// A zero limit is not enforced
+type ThresholdConfig struct {
+	PerRecipient RateLimit
+	PerContent   RateLimit
+	PerCaller    RateLimit
+}
+
+// ThresholdDetector quarantines traffic exceeding fixed rates
+// per recipient, per message content and per caller
+type ThresholdDetector struct {
+	recipients *rateLimiter
+	contents   *rateLimiter
+	callers    *rateLimiter
+}
+
+// NewThresholdDetector creates a threshold detector from the given config
+func NewThresholdDetector(cfg ThresholdConfig) *ThresholdDetector {
+	return &ThresholdDetector{
+		recipients: newRateLimiter(cfg.PerRecipient),
+		contents:   newRateLimiter(cfg.PerContent),
+		callers:    newRateLimiter(cfg.PerCaller),
+	}
+}
+
+// Inspect quarantines the event if any of the rates is exceeded
+func (d *ThresholdDetector) Inspect(ev SendEvent) (Verdict, string) {
+	if rl, ok := d.recipients.allow(ev.Recipient.msisdn(), ev.Time); !ok {
+		return VerdictQuarantine, fmt.Sprintf("more than %d messages per %s for recipient", rl.Count, rl.Window)
+	}
+
+	if rl, ok := d.contents.allow(ev.ContentHash, ev.Time); !ok {
+		return VerdictQuarantine, fmt.Sprintf("more than %d identical messages per %s", rl.Count, rl.Window)
+	}
+
+	if rl, ok := d.callers.allow(ev.Caller, ev.Time); !ok {
+		return VerdictQuarantine, fmt.Sprintf("more than %d messages per %s from caller", rl.Count, rl.Window)
+	}
+
+	return VerdictAllow, ""
+}
+
+// contentHash fingerprints a message body
+func contentHash(message string) string {
+	sum := sha256.Sum256([]byte(message))
+	return hex.EncodeToString(sum[:])
+}
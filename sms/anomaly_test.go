@@ -0,0 +1,73 @@
+package sms_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+)
+
+func TestThresholdDetector_Inspect(t *testing.T) {
+	tests := map[string]struct {
+		cfg    sms.ThresholdConfig
+		events []sms.SendEvent
+		want   []sms.Verdict
+	}{
+		"No limits": {
+			cfg: sms.ThresholdConfig{},
+			events: []sms.SendEvent{
+				{Recipient: "31612345678", ContentHash: "a", Caller: "10.0.0.1"},
+				{Recipient: "31612345678", ContentHash: "a", Caller: "10.0.0.1"},
+			},
+			want: []sms.Verdict{sms.VerdictAllow, sms.VerdictAllow},
+		},
+
+		"Too many messages for recipient": {
+			cfg: sms.ThresholdConfig{
+				PerRecipient: sms.RateLimit{Count: 2, Window: time.Minute},
+			},
+			events: []sms.SendEvent{
+				{Recipient: "31612345678", ContentHash: "a", Caller: "10.0.0.1"},
+				{Recipient: "31612345678", ContentHash: "b", Caller: "10.0.0.2"},
+				{Recipient: "31687654321", ContentHash: "c", Caller: "10.0.0.3"},
+				{Recipient: "31612345678", ContentHash: "d", Caller: "10.0.0.4"},
+			},
+			want: []sms.Verdict{sms.VerdictAllow, sms.VerdictAllow, sms.VerdictAllow, sms.VerdictQuarantine},
+		},
+
+		"Too many identical messages": {
+			cfg: sms.ThresholdConfig{
+				PerContent: sms.RateLimit{Count: 1, Window: time.Minute},
+			},
+			events: []sms.SendEvent{
+				{Recipient: "31612345678", ContentHash: "a", Caller: "10.0.0.1"},
+				{Recipient: "31687654321", ContentHash: "a", Caller: "10.0.0.1"},
+			},
+			want: []sms.Verdict{sms.VerdictAllow, sms.VerdictQuarantine},
+		},
+
+		"Too many messages from caller": {
+			cfg: sms.ThresholdConfig{
+				PerCaller: sms.RateLimit{Count: 1, Window: time.Minute},
+			},
+			events: []sms.SendEvent{
+				{Recipient: "31612345678", ContentHash: "a", Caller: "10.0.0.1"},
+				{Recipient: "31687654321", ContentHash: "b", Caller: "10.0.0.2"},
+				{Recipient: "31687654322", ContentHash: "c", Caller: "10.0.0.1"},
+			},
+			want: []sms.Verdict{sms.VerdictAllow, sms.VerdictAllow, sms.VerdictQuarantine},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			d := sms.NewThresholdDetector(tc.cfg)
+			for i, ev := range tc.events {
+				got, reason := d.Inspect(ev)
+				if got != tc.want[i] {
+					t.Errorf("Inspect(event %d) = %d (%s); want %d", i, got, reason, tc.want[i])
+				}
+			}
+		})
+	}
+}
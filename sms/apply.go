@@ -0,0 +1,223 @@
+package sms
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Resources is the desired state of the resources managed through the
+// admin endpoints, synced by /admin/apply
+// A kind left out of the document is not managed, while an empty one
+// removes all of its resources
+type Resources struct {
+	Rotations map[string]Rotation `json:"rotations,omitempty"`
+	Features  map[string]int      `json:"features,omitempty"`
+}
+
+// ResourceChange is a change made, or planned, by syncing the resources
+type ResourceChange struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Action string `json:"action"`
+}
+
+// ResourceChangeList is the HTTP response of /admin/apply
+type ResourceChangeList struct {
+	Success bool             `json:"success"`
+	DryRun  bool             `json:"dry_run,omitempty"`
+	Data    []ResourceChange `json:"data"`
+}
+
+// ReadResources decodes a resources file, as YAML when its name ends
+// with .yaml or .yml and as JSON otherwise
+func ReadResources(name string, data []byte) (Resources, error) {
+	var res Resources
+
+	var err error
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		err = unmarshalYAML(data, &res)
+	default:
+		err = json.Unmarshal(data, &res)
+	}
+
+	return res, err
+}
+
+const (
+	resourceCreate = "create"
+	resourceUpdate = "update"
+	resourceDelete = "delete"
+)
+
+// check returns what is wrong with the resources, if anything
+func (res Resources) check() string {
+	for team, r := range res.Rotations {
+		if invalid := r.check(); invalid != "" {
+			return "rotation " + team + " " + invalid
+		}
+	}
+	for name, percent := range res.Features {
+		if percent < 0 || percent > 100 {
+			return "feature " + name + " percent must be between 0 and 100"
+		}
+	}
+
+	return ""
+}
+
+// equal reports whether the rotations are the same schedule
+func (r Rotation) equal(o Rotation) bool {
+	if len(r.Members) != len(o.Members) || len(r.Overrides) != len(o.Overrides) ||
+		r.PeriodHours != o.PeriodHours || !r.Start.Equal(o.Start) {
+		return false
+	}
+	for i := range r.Members {
+		if r.Members[i] != o.Members[i] {
+			return false
+		}
+	}
+	for i, ov := range r.Overrides {
+		other := o.Overrides[i]
+		if ov.Member != other.Member || !ov.Start.Equal(other.Start) || !ov.End.Equal(other.End) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// all returns a copy of the rotations by team
+func (s *rotationStore) all() map[string]Rotation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rotations := make(map[string]Rotation, len(s.rotations))
+	for team, r := range s.rotations {
+		rotations[team] = r
+	}
+
+	return rotations
+}
+
+// overridden returns a copy of the admin overrides by feature
+func (f *featureFlags) overridden() map[string]int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	overrides := make(map[string]int, len(f.overrides))
+	for name, percent := range f.overrides {
+		overrides[name] = percent
+	}
+
+	return overrides
+}
+
+// syncResources brings the managed resources to the desired state and
+// returns the changes, only planning them on a dry run
+// Applying the same resources again changes nothing
+func (s *Server) syncResources(desired Resources, dryRun bool) []ResourceChange {
+	changes := []ResourceChange{}
+
+	if desired.Rotations != nil {
+		current := s.rotations.all()
+		for team, r := range desired.Rotations {
+			old, ok := current[team]
+			switch {
+			case !ok:
+				changes = append(changes, ResourceChange{Kind: "rotation", Name: team, Action: resourceCreate})
+			case !old.equal(r):
+				changes = append(changes, ResourceChange{Kind: "rotation", Name: team, Action: resourceUpdate})
+			default:
+				continue
+			}
+			if !dryRun {
+				s.rotations.set(team, r)
+			}
+		}
+		for team := range current {
+			if _, ok := desired.Rotations[team]; ok {
+				continue
+			}
+			changes = append(changes, ResourceChange{Kind: "rotation", Name: team, Action: resourceDelete})
+			if !dryRun {
+				s.rotations.remove(team)
+			}
+		}
+	}
+
+	if desired.Features != nil {
+		current := s.features.overridden()
+		for name, percent := range desired.Features {
+			old, ok := current[name]
+			switch {
+			case !ok:
+				changes = append(changes, ResourceChange{Kind: "feature", Name: name, Action: resourceCreate})
+			case old != percent:
+				changes = append(changes, ResourceChange{Kind: "feature", Name: name, Action: resourceUpdate})
+			default:
+				continue
+			}
+			if !dryRun {
+				s.features.override(name, percent)
+			}
+		}
+		for name := range current {
+			if _, ok := desired.Features[name]; ok {
+				continue
+			}
+			changes = append(changes, ResourceChange{Kind: "feature", Name: name, Action: resourceDelete})
+			if !dryRun {
+				s.features.reset(name)
+			}
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Kind != changes[j].Kind {
+			return changes[i].Kind < changes[j].Kind
+		}
+		return changes[i].Name < changes[j].Name
+	})
+
+	return changes
+}
+
+// applyResources is the HTTP handler syncing the resources to the
+// desired state of the payload, such as from flysms apply
+// With ?dry_run=true the changes are only returned
+func (s *Server) applyResources() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var res Response
+		var desired Resources
+		if err := json.NewDecoder(r.Body).Decode(&desired); err != nil {
+			res = Response{
+				statusCode: http.StatusBadRequest,
+				Error:      "Bad request (invalid payload json structure)",
+			}
+			sendResponse(w, res)
+			return
+		}
+		if invalid := desired.check(); invalid != "" {
+			res = Response{
+				statusCode: http.StatusUnprocessableEntity,
+				Error:      "Invalid parameter (" + invalid + ")",
+			}
+			sendResponse(w, res)
+			return
+		}
+
+		dryRun := r.URL.Query().Get("dry_run") == "true"
+		changes := s.syncResources(desired, dryRun)
+		if !dryRun && len(changes) > 0 {
+			metrics.Add("resources_changed", int64(len(changes)))
+			slog.Info("Applied resource changes", "changes", len(changes))
+		}
+
+		sendJSON(w, http.StatusOK, ResourceChangeList{Success: true, DryRun: dryRun, Data: changes})
+	}
+}
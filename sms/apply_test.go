@@ -0,0 +1,232 @@
+package sms_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/smstest"
+)
+
+func TestServer_applyResources(t *testing.T) {
+	srv := smstest.NewServer(t, sms.Config{
+		AdminKey: "admin_key",
+		Rotations: map[string]sms.Rotation{
+			"payments": {Members: []sms.PhoneNumber{"31611111111"}, Start: time.Date(2019, 12, 30, 0, 0, 0, 0, time.UTC), PeriodHours: 24},
+			"search":   {Members: []sms.PhoneNumber{"31622222222"}, Start: time.Date(2019, 12, 30, 0, 0, 0, 0, time.UTC), PeriodHours: 24},
+		},
+	})
+
+	resources := `{
+		"rotations": {
+			"payments": {"members": ["31611111111"], "start": "2019-12-30T01:00:00+01:00", "period_hours": 24},
+			"search": {"members": ["31633333333"], "start": "2019-12-30T00:00:00Z", "period_hours": 12}
+		},
+		"features": {"split": 50}
+	}`
+
+	tests := []struct {
+		name        string
+		path        string
+		payload     string
+		wantStatus  int
+		wantChanges []sms.ResourceChange
+	}{
+		{
+			name:       "Dry run",
+			path:       "/admin/apply?dry_run=true",
+			payload:    resources,
+			wantStatus: http.StatusOK,
+			wantChanges: []sms.ResourceChange{
+				{Kind: "feature", Name: "split", Action: "create"},
+				{Kind: "rotation", Name: "search", Action: "update"},
+			},
+		},
+		{
+			name:       "Apply",
+			path:       "/admin/apply",
+			payload:    resources,
+			wantStatus: http.StatusOK,
+			wantChanges: []sms.ResourceChange{
+				{Kind: "feature", Name: "split", Action: "create"},
+				{Kind: "rotation", Name: "search", Action: "update"},
+			},
+		},
+		{
+			name:        "Apply again",
+			path:        "/admin/apply",
+			payload:     resources,
+			wantStatus:  http.StatusOK,
+			wantChanges: []sms.ResourceChange{},
+		},
+		{
+			name:       "Removed resources",
+			path:       "/admin/apply",
+			payload:    `{"rotations": {}, "features": {}}`,
+			wantStatus: http.StatusOK,
+			wantChanges: []sms.ResourceChange{
+				{Kind: "feature", Name: "split", Action: "delete"},
+				{Kind: "rotation", Name: "payments", Action: "delete"},
+				{Kind: "rotation", Name: "search", Action: "delete"},
+			},
+		},
+		{
+			name:       "Invalid resources",
+			path:       "/admin/apply",
+			payload:    `{"features": {"split": 150}}`,
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut, tc.path, strings.NewReader(tc.payload))
+			r.Header.Set("Authorization", "AdminKey admin_key")
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			if w.Code != tc.wantStatus {
+				t.Fatalf("Status code was %d; want %d", w.Code, tc.wantStatus)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var list sms.ResourceChangeList
+			if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+			if !reflect.DeepEqual(list.Data, tc.wantChanges) {
+				t.Errorf("Changes were %+v; want %+v", list.Data, tc.wantChanges)
+			}
+		})
+	}
+}
+
+func TestReadResources(t *testing.T) {
+	want := sms.Resources{
+		Rotations: map[string]sms.Rotation{
+			"payments": {
+				Members:     []sms.PhoneNumber{"31611111111", "31622222222"},
+				Start:       time.Date(2019, 12, 30, 0, 0, 0, 0, time.UTC),
+				PeriodHours: 24,
+				Overrides: []sms.Override{
+					{Member: "31622222222", Start: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), End: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)},
+				},
+			},
+		},
+		Features: map[string]int{"split": 50, "auto_split": 0},
+	}
+
+	tests := map[string]struct {
+		file    string
+		data    string
+		want    sms.Resources
+		wantErr bool
+	}{
+		"JSON": {
+			file: "resources.json",
+			data: `{
+				"rotations": {
+					"payments": {
+						"members": ["31611111111", "31622222222"],
+						"start": "2019-12-30T00:00:00Z",
+						"period_hours": 24,
+						"overrides": [{"member": "31622222222", "start": "2020-01-01T00:00:00Z", "end": "2020-01-02T00:00:00Z"}]
+					}
+				},
+				"features": {"split": 50, "auto_split": 0}
+			}`,
+			want: want,
+		},
+
+		"YAML": {
+			file: "resources.yaml",
+			data: `# Managed by the platform team
+rotations:
+  payments:
+    members:
+      - 31611111111
+      - "31622222222"
+    start: 2019-12-30T00:00:00Z
+    period_hours: 24 # one day each
+    overrides:
+    - member: 31622222222
+      start: 2020-01-01T00:00:00Z
+      end: '2020-01-02T00:00:00Z'
+features:
+  split: 50
+  auto_split: 0
+`,
+			want: want,
+		},
+
+		"YAML flow collections": {
+			file: "resources.yml",
+			data: `---
+rotations:
+  payments:
+    members: [31611111111, 31622222222]
+    start: "2019-12-30T00:00:00Z"
+    period_hours: 24
+    overrides:
+      - {member: 31622222222, start: 2020-01-01T00:00:00Z, end: 2020-01-02T00:00:00Z}
+features: {split: 50, auto_split: 0}
+`,
+			want: want,
+		},
+
+		"YAML removing all features": {
+			file: "resources.YAML",
+			data: "features: {}\n",
+			want: sms.Resources{Features: map[string]int{}},
+		},
+
+		"YAML not managing features": {
+			file: "resources.yaml",
+			data: "features:\n",
+			want: sms.Resources{},
+		},
+
+		"YAML of the wrong type": {
+			file:    "resources.yaml",
+			data:    "features:\n  split: half\n",
+			wantErr: true,
+		},
+
+		"YAML with bad indentation": {
+			file:    "resources.yaml",
+			data:    "features:\n    split: 50\n  other: 10\n",
+			wantErr: true,
+		},
+
+		"YAML read as JSON": {
+			file:    "resources.json",
+			data:    "features:\n  split: 50\n",
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := sms.ReadResources(tc.file, []byte(tc.data))
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("Got resources %+v; want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Could not read resources: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Got resources %+v; want %+v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package sms
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const statusHeld = "held"
+
+// DefaultMaxHeld is the number of messages held for approval at once
+// when Config.MaxHeld is not set
+const DefaultMaxHeld = 1000
+
+// DefaultHoldTTL is how long messages wait for approval before being
+// rejected when Config.HoldTTL is not set
+const DefaultHoldTTL = 24 * time.Hour
+
+// HeldMessage is a quarantined message waiting for manual approval
+type HeldMessage struct {
+	ID         string      `json:"id"`
+	Recipient  PhoneNumber `json:"recipient"`
+	Originator string      `json:"originator"`
+	Message    string      `json:"message"`
+	Reason     string      `json:"reason"`
+	Held       string      `json:"held"`
+}
+
+// HeldList is the HTTP response listing the held messages
+// NextCursor is set when more messages are left to fetch
+type HeldList struct {
+	Success    bool          `json:"success"`
+	Data       []HeldMessage `json:"data"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+type heldRequest struct {
+	req    *Request
+	reason string
+	held   time.Time
+}
+
+// holdStore keeps the quarantined requests until they are reviewed,
+// rejecting them when nobody did within the TTL
+// It holds at most max requests, refusing the others
+type holdStore struct {
+	mu    sync.Mutex
+	max   int
+	ttl   time.Duration
+	clock Clock
+	reqs  map[string]heldRequest
+}
+
+func newHoldStore(max int, ttl time.Duration, clock Clock) *holdStore {
+	if max <= 0 {
+		max = DefaultMaxHeld
+	}
+	if ttl <= 0 {
+		ttl = DefaultHoldTTL
+	}
+
+	return &holdStore{
+		max:   max,
+		ttl:   ttl,
+		clock: clock,
+		reqs:  make(map[string]heldRequest),
+	}
+}
+
+// hold stores the request for review and returns the response for the caller
+// The request is refused with 429 when the store is full
+func (h *holdStore) hold(req *Request, reason string) Response {
+	id := newID()
+	now := h.clock.Now()
+
+	h.mu.Lock()
+	h.expire(now)
+	full := len(h.reqs) >= h.max
+	if !full {
+		h.reqs[id] = heldRequest{req: req, reason: reason, held: now}
+	}
+	h.mu.Unlock()
+
+	if full {
+		metrics.Add("held_refused", 1)
+		slog.Warn("Refused to hold request, too many are waiting for approval", "request", req, "reason", reason)
+		return Response{
+			statusCode: http.StatusTooManyRequests,
+			Error:      "Request limit exceeded (too many messages held for approval)",
+		}
+	}
+
+	return Response{
+		statusCode: http.StatusAccepted,
+		Success:    true,
+		Data: Content{
+			ID:         id,
+			Recipient:  req.Recipient,
+			Originator: req.Originator,
+			Message:    req.Message,
+			Status:     statusHeld,
+			Created:    now.Format(time.RFC3339),
+		},
+	}
+}
+
+// put holds a request again, such as one that could not be queued
+// after its approval
+func (h *holdStore) put(id string, hr heldRequest) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.reqs[id] = hr
+}
+
+// expire rejects the requests held for longer than the TTL
+func (h *holdStore) expire(now time.Time) {
+	for id, hr := range h.reqs {
+		if now.Sub(hr.held) >= h.ttl {
+			delete(h.reqs, id)
+			metrics.Add("held_expired", 1)
+			slog.Info("Rejected held request not reviewed in time", "request", hr.req, "held", hr.held)
+		}
+	}
+}
+
+// take removes a held request so that it can be approved or rejected
+func (h *holdStore) take(id string) (heldRequest, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.expire(h.clock.Now())
+	hr, ok := h.reqs[id]
+	delete(h.reqs, id)
+
+	return hr, ok
+}
+
+// page returns up to limit held messages following the cursor, oldest first
+// The cursor of the last message is returned when more messages are left
+func (h *holdStore) page(after *cursor, limit int) ([]HeldMessage, *cursor) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.expire(h.clock.Now())
+	ids := make([]string, 0, len(h.reqs))
+	for id, hr := range h.reqs {
+		if after == nil || after.after(hr.held, id) {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := h.reqs[ids[i]].held, h.reqs[ids[j]].held
+		if a.Equal(b) {
+			return ids[i] < ids[j]
+		}
+		return a.Before(b)
+	})
+
+	var next *cursor
+	if len(ids) > limit {
+		ids = ids[:limit]
+		last := ids[limit-1]
+		next = &cursor{Time: h.reqs[last].held.UnixNano(), ID: last}
+	}
+
+	msgs := make([]HeldMessage, 0, len(ids))
+	for _, id := range ids {
+		hr := h.reqs[id]
+		msgs = append(msgs, HeldMessage{
+			ID:         id,
+			Recipient:  hr.req.Recipient,
+			Originator: hr.req.Originator,
+			Message:    hr.req.Message,
+			Reason:     hr.reason,
+			Held:       hr.held.Format(time.RFC3339),
+		})
+	}
+
+	return msgs, next
+}
+
+// listHeld is the HTTP handler listing the messages waiting for approval
+func (s *Server) listHeld() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, after, ok := s.pageParams(w, r)
+		if !ok {
+			return
+		}
+
+		msgs, next := s.held.page(after, limit)
+		list := HeldList{Success: true, Data: msgs}
+		if next != nil {
+			list.NextCursor = s.cursors.encode(*next)
+		}
+
+		sendCacheable(w, r, http.StatusOK, list)
+	}
+}
+
+// reviewHeld is the HTTP handler approving or rejecting a held message
+// It serves POST /admin/held/{id}/approve and POST /admin/held/{id}/reject
+func (s *Server) reviewHeld() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var res Response
+		id, action := pathParam(r, 2), pathParam(r, 3)
+
+		// Approved messages would be sent right away
+		if action == "approve" && s.refuseInMaintenance(w) {
+			return
+		}
+
+		hr, ok := s.held.take(id)
+		if !ok {
+			res = Response{
+				statusCode: http.StatusNotFound,
+				Error:      "Not found (no held message with this id)",
+			}
+			sendResponse(w, res)
+			return
+		}
+
+		if action == "reject" {
+			slog.Info("Rejected held request", "request", hr.req)
+			res = Response{
+				statusCode: http.StatusOK,
+				Success:    true,
+				Data: Content{
+					ID:         id,
+					Recipient:  hr.req.Recipient,
+					Originator: hr.req.Originator,
+					Message:    hr.req.Message,
+					Status:     "rejected",
+				},
+			}
+			sendResponse(w, res)
+			return
+		}
+
+		slog.Info("Approved held request", "request", hr.req)
+		res = s.submit(r.Context(), hr.req)
+		if res.statusCode == http.StatusTooManyRequests {
+			// The buffer is full, keep the message for another try
+			s.held.put(id, hr)
+		}
+		sendResponse(w, res)
+	}
+}
+
+// newID generates a random identifier
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("Could not generate random id; Error: %v", err)
+	}
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,261 @@
+package sms_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/smstest"
+)
+
+// quarantineAll is an anomaly detector holding every message
+type quarantineAll struct{}
+
+func (quarantineAll) Inspect(ev sms.SendEvent) (sms.Verdict, string) {
+	return sms.VerdictQuarantine, "test"
+}
+
+func TestServer_heldMessages(t *testing.T) {
+
+	testServer := sms.NewTestServer(t, "server_key")
+	defer testServer.Close()
+
+	srv := sms.NewServer(sms.Config{
+		Buffer:       10,
+		ReqTimeout:   5 * time.Second,
+		ThrottleRate: 50 * time.Millisecond,
+		AdminKey:     "admin_key",
+		Detector: sms.NewThresholdDetector(sms.ThresholdConfig{
+			PerContent: sms.RateLimit{Count: 1, Window: time.Minute},
+		}),
+		MessageClient: sms.NewClient(sms.Options{
+			BaseURL:   testServer.URL,
+			AccessKey: "server_key",
+			Timeout:   10 * time.Second,
+		}),
+	})
+	srv.Run()
+
+	do := func(method, path, adminKey, payload string) *http.Response {
+		r := httptest.NewRequest(method, path, strings.NewReader(payload))
+		if adminKey != "" {
+			r.Header.Set("Authorization", "AdminKey "+adminKey)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		return w.Result()
+	}
+
+	held := func() []sms.HeldMessage {
+		res := do(http.MethodGet, "/admin/held", "admin_key", "")
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("Listing held messages: status code was %d; want %d", res.StatusCode, http.StatusOK)
+		}
+		var list sms.HeldList
+		if err := json.NewDecoder(res.Body).Decode(&list); err != nil {
+			t.Fatalf("Failed to decode json response body: %v", err)
+		}
+		return list.Data
+	}
+
+	payload := `{"recipient":31612345678, "originator": "MessageBird", "message": "Buy now"}`
+
+	if res := do(http.MethodPost, "/messages", "", payload); res.StatusCode != http.StatusCreated {
+		t.Fatalf("First message: status code was %d; want %d", res.StatusCode, http.StatusCreated)
+	}
+
+	for i := 0; i < 2; i++ {
+		res := do(http.MethodPost, "/messages", "", payload)
+		if res.StatusCode != http.StatusAccepted {
+			t.Fatalf("Repeated message: status code was %d; want %d", res.StatusCode, http.StatusAccepted)
+		}
+		var smsRes sms.Response
+		if err := json.NewDecoder(res.Body).Decode(&smsRes); err != nil {
+			t.Fatalf("Failed to decode json response body: %v", err)
+		}
+		if smsRes.Data.Status != "held" {
+			t.Errorf("Repeated message status was %q; want %q", smsRes.Data.Status, "held")
+		}
+	}
+
+	// Polling an unchanged list is answered with 304
+	first := do(http.MethodGet, "/admin/held", "admin_key", "")
+	r := httptest.NewRequest(http.MethodGet, "/admin/held", nil)
+	r.Header.Set("Authorization", "AdminKey admin_key")
+	r.Header.Set("If-None-Match", first.Header.Get("ETag"))
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("Conditional listing: status code was %d; want %d", w.Code, http.StatusNotModified)
+	}
+
+	if res := do(http.MethodGet, "/admin/held", "wrong_key", ""); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Listing with wrong admin key: status code was %d; want %d", res.StatusCode, http.StatusUnauthorized)
+	}
+
+	msgs := held()
+	if len(msgs) != 2 {
+		t.Fatalf("Held %d messages; want 2", len(msgs))
+	}
+
+	tests := []struct {
+		path       string
+		wantStatus int
+	}{
+		{path: "/admin/held/" + msgs[0].ID + "/approve", wantStatus: http.StatusCreated},
+		{path: "/admin/held/" + msgs[1].ID + "/reject", wantStatus: http.StatusOK},
+		{path: "/admin/held/" + msgs[1].ID + "/approve", wantStatus: http.StatusNotFound},
+		{path: "/admin/held/" + msgs[1].ID + "/resend", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tc := range tests {
+		if res := do(http.MethodPost, tc.path, "admin_key", ""); res.StatusCode != tc.wantStatus {
+			t.Errorf("POST %s: status code was %d; want %d", tc.path, res.StatusCode, tc.wantStatus)
+		}
+	}
+
+	if msgs := held(); len(msgs) != 0 {
+		t.Errorf("Held %d messages after review; want 0", len(msgs))
+	}
+}
+
+func TestServer_heldMessagesPagination(t *testing.T) {
+
+	srv := sms.NewServer(sms.Config{
+		Buffer:       10,
+		ReqTimeout:   5 * time.Second,
+		ThrottleRate: 50 * time.Millisecond,
+		AdminKey:     "admin_key",
+		Detector: sms.NewThresholdDetector(sms.ThresholdConfig{
+			PerRecipient: sms.RateLimit{Count: 1, Window: time.Minute},
+		}),
+	})
+	srv.Run()
+
+	list := func(query string) (int, sms.HeldList) {
+		r := httptest.NewRequest(http.MethodGet, "/admin/held"+query, nil)
+		r.Header.Set("Authorization", "AdminKey admin_key")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+
+		var list sms.HeldList
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+		}
+		return w.Code, list
+	}
+
+	// Hold three messages by exceeding the recipient threshold
+	for i := 0; i < 4; i++ {
+		r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(`{"recipient":31612345678, "originator": "MessageBird", "message": "Buy now"}`))
+		srv.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	_, all := list("")
+	if len(all.Data) != 3 {
+		t.Fatalf("Held %d messages; want 3", len(all.Data))
+	}
+
+	_, first := list("?limit=2")
+	if len(first.Data) != 2 || first.NextCursor == "" {
+		t.Fatalf("First page had %d messages and cursor %q; want 2 and a cursor", len(first.Data), first.NextCursor)
+	}
+
+	_, second := list("?limit=2&cursor=" + first.NextCursor)
+	if len(second.Data) != 1 || second.NextCursor != "" {
+		t.Fatalf("Second page had %d messages and cursor %q; want 1 and no cursor", len(second.Data), second.NextCursor)
+	}
+
+	got := []string{first.Data[0].ID, first.Data[1].ID, second.Data[0].ID}
+	for i, msg := range all.Data {
+		if got[i] != msg.ID {
+			t.Errorf("Paged message %d was %s; want %s", i, got[i], msg.ID)
+		}
+	}
+
+	tests := map[string]struct {
+		query      string
+		wantStatus int
+	}{
+		"Tampered cursor": {
+			query:      "?cursor=" + first.NextCursor + "x",
+			wantStatus: http.StatusBadRequest,
+		},
+		"Limit too small": {
+			query:      "?limit=0",
+			wantStatus: http.StatusBadRequest,
+		},
+		"Limit not a number": {
+			query:      "?limit=ten",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if status, _ := list(tc.query); status != tc.wantStatus {
+				t.Errorf("Status code was %d; want %d", status, tc.wantStatus)
+			}
+		})
+	}
+}
+
+func TestServer_heldMessagesLimits(t *testing.T) {
+
+	clock := smstest.NewClock(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+	srv := sms.NewServer(sms.Config{
+		Buffer:     10,
+		ReqTimeout: 5 * time.Second,
+		AdminKey:   "admin_key",
+		Detector:   quarantineAll{},
+		MaxHeld:    2,
+		HoldTTL:    time.Hour,
+		Clock:      clock,
+	})
+	srv.Run()
+
+	send := func() int {
+		r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(`{"recipient":31612345678, "originator": "MessageBird", "message": "Buy now"}`))
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	held := func() int {
+		r := httptest.NewRequest(http.MethodGet, "/admin/held", nil)
+		r.Header.Set("Authorization", "AdminKey admin_key")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+
+		var list sms.HeldList
+		if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+			t.Fatalf("Failed to decode json response body: %v", err)
+		}
+		return len(list.Data)
+	}
+
+	for i, want := range []int{http.StatusAccepted, http.StatusAccepted, http.StatusTooManyRequests} {
+		if got := send(); got != want {
+			t.Errorf("Message %d: status code was %d; want %d", i, got, want)
+		}
+	}
+
+	clock.Advance(30 * time.Minute)
+	if n := held(); n != 2 {
+		t.Errorf("Held %d messages before the TTL; want 2", n)
+	}
+
+	clock.Advance(30 * time.Minute)
+	if n := held(); n != 0 {
+		t.Errorf("Held %d messages after the TTL; want 0", n)
+	}
+	if got := send(); got != http.StatusAccepted {
+		t.Errorf("Message after the TTL: status code was %d; want %d", got, http.StatusAccepted)
+	}
+}
@@ -0,0 +1,260 @@
+package sms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const defaultAttemptHistory = 10000
+
+// Attempt records one try at sending a message through a provider
+// Error is the kind of the failure and the description of the provider,
+// never the details of the request (see attemptError)
+// Exchange is only kept for failed attempts while in debug mode
+// and is dropped once it expires
+type Attempt struct {
+	expires    time.Time
+	Provider   string    `json:"provider"`
+	Role       string    `json:"role"`
+	Started    string    `json:"started"`
+	DurationMS int64     `json:"duration_ms"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Outcome    string    `json:"outcome"`
+	Error      string    `json:"error,omitempty"`
+	Exchange   *Exchange `json:"exchange,omitempty"`
+}
+
+// AttemptList is the HTTP response listing the attempts of a message
+type AttemptList struct {
+	Success bool      `json:"success"`
+	Data    []Attempt `json:"data"`
+}
+
+// attemptStore keeps the attempts of the most recent messages
+// Messages are known by their request id and by their provider id
+// The captured exchanges are dropped as they expire, in the order they
+// were captured since they all live for the same time
+type attemptStore struct {
+	mu       sync.Mutex
+	limit    int
+	order    []string
+	attempts map[string][]Attempt
+	aliases  map[string][]string
+	ids      map[string]string
+	captures []capturedAttempt
+	clock    Clock
+}
+
+// capturedAttempt locates an attempt holding an exchange
+type capturedAttempt struct {
+	reqID   string
+	index   int
+	expires time.Time
+}
+
+func newAttemptStore(limit int, clock Clock) *attemptStore {
+	if limit <= 0 {
+		limit = defaultAttemptHistory
+	}
+
+	return &attemptStore{
+		limit:    limit,
+		attempts: make(map[string][]Attempt),
+		aliases:  make(map[string][]string),
+		ids:      make(map[string]string),
+		clock:    clock,
+	}
+}
+
+// add records an attempt of the request
+// The oldest requests are forgotten once the limit is reached
+func (a *attemptStore) add(reqID string, at Attempt) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.dropExpired(a.clock.Now())
+
+	if _, ok := a.attempts[reqID]; !ok {
+		a.order = append(a.order, reqID)
+		if len(a.order) > a.limit {
+			oldest := a.order[0]
+			a.order = a.order[1:]
+			for _, alias := range a.aliases[oldest] {
+				delete(a.ids, alias)
+			}
+			delete(a.aliases, oldest)
+			delete(a.attempts, oldest)
+		}
+	}
+
+	if at.Exchange != nil {
+		a.captures = append(a.captures, capturedAttempt{reqID: reqID, index: len(a.attempts[reqID]), expires: at.expires})
+	}
+	a.attempts[reqID] = append(a.attempts[reqID], at)
+}
+
+// dropExpired drops the exchanges expired at the given time, so that
+// those of the messages nobody looks at do not stay in memory
+func (a *attemptStore) dropExpired(now time.Time) {
+	for len(a.captures) > 0 && now.After(a.captures[0].expires) {
+		c := a.captures[0]
+		a.captures = a.captures[1:]
+
+		// The request may have been forgotten, and its id reused, since
+		if attempts := a.attempts[c.reqID]; c.index < len(attempts) && now.After(attempts[c.index].expires) {
+			attempts[c.index].Exchange = nil
+		}
+	}
+}
+
+// alias makes the attempts of the request available under the provider id
+func (a *attemptStore) alias(providerID, reqID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.attempts[reqID]; !ok {
+		return
+	}
+
+	a.ids[providerID] = reqID
+	a.aliases[reqID] = append(a.aliases[reqID], providerID)
+}
+
+// get returns the attempts of a message by request or provider id
+func (a *attemptStore) get(id string) ([]Attempt, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if reqID, ok := a.ids[id]; ok {
+		id = reqID
+	}
+
+	attempts, ok := a.attempts[id]
+	if !ok {
+		return nil, false
+	}
+
+	a.dropExpired(a.clock.Now())
+
+	return append([]Attempt(nil), attempts...), true
+}
+
+// tryMessage sends the request through the sender and records the attempt
+// In debug mode the provider exchange of a failed attempt is recorded too
+func (s *Server) tryMessage(ctx context.Context, req *Request, role string, sender MessageSender) (Result, error) {
+	var capture *exchangeCapture
+	if s.captureTTL > 0 {
+		ctx, capture = withCapture(ctx)
+	}
+
+	sp := s.tracer.start(spanFromContext(ctx), "provider "+role, SpanClient)
+	defer sp.end()
+	sp.set("provider", providerName(sender))
+	ctx = withSpan(ctx, sp.context())
+
+	start := s.clock.Now()
+	res, err := deliver(ctx, req, sender)
+	if err != nil {
+		// Spans leave the process, so the provider body stays out of them
+		sp.fail(attemptError(err))
+	}
+
+	at := Attempt{
+		Provider:   providerName(sender),
+		Role:       role,
+		Started:    start.Format(time.RFC3339Nano),
+		DurationMS: int64(s.since(start) / time.Millisecond),
+		StatusCode: res.StatusCode,
+	}
+
+	switch e := err.(type) {
+	case nil:
+		at.Outcome = "created"
+	case *ProviderError:
+		at.Outcome = "refused"
+		at.StatusCode = e.StatusCode
+		s.recordDeprecatedCodes(at.Provider, e)
+	case *ContractError:
+		at.Outcome = "invalid_response"
+		at.StatusCode = e.StatusCode
+	default:
+		at.Outcome = "failed"
+		if ctx.Err() != nil {
+			at.Outcome = "cancelled"
+		}
+	}
+	if err != nil {
+		at.Error = attemptError(err)
+	}
+
+	if err != nil && capture != nil {
+		at.Exchange = capture.exchange()
+		at.expires = s.clock.Now().Add(s.captureTTL)
+	}
+
+	s.attempts.add(req.id, at)
+	if err == nil && res.ID != "" {
+		s.attempts.alias(res.ID, req.id)
+	}
+
+	return res, err
+}
+
+// attemptError describes a failure by its kind and the description of the
+// provider, leaving out the details of the request, such as the credentials
+// the error of the HTTP client could carry
+func attemptError(err error) string {
+	switch e := err.(type) {
+	case *ProviderError:
+		return fmt.Sprintf("%s error: %s", e.Kind, e.Description())
+	case *ContractError:
+		return "contract violation: " + e.Reason
+	}
+
+	var netErr net.Error
+	var opErr *net.OpError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "request cancelled"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "request timed out"
+	case errors.As(err, &opErr):
+		return "could not connect to the provider"
+	}
+
+	return "request failed"
+}
+
+// providerName names the provider behind a sender
+func providerName(sender MessageSender) string {
+	if n, ok := sender.(interface{ Name() string }); ok {
+		return n.Name()
+	}
+
+	return fmt.Sprintf("%T", sender)
+}
+
+// messageAttempts is the HTTP handler answering GET /messages/{id}/attempts
+// with the attempts made to send the message
+// It is restricted to the admins, as it shows the recipients and, in debug
+// mode, the provider exchanges
+func (s *Server) messageAttempts() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		attempts, ok := s.attempts.get(pathParam(r, 1))
+		if !ok {
+			res := Response{
+				statusCode: http.StatusNotFound,
+				Error:      "Not found (no attempts for this message)",
+			}
+			sendResponse(w, res)
+			return
+		}
+
+		sendCacheable(w, r, http.StatusOK, AttemptList{Success: true, Data: attempts})
+	}
+}
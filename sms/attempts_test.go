@@ -0,0 +1,40 @@
+package sms_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/smstest"
+)
+
+func TestAttemptStore_expiredCaptures(t *testing.T) {
+	clock := smstest.NewClock(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+	a := sms.NewAttemptStore(10, clock)
+
+	a.Capture("first", &sms.Exchange{Request: "POST /messages"}, time.Minute)
+	a.Capture("second", &sms.Exchange{Request: "POST /messages"}, time.Minute)
+	clock.Advance(30 * time.Second)
+	a.Capture("third", &sms.Exchange{Request: "POST /messages"}, time.Minute)
+
+	// Nobody reads the attempts, yet the next one drops what expired
+	clock.Advance(time.Minute)
+	a.Capture("fourth", &sms.Exchange{Request: "POST /messages"}, time.Minute)
+
+	if got := a.Captured(); got != 2 {
+		t.Errorf("Store held %d exchanges; want 2", got)
+	}
+
+	// The id of a forgotten request may come again with a fresh capture
+	small := sms.NewAttemptStore(1, clock)
+	small.Capture("reused", &sms.Exchange{Request: "POST /messages"}, time.Minute)
+	small.Capture("other", nil, 0)
+	clock.Advance(30 * time.Second)
+	small.Capture("reused", &sms.Exchange{Request: "POST /messages"}, time.Minute)
+	clock.Advance(45 * time.Second)
+	small.Capture("reused", nil, 0)
+
+	if got := small.Captured(); got != 1 {
+		t.Errorf("Store of one request held %d exchanges; want 1", got)
+	}
+}
@@ -0,0 +1,169 @@
+package sms
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Events of the audit log
+const (
+	auditSent      = "sent"
+	auditStatus    = "status"
+	auditExpired   = "expired"
+	auditCancelled = "cancelled"
+)
+
+// AuditRecord is an entry of the audit log
+// Hash is the SHA-256 hash of the record with an empty Hash, Prev being
+// the hash of the previous record, so that changing, removing or
+// reordering records breaks the chain from there on
+type AuditRecord struct {
+	Seq     uint64    `json:"seq"`
+	Time    time.Time `json:"time"`
+	Event   string    `json:"event"`
+	Message Content   `json:"message"`
+	Prev    string    `json:"prev"`
+	Hash    string    `json:"hash"`
+}
+
+// hash returns the hash of the record, chained to the previous one
+func (r AuditRecord) hash(crypto Crypto) (string, error) {
+	r.Hash = ""
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(crypto.Hash(data)), nil
+}
+
+// AuditLog is a tamper-evident log of the messages sent and of their
+// status changes, kept in a file of JSON records forming a hash chain
+// Records are synced to disk as they are appended
+type AuditLog struct {
+	mu     sync.Mutex
+	path   string
+	crypto Crypto
+	f      *os.File
+	seq    uint64
+	last   string
+}
+
+// OpenAuditLog opens the audit log at the path, creating it when
+// it does not exist, hashing with the crypto or StandardCrypto when nil
+// The chain of an existing log is verified before records are appended to it
+func OpenAuditLog(path string, crypto Crypto) (*AuditLog, error) {
+	a := &AuditLog{path: path, crypto: orStandardCrypto(crypto)}
+
+	f, err := os.Open(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		last, err := verifyAuditLog(f, a.crypto)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Could not continue audit log %s; Error: %v", path, err)
+		}
+		a.seq, a.last = last.Seq, last.Hash
+	}
+
+	a.f, err = os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Close closes the file
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.f.Close()
+}
+
+// append adds a record of the event to the chain
+func (a *AuditLog) append(event string, c Content, at time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	rec := AuditRecord{Seq: a.seq + 1, Time: at.UTC(), Event: event, Message: c, Prev: a.last}
+	hash, err := rec.hash(a.crypto)
+	if err != nil {
+		return err
+	}
+	rec.Hash = hash
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := a.f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("Could not write audit log %s; Error: %v", a.path, err)
+	}
+	if err := a.f.Sync(); err != nil {
+		return err
+	}
+	a.seq, a.last = rec.Seq, rec.Hash
+
+	return nil
+}
+
+// VerifyAuditLog checks the hash chain of an audit log, hashed with
+// the crypto or StandardCrypto when nil, and returns the number of
+// records in it
+// The error names the first record breaking the chain
+func VerifyAuditLog(r io.Reader, crypto Crypto) (int, error) {
+	last, err := verifyAuditLog(r, orStandardCrypto(crypto))
+
+	return int(last.Seq), err
+}
+
+// verifyAuditLog checks the hash chain and returns the last record
+func verifyAuditLog(r io.Reader, crypto Crypto) (AuditRecord, error) {
+	var last AuditRecord
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		var rec AuditRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return last, fmt.Errorf("record %d is not valid JSON", last.Seq+1)
+		}
+		if rec.Seq != last.Seq+1 || rec.Prev != last.Hash {
+			return last, fmt.Errorf("record %d does not follow record %d", rec.Seq, last.Seq)
+		}
+		hash, err := rec.hash(crypto)
+		if err != nil {
+			return last, err
+		}
+		if hash != rec.Hash {
+			return last, fmt.Errorf("record %d does not match its hash", rec.Seq)
+		}
+		last = rec
+	}
+
+	return last, sc.Err()
+}
+
+// audit records the event of the message in the audit log, if there is one
+func (s *Server) audit(event string, c Content) {
+	if s.auditLog == nil {
+		return
+	}
+
+	if err := s.auditLog.append(event, c, s.clock.Now()); err != nil {
+		metrics.Add("audit_errors", 1)
+		slog.Error("Could not audit message", "event", event, "message_id", c.ID, "error", err)
+	}
+}
@@ -0,0 +1,77 @@
+package sms_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/smstest"
+)
+
+func TestServer_auditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flysms")
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	// The chain carries on across restarts
+	for run := 0; run < 2; run++ {
+		auditLog, err := sms.OpenAuditLog(path, nil)
+		if err != nil {
+			t.Fatalf("Failed to open audit log: %v", err)
+		}
+		srv := smstest.NewServer(t, sms.Config{AuditLog: auditLog})
+		srv.Send(t, `{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`)
+		auditLog.Close()
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read audit log: %v", err)
+	}
+	if n, err := sms.VerifyAuditLog(bytes.NewReader(data), nil); err != nil || n != 2 {
+		t.Fatalf("Verified %d records with error %v; want 2 records", n, err)
+	}
+
+	tests := map[string]struct {
+		tamper  func(lines []string) []string
+		wantErr string
+	}{
+		"Changed record": {
+			tamper: func(lines []string) []string {
+				lines[0] = strings.Replace(lines[0], "This is a test message", "Another message", 1)
+				return lines
+			},
+			wantErr: "record 1 does not match its hash",
+		},
+		"Removed record": {
+			tamper: func(lines []string) []string {
+				return lines[1:]
+			},
+			wantErr: "record 2 does not follow record 0",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			lines := tc.tamper(strings.Split(strings.TrimSpace(string(data)), "\n"))
+			_, err := sms.VerifyAuditLog(strings.NewReader(strings.Join(lines, "\n")), nil)
+			if err == nil || err.Error() != tc.wantErr {
+				t.Errorf("Error was %v; want %q", err, tc.wantErr)
+			}
+
+			if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")), 0600); err != nil {
+				t.Fatalf("Failed to write audit log: %v", err)
+			}
+			if _, err := sms.OpenAuditLog(path, nil); err == nil {
+				t.Error("Opened a tampered audit log; want an error")
+			}
+		})
+	}
+}
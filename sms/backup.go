@@ -0,0 +1,228 @@
+package sms
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const backupVersion = 1
+
+// Backup is a snapshot of the state of the server, as made by
+// /admin/backup and taken back by /admin/restore
+// Data is the snapshot, sealed with AES-GCM when the server has a
+// backup key, and SHA256 its checksum, verified before restoring it
+type Backup struct {
+	Version   int       `json:"version"`
+	Created   time.Time `json:"created"`
+	Encrypted bool      `json:"encrypted"`
+	SHA256    string    `json:"sha256"`
+	Data      []byte    `json:"data"`
+}
+
+// BackupSummary is the HTTP response of /admin/restore
+type BackupSummary struct {
+	Success bool        `json:"success"`
+	Data    BackupCount `json:"data"`
+}
+
+// BackupCount tells how many resources a snapshot holds
+type BackupCount struct {
+	Messages  int `json:"messages"`
+	Rotations int `json:"rotations"`
+	Features  int `json:"features"`
+}
+
+// snapshot is the state of the server kept in a backup
+type snapshot struct {
+	Messages  []messageSnapshot `json:"messages"`
+	Resources Resources         `json:"resources"`
+}
+
+// messageSnapshot is a sent message along with its last status
+type messageSnapshot struct {
+	Content     Content   `json:"content"`
+	CallbackURL string    `json:"callback_url,omitempty"`
+	Updated     time.Time `json:"updated"`
+}
+
+var (
+	errBackupVersion  = errors.New("unsupported backup version")
+	errBackupChecksum = errors.New("backup checksum mismatch")
+	errBackupKey      = errors.New("backup cannot be decrypted with the backup key")
+)
+
+// newBackupKey derives the AES-256 key of the backups from the
+// configured secret, backups being left unencrypted without one
+func newBackupKey(crypto Crypto, secret string) []byte {
+	if secret == "" {
+		return nil
+	}
+
+	return crypto.Hash([]byte(secret))
+}
+
+// snapshot returns the messages in the order they were sent
+func (d *deliveryStore) snapshot() []messageSnapshot {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	msgs := make([]messageSnapshot, 0, len(d.order))
+	for _, id := range d.order {
+		m := d.msgs[id]
+		msgs = append(msgs, messageSnapshot{Content: m.content, CallbackURL: m.callbackURL, Updated: m.updated})
+	}
+
+	return msgs
+}
+
+// restore replaces the messages with the ones of a snapshot
+func (d *deliveryStore) restore(msgs []messageSnapshot) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(msgs) > d.limit {
+		msgs = msgs[len(msgs)-d.limit:]
+	}
+
+	d.order = d.order[:0]
+	d.msgs = make(map[string]delivery, len(msgs))
+	for _, m := range msgs {
+		if _, ok := d.msgs[m.Content.ID]; !ok {
+			d.order = append(d.order, m.Content.ID)
+		}
+		d.msgs[m.Content.ID] = delivery{content: m.Content, callbackURL: m.CallbackURL, updated: m.Updated}
+	}
+}
+
+// backup takes a snapshot of the server and seals it
+func (s *Server) backup() (Backup, error) {
+	snap := snapshot{
+		Messages: s.deliveries.snapshot(),
+		Resources: Resources{
+			Rotations: s.rotations.all(),
+			Features:  s.features.overridden(),
+		},
+	}
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return Backup{}, err
+	}
+
+	b := Backup{Version: backupVersion, Created: s.clock.Now().UTC()}
+	if s.backupKey != nil {
+		data, err = s.crypto.Seal(s.backupKey, data)
+		if err != nil {
+			return Backup{}, err
+		}
+		b.Encrypted = true
+	}
+
+	b.SHA256 = hex.EncodeToString(s.crypto.Hash(data))
+	b.Data = data
+
+	return b, nil
+}
+
+// openBackup verifies the backup and returns its snapshot
+func (s *Server) openBackup(b Backup) (snapshot, error) {
+	if b.Version != backupVersion {
+		return snapshot{}, errBackupVersion
+	}
+
+	if hex.EncodeToString(s.crypto.Hash(b.Data)) != b.SHA256 {
+		return snapshot{}, errBackupChecksum
+	}
+
+	data := b.Data
+	if b.Encrypted {
+		if s.backupKey == nil {
+			return snapshot{}, errBackupKey
+		}
+		var err error
+		data, err = s.crypto.Open(s.backupKey, data)
+		if err != nil {
+			return snapshot{}, errBackupKey
+		}
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return snapshot{}, fmt.Errorf("invalid backup snapshot: %v", err)
+	}
+	if invalid := snap.Resources.check(); invalid != "" {
+		return snapshot{}, fmt.Errorf("invalid backup snapshot: %s", invalid)
+	}
+
+	return snap, nil
+}
+
+// backupState is the HTTP handler returning a backup of the server
+func (s *Server) backupState() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		b, err := s.backup()
+		if err != nil {
+			slog.Error("Could not back up the server", "error", err)
+			res := Response{
+				statusCode: http.StatusInternalServerError,
+				Error:      "Internal error (could not back up the server)",
+			}
+			sendResponse(w, res)
+			return
+		}
+
+		metrics.Add("backups_made", 1)
+		sendJSON(w, http.StatusOK, b)
+	}
+}
+
+// restoreState is the HTTP handler restoring the server from a backup
+// The messages are replaced, and the resources synced as by /admin/apply
+func (s *Server) restoreState() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var res Response
+		var b Backup
+		if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+			res = Response{
+				statusCode: http.StatusBadRequest,
+				Error:      "Bad request (invalid payload json structure)",
+			}
+			sendResponse(w, res)
+			return
+		}
+
+		snap, err := s.openBackup(b)
+		if err != nil {
+			res = Response{
+				statusCode: http.StatusUnprocessableEntity,
+				Error:      "Invalid parameter (" + err.Error() + ")",
+			}
+			sendResponse(w, res)
+			return
+		}
+
+		if snap.Resources.Rotations == nil {
+			snap.Resources.Rotations = map[string]Rotation{}
+		}
+		if snap.Resources.Features == nil {
+			snap.Resources.Features = map[string]int{}
+		}
+		s.deliveries.restore(snap.Messages)
+		s.syncResources(snap.Resources, false)
+
+		count := BackupCount{
+			Messages:  len(snap.Messages),
+			Rotations: len(snap.Resources.Rotations),
+			Features:  len(snap.Resources.Features),
+		}
+		metrics.Add("backups_restored", 1)
+		slog.Info("Restored backup", "created", b.Created.Format(time.RFC3339), "messages", count.Messages)
+
+		sendJSON(w, http.StatusOK, BackupSummary{Success: true, Data: count})
+	}
+}
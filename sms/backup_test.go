@@ -0,0 +1,112 @@
+package sms_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/smstest"
+)
+
+func TestServer_backupRestore(t *testing.T) {
+	admin := func(srv *smstest.Server, method, path, payload string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(payload))
+		r.Header.Set("Authorization", "AdminKey admin_key")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		return w
+	}
+
+	src := smstest.NewServer(t, sms.Config{
+		AdminKey:  "admin_key",
+		BackupKey: "backup_key",
+		Rotations: map[string]sms.Rotation{
+			"payments": {Members: []sms.PhoneNumber{"31611111111"}, Start: time.Date(2019, 12, 30, 0, 0, 0, 0, time.UTC), PeriodHours: 24},
+		},
+	})
+
+	w := src.Send(t, `{"recipient": "+31612345678", "originator": "MessageBird", "message": "This is a test message"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Status code was %d; want %d", w.Code, http.StatusCreated)
+	}
+	var created sms.Response
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to decode json response body: %v", err)
+	}
+
+	w = admin(src, http.MethodGet, "/admin/backup", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Backup status code was %d; want %d", w.Code, http.StatusOK)
+	}
+	backup := w.Body.String()
+
+	var b sms.Backup
+	if err := json.Unmarshal([]byte(backup), &b); err != nil {
+		t.Fatalf("Failed to decode json response body: %v", err)
+	}
+	if !b.Encrypted || strings.Contains(string(b.Data), "This is a test message") {
+		t.Fatalf("Backup was not encrypted: %s", backup)
+	}
+
+	b.Data[len(b.Data)-1] ^= 1
+	tampered, _ := json.Marshal(b)
+
+	tests := []struct {
+		name       string
+		backupKey  string
+		payload    string
+		wantStatus int
+	}{
+		{
+			name:       "Restored",
+			backupKey:  "backup_key",
+			payload:    backup,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Wrong backup key",
+			backupKey:  "other_key",
+			payload:    backup,
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "Tampered backup",
+			backupKey:  "backup_key",
+			payload:    string(tampered),
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dst := smstest.NewServer(t, sms.Config{AdminKey: "admin_key", BackupKey: tc.backupKey})
+
+			w := admin(dst, http.MethodPost, "/admin/restore", tc.payload)
+			if w.Code != tc.wantStatus {
+				t.Fatalf("Restore status code was %d; want %d", w.Code, tc.wantStatus)
+			}
+
+			// Unknown messages cannot be looked up from the test provider
+			wantMessage, wantRotations := http.StatusNotImplemented, 0
+			if tc.wantStatus == http.StatusOK {
+				wantMessage, wantRotations = http.StatusOK, 1
+			}
+
+			if w := dst.Do(http.MethodGet, "/messages/"+created.Data.ID, ""); w.Code != wantMessage {
+				t.Errorf("Message status code was %d; want %d", w.Code, wantMessage)
+			}
+
+			var list sms.OnCallList
+			if err := json.NewDecoder(admin(dst, http.MethodGet, "/admin/oncall", "").Body).Decode(&list); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+			if len(list.Data) != wantRotations {
+				t.Errorf("Got %d rotations; want %d", len(list.Data), wantRotations)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package sms
+
+import (
+	"context"
+	"net/http"
+)
+
+// BalanceResponse is the HTTP response with the remaining credit
+// of the provider account
+type BalanceResponse struct {
+	Success bool    `json:"success"`
+	Data    Balance `json:"data"`
+}
+
+// balanceChecker is implemented by the senders able to tell
+// the remaining credit of the account
+type balanceChecker interface {
+	balance(ctx context.Context) (Balance, error)
+}
+
+// viewBalance is the HTTP handler answering GET /balance
+// with the remaining credit, as reported by the provider
+func (s *Server) viewBalance() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var res Response
+		checker, ok := s.messageClient.(balanceChecker)
+		if !ok {
+			res = Response{
+				statusCode: http.StatusNotImplemented,
+				Error:      "Not implemented (provider does not report the balance)",
+			}
+			sendResponse(w, res)
+			return
+		}
+
+		ctx, cancel := s.withTimeout(r.Context(), s.reqTimeout)
+		defer cancel()
+
+		balance, err := s.lookups.lookup(w, "balance", func() (interface{}, error) {
+			return checker.balance(ctx)
+		})
+		if err != nil {
+			sendResponse(w, lookupError(err, "balance"))
+			return
+		}
+
+		sendJSON(w, http.StatusOK, BalanceResponse{Success: true, Data: balance.(Balance)})
+	}
+}
@@ -0,0 +1,105 @@
+package sms_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+)
+
+func TestServer_viewBalance(t *testing.T) {
+	testServer := sms.NewTestServer(t, "test_key")
+	defer testServer.Close()
+
+	tests := map[string]struct {
+		sender     sms.MessageSender
+		adminKey   string
+		method     string
+		wantStatus int
+		wantError  string
+	}{
+		"Balance": {
+			sender:     sms.NewClient(sms.Options{AccessKey: "test_key", BaseURL: testServer.URL, Timeout: 10 * time.Second}),
+			adminKey:   "admin_key",
+			method:     http.MethodGet,
+			wantStatus: http.StatusOK,
+		},
+
+		"Refused access key": {
+			sender:     sms.NewClient(sms.Options{AccessKey: "wrong_key", BaseURL: testServer.URL, Timeout: 10 * time.Second}),
+			adminKey:   "admin_key",
+			method:     http.MethodGet,
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "Request not allowed (incorrect access_key)",
+		},
+
+		"Wrong admin key": {
+			sender:     sms.NewClient(sms.Options{AccessKey: "test_key", BaseURL: testServer.URL, Timeout: 10 * time.Second}),
+			adminKey:   "wrong_key",
+			method:     http.MethodGet,
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "Request not allowed (incorrect admin key)",
+		},
+
+		"Invalid method": {
+			sender:     sms.NewClient(sms.Options{AccessKey: "test_key", BaseURL: testServer.URL, Timeout: 10 * time.Second}),
+			adminKey:   "admin_key",
+			method:     http.MethodPost,
+			wantStatus: http.StatusMethodNotAllowed,
+			wantError:  "Request not allowed (invalid HTTP method)",
+		},
+
+		"Provider without balance": {
+			sender:     fakeSender{},
+			adminKey:   "admin_key",
+			method:     http.MethodGet,
+			wantStatus: http.StatusNotImplemented,
+			wantError:  "Not implemented (provider does not report the balance)",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := sms.NewServer(sms.Config{
+				Buffer:        10,
+				ReqTimeout:    5 * time.Second,
+				ThrottleRate:  10 * time.Millisecond,
+				AdminKey:      "admin_key",
+				MessageClient: tc.sender,
+			})
+			srv.Run()
+
+			r := httptest.NewRequest(tc.method, "/balance", nil)
+			r.Header.Set("Authorization", "AdminKey "+tc.adminKey)
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			if w.Code != tc.wantStatus {
+				t.Fatalf("Status code was %d; want %d", w.Code, tc.wantStatus)
+			}
+
+			if tc.wantStatus != http.StatusOK {
+				var smsRes sms.Response
+				if err := json.NewDecoder(w.Body).Decode(&smsRes); err != nil {
+					t.Fatalf("Failed to decode json response body: %v", err)
+				}
+				if smsRes.Error != tc.wantError {
+					t.Errorf("Error was %q; want %q", smsRes.Error, tc.wantError)
+				}
+				return
+			}
+
+			var balance sms.BalanceResponse
+			if err := json.NewDecoder(w.Body).Decode(&balance); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+			want := sms.Balance{Payment: "prepaid", Type: "credits", Amount: 9.2}
+			if !balance.Success || balance.Data != want {
+				t.Errorf("Balance was %+v; want %+v", balance.Data, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package sms
+
+import "strings"
+
+// Branding is the text added around the messages of an originator,
+// such as "[ACME] " or " -- reply STOP to opt out"
+type Branding struct {
+	Prefix string
+	Suffix string
+}
+
+// brand adds the prefix and suffix configured for the originator of the
+// request, or the default ones, to its message
+// Text messages already starting or ending with them are left as they are,
+// and voice and binary messages are never branded
+func (s *Server) brand(req *Request) {
+	if req.Channel == channelVoice || req.Type == messageTypeBinary || len(s.branding) == 0 {
+		return
+	}
+
+	b, ok := s.branding[req.Originator]
+	if !ok {
+		b = s.branding[""]
+	}
+
+	if b.Prefix != "" && !strings.HasPrefix(req.Message, b.Prefix) {
+		req.Message = b.Prefix + req.Message
+	}
+	if b.Suffix != "" && !strings.HasSuffix(req.Message, b.Suffix) {
+		req.Message += b.Suffix
+	}
+}
@@ -0,0 +1,82 @@
+package sms_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/smstest"
+)
+
+func TestServer_branding(t *testing.T) {
+	branding := map[string]sms.Branding{
+		"":     {Suffix: " -- reply STOP to opt out"},
+		"ACME": {Prefix: "[ACME] "},
+	}
+
+	tests := map[string]struct {
+		originator  string
+		message     string
+		wantStatus  int
+		wantError   string
+		wantMessage string
+	}{
+		"Default branding": {
+			originator:  "MessageBird",
+			message:     "Your code is 1234",
+			wantStatus:  http.StatusCreated,
+			wantMessage: "Your code is 1234 -- reply STOP to opt out",
+		},
+
+		"Originator branding": {
+			originator:  "ACME",
+			message:     "Your code is 1234",
+			wantStatus:  http.StatusCreated,
+			wantMessage: "[ACME] Your code is 1234",
+		},
+
+		"Already branded": {
+			originator:  "ACME",
+			message:     "[ACME] Your code is 1234",
+			wantStatus:  http.StatusCreated,
+			wantMessage: "[ACME] Your code is 1234",
+		},
+
+		"Too long once branded": {
+			originator: "ACME",
+			message:    strings.Repeat("a", sms.MaxMessageLength-1),
+			wantStatus: http.StatusUnprocessableEntity,
+			wantError:  "Invalid parameter (message value is to long with its branding)",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := smstest.NewServer(t, sms.Config{Branding: branding})
+
+			w := srv.Send(t, fmt.Sprintf(`{"recipient":31612345678, "originator": %q, "message": %q}`, tc.originator, tc.message))
+			if w.Code != tc.wantStatus {
+				t.Fatalf("Status code was %d; want %d", w.Code, tc.wantStatus)
+			}
+
+			var smsRes sms.Response
+			if err := json.NewDecoder(w.Body).Decode(&smsRes); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+			if smsRes.Error != tc.wantError {
+				t.Errorf("Error was %q; want %q", smsRes.Error, tc.wantError)
+			}
+			if tc.wantStatus != http.StatusCreated {
+				return
+			}
+
+			events := srv.Provider.Events()
+			if len(events) != 1 || events[0].Request.Message != tc.wantMessage {
+				t.Errorf("Provider got %+v; want a single message %q", events, tc.wantMessage)
+			}
+		})
+	}
+}
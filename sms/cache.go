@@ -0,0 +1,105 @@
+package sms
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxLookupCacheEntries bounds the number of cached lookups
+// Lookups are not cached while the cache is full of fresh entries
+const maxLookupCacheEntries = 10000
+
+type cachedLookup struct {
+	value   interface{}
+	expires time.Time
+}
+
+// lookupCache keeps the answers of the provider lookups for a short while,
+// to protect the provider API from aggressive pollers
+// Failed lookups are never cached
+type lookupCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	clock   Clock
+	entries map[string]cachedLookup
+}
+
+func newLookupCache(ttl time.Duration, clock Clock) *lookupCache {
+	if ttl <= 0 {
+		return nil
+	}
+
+	return &lookupCache{
+		ttl:     ttl,
+		clock:   clock,
+		entries: make(map[string]cachedLookup),
+	}
+}
+
+func (c *lookupCache) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || !c.clock.Now().Before(e.expires) {
+		return nil, false
+	}
+
+	return e.value, true
+}
+
+// set caches the value, dropping the expired entries once the cache is full
+func (c *lookupCache) set(key string, v interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	if len(c.entries) >= maxLookupCacheEntries {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxLookupCacheEntries {
+			return
+		}
+	}
+
+	c.entries[key] = cachedLookup{value: v, expires: now.Add(c.ttl)}
+}
+
+func (c *lookupCache) forget(key string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, key)
+}
+
+// lookup answers from the cache when it has a fresh answer for the key,
+// and fetches it otherwise
+// The X-Cache header of the response tells which happened
+func (c *lookupCache) lookup(w http.ResponseWriter, key string, fetch func() (interface{}, error)) (interface{}, error) {
+	if c == nil {
+		return fetch()
+	}
+
+	if v, ok := c.get(key); ok {
+		metrics.Add("lookup_cache_hits", 1)
+		w.Header().Set("X-Cache", "HIT")
+		return v, nil
+	}
+
+	metrics.Add("lookup_cache_misses", 1)
+	w.Header().Set("X-Cache", "MISS")
+	v, err := fetch()
+	if err == nil {
+		c.set(key, v)
+	}
+
+	return v, err
+}
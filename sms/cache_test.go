@@ -0,0 +1,77 @@
+package sms_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/smstest"
+)
+
+func TestServer_lookupCache(t *testing.T) {
+	testServer := sms.NewTestServer(t, "test_key")
+	defer testServer.Close()
+
+	tests := map[string]struct {
+		path      string
+		ttl       time.Duration
+		wantCache []string
+	}{
+		"Balance": {
+			path:      "/balance",
+			ttl:       time.Minute,
+			wantCache: []string{"MISS", "HIT", "MISS"},
+		},
+
+		"Message list": {
+			path:      "/messages?limit=5",
+			ttl:       time.Minute,
+			wantCache: []string{"MISS", "HIT", "MISS"},
+		},
+
+		"Unknown message": {
+			path:      "/messages/unknown",
+			ttl:       time.Minute,
+			wantCache: []string{"MISS", "MISS", "MISS"},
+		},
+
+		"Cache disabled": {
+			path:      "/balance",
+			wantCache: []string{"", "", ""},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			clock := smstest.NewClock(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+			srv := sms.NewServer(sms.Config{
+				Buffer:         10,
+				ReqTimeout:     5 * time.Second,
+				ThrottleRate:   10 * time.Millisecond,
+				AdminKey:       "admin_key",
+				LookupCacheTTL: tc.ttl,
+				Clock:          clock,
+				MessageClient:  sms.NewClient(sms.Options{AccessKey: "test_key", BaseURL: testServer.URL, Timeout: 10 * time.Second}),
+			})
+			srv.Run()
+
+			for i, want := range tc.wantCache {
+				// The last lookup comes once the cached answer expired
+				if i == len(tc.wantCache)-1 {
+					clock.Advance(tc.ttl)
+				}
+
+				r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+				r.Header.Set("Authorization", "AdminKey admin_key")
+				w := httptest.NewRecorder()
+				srv.ServeHTTP(w, r)
+
+				if got := w.Header().Get("X-Cache"); got != want {
+					t.Errorf("Lookup %d was a cache %q; want %q", i+1, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package sms
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"syscall"
+	"text/template"
+	"time"
+)
+
+// callbackBuffer is the number of events waiting to be posted by every
+// callback worker
+// Events are dropped rather than slowing down the delivery reports
+const callbackBuffer = 100
+
+// callbackWorkers is the number of events posted at once, so that a slow
+// endpoint does not hold back the events of the others
+// The events of a message are all posted by the same worker, in order
+const callbackWorkers = 4
+
+// errCallbackRefused is returned when a callback URL resolves to a private,
+// loopback or link-local address without its host being allowed
+var errCallbackRefused = errors.New("callback address is not public")
+
+// callbackAttempts is how many times an event is posted before giving up
+// The wait between attempts starts at callbackBackoff and doubles every time
+const (
+	callbackAttempts = 3
+	callbackBackoff  = time.Second
+)
+
+// StatusEvent is posted to the callback URL of a message
+// whenever its delivery status changes
+type StatusEvent struct {
+	ID        string      `json:"id"`
+	Recipient PhoneNumber `json:"recipient"`
+	Status    string      `json:"status"`
+	Previous  string      `json:"previous_status"`
+	StatusAt  string      `json:"status_at"`
+}
+
+// callbackFuncs are the functions of the callback templates
+// json turns a value into JSON, such as a quoted and escaped string
+var callbackFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// callbackTemplate shapes the events posted to the callback URLs
+// starting with its prefix
+type callbackTemplate struct {
+	prefix string
+	tmpl   *template.Template
+}
+
+// compileCallbackTemplates parses the callback templates, longest prefix first
+// Invalid templates are ignored, their events keeping the default payload
+func compileCallbackTemplates(templates map[string]string) []callbackTemplate {
+	var compiled []callbackTemplate
+	for prefix, text := range templates {
+		tmpl, err := template.New("callback " + prefix).Funcs(callbackFuncs).Parse(text)
+		if err != nil {
+			slog.Warn("Ignored callback template", "prefix", prefix, "error", err)
+			continue
+		}
+		compiled = append(compiled, callbackTemplate{prefix: prefix, tmpl: tmpl})
+	}
+
+	sort.Slice(compiled, func(i, j int) bool { return len(compiled[i].prefix) > len(compiled[j].prefix) })
+
+	return compiled
+}
+
+type callbackEvent struct {
+	url   string
+	event StatusEvent
+}
+
+// callbackNotifier posts the status events of the messages
+// to their callback URL, or to the default one
+// The callback URLs of the messages are only posted to public addresses,
+// unless their host is one of the allowed ones
+type callbackNotifier struct {
+	defaultURL string
+	templates  []callbackTemplate
+	hosts      map[string]bool
+	httpClient *http.Client
+	publicOnly *http.Client
+	clock      Clock
+	eventChs   []chan callbackEvent
+}
+
+func newCallbackNotifier(defaultURL string, templates map[string]string, hosts []string, timeout time.Duration, clock Clock) *callbackNotifier {
+	c := &callbackNotifier{
+		defaultURL: defaultURL,
+		templates:  compileCallbackTemplates(templates),
+		hosts:      make(map[string]bool),
+		httpClient: &http.Client{Timeout: timeout},
+		publicOnly: &http.Client{Timeout: timeout, Transport: publicTransport()},
+		clock:      clock,
+		eventChs:   make([]chan callbackEvent, callbackWorkers),
+	}
+	for _, host := range hosts {
+		c.hosts[strings.ToLower(host)] = true
+	}
+	if u, err := url.Parse(defaultURL); err == nil && u.Host != "" {
+		c.hosts[strings.ToLower(u.Hostname())] = true
+	}
+	for i := range c.eventChs {
+		c.eventChs[i] = make(chan callbackEvent, callbackBuffer)
+	}
+
+	return c
+}
+
+// publicTransport is a HTTP transport refusing to connect to private,
+// loopback and link-local addresses, checked once the host is resolved
+// so that a host name cannot point it to the internal network
+func publicTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return errCallbackRefused
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return transport
+}
+
+// publicIP reports whether the address can be reached from the internet
+func publicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast()
+}
+
+// notify queues the event for the message callback URL
+// Nothing is posted when there is neither a message nor a default URL
+func (c *callbackNotifier) notify(callbackURL string, ev StatusEvent) {
+	if callbackURL == "" {
+		callbackURL = c.defaultURL
+	}
+	if callbackURL == "" {
+		return
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(ev.ID))
+	select {
+	case c.eventChs[h.Sum32()%callbackWorkers] <- callbackEvent{url: callbackURL, event: ev}:
+	default:
+		metrics.Add("callbacks_dropped", 1)
+		slog.Warn("Dropped status event, too many are waiting to be posted", "message_id", ev.ID, "url", callbackURL)
+	}
+}
+
+// run posts the queued events with callbackWorkers workers
+func (c *callbackNotifier) run() {
+	for _, eventCh := range c.eventChs {
+		go c.work(eventCh)
+	}
+}
+
+// work posts the events of the channel one at a time
+func (c *callbackNotifier) work(eventCh <-chan callbackEvent) {
+	for ce := range eventCh {
+		body, err := c.payload(ce)
+		if err != nil {
+			slog.Error("Could not encode status event", "message_id", ce.event.ID, "error", err)
+			continue
+		}
+
+		backoff := callbackBackoff
+		for attempt := 1; ; attempt++ {
+			err := c.post(ce.url, body)
+			if err == nil {
+				metrics.Add("callbacks_sent", 1)
+				break
+			}
+
+			metrics.Add("callback_errors", 1)
+			if errors.Is(err, errCallbackRefused) {
+				metrics.Add("callbacks_refused", 1)
+				slog.Warn("Refused to post status event", "message_id", ce.event.ID, "url", ce.url, "error", err)
+				break
+			}
+			if attempt == callbackAttempts {
+				slog.Error("Gave up posting status event", "message_id", ce.event.ID, "url", ce.url, "error", err)
+				break
+			}
+
+			wait := make(chan struct{})
+			c.clock.AfterFunc(backoff, func() { close(wait) })
+			<-wait
+			backoff *= 2
+		}
+	}
+}
+
+// payload returns the body of the event, shaped by the template of
+// its callback URL when there is one, such as for a Slack webhook
+func (c *callbackNotifier) payload(ce callbackEvent) ([]byte, error) {
+	for _, t := range c.templates {
+		if !strings.HasPrefix(ce.url, t.prefix) {
+			continue
+		}
+
+		var buf bytes.Buffer
+		if err := t.tmpl.Execute(&buf, ce.event); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	return json.Marshal(&ce.event)
+}
+
+// post sends the event body, which must be acknowledged with a 2xx status
+func (c *callbackNotifier) post(callbackURL string, body []byte) error {
+	client := c.publicOnly
+	if u, err := url.Parse(callbackURL); err == nil && c.hosts[strings.ToLower(u.Hostname())] {
+		client = c.httpClient
+	}
+
+	res, err := client.Post(callbackURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("callback answered with status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// validCallbackURL reports whether the callback URL is an absolute HTTP(S) URL
+func validCallbackURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
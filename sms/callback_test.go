@@ -0,0 +1,198 @@
+package sms_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/smstest"
+)
+
+func TestServer_statusCallbacks(t *testing.T) {
+	tests := map[string]struct {
+		messageURL bool
+		defaultURL bool
+		private    bool
+		statuses   []string
+		failures   int
+		want       []string
+	}{
+		"Message callback": {
+			messageURL: true,
+			statuses:   []string{"buffered", "delivered"},
+			want:       []string{"sent > buffered", "buffered > delivered"},
+		},
+
+		"Default callback": {
+			defaultURL: true,
+			statuses:   []string{"delivered"},
+			want:       []string{"sent > delivered"},
+		},
+
+		"Status unchanged": {
+			messageURL: true,
+			statuses:   []string{"sent"},
+		},
+
+		"Callback retried": {
+			messageURL: true,
+			statuses:   []string{"delivered"},
+			failures:   1,
+			want:       []string{"sent > delivered"},
+		},
+
+		"No callback": {
+			statuses: []string{"delivered"},
+		},
+
+		"Private callback refused": {
+			messageURL: true,
+			private:    true,
+			statuses:   []string{"delivered"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var mu sync.Mutex
+			failures := tc.failures
+			events := make(chan sms.StatusEvent, 10)
+			receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				if failures > 0 {
+					failures--
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+
+				var ev sms.StatusEvent
+				if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+					t.Errorf("Failed to decode status event: %v", err)
+				}
+				events <- ev
+			}))
+			defer receiver.Close()
+
+			cfg := sms.Config{MessageClient: fakeSender{}}
+			if tc.defaultURL {
+				cfg.CallbackURL = receiver.URL
+			}
+			if !tc.private {
+				cfg.CallbackHosts = []string{"127.0.0.1"}
+			}
+			srv := smstest.NewServer(t, cfg)
+			refused := counter("callbacks_refused")
+
+			payload := `{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`
+			if tc.messageURL {
+				payload = fmt.Sprintf(`{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message", "callback_url": %q}`, receiver.URL)
+			}
+			if w := srv.Send(t, payload); w.Code != http.StatusCreated {
+				t.Fatalf("Status code was %d; want %d", w.Code, http.StatusCreated)
+			}
+
+			for i, status := range tc.statuses {
+				q := url.Values{}
+				q.Set("id", "fake")
+				q.Set("recipient", "31612345678")
+				q.Set("status", status)
+				q.Set("statusDatetime", time.Now().Add(time.Duration(i+1)*time.Minute).Format(time.RFC3339))
+				if w := srv.Do(http.MethodGet, "/webhooks/dlr?"+q.Encode(), ""); w.Code != http.StatusOK {
+					t.Fatalf("Status code was %d; want %d", w.Code, http.StatusOK)
+				}
+			}
+
+			// Failed callbacks are posted again after a while
+			for i := 0; i < tc.failures; i++ {
+				srv.Clock.WaitTimers(t, 1)
+				srv.Clock.Advance(time.Duration(1<<uint(i)) * time.Second)
+			}
+
+			for _, want := range tc.want {
+				select {
+				case ev := <-events:
+					if got := ev.Previous + " > " + ev.Status; got != want || ev.ID != "fake" {
+						t.Errorf("Status event was %+v; want %s", ev, want)
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("No status event posted; want %s", want)
+				}
+			}
+
+			select {
+			case ev := <-events:
+				t.Errorf("Unexpected status event %+v", ev)
+			case <-time.After(20 * time.Millisecond):
+			}
+
+			if tc.private {
+				deadline := time.Now().Add(time.Second)
+				for counter("callbacks_refused") == refused && time.Now().Before(deadline) {
+					time.Sleep(10 * time.Millisecond)
+				}
+				if counter("callbacks_refused") == refused {
+					t.Error("Callback to a private address was not refused")
+				}
+			}
+		})
+	}
+}
+
+func TestServer_createMessageCallbackURL(t *testing.T) {
+	srv := smstest.NewServer(t, sms.Config{})
+
+	w := srv.Send(t, `{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message", "callback_url": "/relative"}`)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Status code was %d; want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+
+	var smsRes sms.Response
+	if err := json.NewDecoder(w.Body).Decode(&smsRes); err != nil {
+		t.Fatalf("Failed to decode json response body: %v", err)
+	}
+	if want := "Invalid parameter (callback_url value is not a HTTP URL)"; smsRes.Error != want {
+		t.Errorf("Error was %q; want %q", smsRes.Error, want)
+	}
+}
+
+func TestServer_statusCallbackTemplate(t *testing.T) {
+	bodies := make(chan string, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies <- string(b)
+	}))
+	defer receiver.Close()
+
+	srv := smstest.NewServer(t, sms.Config{
+		MessageClient: fakeSender{},
+		CallbackURL:   receiver.URL + "/hooks",
+		CallbackTemplates: map[string]string{
+			receiver.URL:          `{"text": {{printf "Message %s to %s is %s" .ID .Recipient .Status | json}}}`,
+			receiver.URL + "/api": `{{.Status}}`,
+		},
+	})
+
+	if w := srv.Send(t, `{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`); w.Code != http.StatusCreated {
+		t.Fatalf("Status code was %d; want %d", w.Code, http.StatusCreated)
+	}
+	if w := srv.Do(http.MethodGet, "/webhooks/dlr?id=fake&recipient=31612345678&status=delivered&statusDatetime="+url.QueryEscape(time.Now().Add(time.Minute).Format(time.RFC3339)), ""); w.Code != http.StatusOK {
+		t.Fatalf("Status code was %d; want %d", w.Code, http.StatusOK)
+	}
+
+	select {
+	case got := <-bodies:
+		if want := `{"text": "Message fake to 31612345678 is delivered"}`; got != want {
+			t.Errorf("Callback body was %s; want %s", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("No status event posted")
+	}
+}
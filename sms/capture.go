@@ -0,0 +1,142 @@
+package sms
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxCaptureBytes bounds each captured request and response body snippet
+const maxCaptureBytes = 512
+
+// headers carrying credentials, never captured as they are
+var redactedHeaders = map[string]bool{
+	"Authorization":        true,
+	"X-Amz-Security-Token": true,
+}
+
+// Exchange is a redacted snippet of a provider request and of its response
+type Exchange struct {
+	Request  string `json:"request"`
+	Response string `json:"response,omitempty"`
+}
+
+type captureKey struct{}
+
+// exchangeCapture collects the exchange of the request carrying it
+type exchangeCapture struct {
+	mu  sync.Mutex
+	req bytes.Buffer
+	res bytes.Buffer
+}
+
+// withCapture returns a context capturing the provider exchange
+// of the requests made with it
+func withCapture(ctx context.Context) (context.Context, *exchangeCapture) {
+	c := &exchangeCapture{}
+	return context.WithValue(ctx, captureKey{}, c), c
+}
+
+// exchange returns what was captured so far
+func (c *exchangeCapture) exchange() *Exchange {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.req.Len() == 0 {
+		return nil
+	}
+
+	return &Exchange{Request: c.req.String(), Response: c.res.String()}
+}
+
+// capturingTransport records the exchange of the requests
+// whose context carries a capture and passes the others through
+type capturingTransport struct {
+	next http.RoundTripper
+}
+
+func (t *capturingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c, ok := req.Context().Value(captureKey{}).(*exchangeCapture)
+	if !ok {
+		return t.next.RoundTrip(req)
+	}
+
+	var body []byte
+	if req.Body != nil && req.GetBody != nil {
+		if rc, err := req.GetBody(); err == nil {
+			body = make([]byte, maxCaptureBytes)
+			n, _ := io.ReadFull(rc, body)
+			body = body[:n]
+			rc.Close()
+		}
+	}
+
+	c.mu.Lock()
+	c.req.Reset()
+	c.res.Reset()
+	fmt.Fprintf(&c.req, "%s %s\n", req.Method, req.URL.RequestURI())
+	writeHeaders(&c.req, req.Header)
+	c.req.WriteString("\n")
+	c.req.Write(body)
+	c.mu.Unlock()
+
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	fmt.Fprintf(&c.res, "%s\n", res.Status)
+	writeHeaders(&c.res, res.Header)
+	c.res.WriteString("\n")
+	c.mu.Unlock()
+
+	res.Body = &captureBody{ReadCloser: res.Body, c: c, left: maxCaptureBytes}
+
+	return res, nil
+}
+
+// writeHeaders writes the headers in a stable order, with credentials redacted
+func writeHeaders(w io.Writer, h http.Header) {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := strings.Join(h[name], ", ")
+		if redactedHeaders[name] {
+			value = "[redacted]"
+		}
+		fmt.Fprintf(w, "%s: %s\n", name, value)
+	}
+}
+
+// captureBody records the beginning of a response body as it is read
+type captureBody struct {
+	io.ReadCloser
+	c    *exchangeCapture
+	left int
+}
+
+func (b *captureBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && b.left > 0 {
+		keep := n
+		if keep > b.left {
+			keep = b.left
+		}
+		b.c.mu.Lock()
+		b.c.res.Write(p[:keep])
+		b.c.mu.Unlock()
+		b.left -= keep
+	}
+
+	return n, err
+}
@@ -0,0 +1,55 @@
+package sms
+
+import (
+	"context"
+	"fmt"
+)
+
+// Channels the messages are delivered over
+const (
+	channelSMS      = "sms"
+	channelVoice    = "voice"
+	channelWhatsApp = "whatsapp"
+)
+
+// MaxWhatsAppMessageLength is the length limit of WhatsApp text messages
+const MaxWhatsAppMessageLength = 4096
+
+func knownChannel(channel string) bool {
+	switch channel {
+	case channelSMS, channelVoice, channelWhatsApp:
+		return true
+	}
+
+	return false
+}
+
+// supportsChannel reports whether the sender can deliver over the channel
+func supportsChannel(sender MessageSender, channel string) bool {
+	switch channel {
+	case channelVoice:
+		_, ok := sender.(voiceSender)
+		return ok
+	case channelWhatsApp:
+		_, ok := sender.(whatsAppSender)
+		return ok
+	}
+
+	return true
+}
+
+// deliver sends the request through the sender over the channel of the request
+func deliver(ctx context.Context, req *Request, sender MessageSender) (Result, error) {
+	if !supportsChannel(sender, req.Channel) {
+		return Result{}, fmt.Errorf("Provider %s does not support %s messages", providerName(sender), req.Channel)
+	}
+
+	switch req.Channel {
+	case channelVoice:
+		return sender.(voiceSender).CreateVoiceMessage(ctx, req)
+	case channelWhatsApp:
+		return sender.(whatsAppSender).CreateWhatsAppMessage(ctx, req)
+	}
+
+	return sender.CreateMessage(ctx, req)
+}
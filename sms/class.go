@@ -0,0 +1,69 @@
+package sms
+
+import "time"
+
+// Classes of the messages
+// Marketing messages are sent from a queue of their own when one
+// is configured, so that their bursts never delay transactional ones
+const (
+	ClassTransactional = "transactional"
+	ClassMarketing     = "marketing"
+)
+
+// ClassQueue is the configuration of the queue of a message class
+// Its options are those of Config, for the messages of the class only
+type ClassQueue struct {
+	Buffer       int
+	ThrottleRate time.Duration
+	MaxQueueAge  time.Duration
+	Workers      int
+}
+
+// classQueue is the queue of a message class, dispatching its requests
+// at its own rate to its own pool of workers
+type classQueue struct {
+	class        string
+	reqCh        chan *Request
+	buf          int
+	throttleRate time.Duration
+	maxQueueAge  time.Duration
+	workers      int
+	work         chan *Request
+	admission    *admission
+}
+
+func newClassQueue(class string, cfg ClassQueue) *classQueue {
+	workers := cfg.Workers
+	if workers < 1 {
+		workers = DefaultWorkers
+	}
+
+	return &classQueue{
+		class:        class,
+		reqCh:        make(chan *Request, cfg.Buffer),
+		buf:          cfg.Buffer,
+		throttleRate: cfg.ThrottleRate,
+		maxQueueAge:  cfg.MaxQueueAge,
+		workers:      workers,
+		work:         make(chan *Request),
+	}
+}
+
+// knownClass reports whether the class of a request is supported
+func knownClass(class string) bool {
+	return class == "" || class == ClassTransactional || class == ClassMarketing
+}
+
+// queueOf returns the queue of the class of the request, the
+// transactional one unless a marketing queue is configured
+func (s *Server) queueOf(req *Request) *classQueue {
+	return s.queueOfClass(req.Class)
+}
+
+func (s *Server) queueOfClass(class string) *classQueue {
+	if class == ClassMarketing && s.mktQueue != nil {
+		return s.mktQueue
+	}
+
+	return s.txQueue
+}
@@ -1,24 +1,36 @@
 package sms
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 )
 
 const defaultBaseURL = "https://rest.messagebird.com"
 
+// defaultMaxResponseBytes is plenty for any messagebird response
+const defaultMaxResponseBytes = 1 << 20
+
 // MessageCreated is the API mapping for a succesfully created message
 type MessageCreated struct {
-	ID              string            `json:"id"`
-	Originator      string            `json:"originator"`
-	Body            string            `json:"body"`
-	Recipients      MessageRecipients `json:"recipients"`
-	CreatedDateTime time.Time         `json:"createdDatetime"`
+	ID                string            `json:"id"`
+	Originator        string            `json:"originator"`
+	Body              string            `json:"body"`
+	Recipients        MessageRecipients `json:"recipients"`
+	CreatedDateTime   time.Time         `json:"createdDatetime"`
+	ScheduledDateTime *time.Time        `json:"scheduledDatetime"`
 }
 
 // MessageRecipients contains relevant information about every recipient
@@ -31,9 +43,25 @@ type MessageRecipients struct {
 
 // MessageItem containts relevant information for a given recipient
 type MessageItem struct {
-	Recipient      int64     `json:"recipient"`
-	Status         string    `json:"status"`
-	StatusDateTime time.Time `json:"statusDatetime"`
+	Recipient      PhoneNumber `json:"recipient"`
+	Status         string      `json:"status"`
+	StatusDateTime time.Time   `json:"statusDatetime"`
+}
+
+// MessagePage is the API mapping for a page of listed messages
+type MessagePage struct {
+	Offset     int              `json:"offset"`
+	Limit      int              `json:"limit"`
+	Count      int              `json:"count"`
+	TotalCount int              `json:"totalCount"`
+	Items      []MessageCreated `json:"items"`
+}
+
+// Balance is the API mapping for the remaining credit of the account
+type Balance struct {
+	Payment string  `json:"payment"`
+	Type    string  `json:"type"`
+	Amount  float64 `json:"amount"`
 }
 
 // MessageErrors is the errors bag API response for a failed create message action
@@ -48,37 +76,144 @@ type MessageError struct {
 	Parameter   string `json:"parameter"`
 }
 
+// messagebird error codes used to classify the errors
+const (
+	errCodeAccess     = 2
+	errCodeRecipients = 9
+	errCodeParameter  = 10
+	errCodeNotFound   = 20
+)
+
+// errorKind classifies a provider error by status code
+// and falls back to the messagebird error codes
+func errorKind(statusCode int, errs []MessageError) ErrorKind {
+	if kind := statusKind(statusCode); kind != KindUnknown {
+		return kind
+	}
+
+	for _, e := range errs {
+		switch e.Code {
+		case errCodeAccess:
+			return KindAuth
+		case errCodeRecipients, errCodeParameter:
+			return KindValidation
+		}
+	}
+
+	return KindUnknown
+}
+
 // Client sends requests to the SMS API
 type Client struct {
-	accessKey  string
-	baseURL    string
-	httpClient *http.Client
+	accessKey   string
+	baseURL     string
+	compress    bool
+	warmConns   int
+	lenient     bool
+	maxBody     int64
+	bodyTimeout time.Duration
+	convURL     string
+	waChannel   string
+	httpClient  *http.Client
 }
 
 // Options is a collection of client options
+// Provider selects the SMS provider for NewSender and AccountSID is
+// the account of the twilio provider
+// The sns provider signs its requests with AccessKey and SecretKey, or with
+// the credentials found by LoadAWSCredentials, for the AWS Region
+// Compress gzips the request bodies, for providers accepting it
+// DNSCacheTTL enables caching of the provider host name resolution,
+// expiring the answers on Clock, the wall clock by default
+// DialFallbackDelay is the head start of every provider address over the
+// next when dialing, 300ms by default
+// RootCAs replaces the system CA bundle and PinnedKeys restricts the accepted
+// provider certificates to the given public key pins (see SPKIHash)
+// WarmConnections is the number of connections kept open by Warm
+// Lenient accepts provider responses deviating from the API schema
+// as long as something usable can be extracted from them
+// HeaderTimeout and BodyReadTimeout bound the wait for the response headers
+// and the time spent reading the body, on top of the overall Timeout
+// MaxResponseBytes caps how much of a response is read into memory
+// WhatsApp messages are sent from the WhatsAppChannelID channel through
+// the conversations API, found at ConversationsURL
+// HTTP/2 is used with the providers supporting it unless DisableHTTP2,
+// for the proxies breaking it
+// UserAgent replaces the flysms/Version User-Agent and Headers are added to
+// every provider request, such as the API version it expects
+// The sns provider does not sign them, so they must not be X-Amz- headers
 type Options struct {
-	AccessKey string
-	BaseURL   string
-	Timeout   time.Duration
+	Provider          string
+	AccountSID        string
+	AccessKey         string
+	SecretKey         string
+	Region            string
+	BaseURL           string
+	Timeout           time.Duration
+	Compress          bool
+	DNSCacheTTL       time.Duration
+	DialTimeout       time.Duration
+	DialFallbackDelay time.Duration
+	LocalAddr         net.IP
+	RootCAs           *x509.CertPool
+	PinnedKeys        []string
+	WarmConnections   int
+	Lenient           bool
+	HeaderTimeout     time.Duration
+	BodyReadTimeout   time.Duration
+	MaxResponseBytes  int64
+	ConversationsURL  string
+	WhatsAppChannelID string
+	DisableHTTP2      bool
+	UserAgent         string
+	Headers           map[string]string
+	Clock             Clock
 }
 
 // NewClient creates a new client from the given options
 func NewClient(opts Options) *Client {
+	baseURL := opts.BaseURL
+	if baseURL == "" {
+		baseURL = defaultBaseURL
+	}
+
 	return &Client{
-		accessKey: opts.AccessKey,
-		baseURL:   opts.BaseURL,
+		accessKey:   opts.AccessKey,
+		baseURL:     baseURL,
+		compress:    opts.Compress,
+		warmConns:   opts.WarmConnections,
+		lenient:     opts.Lenient,
+		maxBody:     opts.MaxResponseBytes,
+		bodyTimeout: opts.BodyReadTimeout,
+		convURL:     opts.ConversationsURL,
+		waChannel:   opts.WhatsAppChannelID,
 		httpClient: &http.Client{
-			Timeout: opts.Timeout,
+			Timeout:   opts.Timeout,
+			Transport: newTransport(opts),
 		},
 	}
 }
 
+// Name identifies the provider of the client
+func (c *Client) Name() string {
+	return ProviderMessageBird
+}
+
+// scheduleMessages marks messagebird as holding scheduled messages
+func (c *Client) scheduleMessages() {}
+
+// flashMessages marks messagebird as delivering flash messages
+func (c *Client) flashMessages() {}
+
+// binaryMessages marks messagebird as delivering binary messages
+func (c *Client) binaryMessages() {}
+
+// concatenateMessages marks messagebird as delivering the parts of split messages
+func (c *Client) concatenateMessages() {}
+
 // URL computes the full path using the base URL
+// It only reads the client, as Warm calls it from concurrent goroutines
 func (c *Client) URL(path string) string {
-	if c.baseURL == "" {
-		c.baseURL = defaultBaseURL
-	}
-
 	if !strings.HasPrefix(path, "/") {
 		path = "/" + path
 	}
@@ -86,52 +221,437 @@ func (c *Client) URL(path string) string {
 	return fmt.Sprintf("%s%s", c.baseURL, path)
 }
 
+// Warm opens the configured number of connections to the provider
+// so that the next messages do not pay for the TCP and TLS handshakes
+// The connections are left idle in the pool of the client
+func (c *Client) Warm() {
+	var wg sync.WaitGroup
+
+	// Concurrent HTTP/1.1 requests cannot share a connection, while
+	// HTTP/2 ones are multiplexed over a single one
+	for i := 0; i < c.warmConns; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req, err := http.NewRequest(http.MethodHead, c.URL("/"), nil)
+			if err != nil {
+				slog.Error("Could not create warm up request", "error", err)
+				return
+			}
+
+			res, err := c.httpClient.Do(req)
+			if err != nil {
+				metrics.Add("warm_failures", 1)
+				slog.Warn("Could not warm up provider connection", "error", err)
+				return
+			}
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+		}()
+	}
+
+	wg.Wait()
+}
+
+// KeepWarm warms the connections right away and then at every interval,
+// which should be shorter than the idle connection timeout,
+// until the returned stop function is called
+func (c *Client) KeepWarm(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			c.Warm()
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// CreateMessage sends the message through messagebird
+// The request is abandoned as soon as the given context is done
+func (c *Client) CreateMessage(ctx context.Context, r *Request) (Result, error) {
+	msg, statusCode, err := c.createMessage(ctx, r)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return messageResult(statusCode, msg), nil
+}
+
+// messageResult reports a messagebird message with its first recipient
+func messageResult(statusCode int, msg MessageCreated) Result {
+	res := Result{
+		StatusCode: statusCode,
+		ID:         msg.ID,
+		Recipient:  msg.Recipients.Items[0].Recipient,
+		Originator: msg.Originator,
+		Message:    msg.Body,
+		Status:     msg.Recipients.Items[0].Status,
+		Created:    msg.CreatedDateTime,
+	}
+	if msg.ScheduledDateTime != nil {
+		res.Scheduled = *msg.ScheduledDateTime
+	}
+
+	return res
+}
+
 // createMessage sends the API request to messagebird
-func (c *Client) createMessage(r *Request) (interface{}, int, error) {
+func (c *Client) createMessage(ctx context.Context, r *Request) (MessageCreated, int, error) {
 	v := url.Values{}
-	v.Set("recipients", fmt.Sprintf("%d", r.Recipient))
+	v.Set("recipients", r.Recipient.msisdn())
 	v.Set("originator", r.Originator)
 	v.Set("body", r.Message)
+	switch r.Type {
+	case messageTypeFlash:
+		v.Set("mclass", "0")
+	case messageTypeBinary:
+		v.Set("type", messageTypeBinary)
+	}
+	if r.UDH != "" {
+		v.Set("typeDetails[udh]", r.UDH)
+	}
+	if r.UDH != "" && r.Type != messageTypeBinary {
+		// The parts of split messages are sized for their encoding
+		// which messagebird must not pick differently
+		if _, ok := gsmLength(r.Message); ok {
+			v.Set("datacoding", "plain")
+		} else {
+			v.Set("datacoding", "unicode")
+		}
+	}
+	start := time.Now()
+	if r.ScheduledAt != nil {
+		v.Set("scheduledDatetime", r.ScheduledAt.Format(time.RFC3339))
+		start = *r.ScheduledAt
+	}
+	if r.DeliverBy != nil {
+		// Let the carriers drop the message once the deadline is gone
+		if validity := r.DeliverBy.Sub(start) / time.Second; validity > 0 {
+			v.Set("validity", fmt.Sprintf("%d", validity))
+		}
+	}
 
-	endpoint := c.URL("messages")
-	payload := strings.NewReader(v.Encode())
+	return c.postMessage(ctx, r, "messages", v)
+}
 
-	req, err := http.NewRequest(http.MethodPost, endpoint, payload)
+// CreateVoiceMessage makes a POST request to the messagebird voice messages
+// API, which calls the recipient and reads the message out loud
+func (c *Client) CreateVoiceMessage(ctx context.Context, r *Request) (Result, error) {
+	v := url.Values{}
+	v.Set("recipients", r.Recipient.msisdn())
+	v.Set("originator", r.Originator)
+	v.Set("body", r.Message)
+	if r.Language != "" {
+		v.Set("language", r.Language)
+	}
+	if r.Voice != "" {
+		v.Set("voice", r.Voice)
+	}
+	if r.ScheduledAt != nil {
+		v.Set("scheduledDatetime", r.ScheduledAt.Format(time.RFC3339))
+	}
+
+	msg, statusCode, err := c.postMessage(ctx, r, "voicemessages", v)
 	if err != nil {
-		return nil, http.StatusInternalServerError, fmt.Errorf("Could not create POST request for url %s; Error: %v", endpoint, err)
+		return Result{}, err
+	}
+
+	return messageResult(statusCode, msg), nil
+}
+
+// postMessage posts the form of the message to the API path
+func (c *Client) postMessage(ctx context.Context, r *Request, path string, v url.Values) (MessageCreated, int, error) {
+	endpoint := c.URL(path)
+	payload, err := c.payload(v.Encode())
+	if err != nil {
+		return MessageCreated{}, http.StatusInternalServerError, fmt.Errorf("Could not compress payload for url %s; Error: %v", endpoint, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, payload)
+	if err != nil {
+		return MessageCreated{}, http.StatusInternalServerError, fmt.Errorf("Could not create POST request for url %s; Error: %v", endpoint, err)
 	}
-	req.Header.Set("Authorization", fmt.Sprintf("AccessKey %s", c.accessKey))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if c.compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
 
-	res, err := c.httpClient.Do(req)
+	statusCode, body, err := c.do(ctx, req)
 	if err != nil {
-		return nil, http.StatusInternalServerError, fmt.Errorf("Could not get response for request %#v; Error: %v", req, err)
+		return MessageCreated{}, http.StatusInternalServerError, err
 	}
 
-	body, err := ioutil.ReadAll(res.Body)
+	msg, err := c.decodeMessage(r, statusCode, body)
 	if err != nil {
-		return nil, http.StatusInternalServerError, fmt.Errorf("Could not read response body %#v; Error: %v", res, err)
+		return MessageCreated{}, statusCode, err
 	}
+
+	return msg, statusCode, nil
+}
+
+// viewMessage fetches the current state of a message from messagebird
+func (c *Client) viewMessage(ctx context.Context, id string) (Result, error) {
+	endpoint := c.URL("messages/" + url.PathEscape(id))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return Result{}, fmt.Errorf("Could not create GET request for url %s; Error: %v", endpoint, err)
+	}
+
+	statusCode, body, err := c.do(ctx, req)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// The recipient is only needed by lenient decoding, which marks it unknown
+	msg, err := c.decodeMessage(&Request{}, statusCode, body)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return messageResult(statusCode, msg), nil
+}
+
+// cancelMessage deletes the message, which stops it from being sent
+// if it was scheduled
+func (c *Client) cancelMessage(ctx context.Context, id string) error {
+	endpoint := c.URL("messages/" + url.PathEscape(id))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("Could not create DELETE request for url %s; Error: %v", endpoint, err)
+	}
+
+	statusCode, body, err := c.do(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	if statusCode != http.StatusNoContent {
+		return c.decodeErrors(statusCode, body)
+	}
+
+	return nil
+}
+
+// listMessages fetches a page of the most recent messages from messagebird
+// It also returns the total number of messages
+func (c *Client) listMessages(ctx context.Context, limit, offset int) ([]Result, int, error) {
+	q := url.Values{}
+	q.Set("limit", fmt.Sprintf("%d", limit))
+	q.Set("offset", fmt.Sprintf("%d", offset))
+	endpoint := c.URL("messages") + "?" + q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Could not create GET request for url %s; Error: %v", endpoint, err)
+	}
+
+	statusCode, body, err := c.do(ctx, req)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	page, err := c.decodeList(statusCode, body)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	results := make([]Result, 0, len(page.Items))
+	for _, msg := range page.Items {
+		results = append(results, messageResult(statusCode, msg))
+	}
+
+	return results, page.TotalCount, nil
+}
+
+// balance fetches the remaining credit of the account from messagebird
+func (c *Client) balance(ctx context.Context) (Balance, error) {
+	endpoint := c.URL("balance")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return Balance{}, fmt.Errorf("Could not create GET request for url %s; Error: %v", endpoint, err)
+	}
+
+	statusCode, body, err := c.do(ctx, req)
+	if err != nil {
+		return Balance{}, err
+	}
+
+	if statusCode < 200 || statusCode >= 300 {
+		return Balance{}, c.decodeErrors(statusCode, body)
+	}
+
+	var b Balance
+	if err := json.Unmarshal(body, &b); err != nil {
+		return b, &ContractError{StatusCode: statusCode, Body: body, Reason: fmt.Sprintf("invalid balance JSON: %v", err)}
+	}
+	if b.Type == "" && !c.lenient {
+		return b, &ContractError{StatusCode: statusCode, Body: body, Reason: "balance has no type"}
+	}
+
+	return b, nil
+}
+
+// do sends the API request to messagebird and reads the response body
+// The request is abandoned as soon as the given context is done
+func (c *Client) do(ctx context.Context, req *http.Request) (int, []byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", fmt.Sprintf("AccessKey %s", c.accessKey))
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, nil, newRequestError(req, false, err)
+	}
+
 	defer res.Body.Close()
 
-	var data interface{}
-	var msgSuccess MessageCreated
+	// The body gets its own deadline once the headers arrived
+	if c.bodyTimeout > 0 {
+		timer := time.AfterFunc(c.bodyTimeout, cancel)
+		defer timer.Stop()
+	}
+
+	maxBody := c.maxBody
+	if maxBody <= 0 {
+		maxBody = defaultMaxResponseBytes
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, maxBody+1))
+	if err != nil {
+		return 0, nil, newRequestError(req, true, err)
+	}
+
+	if int64(len(body)) > maxBody {
+		// Only keep the beginning of the body for troubleshooting
+		if len(body) > 512 {
+			body = body[:512]
+		}
+		return 0, nil, &ContractError{
+			StatusCode: res.StatusCode,
+			Body:       body,
+			Reason:     fmt.Sprintf("response body is larger than %d bytes", maxBody),
+		}
+	}
+
+	return res.StatusCode, body, nil
+}
+
+// decodeMessage interprets the body of a create or view message response
+// Successful status codes must carry a created message and the other ones
+// an errors bag, which is returned as a *ProviderError
+// A body not matching the API schema results in a *ContractError,
+// unless the client is lenient and something usable can be extracted
+func (c *Client) decodeMessage(r *Request, statusCode int, body []byte) (MessageCreated, error) {
+	if statusCode < 200 || statusCode >= 300 {
+		return MessageCreated{}, c.decodeErrors(statusCode, body)
+	}
+
+	var msg MessageCreated
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return msg, &ContractError{StatusCode: statusCode, Body: body, Reason: fmt.Sprintf("invalid message JSON: %v", err)}
+	}
+
+	if reason := c.checkMessage(&msg, r.Recipient, "created message"); reason != "" {
+		return msg, &ContractError{StatusCode: statusCode, Body: body, Reason: reason}
+	}
+
+	return msg, nil
+}
+
+// decodeList interprets the body of a list messages response
+func (c *Client) decodeList(statusCode int, body []byte) (MessagePage, error) {
+	if statusCode < 200 || statusCode >= 300 {
+		return MessagePage{}, c.decodeErrors(statusCode, body)
+	}
+
+	var page MessagePage
+	if err := json.Unmarshal(body, &page); err != nil {
+		return page, &ContractError{StatusCode: statusCode, Body: body, Reason: fmt.Sprintf("invalid message list JSON: %v", err)}
+	}
+
+	for i := range page.Items {
+		if reason := c.checkMessage(&page.Items[i], "", "listed message"); reason != "" {
+			return page, &ContractError{StatusCode: statusCode, Body: body, Reason: reason}
+		}
+	}
+
+	return page, nil
+}
+
+// checkMessage tells what is wrong with a decoded message, if anything
+// In lenient mode a missing recipient item is replaced by the given
+// recipient with an unknown status
+func (c *Client) checkMessage(msg *MessageCreated, recipient PhoneNumber, what string) string {
+	if msg.ID == "" {
+		return what + " has no id"
+	}
+
+	if len(msg.Recipients.Items) == 0 {
+		if !c.lenient {
+			return what + " has no recipient items"
+		}
+		msg.Recipients.Items = []MessageItem{
+			{Recipient: recipient, Status: "unknown"},
+		}
+	}
+
+	return ""
+}
+
+// decodeErrors interprets the body of an error response as a *ProviderError
+func (c *Client) decodeErrors(statusCode int, body []byte) error {
 	var msgFail MessageErrors
+	if err := json.Unmarshal(body, &msgFail); err != nil && !c.lenient {
+		return &ContractError{StatusCode: statusCode, Body: body, Reason: fmt.Sprintf("invalid errors JSON: %v", err)}
+	}
 
-	if err := json.Unmarshal(body, &msgSuccess); err != nil {
-		return nil, http.StatusInternalServerError, fmt.Errorf("Failed to unmarshal body into JSON %s; Error: %v", string(body), err)
+	if len(msgFail.Errors) == 0 {
+		if !c.lenient {
+			return &ContractError{StatusCode: statusCode, Body: body, Reason: "error response has no errors"}
+		}
+		msgFail.Errors = []MessageError{
+			{Description: fmt.Sprintf("Unknown provider error (status %d)", statusCode)},
+		}
 	}
 
-	if msgSuccess.ID != "" {
-		data = msgSuccess
-		return data, res.StatusCode, nil
+	return &ProviderError{
+		Kind:       errorKind(statusCode, msgFail.Errors),
+		StatusCode: statusCode,
+		Errors:     msgFail.Errors,
 	}
+}
 
-	if err := json.Unmarshal(body, &msgFail); err != nil {
-		return nil, http.StatusInternalServerError, fmt.Errorf("Failed to unmarshal body into JSON %s; Error: %v", string(body), err)
+// payload prepares the request body, gzipped when compression is enabled
+// Compressed responses are handled transparently by the HTTP transport
+func (c *Client) payload(body string) (io.Reader, error) {
+	if !c.compress {
+		return strings.NewReader(body), nil
 	}
 
-	data = msgFail
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(body)); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
 
-	return data, res.StatusCode, nil
+	return &buf, nil
 }
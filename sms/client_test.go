@@ -1,9 +1,21 @@
 package sms_test
 
 import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/fixtures"
 )
 
 func TestClient_URL(t *testing.T) {
@@ -51,3 +63,737 @@ func TestClient_URL(t *testing.T) {
 		})
 	}
 }
+
+func TestClient_URLConcurrent(t *testing.T) {
+	// Warm computes the URL from as many goroutines as connections,
+	// which the race detector checks here
+	client := sms.NewClient(sms.Options{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got, want := client.URL("/"), "https://rest.messagebird.com/"; got != want {
+				t.Errorf("URL(%q) = %q; want %q", "/", got, want)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestClient_DNSCache(t *testing.T) {
+
+	testServer := sms.NewTestServer(t, "server_key")
+	defer testServer.Close()
+
+	// Every request has to dial a new connection
+	testServer.Config.SetKeepAlivesEnabled(false)
+
+	// Use a host name so that the resolver gets involved
+	baseURL := strings.Replace(testServer.URL, "127.0.0.1", "localhost", 1)
+
+	srv := sms.NewServer(sms.Config{
+		Buffer:       10,
+		ReqTimeout:   5 * time.Second,
+		ThrottleRate: 50 * time.Millisecond,
+		MessageClient: sms.NewClient(sms.Options{
+			BaseURL:     baseURL,
+			AccessKey:   "server_key",
+			Timeout:     10 * time.Second,
+			DNSCacheTTL: time.Minute,
+		}),
+	})
+	srv.Run()
+
+	lookups, hits := counter("dns_lookups"), counter("dns_cache_hits")
+
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(`{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`))
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Request %d: status code was %d; want %d", i, w.Code, http.StatusCreated)
+		}
+	}
+
+	if got := counter("dns_lookups") - lookups; got != 1 {
+		t.Errorf("DNS lookups increased by %d; want 1", got)
+	}
+	if got := counter("dns_cache_hits") - hits; got != 2 {
+		t.Errorf("DNS cache hits increased by %d; want 2", got)
+	}
+}
+
+func TestClient_TLS(t *testing.T) {
+
+	testServer := sms.NewTLSTestServer(t, "server_key")
+	defer testServer.Close()
+
+	trusted := x509.NewCertPool()
+	trusted.AddCert(testServer.Certificate())
+
+	tests := map[string]struct {
+		rootCAs    *x509.CertPool
+		pins       []string
+		wantStatus int
+	}{
+		"Unknown certificate authority": {
+			wantStatus: http.StatusInternalServerError,
+		},
+
+		"Trusted certificate authority": {
+			rootCAs:    trusted,
+			wantStatus: http.StatusCreated,
+		},
+
+		"Pinned public key": {
+			rootCAs:    trusted,
+			pins:       []string{"bm90IHRoZSBwaW4=", sms.SPKIHash(testServer.Certificate())},
+			wantStatus: http.StatusCreated,
+		},
+
+		"Public key not pinned": {
+			rootCAs:    trusted,
+			pins:       []string{"bm90IHRoZSBwaW4="},
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := sms.NewServer(sms.Config{
+				Buffer:       10,
+				ReqTimeout:   5 * time.Second,
+				ThrottleRate: 50 * time.Millisecond,
+				MessageClient: sms.NewClient(sms.Options{
+					BaseURL:    testServer.URL,
+					AccessKey:  "server_key",
+					Timeout:    10 * time.Second,
+					RootCAs:    tc.rootCAs,
+					PinnedKeys: tc.pins,
+				}),
+			})
+			srv.Run()
+
+			r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(`{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`))
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			if w.Code != tc.wantStatus {
+				t.Errorf("Status code was %d; want %d", w.Code, tc.wantStatus)
+			}
+		})
+	}
+}
+
+func TestClient_LocalAddr(t *testing.T) {
+	remote := make(chan string, 1)
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		remote <- host
+		fixtures.MessageCreated.ServeHTTP(w, r)
+	}))
+	defer testServer.Close()
+
+	tests := map[string]struct {
+		localAddr  net.IP
+		wantStatus int
+		wantRemote string
+	}{
+		"Any local address": {
+			wantStatus: http.StatusCreated,
+			wantRemote: "127.0.0.1",
+		},
+
+		"Bound local address": {
+			// The whole 127.0.0.0/8 block is assigned to the loopback interface
+			localAddr:  net.ParseIP("127.0.0.2"),
+			wantStatus: http.StatusCreated,
+			wantRemote: "127.0.0.2",
+		},
+
+		"Address not assigned to the host": {
+			localAddr:  net.ParseIP("192.0.2.1"),
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := sms.NewServer(sms.Config{
+				Buffer:       10,
+				ReqTimeout:   5 * time.Second,
+				ThrottleRate: 50 * time.Millisecond,
+				MessageClient: sms.NewClient(sms.Options{
+					BaseURL:   testServer.URL,
+					Timeout:   10 * time.Second,
+					LocalAddr: tc.localAddr,
+				}),
+			})
+			srv.Run()
+
+			payload := fmt.Sprintf(`{"recipient":%d, "originator": %q, "message": "This is a test message"}`, fixtures.Recipient, fixtures.Originator)
+			r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(payload))
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			if w.Code != tc.wantStatus {
+				t.Fatalf("Status code was %d; want %d", w.Code, tc.wantStatus)
+			}
+			if tc.wantRemote == "" {
+				return
+			}
+			if got := <-remote; got != tc.wantRemote {
+				t.Errorf("Provider saw the request coming from %s; want %s", got, tc.wantRemote)
+			}
+		})
+	}
+}
+
+func TestInterfaceAddr(t *testing.T) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatalf("Could not list the network interfaces; Error: %v", err)
+	}
+
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback == 0 {
+			continue
+		}
+		ip, err := sms.InterfaceAddr(iface.Name)
+		if err != nil {
+			t.Fatalf("InterfaceAddr(%q) failed; Error: %v", iface.Name, err)
+		}
+		if !ip.IsLoopback() {
+			t.Errorf("InterfaceAddr(%q) = %s; want a loopback address", iface.Name, ip)
+		}
+	}
+
+	if _, err := sms.InterfaceAddr("no-such-interface"); err == nil {
+		t.Error("InterfaceAddr of an unknown interface did not fail")
+	}
+}
+
+func TestClient_Warm(t *testing.T) {
+	var mu sync.Mutex
+	conns := 0
+
+	testServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Keep the requests in flight together so that each needs a connection
+		time.Sleep(50 * time.Millisecond)
+	}))
+	testServer.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			conns++
+			mu.Unlock()
+		}
+	}
+	testServer.Start()
+	defer testServer.Close()
+
+	client := sms.NewClient(sms.Options{
+		BaseURL:         testServer.URL,
+		Timeout:         10 * time.Second,
+		WarmConnections: 4,
+	})
+
+	client.Warm()
+	client.Warm()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if conns != 4 {
+		t.Errorf("Warm opened %d connections; want 4", conns)
+	}
+}
+
+func TestClient_HTTP2(t *testing.T) {
+	tests := map[string]struct {
+		disable   bool
+		wantProto int
+	}{
+		"HTTP/2": {
+			wantProto: 2,
+		},
+		"HTTP/2 disabled": {
+			disable:   true,
+			wantProto: 1,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			protos := make(chan int, 2)
+			testServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				protos <- r.ProtoMajor
+			}))
+			testServer.EnableHTTP2 = true
+			testServer.StartTLS()
+			defer testServer.Close()
+
+			trusted := x509.NewCertPool()
+			trusted.AddCert(testServer.Certificate())
+			client := sms.NewClient(sms.Options{
+				BaseURL:         testServer.URL,
+				Timeout:         10 * time.Second,
+				RootCAs:         trusted,
+				WarmConnections: 1,
+				DisableHTTP2:    tc.disable,
+			})
+
+			reused := counter("provider_conns_reused")
+			requests := counter(fmt.Sprintf("provider_requests_http%d", tc.wantProto))
+			client.Warm()
+			client.Warm()
+
+			for i := 0; i < 2; i++ {
+				if proto := <-protos; proto != tc.wantProto {
+					t.Errorf("Request %d was HTTP/%d; want HTTP/%d", i+1, proto, tc.wantProto)
+				}
+			}
+			if n := counter("provider_conns_reused") - reused; n != 1 {
+				t.Errorf("Connections reused were %d; want 1", n)
+			}
+			if n := counter(fmt.Sprintf("provider_requests_http%d", tc.wantProto)) - requests; n != 2 {
+				t.Errorf("HTTP/%d requests were %d; want 2", tc.wantProto, n)
+			}
+		})
+	}
+}
+
+func TestClient_contractViolations(t *testing.T) {
+	tests := map[string]struct {
+		statusCode int
+		body       string
+		lenient    bool
+		wantStatus int
+		wantError  string
+	}{
+		"Body is not JSON": {
+			statusCode: http.StatusOK,
+			body:       `<html>Service Unavailable</html>`,
+			wantStatus: http.StatusBadGateway,
+			wantError:  "Bad gateway (provider contract violation)",
+		},
+
+		"Body is not JSON in lenient mode": {
+			statusCode: http.StatusOK,
+			body:       `<html>Service Unavailable</html>`,
+			lenient:    true,
+			wantStatus: http.StatusBadGateway,
+			wantError:  "Bad gateway (provider contract violation)",
+		},
+
+		"Created message without recipients": {
+			statusCode: http.StatusCreated,
+			body:       `{"id": "abc", "originator": "MessageBird", "body": "This is a test message"}`,
+			wantStatus: http.StatusBadGateway,
+			wantError:  "Bad gateway (provider contract violation)",
+		},
+
+		"Created message without recipients in lenient mode": {
+			statusCode: http.StatusCreated,
+			body:       `{"id": "abc", "originator": "MessageBird", "body": "This is a test message"}`,
+			lenient:    true,
+			wantStatus: http.StatusCreated,
+		},
+
+		"Neither message nor errors": {
+			statusCode: http.StatusServiceUnavailable,
+			body:       `{}`,
+			wantStatus: http.StatusBadGateway,
+			wantError:  "Bad gateway (provider contract violation)",
+		},
+
+		"Neither message nor errors in lenient mode": {
+			statusCode: http.StatusServiceUnavailable,
+			body:       `{}`,
+			lenient:    true,
+			wantStatus: http.StatusServiceUnavailable,
+			wantError:  "Unknown provider error (status 503)",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.statusCode)
+				w.Write([]byte(tc.body))
+			}))
+			defer provider.Close()
+
+			srv := sms.NewServer(sms.Config{
+				Buffer:       10,
+				ReqTimeout:   5 * time.Second,
+				ThrottleRate: 50 * time.Millisecond,
+				MessageClient: sms.NewClient(sms.Options{
+					BaseURL: provider.URL,
+					Timeout: 10 * time.Second,
+					Lenient: tc.lenient,
+				}),
+			})
+			srv.Run()
+
+			r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(`{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`))
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			if w.Code != tc.wantStatus {
+				t.Errorf("Status code was %d; want %d", w.Code, tc.wantStatus)
+			}
+
+			var smsRes sms.Response
+			if err := json.NewDecoder(w.Body).Decode(&smsRes); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+			if smsRes.Error != tc.wantError {
+				t.Errorf("Error was %q; want %q", smsRes.Error, tc.wantError)
+			}
+		})
+	}
+}
+
+func TestClient_providerErrors(t *testing.T) {
+	tests := map[string]struct {
+		statusCode  int
+		body        string
+		wantStatus  int
+		wantError   string
+		wantCounter string
+	}{
+		"Refused access key": {
+			statusCode:  http.StatusUnauthorized,
+			body:        `{"errors":[{"code":2,"description":"Request not allowed (incorrect access_key)","parameter":"access_key"}]}`,
+			wantStatus:  http.StatusUnauthorized,
+			wantError:   "Request not allowed (incorrect access_key)",
+			wantCounter: "provider_errors_auth",
+		},
+
+		"Refused parameter": {
+			statusCode:  http.StatusUnprocessableEntity,
+			body:        `{"errors":[{"code":10,"description":"originator is invalid","parameter":"originator"}]}`,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantError:   "originator is invalid",
+			wantCounter: "provider_errors_validation",
+		},
+
+		"Rate limited": {
+			statusCode:  http.StatusTooManyRequests,
+			body:        `{"errors":[{"code":429,"description":"Too many requests","parameter":null}]}`,
+			wantStatus:  http.StatusTooManyRequests,
+			wantError:   "Too many requests",
+			wantCounter: "provider_errors_throttled",
+		},
+
+		"Unclassified error": {
+			statusCode:  http.StatusInternalServerError,
+			body:        `{"errors":[{"code":99,"description":"Internal error","parameter":null}]}`,
+			wantStatus:  http.StatusInternalServerError,
+			wantError:   "Internal error",
+			wantCounter: "provider_errors_unknown",
+		},
+
+		"Errors bag with a successful status": {
+			statusCode:  http.StatusOK,
+			body:        `{"errors":[{"code":2,"description":"Request not allowed (incorrect access_key)","parameter":"access_key"}]}`,
+			wantStatus:  http.StatusBadGateway,
+			wantError:   "Bad gateway (provider contract violation)",
+			wantCounter: "contract_violations",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.statusCode)
+				w.Write([]byte(tc.body))
+			}))
+			defer provider.Close()
+
+			srv := sms.NewServer(sms.Config{
+				Buffer:       10,
+				ReqTimeout:   5 * time.Second,
+				ThrottleRate: 50 * time.Millisecond,
+				MessageClient: sms.NewClient(sms.Options{
+					BaseURL: provider.URL,
+					Timeout: 10 * time.Second,
+				}),
+			})
+			srv.Run()
+
+			before := counter(tc.wantCounter)
+
+			r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(`{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`))
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			if w.Code != tc.wantStatus {
+				t.Errorf("Status code was %d; want %d", w.Code, tc.wantStatus)
+			}
+
+			var smsRes sms.Response
+			if err := json.NewDecoder(w.Body).Decode(&smsRes); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+			if smsRes.Error != tc.wantError {
+				t.Errorf("Error was %q; want %q", smsRes.Error, tc.wantError)
+			}
+			if got := counter(tc.wantCounter) - before; got != 1 {
+				t.Errorf("Counter %s increased by %d; want 1", tc.wantCounter, got)
+			}
+		})
+	}
+}
+
+func TestClient_fixtures(t *testing.T) {
+	tests := map[string]struct {
+		fixture    fixtures.Fixture
+		wantStatus int
+		wantError  string
+	}{
+		"Message created": {
+			fixture:    fixtures.MessageCreated,
+			wantStatus: http.StatusCreated,
+		},
+
+		"Validation error": {
+			fixture:    fixtures.ValidationError,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantError:  "no (correct) recipients found",
+		},
+
+		"Auth error": {
+			fixture:    fixtures.AuthError,
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "Request not allowed (incorrect access_key)",
+		},
+
+		"Throttled": {
+			fixture:    fixtures.Throttled,
+			wantStatus: http.StatusTooManyRequests,
+			wantError:  "Too many requests",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			provider := sms.NewFixtureServer(t, tc.fixture)
+			defer provider.Close()
+
+			srv := sms.NewServer(sms.Config{
+				Buffer:       10,
+				ReqTimeout:   5 * time.Second,
+				ThrottleRate: 10 * time.Millisecond,
+				MessageClient: sms.NewClient(sms.Options{
+					BaseURL: provider.URL,
+					Timeout: 10 * time.Second,
+				}),
+			})
+			srv.Run()
+
+			payload := fmt.Sprintf(`{"recipient":%d, "originator": %q, "message": "This is a test message"}`, fixtures.Recipient, fixtures.Originator)
+			r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(payload))
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			if w.Code != tc.wantStatus {
+				t.Fatalf("Status code was %d; want %d", w.Code, tc.wantStatus)
+			}
+
+			var smsRes sms.Response
+			if err := json.NewDecoder(w.Body).Decode(&smsRes); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+			if smsRes.Error != tc.wantError {
+				t.Errorf("Error was %q; want %q", smsRes.Error, tc.wantError)
+			}
+			if tc.wantStatus != http.StatusCreated {
+				return
+			}
+
+			want := sms.Content{
+				ID:         "e8077d803532c0b5937c639b60216938",
+				Recipient:  sms.PhoneNumber(fmt.Sprint(fixtures.Recipient)),
+				Originator: fixtures.Originator,
+				Message:    "This is a test message",
+				Status:     "sent",
+				Created:    "2020-01-01T12:00:00Z",
+			}
+			if smsRes.Data != want {
+				t.Errorf("Message was %+v; want %+v", smsRes.Data, want)
+			}
+		})
+	}
+}
+
+func TestClient_simulatedProvider(t *testing.T) {
+	tests := map[string]struct {
+		opts      sms.TestServerOptions
+		timeout   time.Duration
+		messages  int
+		wantKinds []sms.ErrorKind
+		wantSlow  bool
+		wantFail  bool
+	}{
+		"Within the rate limit": {
+			opts:      sms.TestServerOptions{RequestsPerSecond: 2},
+			timeout:   time.Second,
+			messages:  2,
+			wantKinds: []sms.ErrorKind{sms.KindUnknown, sms.KindUnknown},
+		},
+
+		"Over the rate limit": {
+			opts:      sms.TestServerOptions{RequestsPerSecond: 1},
+			timeout:   time.Second,
+			messages:  2,
+			wantKinds: []sms.ErrorKind{sms.KindUnknown, sms.KindThrottled},
+		},
+
+		"Slow provider": {
+			opts:      sms.TestServerOptions{Latency: 50 * time.Millisecond},
+			timeout:   time.Second,
+			messages:  1,
+			wantKinds: []sms.ErrorKind{sms.KindUnknown},
+			wantSlow:  true,
+		},
+
+		"Provider slower than the timeout": {
+			opts:      sms.TestServerOptions{Latency: 200 * time.Millisecond},
+			timeout:   50 * time.Millisecond,
+			messages:  1,
+			wantKinds: []sms.ErrorKind{sms.KindUnknown},
+			wantFail:  true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			testServer := sms.NewTestServerWith(t, "server_key", tc.opts)
+			defer testServer.Close()
+
+			client := sms.NewClient(sms.Options{
+				BaseURL:   testServer.URL,
+				AccessKey: "server_key",
+				Timeout:   tc.timeout,
+			})
+
+			// Keep all the messages within the same second of the rate limit
+			if d := time.Until(time.Now().Truncate(time.Second).Add(time.Second)); d < 200*time.Millisecond {
+				time.Sleep(d)
+			}
+
+			for i := 0; i < tc.messages; i++ {
+				start := time.Now()
+				req := &sms.Request{Recipient: "31612345678", Originator: "MessageBird", Message: "This is a test message"}
+				_, err := client.CreateMessage(context.Background(), req)
+
+				switch e := err.(type) {
+				case nil:
+					if tc.wantFail || tc.wantKinds[i] != sms.KindUnknown {
+						t.Errorf("Message %d was created; want error kind %s", i+1, tc.wantKinds[i])
+					}
+				case *sms.ProviderError:
+					if e.Kind != tc.wantKinds[i] {
+						t.Errorf("Message %d error kind was %s; want %s", i+1, e.Kind, tc.wantKinds[i])
+					}
+				default:
+					// Only a timeout is expected to fail without a provider error
+					if !tc.wantFail {
+						t.Errorf("Message %d failed; Error: %v", i+1, err)
+					}
+				}
+
+				if took := time.Since(start); tc.wantSlow && took < tc.opts.Latency {
+					t.Errorf("Message %d took %v; want at least %v", i+1, took, tc.opts.Latency)
+				}
+			}
+		})
+	}
+}
+
+func TestClient_responseLimits(t *testing.T) {
+	tests := map[string]struct {
+		handler     http.HandlerFunc
+		opts        sms.Options
+		wantStatus  int
+		wantElapsed time.Duration
+	}{
+		"Response body too large": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"id":"` + strings.Repeat("x", 4096) + `"}`))
+			},
+			opts: sms.Options{
+				MaxResponseBytes: 1024,
+			},
+			wantStatus:  http.StatusBadGateway,
+			wantElapsed: time.Second,
+		},
+
+		"Response body too slow": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"id":`))
+				w.(http.Flusher).Flush()
+				select {
+				case <-time.After(3 * time.Second):
+				case <-r.Context().Done():
+				}
+			},
+			opts: sms.Options{
+				BodyReadTimeout: 200 * time.Millisecond,
+			},
+			wantStatus:  http.StatusInternalServerError,
+			wantElapsed: time.Second,
+		},
+
+		"Response headers too slow": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				ioutil.ReadAll(r.Body)
+				select {
+				case <-time.After(3 * time.Second):
+				case <-r.Context().Done():
+				}
+			},
+			opts: sms.Options{
+				HeaderTimeout: 200 * time.Millisecond,
+			},
+			wantStatus:  http.StatusInternalServerError,
+			wantElapsed: time.Second,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			provider := httptest.NewServer(tc.handler)
+			defer provider.Close()
+
+			tc.opts.BaseURL = provider.URL
+			tc.opts.Timeout = 10 * time.Second
+
+			srv := sms.NewServer(sms.Config{
+				Buffer:        10,
+				ReqTimeout:    5 * time.Second,
+				ThrottleRate:  10 * time.Millisecond,
+				MessageClient: sms.NewClient(tc.opts),
+			})
+			srv.Run()
+
+			start := time.Now()
+
+			r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(`{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`))
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			if w.Code != tc.wantStatus {
+				t.Errorf("Status code was %d; want %d", w.Code, tc.wantStatus)
+			}
+			if elapsed := time.Since(start); elapsed > tc.wantElapsed {
+				t.Errorf("Request took %s; want less than %s", elapsed, tc.wantElapsed)
+			}
+		})
+	}
+}
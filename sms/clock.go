@@ -0,0 +1,69 @@
+package sms
+
+import (
+	"context"
+	"time"
+)
+
+// Clock tells the time to the server and drives its throttling,
+// its delivery deadlines and its timeouts
+// Tests can provide their own clock to control the time
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Ticker delivers the ticks of a clock at regular intervals
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Timer is a function call scheduled on a clock
+type Timer interface {
+	Stop() bool
+}
+
+// realClock is the wall clock
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// withTimeout is context.WithTimeout for the clock of the server
+func (s *Server) withTimeout(parent context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := s.clock.(realClock); ok {
+		return context.WithTimeout(parent, d)
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	timer := s.clock.AfterFunc(d, cancel)
+
+	return ctx, func() {
+		timer.Stop()
+		cancel()
+	}
+}
+
+// since is time.Since for the clock of the server
+func (s *Server) since(t time.Time) time.Duration {
+	return s.clock.Now().Sub(t)
+}
@@ -0,0 +1,363 @@
+package sms_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/smstest"
+)
+
+// blockingSender signals every message it receives
+// and answers once it is released
+type blockingSender struct {
+	received chan *sms.Request
+	release  chan struct{}
+}
+
+func (s blockingSender) CreateMessage(ctx context.Context, r *sms.Request) (sms.Result, error) {
+	s.received <- r
+	select {
+	case <-s.release:
+	case <-ctx.Done():
+		return sms.Result{}, ctx.Err()
+	}
+	return fakeSender{}.CreateMessage(ctx, r)
+}
+
+// abandonedSender signals every message it receives and
+// the error of its context once the call is abandoned
+type abandonedSender struct {
+	received  chan *sms.Request
+	abandoned chan error
+}
+
+func (s abandonedSender) CreateMessage(ctx context.Context, r *sms.Request) (sms.Result, error) {
+	s.received <- r
+	<-ctx.Done()
+	s.abandoned <- ctx.Err()
+	return sms.Result{}, ctx.Err()
+}
+
+func TestServer_clock(t *testing.T) {
+	post := func(srv *sms.Server, payload string) <-chan *httptest.ResponseRecorder {
+		done := make(chan *httptest.ResponseRecorder, 1)
+		go func() {
+			r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(payload))
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+			done <- w
+		}()
+		return done
+	}
+
+	payload := `{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`
+
+	t.Run("Throttled by ticks", func(t *testing.T) {
+		clock := smstest.NewClock(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+		sender := blockingSender{received: make(chan *sms.Request, 2), release: make(chan struct{})}
+		close(sender.release)
+
+		srv := sms.NewServer(sms.Config{
+			Buffer:        10,
+			ReqTimeout:    time.Minute,
+			ThrottleRate:  time.Second,
+			MessageClient: sender,
+			Clock:         clock,
+		})
+		srv.Run()
+
+		first := post(srv, payload)
+		second := post(srv, payload)
+		clock.WaitTimers(t, 2)
+
+		for i := 0; i < 2; i++ {
+			select {
+			case <-sender.received:
+				t.Fatalf("Message %d was sent before the clock ticked", i+1)
+			case <-time.After(20 * time.Millisecond):
+			}
+
+			clock.Advance(time.Second)
+			select {
+			case <-sender.received:
+			case <-time.After(time.Second):
+				t.Fatalf("Message %d was not sent after the clock ticked", i+1)
+			}
+		}
+
+		for _, done := range []<-chan *httptest.ResponseRecorder{first, second} {
+			if w := <-done; w.Code != http.StatusCreated {
+				t.Errorf("Status code was %d; want %d", w.Code, http.StatusCreated)
+			}
+		}
+	})
+
+	t.Run("Warm-up", func(t *testing.T) {
+		clock := smstest.NewClock(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+		sender := blockingSender{received: make(chan *sms.Request, 1), release: make(chan struct{})}
+		close(sender.release)
+
+		srv := sms.NewServer(sms.Config{
+			Buffer:        10,
+			ReqTimeout:    time.Minute,
+			ThrottleRate:  time.Second,
+			WarmUp:        10 * time.Second,
+			MessageClient: sender,
+			Clock:         clock,
+		})
+		srv.Run()
+
+		done := post(srv, payload)
+		clock.WaitTimers(t, 1)
+
+		// A tenth of a message per tick at first, growing with every tick,
+		// adds up to a message on the fourth tick
+		for i := 1; i <= 4; i++ {
+			clock.Advance(time.Second)
+			select {
+			case <-sender.received:
+				if i < 4 {
+					t.Fatalf("Message was sent on tick %d of the warm-up; want tick 4", i)
+				}
+			case <-time.After(50 * time.Millisecond):
+				if i == 4 {
+					t.Fatal("Message was not sent on tick 4 of the warm-up")
+				}
+			}
+		}
+
+		if w := <-done; w.Code != http.StatusCreated {
+			t.Errorf("Status code was %d; want %d", w.Code, http.StatusCreated)
+		}
+	})
+
+	t.Run("Bounded workers", func(t *testing.T) {
+		clock := smstest.NewClock(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+		sender := blockingSender{received: make(chan *sms.Request, 2), release: make(chan struct{})}
+
+		srv := sms.NewServer(sms.Config{
+			Buffer:        10,
+			Workers:       1,
+			ReqTimeout:    time.Minute,
+			ThrottleRate:  time.Second,
+			MessageClient: sender,
+			Clock:         clock,
+		})
+		srv.Run()
+
+		first := post(srv, payload)
+		second := post(srv, payload)
+		clock.WaitTimers(t, 2)
+		clock.Advance(time.Second)
+		<-sender.received
+
+		// The only worker is busy with the first message
+		for i := 0; i < 3; i++ {
+			clock.Advance(time.Second)
+			select {
+			case <-sender.received:
+				t.Fatal("Second message was sent while the worker was busy")
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+
+		// The second message waits for the worker, not for another tick
+		sender.release <- struct{}{}
+		select {
+		case <-sender.received:
+		case <-time.After(time.Second):
+			t.Fatal("Second message was not sent once the worker was free")
+		}
+		sender.release <- struct{}{}
+
+		for _, done := range []<-chan *httptest.ResponseRecorder{first, second} {
+			if w := <-done; w.Code != http.StatusCreated {
+				t.Errorf("Status code was %d; want %d", w.Code, http.StatusCreated)
+			}
+		}
+	})
+
+	t.Run("Request timeout", func(t *testing.T) {
+		clock := smstest.NewClock(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+		sender := blockingSender{received: make(chan *sms.Request, 1), release: make(chan struct{})}
+
+		srv := sms.NewServer(sms.Config{
+			Buffer:        10,
+			ReqTimeout:    5 * time.Second,
+			ThrottleRate:  time.Second,
+			MessageClient: sender,
+			Clock:         clock,
+		})
+		srv.Run()
+
+		done := post(srv, payload)
+		clock.WaitTimers(t, 1)
+		clock.Advance(time.Second)
+		<-sender.received
+		clock.Advance(4 * time.Second)
+
+		w := <-done
+		if w.Code != http.StatusRequestTimeout {
+			t.Fatalf("Status code was %d; want %d", w.Code, http.StatusRequestTimeout)
+		}
+	})
+
+	t.Run("Maximum queue age", func(t *testing.T) {
+		clock := smstest.NewClock(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+		sender := blockingSender{received: make(chan *sms.Request, 1), release: make(chan struct{})}
+		close(sender.release)
+
+		srv := sms.NewServer(sms.Config{
+			Buffer:        10,
+			ReqTimeout:    time.Minute,
+			ThrottleRate:  10 * time.Second,
+			MaxQueueAge:   8 * time.Second,
+			MessageClient: sender,
+			Clock:         clock,
+		})
+		srv.Run()
+
+		stale := post(srv, payload)
+		clock.WaitTimers(t, 1)
+		clock.Advance(3 * time.Second)
+		fresh := post(srv, payload)
+		clock.WaitTimers(t, 2)
+		clock.Advance(7 * time.Second)
+
+		w := <-stale
+		if w.Code != http.StatusGatewayTimeout {
+			t.Fatalf("Status code was %d; want %d", w.Code, http.StatusGatewayTimeout)
+		}
+		var smsRes sms.Response
+		if err := json.NewDecoder(w.Body).Decode(&smsRes); err != nil {
+			t.Fatalf("Failed to decode json response body: %v", err)
+		}
+		if smsRes.Error != "Queue age exceeded (message expired before sending)" {
+			t.Errorf("Error was %q", smsRes.Error)
+		}
+
+		if w := <-fresh; w.Code != http.StatusCreated {
+			t.Errorf("Status code was %d; want %d", w.Code, http.StatusCreated)
+		}
+	})
+
+	t.Run("Class queues", func(t *testing.T) {
+		clock := smstest.NewClock(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+		sender := blockingSender{received: make(chan *sms.Request, 2), release: make(chan struct{})}
+		close(sender.release)
+
+		srv := sms.NewServer(sms.Config{
+			Buffer:         10,
+			ReqTimeout:     5 * time.Minute,
+			ThrottleRate:   time.Second,
+			MarketingQueue: sms.ClassQueue{Buffer: 10, ThrottleRate: time.Minute},
+			MessageClient:  sender,
+			Clock:          clock,
+		})
+		srv.Run()
+
+		marketing := post(srv, `{"recipient":31612345678, "originator": "MessageBird", "message": "Sale!", "class": "marketing"}`)
+		clock.WaitTimers(t, 1)
+		transactional := post(srv, `{"recipient":31612345678, "originator": "MessageBird", "message": "Your order shipped"}`)
+		clock.WaitTimers(t, 2)
+
+		// The marketing message waits for its own, slower queue
+		clock.Advance(time.Second)
+		select {
+		case req := <-sender.received:
+			if req.Message != "Your order shipped" {
+				t.Errorf("Message sent first was %q; want the transactional one", req.Message)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Transactional message was not sent after the clock ticked")
+		}
+		if w := <-transactional; w.Code != http.StatusCreated {
+			t.Errorf("Status code was %d; want %d", w.Code, http.StatusCreated)
+		}
+
+		clock.Advance(time.Minute)
+		if w := <-marketing; w.Code != http.StatusCreated {
+			t.Errorf("Status code was %d; want %d", w.Code, http.StatusCreated)
+		}
+	})
+
+	t.Run("Delivery deadline", func(t *testing.T) {
+		clock := smstest.NewClock(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+		sender := blockingSender{received: make(chan *sms.Request, 1), release: make(chan struct{})}
+
+		srv := sms.NewServer(sms.Config{
+			Buffer:        10,
+			ReqTimeout:    time.Minute,
+			ThrottleRate:  10 * time.Second,
+			MessageClient: sender,
+			Clock:         clock,
+		})
+		srv.Run()
+
+		deliverBy := clock.Now().Add(5 * time.Second).Format(time.RFC3339)
+		done := post(srv, `{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message", "deliver_by": "`+deliverBy+`"}`)
+		clock.WaitTimers(t, 1)
+		clock.Advance(10 * time.Second)
+
+		w := <-done
+		if w.Code != http.StatusGatewayTimeout {
+			t.Fatalf("Status code was %d; want %d", w.Code, http.StatusGatewayTimeout)
+		}
+		var smsRes sms.Response
+		if err := json.NewDecoder(w.Body).Decode(&smsRes); err != nil {
+			t.Fatalf("Failed to decode json response body: %v", err)
+		}
+		if smsRes.Error != "Delivery deadline exceeded (message expired before sending)" {
+			t.Errorf("Error was %q", smsRes.Error)
+		}
+	})
+	t.Run("Caller gone", func(t *testing.T) {
+		clock := smstest.NewClock(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+		sender := abandonedSender{received: make(chan *sms.Request, 1), abandoned: make(chan error, 1)}
+
+		srv := sms.NewServer(sms.Config{
+			Buffer:        10,
+			ReqTimeout:    time.Minute,
+			ThrottleRate:  time.Second,
+			MessageClient: sender,
+			Clock:         clock,
+		})
+		srv.Run()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		done := make(chan *httptest.ResponseRecorder, 1)
+		go func() {
+			r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(payload)).WithContext(ctx)
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+			done <- w
+		}()
+		clock.WaitTimers(t, 1)
+		clock.Advance(time.Second)
+		<-sender.received
+
+		// The provider call is abandoned with the HTTP request, well
+		// before the request timeout
+		abandoned := counter("requests_abandoned")
+		cancel()
+		select {
+		case err := <-sender.abandoned:
+			if err != context.Canceled {
+				t.Errorf("Provider call ended with %v; want %v", err, context.Canceled)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Provider call went on after the caller went away")
+		}
+		<-done
+		if got := counter("requests_abandoned") - abandoned; got != 1 {
+			t.Errorf("Counted %d abandoned requests; want 1", got)
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package sms
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// concurrencyLimiter caps the HTTP requests being processed at once,
+// over all the routes and by route family, the first segment of their
+// path such as "messages" or "admin"
+// Message requests hold their slot while they wait in the queue, so
+// the cap bounds the memory of a connection flood where the queue
+// buffer does not
+type concurrencyLimiter struct {
+	global   *slots
+	families map[string]*slots
+}
+
+// slots counts the requests in progress against a maximum
+type slots struct {
+	max   int32
+	inUse int32
+}
+
+func newConcurrencyLimiter(global int, families map[string]int) *concurrencyLimiter {
+	l := &concurrencyLimiter{families: make(map[string]*slots)}
+	if global > 0 {
+		l.global = &slots{max: int32(global)}
+	}
+	for family, max := range families {
+		if max <= 0 {
+			slog.Warn("Ignored concurrency limit", "family", family, "limit", max)
+			continue
+		}
+		l.families[strings.Trim(family, "/")] = &slots{max: int32(max)}
+	}
+
+	return l
+}
+
+// acquire takes a slot, reporting false when all of them are in use
+func (s *slots) acquire() bool {
+	if s == nil {
+		return true
+	}
+	if atomic.AddInt32(&s.inUse, 1) > s.max {
+		atomic.AddInt32(&s.inUse, -1)
+		return false
+	}
+
+	return true
+}
+
+func (s *slots) release() {
+	if s != nil {
+		atomic.AddInt32(&s.inUse, -1)
+	}
+}
+
+// family returns the slots of the route family of the path, nil when
+// it is not limited
+func (l *concurrencyLimiter) family(path string) *slots {
+	if len(l.families) == 0 {
+		return nil
+	}
+	path = strings.TrimPrefix(path, "/")
+	if i := strings.IndexByte(path, '/'); i >= 0 {
+		path = path[:i]
+	}
+
+	return l.families[path]
+}
+
+// ServeHTTP routes the requests within the concurrency limits, answering
+// 503 to those beyond them, and closes the connections past their lifetime
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	family := s.concurrency.family(r.URL.Path)
+	if !s.concurrency.global.acquire() {
+		s.refuseConcurrent(w)
+		return
+	}
+	defer s.concurrency.global.release()
+	if !family.acquire() {
+		s.refuseConcurrent(w)
+		return
+	}
+	defer family.release()
+
+	// The client is told to reconnect once its connection is too old
+	if s.conns.expired(r) {
+		metrics.Add("connections_expired", 1)
+		w.Header().Set("Connection", "close")
+	}
+
+	s.router.ServeHTTP(w, r)
+}
+
+// refuseConcurrent answers a request beyond the concurrency limits
+func (s *Server) refuseConcurrent(w http.ResponseWriter) {
+	metrics.Add("concurrency_refusals", 1)
+	w.Header()["Retry-After"] = retryAfterOne
+	res := Response{
+		statusCode: http.StatusServiceUnavailable,
+		Error:      "Service unavailable (too many concurrent requests)",
+	}
+	sendResponse(w, res)
+}
+
+// retryAfterOne is the Retry-After header value of the refused requests,
+// shared rather than allocated for every refusal
+var retryAfterOne = []string{"1"}
@@ -0,0 +1,80 @@
+package sms_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/smstest"
+)
+
+func TestServer_concurrencyLimits(t *testing.T) {
+	payload := `{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`
+
+	tests := map[string]struct {
+		cfg       sms.Config
+		wantAdmin int
+	}{
+		"Global": {
+			cfg:       sms.Config{MaxConcurrentRequests: 1},
+			wantAdmin: http.StatusServiceUnavailable,
+		},
+		"Route family": {
+			cfg:       sms.Config{MaxConcurrentRequests: 2, RouteConcurrency: map[string]int{"messages": 1}},
+			wantAdmin: http.StatusOK,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			sender := blockingSender{received: make(chan *sms.Request, 1), release: make(chan struct{})}
+			cfg := tc.cfg
+			cfg.AdminKey = "admin_key"
+			cfg.MessageClient = sender
+			srv := smstest.NewServer(t, cfg)
+
+			// The first message holds its slot until it is sent
+			first := srv.Go(http.MethodPost, "/messages", payload)
+			srv.Clock.WaitTimers(t, 1)
+			srv.Clock.Advance(time.Second)
+			<-sender.received
+
+			w := srv.Do(http.MethodPost, "/messages", payload)
+			if w.Code != http.StatusServiceUnavailable {
+				t.Errorf("Status code was %d; want %d", w.Code, http.StatusServiceUnavailable)
+			}
+			if got := w.Header().Get("Retry-After"); got != "1" {
+				t.Errorf("Retry-After was %q; want %q", got, "1")
+			}
+			var res sms.Response
+			if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+			if want := "Service unavailable (too many concurrent requests)"; res.Error != want {
+				t.Errorf("Error was %q; want %q", res.Error, want)
+			}
+
+			// The other route families have slots of their own
+			r := httptest.NewRequest(http.MethodGet, "/admin/maintenance", nil)
+			r.Header.Set("Authorization", "AdminKey admin_key")
+			aw := httptest.NewRecorder()
+			srv.ServeHTTP(aw, r)
+			if aw.Code != tc.wantAdmin {
+				t.Errorf("Admin status code was %d; want %d", aw.Code, tc.wantAdmin)
+			}
+
+			close(sender.release)
+			if w := <-first; w.Code != http.StatusCreated {
+				t.Errorf("First status code was %d; want %d", w.Code, http.StatusCreated)
+			}
+
+			// The slots are free again once the requests are answered
+			if w := srv.Send(t, payload); w.Code != http.StatusCreated {
+				t.Errorf("Status code after the first was %d; want %d", w.Code, http.StatusCreated)
+			}
+		})
+	}
+}
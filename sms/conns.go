@@ -0,0 +1,178 @@
+package sms
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// connListLimit is the number of clients listed at most, those
+// holding the most connections first
+const connListLimit = 20
+
+// ConnClient is a client of the HTTP listener and the connections it holds
+type ConnClient struct {
+	Client      string    `json:"client"`
+	Connections int       `json:"connections"`
+	Active      int       `json:"active"`
+	Oldest      time.Time `json:"oldest"`
+}
+
+// ConnStats counts the open connections of the HTTP listener
+type ConnStats struct {
+	Open    int          `json:"open"`
+	Active  int          `json:"active"`
+	Idle    int          `json:"idle"`
+	Clients []ConnClient `json:"clients"`
+}
+
+// ConnList is the HTTP response of the connections endpoint
+type ConnList struct {
+	Success bool      `json:"success"`
+	Data    ConnStats `json:"data"`
+}
+
+// connTracker follows the connections of the HTTP listener through
+// their states, so that a client holding many of them shows
+// It is fed by the ConnState and ConnContext hooks of the http.Server
+type connTracker struct {
+	mu          sync.Mutex
+	maxLifetime time.Duration
+	clock       Clock
+	conns       map[net.Conn]*trackedConn
+}
+
+type trackedConn struct {
+	client string
+	opened time.Time
+	state  http.ConnState
+}
+
+type connKey struct{}
+
+func newConnTracker(maxLifetime time.Duration, clock Clock) *connTracker {
+	return &connTracker{
+		maxLifetime: maxLifetime,
+		clock:       clock,
+		conns:       make(map[net.Conn]*trackedConn),
+	}
+}
+
+// ConnState is the http.Server hook tracking the states of the connections
+func (s *Server) ConnState(c net.Conn, state http.ConnState) {
+	s.conns.update(c, state)
+}
+
+// ConnContext is the http.Server hook letting the requests know
+// their connection, which is closed past Config.MaxConnLifetime
+func (s *Server) ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+func (t *connTracker) update(c net.Conn, state http.ConnState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tc, ok := t.conns[c]
+	if !ok {
+		if state != http.StateNew {
+			return
+		}
+		client := c.RemoteAddr().String()
+		if host, _, err := net.SplitHostPort(client); err == nil {
+			client = host
+		}
+		tc = &trackedConn{client: client, opened: t.clock.Now()}
+		t.conns[c] = tc
+		metrics.Add("connections_opened", 1)
+		metrics.Add("connections_open", 1)
+	}
+
+	switch tc.state {
+	case http.StateActive:
+		metrics.Add("connections_active", -1)
+	case http.StateIdle:
+		metrics.Add("connections_idle", -1)
+	}
+	tc.state = state
+
+	switch state {
+	case http.StateActive:
+		metrics.Add("connections_active", 1)
+	case http.StateIdle:
+		metrics.Add("connections_idle", 1)
+	case http.StateHijacked, http.StateClosed:
+		delete(t.conns, c)
+		metrics.Add("connections_open", -1)
+	}
+}
+
+// expired reports whether the connection of the request outlived
+// the maximum lifetime
+func (t *connTracker) expired(r *http.Request) bool {
+	if t.maxLifetime <= 0 {
+		return false
+	}
+	c, ok := r.Context().Value(connKey{}).(net.Conn)
+	if !ok {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tc, ok := t.conns[c]
+	return ok && t.clock.Now().Sub(tc.opened) > t.maxLifetime
+}
+
+// stats counts the connections, overall and by client
+func (t *connTracker) stats() ConnStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats := ConnStats{Clients: []ConnClient{}}
+	clients := make(map[string]*ConnClient)
+	for _, tc := range t.conns {
+		stats.Open++
+		c, ok := clients[tc.client]
+		if !ok {
+			c = &ConnClient{Client: tc.client, Oldest: tc.opened}
+			clients[tc.client] = c
+		}
+		c.Connections++
+		if tc.opened.Before(c.Oldest) {
+			c.Oldest = tc.opened
+		}
+
+		switch tc.state {
+		case http.StateActive:
+			stats.Active++
+			c.Active++
+		case http.StateIdle:
+			stats.Idle++
+		}
+	}
+
+	for _, c := range clients {
+		stats.Clients = append(stats.Clients, *c)
+	}
+	sort.Slice(stats.Clients, func(i, j int) bool {
+		a, b := stats.Clients[i], stats.Clients[j]
+		return a.Connections > b.Connections || (a.Connections == b.Connections && a.Client < b.Client)
+	})
+	if len(stats.Clients) > connListLimit {
+		stats.Clients = stats.Clients[:connListLimit]
+	}
+
+	return stats
+}
+
+// listConnections is the HTTP handler counting the open connections
+func (s *Server) listConnections() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sendJSON(w, http.StatusOK, ConnList{Success: true, Data: s.conns.stats()})
+	}
+}
@@ -0,0 +1,70 @@
+package sms_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/smstest"
+)
+
+func TestServer_connections(t *testing.T) {
+	srv := smstest.NewServer(t, sms.Config{AdminKey: "admin_key", MaxConnLifetime: time.Minute})
+
+	listener := httptest.NewUnstartedServer(srv)
+	listener.Config.ConnState = srv.ConnState
+	listener.Config.ConnContext = srv.ConnContext
+	listener.Start()
+	defer listener.Close()
+
+	client := listener.Client()
+	list := func() (*http.Response, sms.ConnStats) {
+		r, _ := http.NewRequest(http.MethodGet, listener.URL+"/admin/connections", nil)
+		r.Header.Set("Authorization", "AdminKey admin_key")
+		res, err := client.Do(r)
+		if err != nil {
+			t.Fatalf("Failed to list the connections: %v", err)
+		}
+		defer res.Body.Close()
+
+		var list sms.ConnList
+		if err := json.NewDecoder(res.Body).Decode(&list); err != nil {
+			t.Fatalf("Failed to decode json response body: %v", err)
+		}
+		return res, list.Data
+	}
+
+	res, stats := list()
+	if res.Close {
+		t.Error("Fresh connection was closed")
+	}
+	if stats.Open != 1 || stats.Active != 1 || len(stats.Clients) != 1 || stats.Clients[0].Client != "127.0.0.1" {
+		t.Errorf("Stats were %+v; want the active connection of 127.0.0.1", stats)
+	}
+
+	// The same connection is reused until it is too old
+	srv.Clock.Advance(2 * time.Minute)
+	res, stats = list()
+	if stats.Open != 1 {
+		t.Errorf("Open connections were %d; want the kept alive one", stats.Open)
+	}
+	if !res.Close {
+		t.Error("Connection past its lifetime was kept open")
+	}
+
+	// The old connection is forgotten once closed
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, stats = list()
+		if stats.Open == 1 && !stats.Clients[0].Oldest.Before(srv.Clock.Now()) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Stats were %+v; want only a new connection", stats)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
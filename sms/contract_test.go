@@ -0,0 +1,105 @@
+package sms_test
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+)
+
+// TestContract exercises the real provider end to end
+// It only runs when FLYSMS_CONTRACT_ACCESSKEY holds a messagebird test access
+// key, which is accepted by the API without sending anything, and
+// FLYSMS_CONTRACT_RECIPIENT holds a test number
+// FLYSMS_CONTRACT_BASEURL points the client to another API endpoint
+func TestContract(t *testing.T) {
+	accessKey := os.Getenv("FLYSMS_CONTRACT_ACCESSKEY")
+	if accessKey == "" {
+		t.Skip("FLYSMS_CONTRACT_ACCESSKEY not set; skipping contract tests against the provider")
+	}
+
+	// The provider reports recipients as digits, without plus sign
+	recipient := sms.PhoneNumber(os.Getenv("FLYSMS_CONTRACT_RECIPIENT"))
+	if _, err := strconv.ParseInt(string(recipient), 10, 64); err != nil {
+		t.Fatalf("FLYSMS_CONTRACT_RECIPIENT must be a phone number; Error: %v", err)
+	}
+
+	newClient := func(key string) *sms.Client {
+		return sms.NewClient(sms.Options{
+			AccessKey: key,
+			BaseURL:   os.Getenv("FLYSMS_CONTRACT_BASEURL"),
+			Timeout:   10 * time.Second,
+		})
+	}
+
+	tests := map[string]struct {
+		accessKey  string
+		originator string
+		message    string
+		wantKind   sms.ErrorKind
+	}{
+		"Message created": {
+			accessKey:  accessKey,
+			originator: "MessageBird",
+			message:    "flysms contract test",
+		},
+
+		"Message with reserved characters": {
+			accessKey:  accessKey,
+			originator: "MessageBird",
+			message:    "a&b=c+d %20 é ✓",
+		},
+
+		"Refused access key": {
+			accessKey:  "invalid" + accessKey,
+			originator: "MessageBird",
+			message:    "flysms contract test",
+			wantKind:   sms.KindAuth,
+		},
+
+		"Refused originator": {
+			accessKey:  accessKey,
+			originator: "originator-too-long",
+			message:    "flysms contract test",
+			wantKind:   sms.KindValidation,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+			defer cancel()
+
+			req := &sms.Request{
+				Recipient:  recipient,
+				Originator: tc.originator,
+				Message:    tc.message,
+			}
+			res, err := newClient(tc.accessKey).CreateMessage(ctx, req)
+
+			if tc.wantKind != sms.KindUnknown {
+				pe, ok := err.(*sms.ProviderError)
+				if !ok {
+					t.Fatalf("Error was %v; want a provider error of kind %s", err, tc.wantKind)
+				}
+				if pe.Kind != tc.wantKind {
+					t.Errorf("Error kind was %s; want %s; Error: %v", pe.Kind, tc.wantKind, pe)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Could not create message; Error: %v", err)
+			}
+			if res.ID == "" || res.Recipient != recipient {
+				t.Errorf("Result was %+v; want an id and recipient %s", res, recipient)
+			}
+			if res.Originator != tc.originator || res.Message != tc.message {
+				t.Errorf("Message was sent from %q as %q; want from %q as %q", res.Originator, res.Message, tc.originator, tc.message)
+			}
+		})
+	}
+}
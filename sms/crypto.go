@@ -0,0 +1,96 @@
+package sms
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+)
+
+// Crypto is the cryptography protecting the data of the server: the hash
+// chain of the audit log, the signatures of the pagination cursors and
+// the checksums and encryption of the backups
+type Crypto interface {
+	Hash(data []byte) []byte
+	MAC(key, data []byte) []byte
+	Seal(key, plaintext []byte) ([]byte, error)
+	Open(key, ciphertext []byte) ([]byte, error)
+}
+
+// StandardCrypto is the Crypto of the Go standard library, using the
+// FIPS approved SHA-256, HMAC-SHA-256 and AES-GCM only
+// It is run by the validated BoringCrypto module in the binaries built
+// with GOEXPERIMENT=boringcrypto
+type StandardCrypto struct{}
+
+// Hash returns the SHA-256 hash of the data
+func (StandardCrypto) Hash(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+// MAC returns the HMAC-SHA-256 of the data
+func (StandardCrypto) MAC(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// Seal encrypts and authenticates the plaintext with AES-GCM,
+// the random nonce being prepended to the ciphertext
+func (StandardCrypto) Seal(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Open decrypts and authenticates a ciphertext of Seal
+func (StandardCrypto) Open(key, ciphertext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	return gcm.Open(nil, ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// NewFIPSCrypto returns the Crypto of the regulated deployments, which
+// requires the binary to be built with GOEXPERIMENT=boringcrypto so that
+// the cryptography, TLS included, is done by the validated module
+func NewFIPSCrypto() (Crypto, error) {
+	if !fipsModule() {
+		return nil, errors.New("FIPS mode requires a build with GOEXPERIMENT=boringcrypto")
+	}
+
+	return StandardCrypto{}, nil
+}
+
+// orStandardCrypto returns the crypto, or StandardCrypto when nil
+func orStandardCrypto(c Crypto) Crypto {
+	if c == nil {
+		return StandardCrypto{}
+	}
+
+	return c
+}
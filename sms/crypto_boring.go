@@ -0,0 +1,15 @@
+//go:build boringcrypto
+// +build boringcrypto
+
+package sms
+
+import (
+	"crypto/boring"
+	// Restricts TLS to the FIPS approved versions, ciphers and curves
+	_ "crypto/tls/fipsonly"
+)
+
+// fipsModule reports whether the cryptography is done by BoringCrypto
+func fipsModule() bool {
+	return boring.Enabled()
+}
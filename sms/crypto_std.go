@@ -0,0 +1,10 @@
+//go:build !boringcrypto
+// +build !boringcrypto
+
+package sms
+
+// fipsModule reports whether the cryptography is done by BoringCrypto,
+// which it never is without GOEXPERIMENT=boringcrypto
+func fipsModule() bool {
+	return false
+}
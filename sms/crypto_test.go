@@ -0,0 +1,64 @@
+package sms_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/smstest"
+)
+
+// countingCrypto counts the operations of the standard crypto
+type countingCrypto struct {
+	sms.StandardCrypto
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (c *countingCrypto) count(op string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls[op]++
+}
+
+func (c *countingCrypto) Hash(data []byte) []byte {
+	c.count("hash")
+	return c.StandardCrypto.Hash(data)
+}
+
+func (c *countingCrypto) Seal(key, plaintext []byte) ([]byte, error) {
+	c.count("seal")
+	return c.StandardCrypto.Seal(key, plaintext)
+}
+
+func TestServer_crypto(t *testing.T) {
+	crypto := &countingCrypto{calls: make(map[string]int)}
+	srv := smstest.NewServer(t, sms.Config{
+		AdminKey:  "admin_key",
+		BackupKey: "backup_key",
+		Crypto:    crypto,
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/admin/backup", nil)
+	r.Header.Set("Authorization", "AdminKey admin_key")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Backup status code was %d; want %d", w.Code, http.StatusOK)
+	}
+
+	// The backup key is derived, and the backup sealed and checksummed
+	if crypto.calls["seal"] != 1 || crypto.calls["hash"] != 2 {
+		t.Errorf("Crypto calls were %v; want 1 seal and 2 hashes", crypto.calls)
+	}
+}
+
+func TestNewFIPSCrypto(t *testing.T) {
+	// The tests are not built with GOEXPERIMENT=boringcrypto
+	if _, err := sms.NewFIPSCrypto(); err == nil {
+		t.Error("FIPS crypto was created without BoringCrypto; want an error")
+	}
+}
@@ -0,0 +1,136 @@
+package sms
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cursor marks the position of the last item of a page
+// Items are ordered by time and then by id
+type cursor struct {
+	Time int64  `json:"t"`
+	ID   string `json:"i"`
+}
+
+// after reports whether an item comes after the cursor
+func (c cursor) after(t time.Time, id string) bool {
+	n := t.UnixNano()
+	return n > c.Time || (n == c.Time && id > c.ID)
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// cursorSigner turns cursors into opaque tokens and back
+// Tokens are signed so that callers cannot forge positions
+type cursorSigner struct {
+	key    []byte
+	crypto Crypto
+}
+
+// newCursorSigner creates a signer using the given key
+// A random key is generated when none is given, in which case
+// tokens do not survive a restart
+func newCursorSigner(key string, crypto Crypto) *cursorSigner {
+	if key != "" {
+		return &cursorSigner{key: []byte(key), crypto: crypto}
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("Could not generate cursor key; Error: %v", err)
+	}
+
+	return &cursorSigner{key: b, crypto: crypto}
+}
+
+// encode returns the token of a cursor
+func (s *cursorSigner) encode(c cursor) string {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		log.Fatalf("Could not encode cursor %#v; Error: %v", c, err)
+	}
+
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(s.sign(payload))
+}
+
+// decode verifies a token and returns its cursor
+func (s *cursorSigner) decode(token string) (cursor, error) {
+	var c cursor
+	enc := base64.RawURLEncoding
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return c, errInvalidCursor
+	}
+
+	payload, err := enc.DecodeString(parts[0])
+	if err != nil {
+		return c, errInvalidCursor
+	}
+
+	sig, err := enc.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, s.sign(payload)) {
+		return c, errInvalidCursor
+	}
+
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return c, errInvalidCursor
+	}
+
+	return c, nil
+}
+
+func (s *cursorSigner) sign(payload []byte) []byte {
+	return s.crypto.MAC(s.key, payload)
+}
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+// pageParams extracts the limit and cursor query parameters of a list request
+// An error response is sent when they are invalid
+func (s *Server) pageParams(w http.ResponseWriter, r *http.Request) (int, *cursor, bool) {
+	q := r.URL.Query()
+
+	limit := defaultPageLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageLimit {
+			res := Response{
+				statusCode: http.StatusBadRequest,
+				Error:      fmt.Sprintf("Bad request (limit must be between 1 and %d)", maxPageLimit),
+			}
+			sendResponse(w, res)
+			return 0, nil, false
+		}
+		limit = n
+	}
+
+	var after *cursor
+	if v := q.Get("cursor"); v != "" {
+		c, err := s.cursors.decode(v)
+		if err != nil {
+			res := Response{
+				statusCode: http.StatusBadRequest,
+				Error:      "Bad request (invalid cursor)",
+			}
+			sendResponse(w, res)
+			return 0, nil, false
+		}
+		after = &c
+	}
+
+	return limit, after, true
+}
@@ -0,0 +1,113 @@
+package sms
+
+import (
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Sources of the provider deprecation notices
+const (
+	DeprecationHeader    = "header"
+	DeprecationStatus    = "status"
+	DeprecationErrorCode = "error_code"
+)
+
+// DeprecationNotice is a sign that the provider is retiring the API in use,
+// kept until the server restarts so that it is not missed
+// Name is the header, the status code or the error code reporting it
+type DeprecationNotice struct {
+	Provider  string    `json:"provider"`
+	Source    string    `json:"source"`
+	Name      string    `json:"name"`
+	Detail    string    `json:"detail,omitempty"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Count     int       `json:"count"`
+}
+
+// DeprecationList is the HTTP response of the deprecations endpoint
+type DeprecationList struct {
+	Success bool                `json:"success"`
+	Data    []DeprecationNotice `json:"data"`
+}
+
+// deprecationRegistry keeps the deprecation notices of the providers
+// It is shared by the transports of the clients, which are built
+// without knowing the server, the same way as the metrics
+type deprecationRegistry struct {
+	mu      sync.Mutex
+	notices map[deprecationKey]*DeprecationNotice
+}
+
+type deprecationKey struct {
+	provider, source, name string
+}
+
+var deprecations = &deprecationRegistry{notices: make(map[deprecationKey]*DeprecationNotice)}
+
+// record adds an occurrence of the notice, logging it as a warning
+// when it is new or its detail changed
+func (d *deprecationRegistry) record(provider, source, name, detail string, at time.Time) {
+	metrics.Add("provider_deprecation_notices", 1)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := deprecationKey{provider, source, name}
+	n, ok := d.notices[key]
+	if !ok {
+		n = &DeprecationNotice{Provider: provider, Source: source, Name: name, FirstSeen: at}
+		d.notices[key] = n
+		metrics.Add("provider_deprecations_active", 1)
+	}
+	if !ok || n.Detail != detail {
+		slog.Warn("Provider API deprecation notice", "provider", provider, "source", source, "name", name, "detail", detail)
+	}
+	n.Detail = detail
+	n.LastSeen = at
+	n.Count++
+}
+
+// list returns the notices, the most recently seen first
+func (d *deprecationRegistry) list() []DeprecationNotice {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	notices := make([]DeprecationNotice, 0, len(d.notices))
+	for _, n := range d.notices {
+		notices = append(notices, *n)
+	}
+	sort.Slice(notices, func(i, j int) bool {
+		return notices[i].LastSeen.After(notices[j].LastSeen)
+	})
+
+	return notices
+}
+
+// recordDeprecatedCodes records the errors of the provider whose codes
+// the configuration lists as reporting a retired API
+func (s *Server) recordDeprecatedCodes(provider string, e *ProviderError) {
+	codes := s.deprecated[provider]
+	if len(codes) == 0 {
+		return
+	}
+	for _, me := range e.Errors {
+		for _, code := range codes {
+			if me.Code == code {
+				deprecations.record(provider, DeprecationErrorCode, strconv.Itoa(code), me.Description, s.clock.Now())
+			}
+		}
+	}
+}
+
+// listDeprecations is the HTTP handler listing the deprecation notices
+// of the providers
+func (s *Server) listDeprecations() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sendJSON(w, http.StatusOK, DeprecationList{Success: true, Data: deprecations.list()})
+	}
+}
@@ -0,0 +1,85 @@
+package sms_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/fixtures"
+)
+
+func TestServer_deprecations(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusGone)
+		fmt.Fprint(w, `{"errors":[{"code":98,"description":"API version 1 was retired","parameter":null}]}`)
+	}))
+	defer testServer.Close()
+
+	srv := sms.NewServer(sms.Config{
+		Buffer:          10,
+		ReqTimeout:      5 * time.Second,
+		ThrottleRate:    50 * time.Millisecond,
+		AdminKey:        "admin_key",
+		DeprecatedCodes: map[string][]int{sms.ProviderMessageBird: {98}},
+		MessageClient: sms.NewClient(sms.Options{
+			AccessKey: "test_key",
+			BaseURL:   testServer.URL,
+			Timeout:   10 * time.Second,
+		}),
+	})
+	srv.Run()
+
+	active := counter("provider_deprecations_active")
+	for i := 0; i < 2; i++ {
+		payload := fmt.Sprintf(`{"recipient":%d, "originator": %q, "message": "This is a test message"}`, fixtures.Recipient, fixtures.Originator)
+		r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(payload))
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		if w.Code == http.StatusCreated {
+			t.Fatal("Message was sent through a retired API")
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/admin/deprecations", nil)
+	r.Header.Set("Authorization", "AdminKey admin_key")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status code was %d; want %d", w.Code, http.StatusOK)
+	}
+
+	var list sms.DeprecationList
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode json response body: %v", err)
+	}
+
+	want := map[string]string{
+		sms.DeprecationStatus + " 410":   "/messages",
+		sms.DeprecationErrorCode + " 98": "API version 1 was retired",
+	}
+	for _, n := range list.Data {
+		detail, ok := want[n.Source+" "+n.Name]
+		if !ok || n.Provider != sms.ProviderMessageBird {
+			continue
+		}
+		delete(want, n.Source+" "+n.Name)
+		if n.Detail != detail {
+			t.Errorf("Detail of the %s %s notice was %q; want %q", n.Source, n.Name, n.Detail, detail)
+		}
+		if n.Count != 2 {
+			t.Errorf("The %s %s notice was seen %d times; want 2", n.Source, n.Name, n.Count)
+		}
+	}
+	for notice := range want {
+		t.Errorf("No %s notice was listed", notice)
+	}
+	if got := counter("provider_deprecations_active") - active; got != 2 {
+		t.Errorf("Active deprecations grew by %d; want 2", got)
+	}
+}
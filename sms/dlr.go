@@ -0,0 +1,169 @@
+package sms
+
+import (
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const defaultDeliveryHistory = 10000
+
+// deliveryStatuses are the message statuses reported by messagebird
+var deliveryStatuses = map[string]bool{
+	"scheduled":       true,
+	"sent":            true,
+	"buffered":        true,
+	"delivered":       true,
+	"expired":         true,
+	"delivery_failed": true,
+}
+
+type delivery struct {
+	content     Content
+	callbackURL string
+	updated     time.Time
+	timeline    *Timeline
+}
+
+// deliveryStore keeps the most recent sent messages by provider id,
+// with the last status reported for them
+type deliveryStore struct {
+	mu    sync.Mutex
+	limit int
+	order []string
+	msgs  map[string]delivery
+}
+
+func newDeliveryStore(limit int) *deliveryStore {
+	if limit <= 0 {
+		limit = defaultDeliveryHistory
+	}
+
+	return &deliveryStore{
+		limit: limit,
+		msgs:  make(map[string]delivery),
+	}
+}
+
+// add records a message sent through the provider, along with the URL
+// its status changes are posted to
+// The oldest messages are forgotten once the limit is reached
+func (d *deliveryStore) add(c Content, callbackURL string, at time.Time) {
+	if c.ID == "" {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.msgs[c.ID]; !ok {
+		d.order = append(d.order, c.ID)
+		if len(d.order) > d.limit {
+			delete(d.msgs, d.order[0])
+			d.order = d.order[1:]
+		}
+	}
+
+	d.msgs[c.ID] = delivery{content: c, callbackURL: callbackURL, updated: at}
+}
+
+// update sets the status of a known message and returns
+// the message as it was before and after
+// Reports arriving out of order do not override more recent ones
+func (d *deliveryStore) update(id string, recipient PhoneNumber, status string, at time.Time) (delivery, delivery, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	prev, ok := d.msgs[id]
+	if !ok || prev.content.Recipient.msisdn() != recipient.msisdn() {
+		return delivery{}, delivery{}, false
+	}
+
+	m := prev
+	if !at.Before(m.updated) {
+		m.content.Status = status
+		m.updated = at
+		d.msgs[id] = m
+	}
+
+	return prev, m, true
+}
+
+func (d *deliveryStore) get(id string) (Content, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	m, ok := d.msgs[id]
+	return m.content, ok
+}
+
+// deliveryReport is the HTTP handler for the messagebird status callbacks
+// It answers successfully for messages it does not know about,
+// so that the provider does not keep retrying them
+func (s *Server) deliveryReport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var res Response
+		q := r.URL.Query()
+		id, status := q.Get("id"), q.Get("status")
+		recipient := canonicalNumber(q.Get("recipient"))
+		at, atErr := time.Parse(time.RFC3339, q.Get("statusDatetime"))
+
+		var invalid string
+		switch {
+		case id == "":
+			invalid = "Missing parameter (id value is not present)"
+		case !recipient.wellFormed():
+			invalid = "Invalid parameter (recipient value is not a number)"
+		case !deliveryStatuses[status]:
+			invalid = "Invalid parameter (status value is not supported)"
+		case atErr != nil:
+			invalid = "Invalid parameter (statusDatetime value is not a RFC3339 time)"
+		}
+		if invalid != "" {
+			metrics.Add("dlr_invalid", 1)
+			res = Response{
+				statusCode: http.StatusBadRequest,
+				Error:      invalid,
+			}
+			sendResponse(w, res)
+			return
+		}
+
+		content, _ := s.recordDelivery(id, recipient, status, at)
+
+		res = Response{
+			statusCode: http.StatusOK,
+			Success:    true,
+			Data:       content,
+		}
+		sendResponse(w, res)
+	}
+}
+
+// recordDelivery sets the status reported for a message and notifies
+// its callback URL when it changed
+// Messages that are not known are reported as they are, with ok false
+func (s *Server) recordDelivery(id string, recipient PhoneNumber, status string, at time.Time) (Content, bool) {
+	metrics.Add("dlr_received", 1)
+	prev, cur, ok := s.deliveries.update(id, recipient, status, at)
+	if !ok {
+		metrics.Add("dlr_unknown", 1)
+		slog.Warn("Ignored delivery report for unknown message", "message_id", id)
+		return Content{ID: id, Recipient: recipient, Status: status}, false
+	}
+
+	if cur.content.Status != prev.content.Status {
+		s.reports.delivered(cur.content.Status)
+		s.audit(auditStatus, cur.content)
+		s.callbacks.notify(cur.callbackURL, StatusEvent{
+			ID:        id,
+			Recipient: recipient,
+			Status:    cur.content.Status,
+			Previous:  prev.content.Status,
+			StatusAt:  cur.updated.Format(time.RFC3339),
+		})
+	}
+
+	return cur.content, true
+}
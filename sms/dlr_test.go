@@ -0,0 +1,147 @@
+package sms_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+)
+
+func TestServer_deliveryReport(t *testing.T) {
+	report := func(id, recipient, status string, at time.Time) string {
+		q := url.Values{}
+		q.Set("id", id)
+		q.Set("recipient", recipient)
+		q.Set("status", status)
+		q.Set("statusDatetime", at.Format(time.RFC3339))
+		return "/webhooks/dlr?" + q.Encode()
+	}
+
+	later := time.Now().Add(time.Minute)
+
+	tests := map[string]struct {
+		method     string
+		reports    []string
+		wantStatus int
+		wantError  string
+		wantState  string
+	}{
+		"Delivered message": {
+			method:     http.MethodGet,
+			reports:    []string{report("fake", "31612345678", "delivered", later)},
+			wantStatus: http.StatusOK,
+			wantState:  "delivered",
+		},
+
+		"Reports out of order": {
+			method: http.MethodGet,
+			reports: []string{
+				report("fake", "31612345678", "delivered", later.Add(time.Second)),
+				report("fake", "31612345678", "buffered", later),
+			},
+			wantStatus: http.StatusOK,
+			wantState:  "delivered",
+		},
+
+		"Unknown message": {
+			method:     http.MethodGet,
+			reports:    []string{report("unknown", "31612345678", "delivered", later)},
+			wantStatus: http.StatusOK,
+			wantState:  "sent",
+		},
+
+		"Other recipient": {
+			method:     http.MethodGet,
+			reports:    []string{report("fake", "31600000000", "delivered", later)},
+			wantStatus: http.StatusOK,
+			wantState:  "sent",
+		},
+
+		"Unsupported status": {
+			method:     http.MethodGet,
+			reports:    []string{report("fake", "31612345678", "lost", later)},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "Invalid parameter (status value is not supported)",
+			wantState:  "sent",
+		},
+
+		"Missing id": {
+			method:     http.MethodGet,
+			reports:    []string{report("", "31612345678", "delivered", later)},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "Missing parameter (id value is not present)",
+			wantState:  "sent",
+		},
+
+		"Invalid status time": {
+			method:     http.MethodGet,
+			reports:    []string{"/webhooks/dlr?id=fake&recipient=31612345678&status=delivered&statusDatetime=yesterday"},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "Invalid parameter (statusDatetime value is not a RFC3339 time)",
+			wantState:  "sent",
+		},
+
+		"Invalid method": {
+			method:     http.MethodPost,
+			reports:    []string{report("fake", "31612345678", "delivered", later)},
+			wantStatus: http.StatusMethodNotAllowed,
+			wantError:  "Request not allowed (invalid HTTP method)",
+			wantState:  "sent",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := sms.NewServer(sms.Config{
+				Buffer:        10,
+				ReqTimeout:    5 * time.Second,
+				ThrottleRate:  10 * time.Millisecond,
+				MessageClient: fakeSender{},
+			})
+			srv.Run()
+
+			r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(`{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`))
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+			if w.Code != http.StatusCreated {
+				t.Fatalf("Status code was %d; want %d", w.Code, http.StatusCreated)
+			}
+
+			for _, target := range tc.reports {
+				r = httptest.NewRequest(tc.method, target, nil)
+				w = httptest.NewRecorder()
+				srv.ServeHTTP(w, r)
+			}
+
+			if w.Code != tc.wantStatus {
+				t.Fatalf("Status code was %d; want %d", w.Code, tc.wantStatus)
+			}
+
+			var smsRes sms.Response
+			if err := json.NewDecoder(w.Body).Decode(&smsRes); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+			if smsRes.Error != tc.wantError {
+				t.Errorf("Error was %q; want %q", smsRes.Error, tc.wantError)
+			}
+
+			// The provider cannot look messages up, the reports tell their status
+			r = httptest.NewRequest(http.MethodGet, "/messages/fake", nil)
+			w = httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			var viewed sms.Response
+			if err := json.NewDecoder(w.Body).Decode(&viewed); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+			if viewed.Data.Status != tc.wantState {
+				t.Errorf("Message status was %q; want %q", viewed.Data.Status, tc.wantState)
+			}
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package sms
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+const (
+	defaultEmailDomain     = "sms.local"
+	defaultEmailOriginator = "flysms"
+	maxEmailBytes          = 64 * 1024
+	maxEmailRecipients     = 50
+	smtpIdleTimeout        = 5 * time.Minute
+)
+
+// ListenAndServeSMTP listens for emails on the TCP address and sends them
+// as messages; see ServeSMTP
+func (s *Server) ListenAndServeSMTP(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.ServeSMTP(l)
+}
+
+// ServeSMTP accepts SMTP connections on the listener, for legacy systems
+// which can only send their alerts by email
+// Emails to <number>@ the email domain are sent to the number, with their
+// subject and text body as message, through the same validation and queue
+// as the messages of /messages
+// There is neither authentication nor encryption, so the listener
+// is meant for trusted networks only
+func (s *Server) ServeSMTP(l net.Listener) error {
+	defer l.Close()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveSMTPConn(conn)
+	}
+}
+
+// serveSMTPConn runs the SMTP session of the connection
+func (s *Server) serveSMTPConn(conn net.Conn) {
+	defer conn.Close()
+
+	tp := textproto.NewConn(conn)
+	reply := func(format string, args ...interface{}) {
+		if err := tp.PrintfLine(format, args...); err != nil {
+			slog.Error("Could not write SMTP reply", "error", err)
+		}
+	}
+
+	var from string
+	var recipients []PhoneNumber
+
+	reply("220 %s flysms ESMTP ready", s.emailDomain)
+	for {
+		conn.SetDeadline(time.Now().Add(smtpIdleTimeout))
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			verb, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			reply("250 %s", s.emailDomain)
+		case "EHLO":
+			reply("250-%s", s.emailDomain)
+			reply("250-8BITMIME")
+			reply("250 SIZE %d", maxEmailBytes)
+		case "MAIL":
+			addr, ok := smtpPath(arg, "FROM:")
+			if !ok {
+				reply("501 5.5.4 Syntax: MAIL FROM:<address>")
+				continue
+			}
+			from, recipients = addr, nil
+			reply("250 2.1.0 OK")
+		case "RCPT":
+			addr, ok := smtpPath(arg, "TO:")
+			switch {
+			case from == "":
+				reply("503 5.5.1 MAIL first")
+			case !ok:
+				reply("501 5.5.4 Syntax: RCPT TO:<address>")
+			case len(recipients) >= maxEmailRecipients:
+				reply("452 4.5.3 Too many recipients")
+			default:
+				recipient, ok := s.emailRecipient(addr)
+				if !ok {
+					reply("550 5.1.1 Recipient must be <number>@%s", s.emailDomain)
+					continue
+				}
+				recipients = append(recipients, recipient)
+				reply("250 2.1.5 OK")
+			}
+		case "DATA":
+			if len(recipients) == 0 {
+				reply("503 5.5.1 RCPT first")
+				continue
+			}
+			reply("354 End data with <CR><LF>.<CR><LF>")
+
+			dr := tp.DotReader()
+			data, err := ioutil.ReadAll(io.LimitReader(dr, maxEmailBytes+1))
+			// Whatever is left of an email too large is skipped
+			io.Copy(ioutil.Discard, dr)
+			if err != nil {
+				return
+			}
+			if len(data) > maxEmailBytes {
+				metrics.Add("emails_rejected", 1)
+				reply("552 5.3.4 Email is larger than %d bytes", maxEmailBytes)
+				from, recipients = "", nil
+				continue
+			}
+
+			text, err := emailText(bytes.NewReader(data))
+			if err != nil {
+				metrics.Add("emails_rejected", 1)
+				reply("554 5.6.0 %s", err)
+			} else if failed := s.relayEmail(conn.RemoteAddr().String(), recipients, text); failed != "" {
+				metrics.Add("emails_rejected", 1)
+				reply("554 5.0.0 %s", failed)
+			} else {
+				metrics.Add("emails_received", 1)
+				reply("250 2.0.0 OK")
+			}
+			from, recipients = "", nil
+		case "RSET":
+			from, recipients = "", nil
+			reply("250 2.0.0 OK")
+		case "NOOP":
+			reply("250 2.0.0 OK")
+		case "QUIT":
+			reply("221 2.0.0 Bye")
+			return
+		default:
+			reply("502 5.5.2 Command not recognized")
+		}
+	}
+}
+
+// relayEmail sends the text to each recipient and returns
+// the reasons the messages were refused, if any was
+func (s *Server) relayEmail(remoteAddr string, recipients []PhoneNumber, text string) string {
+	var failed []string
+	for _, recipient := range recipients {
+		req := Request{
+			Recipient:  recipient,
+			Originator: s.emailOrig,
+			Message:    text,
+		}
+
+		res, _ := s.relay(context.Background(), remoteAddr, nil, req)
+		if !res.Success {
+			failed = append(failed, fmt.Sprintf("%s: %s", recipient, res.Error))
+		}
+	}
+
+	return strings.Join(failed, "; ")
+}
+
+// emailRecipient returns the number of the email address,
+// when it is in the email domain
+func (s *Server) emailRecipient(addr string) (PhoneNumber, bool) {
+	i := strings.LastIndexByte(addr, '@')
+	if i < 0 || !strings.EqualFold(addr[i+1:], s.emailDomain) {
+		return "", false
+	}
+
+	recipient := canonicalNumber(addr[:i])
+	return recipient, recipient.wellFormed()
+}
+
+// smtpPath returns the address of a MAIL or RCPT argument
+// such as FROM:<alerts@example.com> SIZE=1024
+func smtpPath(arg, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+
+	path := strings.TrimSpace(arg[len(prefix):])
+	if i := strings.IndexByte(path, ' '); i >= 0 {
+		path = path[:i]
+	}
+	if !strings.HasPrefix(path, "<") || !strings.HasSuffix(path, ">") {
+		return "", false
+	}
+
+	return path[1 : len(path)-1], true
+}
+
+// emailText returns the message of the email, its subject followed
+// by its text body on the next line
+// The text/plain part of multipart emails is used
+func emailText(r io.Reader) (string, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return "", fmt.Errorf("Could not parse email; Error: %v", err)
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		subject = msg.Header.Get("Subject")
+	}
+
+	body, err := emailBody(textproto.MIMEHeader(msg.Header), msg.Body)
+	if err != nil {
+		return "", err
+	}
+
+	var lines []string
+	for _, s := range []string{subject, strings.Replace(body, "\r\n", "\n", -1)} {
+		if s = strings.TrimSpace(s); s != "" {
+			lines = append(lines, s)
+		}
+	}
+	if len(lines) == 0 {
+		return "", fmt.Errorf("Email has neither subject nor text body")
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// emailBody returns the text of an email or of one of its parts
+func emailBody(header textproto.MIMEHeader, r io.Reader) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(r, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return "", nil
+			}
+			if err != nil {
+				return "", fmt.Errorf("Could not read email part; Error: %v", err)
+			}
+			if text, err := emailBody(part.Header, part); err != nil || text != "" {
+				return text, err
+			}
+		}
+	}
+
+	if mediaType != "text/plain" {
+		return "", nil
+	}
+
+	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+	case "quoted-printable":
+		r = quotedprintable.NewReader(r)
+	case "base64":
+		r = base64.NewDecoder(base64.StdEncoding, r)
+	}
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("Could not read email body; Error: %v", err)
+	}
+
+	return string(b), nil
+}
@@ -0,0 +1,113 @@
+package sms_test
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/fixtures"
+)
+
+func TestServer_ServeSMTP(t *testing.T) {
+	tests := map[string]struct {
+		to          []string
+		email       string
+		wantErr     string
+		wantMessage string
+	}{
+		"Plain text email": {
+			to:          []string{"31612345678@sms.local"},
+			email:       "Subject: Disk full\r\n\r\nserver01 disk is 95% full\r\n",
+			wantMessage: "Disk full\nserver01 disk is 95% full",
+		},
+
+		"Multipart email": {
+			to: []string{"+31612345678@SMS.local"},
+			email: "Subject: =?utf-8?q?Temp=C3=A9rature?=\r\n" +
+				"Content-Type: multipart/alternative; boundary=b1\r\n\r\n" +
+				"--b1\r\nContent-Type: text/html\r\n\r\n<p>html</p>\r\n" +
+				"--b1\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n" +
+				"40 =C2=B0C in the server room\r\n--b1--\r\n",
+			wantMessage: "Température\n40 °C in the server room",
+		},
+
+		"Recipient of another domain": {
+			to:      []string{"31612345678@example.com"},
+			email:   "Subject: Disk full\r\n\r\n",
+			wantErr: "550 5.1.1 Recipient must be <number>@sms.local",
+		},
+
+		"Recipient not a number": {
+			to:      []string{"oncall@sms.local"},
+			email:   "Subject: Disk full\r\n\r\n",
+			wantErr: "550 5.1.1 Recipient must be <number>@sms.local",
+		},
+
+		"Message refused": {
+			to:      []string{"31612345678@sms.local"},
+			email:   "Subject: Disk full\r\n\r\n" + strings.Repeat("a", sms.MaxMessageLength) + "\r\n",
+			wantErr: "554 5.0.0 31612345678: Invalid parameter (message value is to long)",
+		},
+
+		"Empty email": {
+			to:      []string{"31612345678@sms.local"},
+			email:   "From: alerts@example.com\r\n\r\n",
+			wantErr: "554 5.6.0 Email has neither subject nor text body",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var mu sync.Mutex
+			var bodies []string
+			provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				bodies = append(bodies, r.FormValue("body"))
+				mu.Unlock()
+				fixtures.MessageCreated.ServeHTTP(w, r)
+			}))
+			defer provider.Close()
+
+			srv := sms.NewServer(sms.Config{
+				Buffer:        10,
+				ReqTimeout:    5 * time.Second,
+				ThrottleRate:  10 * time.Millisecond,
+				MessageClient: sms.NewClient(sms.Options{BaseURL: provider.URL, Timeout: 10 * time.Second}),
+			})
+			srv.Run()
+
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Could not listen for emails; Error: %v", err)
+			}
+			go srv.ServeSMTP(l)
+			defer l.Close()
+
+			err = smtp.SendMail(l.Addr().String(), nil, "alerts@example.com", tc.to, []byte(tc.email))
+			if tc.wantErr != "" {
+				terr, ok := err.(*textproto.Error)
+				if !ok || fmt.Sprintf("%d %s", terr.Code, terr.Msg) != tc.wantErr {
+					t.Fatalf("Sending email failed with %v; want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Could not send email; Error: %v", err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(bodies) != 1 || bodies[0] != tc.wantMessage {
+				t.Errorf("Provider got %q; want a single message %q", bodies, tc.wantMessage)
+			}
+		})
+	}
+}
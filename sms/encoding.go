@@ -0,0 +1,108 @@
+package sms
+
+import (
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// gsmBasic is the GSM 03.38 default alphabet in the order of its septets,
+// with the escape to the extension table at 0x1B
+const gsmBasic = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞ\x1bÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+	"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+
+const gsmEscape = 0x1B
+
+// gsmExtended is the GSM 03.38 extension table, whose characters
+// take two septets as they are escaped
+var gsmExtended = map[rune]byte{
+	'\f': 0x0A,
+	'^':  0x14,
+	'{':  0x28,
+	'}':  0x29,
+	'\\': 0x2F,
+	'[':  0x3C,
+	'~':  0x3D,
+	']':  0x3E,
+	'|':  0x40,
+	'€':  0x65,
+}
+
+// gsmSeptets maps the characters of the default alphabet to their septet
+var gsmSeptets = func() map[rune]byte {
+	m := make(map[rune]byte)
+	for i, r := range []rune(gsmBasic) {
+		if i != gsmEscape {
+			m[r] = byte(i)
+		}
+	}
+	return m
+}()
+
+// gsmLength returns the number of septets of the text in the GSM-7 encoding
+// It reports false when the text has characters outside of the alphabet
+func gsmLength(text string) (int, bool) {
+	n := 0
+	for _, r := range text {
+		l := gsmRuneLength(r)
+		if l == 0 {
+			return 0, false
+		}
+		n += l
+	}
+
+	return n, true
+}
+
+// gsmRuneLength returns the number of septets of the character,
+// 0 when it is not part of the GSM-7 alphabet
+func gsmRuneLength(r rune) int {
+	if _, ok := gsmSeptets[r]; ok {
+		return 1
+	}
+	if _, ok := gsmExtended[r]; ok {
+		return 2
+	}
+
+	return 0
+}
+
+// gsmEncode returns the unpacked septets of the text, one per octet
+// It reports false when the text has characters outside of the alphabet
+func gsmEncode(text string) ([]byte, bool) {
+	b := make([]byte, 0, len(text))
+	for _, r := range text {
+		if c, ok := gsmSeptets[r]; ok {
+			b = append(b, c)
+		} else if c, ok := gsmExtended[r]; ok {
+			b = append(b, gsmEscape, c)
+		} else {
+			return nil, false
+		}
+	}
+
+	return b, true
+}
+
+// messageLength returns the length of the message of the request
+// and the limit it has to fit in
+// Text messages are counted in GSM-7 septets when they can be encoded so,
+// and in UCS-2 characters otherwise, where characters beyond the basic
+// multilingual plane such as emoji take two
+// Binary messages are counted in hex characters, two per octet,
+// and the messages of the other channels in characters
+func messageLength(req *Request) (int, int) {
+	switch {
+	case req.Type == messageTypeBinary:
+		return len(req.Message), 2 * MaxBinaryMessageLength
+	case req.Channel == channelVoice:
+		return utf8.RuneCountInString(req.Message), MaxVoiceMessageLength
+	case req.Channel == channelWhatsApp:
+		return utf8.RuneCountInString(req.Message), MaxWhatsAppMessageLength
+	}
+
+	if n, ok := gsmLength(req.Message); ok {
+		return n, MaxMessageLength
+	}
+
+	return len(utf16.Encode([]rune(req.Message))), MaxUCS2MessageLength
+}
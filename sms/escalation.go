@@ -0,0 +1,282 @@
+package sms
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ackCodeLength is the length of the codes alerts are acknowledged with
+const ackCodeLength = 8
+
+// EscalationPolicy pages the recipients of its steps in turn,
+// until someone acknowledges the alert
+type EscalationPolicy struct {
+	Steps []EscalationStep `json:"steps"`
+}
+
+// EscalationStep is a step of an escalation policy
+// Its recipients, numbers or teams such as team:payments, are sent the
+// alert over the channel, sms by default or voice, and are given
+// WaitMinutes minutes to acknowledge it before the next step
+type EscalationStep struct {
+	Recipients  []PhoneNumber `json:"recipients"`
+	Channel     string        `json:"channel,omitempty"`
+	WaitMinutes int           `json:"wait_minutes"`
+}
+
+// check returns what is wrong with the policy, if anything
+func (p EscalationPolicy) check() string {
+	if len(p.Steps) == 0 {
+		return "steps value is not present"
+	}
+	for _, step := range p.Steps {
+		if len(step.Recipients) == 0 {
+			return "step recipients value is not present"
+		}
+		if step.Channel != "" && step.Channel != channelSMS && step.Channel != channelVoice {
+			return "step channel value is not supported"
+		}
+		if step.WaitMinutes <= 0 {
+			return "step wait_minutes value must be positive"
+		}
+	}
+
+	return ""
+}
+
+// escalation is an alert going through the steps of its policy
+type escalation struct {
+	code       string
+	policy     EscalationPolicy
+	remoteAddr string
+	originator string
+	text       string
+	step       int
+	notified   []PhoneNumber
+	timer      Timer
+	done       bool
+}
+
+// escalationStore holds the escalation policies and the alerts
+// waiting to be acknowledged, by code
+type escalationStore struct {
+	mu       sync.Mutex
+	policies map[string]EscalationPolicy
+	open     map[string]*escalation
+}
+
+func newEscalationStore(policies map[string]EscalationPolicy) *escalationStore {
+	s := &escalationStore{
+		policies: make(map[string]EscalationPolicy),
+		open:     make(map[string]*escalation),
+	}
+	for name, p := range policies {
+		if invalid := p.check(); invalid != "" {
+			slog.Warn("Ignored escalation policy", "policy", name, "reason", invalid)
+			continue
+		}
+		s.policies[name] = p
+	}
+
+	return s
+}
+
+// policy returns the escalation policy of the given name
+func (s *escalationStore) policy(name string) (EscalationPolicy, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.policies[name]
+	return p, ok
+}
+
+// acknowledge ends the escalation of the code, or else the ones the
+// number was paged for when no code is given, and returns how many ended
+func (s *escalationStore) acknowledge(code string, from PhoneNumber) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var acked []*escalation
+	if code != "" {
+		if e, ok := s.open[code]; ok {
+			acked = append(acked, e)
+		}
+	} else {
+		for _, e := range s.open {
+			for _, n := range e.notified {
+				if n.msisdn() == from.msisdn() {
+					acked = append(acked, e)
+					break
+				}
+			}
+		}
+	}
+
+	for _, e := range acked {
+		e.done = true
+		if e.timer != nil {
+			e.timer.Stop()
+		}
+		delete(s.open, e.code)
+	}
+
+	return len(acked)
+}
+
+// escalate starts the escalation of an alert with the policy
+// and returns how many of the messages of its first step were sent
+// and how many failed
+func (s *Server) escalate(ctx context.Context, remoteAddr, policy, originator, text string) (int, int) {
+	p, ok := s.escalations.policy(policy)
+	if !ok {
+		metrics.Add("alerts_failed", 1)
+		slog.Error("Could not escalate alert", "policy", policy, "error", "unknown escalation policy")
+		return 0, 1
+	}
+
+	e := &escalation{
+		code:       newID()[:ackCodeLength],
+		policy:     p,
+		remoteAddr: remoteAddr,
+		originator: originator,
+		text:       text,
+	}
+
+	s.escalations.mu.Lock()
+	s.escalations.open[e.code] = e
+	s.escalations.mu.Unlock()
+	metrics.Add("escalations_started", 1)
+
+	return s.escalateStep(ctx, e)
+}
+
+// escalateStep pages the recipients of the current step of the escalation
+// and schedules the next step, unless the alert was acknowledged
+func (s *Server) escalateStep(ctx context.Context, e *escalation) (int, int) {
+	s.escalations.mu.Lock()
+	if e.done {
+		s.escalations.mu.Unlock()
+		return 0, 0
+	}
+	step := e.policy.Steps[e.step]
+	s.escalations.mu.Unlock()
+
+	alert := Request{
+		Originator: e.originator,
+		Message:    s.fitAlertWith(e.text, s.ackInstructions(e.code)),
+		Channel:    step.Channel,
+	}
+	notified, failed := s.sendAlert(ctx, e.remoteAddr, step.Recipients, alert)
+
+	s.escalations.mu.Lock()
+	defer s.escalations.mu.Unlock()
+
+	e.notified = append(e.notified, notified...)
+	if e.done {
+		return len(notified), failed
+	}
+
+	e.timer = s.clock.AfterFunc(time.Duration(step.WaitMinutes)*time.Minute, func() {
+		// Steps are sent in the background, as timers of the test
+		// clocks run their functions while it is advanced
+		go s.nextEscalationStep(e)
+	})
+
+	return len(notified), failed
+}
+
+// nextEscalationStep moves the unacknowledged escalation to its next step,
+// and gives up after the last one
+func (s *Server) nextEscalationStep(e *escalation) {
+	s.escalations.mu.Lock()
+	if e.done {
+		s.escalations.mu.Unlock()
+		return
+	}
+	e.step++
+	if e.step == len(e.policy.Steps) {
+		e.done = true
+		delete(s.escalations.open, e.code)
+		s.escalations.mu.Unlock()
+
+		metrics.Add("escalations_exhausted", 1)
+		slog.Warn("Alert was not acknowledged by anyone of its escalation policy", "alert", e.code)
+		return
+	}
+	s.escalations.mu.Unlock()
+
+	metrics.Add("escalations_escalated", 1)
+	s.escalateStep(context.Background(), e)
+}
+
+// ackInstructions tells how to acknowledge the alert of the code
+func (s *Server) ackInstructions(code string) string {
+	if s.ackURL == "" {
+		return fmt.Sprintf("\nReply ACK %s", code)
+	}
+
+	return fmt.Sprintf("\nReply ACK %s or open %s/alerts/%s/ack", code, strings.TrimSuffix(s.ackURL, "/"), code)
+}
+
+// acknowledgeAlert is the HTTP handler of the ack links of the alerts,
+// /alerts/{code}/ack
+// GET is accepted, as the links are opened from the messages
+func (s *Server) acknowledgeAlert() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var res Response
+		code := pathParam(r, 1)
+		if s.escalations.acknowledge(code, "") == 0 {
+			res = Response{
+				statusCode: http.StatusNotFound,
+				Error:      "Not found (no alert waiting for acknowledgment with this code)",
+			}
+			sendResponse(w, res)
+			return
+		}
+
+		metrics.Add("escalations_acked", 1)
+		slog.Info("Alert acknowledged through its link", "alert", code)
+		res = Response{
+			statusCode: http.StatusOK,
+			Success:    true,
+		}
+		sendResponse(w, res)
+	}
+}
+
+// inboundMessage is the HTTP handler of the messagebird webhook of the
+// messages received by the virtual numbers, /webhooks/inbound
+// Replies such as "ACK 1a2b3c4d" acknowledge the alert of the code, and
+// a bare "ACK" the alerts the sender was paged for
+// It answers successfully for the other messages, so that the provider
+// does not keep retrying them
+func (s *Server) inboundMessage() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var res Response
+		from := canonicalNumber(r.FormValue("originator"))
+		words := strings.Fields(r.FormValue("payload"))
+		metrics.Add("inbound_messages", 1)
+
+		if len(words) > 0 && strings.EqualFold(words[0], "ack") && from.wellFormed() {
+			code := ""
+			if len(words) > 1 {
+				code = strings.ToLower(words[1])
+			}
+			if n := s.escalations.acknowledge(code, from); n > 0 {
+				metrics.Add("escalations_acked", int64(n))
+				slog.Info("Alerts acknowledged", "alerts", n, "by", from)
+			}
+		}
+
+		res = Response{
+			statusCode: http.StatusOK,
+			Success:    true,
+		}
+		sendResponse(w, res)
+	}
+}
@@ -0,0 +1,110 @@
+package sms_test
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/smstest"
+)
+
+func TestServer_escalation(t *testing.T) {
+	policies := map[string]sms.EscalationPolicy{
+		"dba": {Steps: []sms.EscalationStep{
+			{Recipients: []sms.PhoneNumber{"31611111111"}, WaitMinutes: 5},
+			{Recipients: []sms.PhoneNumber{"31622222222"}, WaitMinutes: 10},
+		}},
+	}
+	ackCode := regexp.MustCompile(`Reply ACK (\w+) or open https://sms\.example\.com/alerts/(\w+)/ack$`)
+
+	tests := []struct {
+		name           string
+		ack            func(srv *smstest.Server, code string) int
+		wantStatus     int
+		wantRecipients []sms.PhoneNumber
+	}{
+		{
+			name:           "Not acknowledged",
+			wantRecipients: []sms.PhoneNumber{"31611111111", "31622222222"},
+		},
+		{
+			name: "Acknowledged by link",
+			ack: func(srv *smstest.Server, code string) int {
+				return srv.Do(http.MethodGet, "/alerts/"+code+"/ack", "").Code
+			},
+			wantStatus:     http.StatusOK,
+			wantRecipients: []sms.PhoneNumber{"31611111111"},
+		},
+		{
+			name: "Acknowledged by reply",
+			ack: func(srv *smstest.Server, code string) int {
+				return srv.Do(http.MethodGet, "/webhooks/inbound?originator=%2B31611111111&payload=ack", "").Code
+			},
+			wantStatus:     http.StatusOK,
+			wantRecipients: []sms.PhoneNumber{"31611111111"},
+		},
+		{
+			name: "Unknown code",
+			ack: func(srv *smstest.Server, code string) int {
+				return srv.Do(http.MethodPost, "/alerts/00000000/ack", "").Code
+			},
+			wantStatus:     http.StatusNotFound,
+			wantRecipients: []sms.PhoneNumber{"31611111111", "31622222222"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := smstest.NewServer(t, sms.Config{
+				AlertmanagerRoutes: []sms.AlertmanagerRoute{{Escalation: "dba"}},
+				EscalationPolicies: policies,
+				AckURL:             "https://sms.example.com/",
+			})
+
+			w := srv.SendRequest(t, http.MethodPost, "/webhooks/alertmanager", `{"version": "4", "status": "firing", "alerts": [{"status": "firing", "labels": {"alertname": "ReplicationLag"}}]}`)
+			if w.Code != http.StatusOK {
+				t.Fatalf("Status code was %d; want %d", w.Code, http.StatusOK)
+			}
+
+			events := srv.Provider.Events()
+			if len(events) != 1 {
+				t.Fatalf("Provider got %d messages; want 1", len(events))
+			}
+			m := ackCode.FindStringSubmatch(events[0].Request.Message)
+			if m == nil || m[1] != m[2] {
+				t.Fatalf("Message %q does not tell how to acknowledge it", events[0].Request.Message)
+			}
+
+			if tc.ack != nil {
+				if status := tc.ack(srv, m[1]); status != tc.wantStatus {
+					t.Fatalf("Ack status code was %d; want %d", status, tc.wantStatus)
+				}
+			}
+
+			// The next steps are sent in the background, once the clock
+			// ticks for the throttle
+			for _, wait := range []time.Duration{5 * time.Minute, 10 * time.Minute} {
+				srv.Clock.Advance(wait)
+				for deadline := time.Now().Add(100 * time.Millisecond); time.Now().Before(deadline); {
+					srv.Clock.Advance(time.Second)
+					time.Sleep(5 * time.Millisecond)
+				}
+			}
+
+			var got []sms.PhoneNumber
+			for _, ev := range srv.Provider.Events() {
+				got = append(got, ev.Request.Recipient)
+			}
+			if len(got) != len(tc.wantRecipients) {
+				t.Fatalf("Alert was sent to %q; want %q", got, tc.wantRecipients)
+			}
+			for i := range got {
+				if got[i] != tc.wantRecipients[i] {
+					t.Errorf("Alert was sent to %q; want %q", got, tc.wantRecipients)
+				}
+			}
+		})
+	}
+}
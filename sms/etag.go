@@ -0,0 +1,56 @@
+package sms
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// sendCacheable writes the value as a JSON body tagged with an ETag
+// Callers presenting a matching If-None-Match header get a 304 without body
+// The data is cut down to the fields of ?fields= when it is given
+func sendCacheable(w http.ResponseWriter, r *http.Request, statusCode int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		log.Fatalf("Could not encode value %#v; Error: %v", v, err)
+	}
+	if fields := requestedFields(r); fields != nil {
+		if body, err = selectFields(body, fields); err != nil {
+			log.Fatalf("Could not select fields of value %#v; Error: %v", v, err)
+		}
+	}
+	body = append(body, '\n')
+
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	w.Header().Set("ETag", etag)
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Accept", "application/json")
+	w.WriteHeader(statusCode)
+	if _, err := w.Write(body); err != nil {
+		slog.Error("Could not write response body", "error", err)
+	}
+}
+
+// etagMatch reports whether the If-None-Match header matches the ETag
+// Weak comparison is used, as recommended for If-None-Match
+func etagMatch(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,115 @@
+package sms
+
+import (
+	"context"
+	"net"
+	"sort"
+	"time"
+)
+
+// This file exports to the sms_test package the internals
+// it cannot reach through the server
+
+// LookupFunc is a hostResolver answering with a function
+type LookupFunc func(ctx context.Context, host string) ([]string, error)
+
+func (f LookupFunc) LookupHost(ctx context.Context, host string) ([]string, error) {
+	return f(ctx, host)
+}
+
+// CachingResolver is the DNS cache of the provider transport
+type CachingResolver = cachingResolver
+
+func NewCachingResolver(ttl time.Duration, lookup LookupFunc, clock Clock) *CachingResolver {
+	return newCachingResolver(ttl, lookup, clock)
+}
+
+func (r *cachingResolver) Lookup(ctx context.Context, host string) ([]string, error) {
+	return r.lookup(ctx, host)
+}
+
+func DialFirst(ctx context.Context, dial func(ctx context.Context, network, addr string) (net.Conn, error), network, port string, addrs []string, delay time.Duration) (net.Conn, error) {
+	return dialFirst(ctx, dial, network, port, addrs, delay)
+}
+
+const MaxDNSStaleness = maxDNSStaleness
+
+// RateLimiter is the limiter of the recipient and one-time password limits
+type RateLimiter = rateLimiter
+
+func NewRateLimiter(limits ...RateLimit) *RateLimiter {
+	return newRateLimiter(limits...)
+}
+
+func (l *rateLimiter) Allow(key string, now time.Time) bool {
+	_, ok := l.allow(key, now)
+	return ok
+}
+
+// Keys returns the keys the limiter remembers, sorted
+func (l *rateLimiter) Keys() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	keys := make([]string, 0, len(l.hits))
+	for key := range l.hits {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// NumberCache is the cache of the recipient statuses
+type NumberCache = numberCache
+
+func NewNumberCache(ttl time.Duration, max int, clock Clock) *NumberCache {
+	c := newNumberCache(ttl, clock)
+	c.max = max
+	return c
+}
+
+func (c *numberCache) Set(recipient PhoneNumber, status string) {
+	c.set(recipient, status)
+}
+
+func (c *numberCache) Get(recipient PhoneNumber) (string, bool) {
+	return c.get(recipient)
+}
+
+func (c *numberCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.entries)
+}
+
+// AttemptStore is the store of the attempts of the recent messages
+type AttemptStore = attemptStore
+
+func NewAttemptStore(limit int, clock Clock) *AttemptStore {
+	return newAttemptStore(limit, clock)
+}
+
+// Capture records an attempt of the request along with its exchange,
+// if any, expiring after the ttl
+func (a *attemptStore) Capture(reqID string, ex *Exchange, ttl time.Duration) {
+	a.add(reqID, Attempt{Outcome: "failed", Exchange: ex, expires: a.clock.Now().Add(ttl)})
+}
+
+// Captured counts the exchanges held by the store
+func (a *attemptStore) Captured() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	n := 0
+	for _, attempts := range a.attempts {
+		for _, at := range attempts {
+			if at.Exchange != nil {
+				n++
+			}
+		}
+	}
+
+	return n
+}
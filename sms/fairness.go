@@ -0,0 +1,182 @@
+package sms
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The dispatches are counted in buckets over the fairness window,
+// so that the memory does not grow with the send rate
+const (
+	fairnessWindow  = 5 * time.Minute
+	fairnessBuckets = 30
+)
+
+// QueueShare is the part of the queue taken by the messages of a class,
+// priority and tenant, and of the messages recently dispatched
+// The shares are fractions of all the messages dispatched in the window
+type QueueShare struct {
+	Class      string  `json:"class"`
+	Priority   string  `json:"priority"`
+	Tenant     string  `json:"tenant,omitempty"`
+	Queued     int     `json:"queued"`
+	Dispatched int     `json:"dispatched"`
+	Share      float64 `json:"share"`
+}
+
+// QueueFairness is the composition of the queue and the shares of the
+// dispatches over the last WindowSeconds
+type QueueFairness struct {
+	WindowSeconds int          `json:"window_seconds"`
+	Queued        int          `json:"queued"`
+	Dispatched    int          `json:"dispatched"`
+	Shares        []QueueShare `json:"shares"`
+}
+
+// QueueFairnessStatus is the HTTP response of the fairness endpoint
+type QueueFairnessStatus struct {
+	Success bool          `json:"success"`
+	Data    QueueFairness `json:"data"`
+}
+
+type shareKey struct {
+	class, priority, tenant string
+}
+
+// shareOf returns the class, priority and tenant of the request, with
+// the defaults of the class and priority when it does not set them
+func shareOf(req *Request) shareKey {
+	k := shareKey{class: req.Class, priority: req.Priority, tenant: req.tenant}
+	if k.class == "" {
+		k.class = ClassTransactional
+	}
+	if k.priority == "" {
+		k.priority = priorityNormal
+	}
+
+	return k
+}
+
+// dispatchLog counts the requests dispatched to the workers by class,
+// priority and tenant, in buckets spanning the fairness window
+type dispatchLog struct {
+	mu      sync.Mutex
+	clock   Clock
+	buckets []dispatchBucket
+}
+
+type dispatchBucket struct {
+	start  time.Time
+	counts map[shareKey]int
+}
+
+func newDispatchLog(clock Clock) *dispatchLog {
+	return &dispatchLog{clock: clock}
+}
+
+// record counts a dispatched request
+func (d *dispatchLog) record(req *Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.clock.Now()
+	d.prune(now)
+	n := len(d.buckets)
+	if n == 0 || now.Sub(d.buckets[n-1].start) >= fairnessWindow/fairnessBuckets {
+		d.buckets = append(d.buckets, dispatchBucket{start: now, counts: make(map[shareKey]int)})
+		n++
+	}
+	d.buckets[n-1].counts[shareOf(req)]++
+}
+
+// prune drops the buckets started before the window
+func (d *dispatchLog) prune(now time.Time) {
+	i := 0
+	for i < len(d.buckets) && now.Sub(d.buckets[i].start) > fairnessWindow {
+		i++
+	}
+	d.buckets = d.buckets[i:]
+}
+
+// counts sums the dispatches of the window
+func (d *dispatchLog) counts() map[shareKey]int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.prune(d.clock.Now())
+	counts := make(map[shareKey]int)
+	for _, b := range d.buckets {
+		for k, n := range b.counts {
+			counts[k] += n
+		}
+	}
+
+	return counts
+}
+
+// composition counts the requests waiting in the queues
+func (q *queuedRequests) composition() map[shareKey]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	counts := make(map[shareKey]int)
+	for _, req := range q.reqs {
+		counts[shareOf(req)]++
+	}
+
+	return counts
+}
+
+// fairness puts together the composition of the queue and the
+// recent dispatches
+func (s *Server) fairness() QueueFairness {
+	queued, dispatched := s.queued.composition(), s.dispatches.counts()
+	f := QueueFairness{WindowSeconds: int(fairnessWindow / time.Second), Shares: []QueueShare{}}
+
+	shares := make(map[shareKey]*QueueShare)
+	share := func(k shareKey) *QueueShare {
+		if sh, ok := shares[k]; ok {
+			return sh
+		}
+		sh := &QueueShare{Class: k.class, Priority: k.priority, Tenant: k.tenant}
+		shares[k] = sh
+		return sh
+	}
+	for k, n := range queued {
+		share(k).Queued = n
+		f.Queued += n
+	}
+	for k, n := range dispatched {
+		share(k).Dispatched = n
+		f.Dispatched += n
+	}
+
+	for _, sh := range shares {
+		if f.Dispatched > 0 {
+			sh.Share = float64(sh.Dispatched) / float64(f.Dispatched)
+		}
+		f.Shares = append(f.Shares, *sh)
+	}
+	sort.Slice(f.Shares, func(i, j int) bool {
+		a, b := f.Shares[i], f.Shares[j]
+		if a.Class != b.Class {
+			return a.Class < b.Class
+		}
+		if a.Priority != b.Priority {
+			return a.Priority < b.Priority
+		}
+		return a.Tenant < b.Tenant
+	})
+
+	return f
+}
+
+// viewFairness is the HTTP handler showing the composition of the queue
+// and the shares of the recent dispatches, by class, priority and tenant
+func (s *Server) viewFairness() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sendJSON(w, http.StatusOK, QueueFairnessStatus{Success: true, Data: s.fairness()})
+	}
+}
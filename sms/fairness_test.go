@@ -0,0 +1,81 @@
+package sms_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/fixtures"
+	"github.com/iulianclita/flysms/sms/smstest"
+)
+
+func TestServer_fairness(t *testing.T) {
+	clock := smstest.NewClock(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+	sender := blockingSender{received: make(chan *sms.Request, 3), release: make(chan struct{})}
+	defer close(sender.release)
+
+	srv := sms.NewServer(sms.Config{
+		Buffer:        10,
+		ReqTimeout:    time.Minute,
+		ThrottleRate:  time.Second,
+		AdminKey:      "admin_key",
+		MessageClient: sender,
+		Clock:         clock,
+	})
+	srv.Run()
+
+	for _, fields := range []string{`"priority": "high"`, `"class": "marketing"`, `"class": "marketing"`} {
+		payload := fmt.Sprintf(`{"recipient":%d, "originator": %q, "message": "This is a test message", %s}`, fixtures.Recipient, fixtures.Originator, fields)
+		go func() {
+			r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(payload))
+			srv.ServeHTTP(httptest.NewRecorder(), r)
+		}()
+	}
+	clock.WaitTimers(t, 3)
+	clock.Advance(time.Second)
+	<-sender.received
+
+	r := httptest.NewRequest(http.MethodGet, "/admin/fairness", nil)
+	r.Header.Set("Authorization", "AdminKey admin_key")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status code was %d; want %d", w.Code, http.StatusOK)
+	}
+
+	var status sms.QueueFairnessStatus
+	if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+		t.Fatalf("Failed to decode json response body: %v", err)
+	}
+	f := status.Data
+	if f.Queued != 2 || f.Dispatched != 1 {
+		t.Fatalf("Queue had %d requests queued and %d dispatched; want 2 and 1", f.Queued, f.Dispatched)
+	}
+
+	// Whichever was sent first, the requests of every class and
+	// priority are either queued or dispatched
+	want := map[string]int{
+		sms.ClassMarketing + "/normal":   2,
+		sms.ClassTransactional + "/high": 1,
+	}
+	var share float64
+	for _, sh := range f.Shares {
+		key := sh.Class + "/" + sh.Priority
+		if got := sh.Queued + sh.Dispatched; got != want[key] {
+			t.Errorf("Share %s had %d requests; want %d", key, got, want[key])
+		}
+		delete(want, key)
+		share += sh.Share
+	}
+	for key := range want {
+		t.Errorf("Share %s was missing", key)
+	}
+	if share != 1 {
+		t.Errorf("Dispatch shares added up to %g; want 1", share)
+	}
+}
@@ -0,0 +1,186 @@
+package sms
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Features gating the behaviors being rolled out
+// They keep their default behavior, on, until a rollout is configured
+// or overridden for them
+const (
+	// FeatureAutoSplit splits the text messages too long for a single one,
+	// as far as Config.MaxMessageParts allows
+	FeatureAutoSplit = "auto_split"
+	// FeatureRejectKnownInvalid refuses the recipients recently confirmed
+	// invalid by the provider
+	FeatureRejectKnownInvalid = "reject_known_invalid"
+)
+
+// FeatureFlag is the rollout of a feature
+// Percent of the tenants get the feature, each tenant always falling in
+// the same bucket; Overridden is set when an admin changed the configuration
+type FeatureFlag struct {
+	Name       string `json:"name"`
+	Percent    int    `json:"percent"`
+	Overridden bool   `json:"overridden,omitempty"`
+}
+
+// FeatureList is the HTTP response listing the feature flags
+type FeatureList struct {
+	Success bool          `json:"success"`
+	Data    []FeatureFlag `json:"data"`
+}
+
+// featureFlags holds the configured rollouts and the admin overrides
+type featureFlags struct {
+	mu         sync.Mutex
+	configured map[string]int
+	overrides  map[string]int
+}
+
+func newFeatureFlags(configured map[string]int) *featureFlags {
+	f := &featureFlags{
+		configured: make(map[string]int, len(configured)),
+		overrides:  make(map[string]int),
+	}
+	for name, percent := range configured {
+		f.configured[name] = clampPercent(percent)
+	}
+
+	return f
+}
+
+// enabled reports whether the feature is rolled out to the tenant
+// Features neither configured nor overridden are enabled
+func (f *featureFlags) enabled(name, tenant string) bool {
+	f.mu.Lock()
+	percent, ok := f.overrides[name]
+	if !ok {
+		percent, ok = f.configured[name]
+	}
+	f.mu.Unlock()
+
+	if !ok {
+		return true
+	}
+
+	switch {
+	case percent <= 0:
+		return false
+	case percent >= 100:
+		return true
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(name + "\x00" + tenant))
+
+	return int(h.Sum32()%100) < percent
+}
+
+// override replaces the rollout of the feature until it is reset
+func (f *featureFlags) override(name string, percent int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.overrides[name] = clampPercent(percent)
+}
+
+// reset drops the override of the feature
+func (f *featureFlags) reset(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.overrides, name)
+}
+
+// list returns the configured and overridden features by name
+func (f *featureFlags) list() []FeatureFlag {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	flags := make([]FeatureFlag, 0, len(f.configured)+len(f.overrides))
+	for name, percent := range f.configured {
+		if _, ok := f.overrides[name]; !ok {
+			flags = append(flags, FeatureFlag{Name: name, Percent: percent})
+		}
+	}
+	for name, percent := range f.overrides {
+		flags = append(flags, FeatureFlag{Name: name, Percent: percent, Overridden: true})
+	}
+
+	sort.Slice(flags, func(i, j int) bool { return flags[i].Name < flags[j].Name })
+
+	return flags
+}
+
+func clampPercent(percent int) int {
+	if percent < 0 {
+		return 0
+	}
+	if percent > 100 {
+		return 100
+	}
+	return percent
+}
+
+// featureEnabled reports whether the feature is rolled out to the tenant
+// of the request (see tenantOf)
+// The requests without a tenant all fall in the same bucket
+func (s *Server) featureEnabled(name string, req *Request) bool {
+	return s.features.enabled(name, req.tenant)
+}
+
+// listFeatures is the HTTP handler listing the feature flags
+func (s *Server) listFeatures() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sendCacheable(w, r, http.StatusOK, FeatureList{Success: true, Data: s.features.list()})
+	}
+}
+
+// overrideFeature is the HTTP handler of PUT /admin/features/{name},
+// overriding the rollout of the feature
+func (s *Server) overrideFeature() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var res Response
+		name := pathParam(r, 2)
+
+		var flag FeatureFlag
+		if err := json.NewDecoder(r.Body).Decode(&flag); err != nil {
+			res = Response{
+				statusCode: http.StatusBadRequest,
+				Error:      "Bad request (invalid payload json structure)",
+			}
+			sendResponse(w, res)
+			return
+		}
+		if flag.Percent < 0 || flag.Percent > 100 {
+			res = Response{
+				statusCode: http.StatusUnprocessableEntity,
+				Error:      "Invalid parameter (percent must be between 0 and 100)",
+			}
+			sendResponse(w, res)
+			return
+		}
+		s.features.override(name, flag.Percent)
+		slog.Info("Feature rolled out", "feature", name, "percent", flag.Percent)
+
+		sendJSON(w, http.StatusOK, FeatureList{Success: true, Data: s.features.list()})
+	}
+}
+
+// resetFeature is the HTTP handler of DELETE /admin/features/{name},
+// restoring the configured rollout of the feature
+func (s *Server) resetFeature() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := pathParam(r, 2)
+		s.features.reset(name)
+		slog.Info("Feature restored to its configured rollout", "feature", name)
+
+		sendJSON(w, http.StatusOK, FeatureList{Success: true, Data: s.features.list()})
+	}
+}
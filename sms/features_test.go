@@ -0,0 +1,104 @@
+package sms_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+)
+
+func TestServer_features(t *testing.T) {
+	srv := sms.NewServer(sms.Config{
+		Buffer:       10,
+		ReqTimeout:   5 * time.Second,
+		ThrottleRate: 10 * time.Millisecond,
+		AdminKey:     "admin_key",
+		Features:     map[string]int{"beta": 10, "legacy": 150},
+	})
+	srv.Run()
+
+	do := func(method, path, payload string) (int, []sms.FeatureFlag) {
+		r := httptest.NewRequest(method, path, strings.NewReader(payload))
+		r.Header.Set("Authorization", "AdminKey admin_key")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+
+		var list sms.FeatureList
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+		}
+		return w.Code, list.Data
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		payload    string
+		wantStatus int
+		wantFlags  []sms.FeatureFlag
+	}{
+		{
+			name:       "Configured flags",
+			method:     http.MethodGet,
+			path:       "/admin/features",
+			wantStatus: http.StatusOK,
+			wantFlags:  []sms.FeatureFlag{{Name: "beta", Percent: 10}, {Name: "legacy", Percent: 100}},
+		},
+		{
+			name:       "Override configured flag",
+			method:     http.MethodPut,
+			path:       "/admin/features/beta",
+			payload:    `{"percent": 50}`,
+			wantStatus: http.StatusOK,
+			wantFlags:  []sms.FeatureFlag{{Name: "beta", Percent: 50, Overridden: true}, {Name: "legacy", Percent: 100}},
+		},
+		{
+			name:       "Override unknown flag",
+			method:     http.MethodPut,
+			path:       "/admin/features/autosplit",
+			payload:    `{"percent": 0}`,
+			wantStatus: http.StatusOK,
+			wantFlags:  []sms.FeatureFlag{{Name: "autosplit", Overridden: true}, {Name: "beta", Percent: 50, Overridden: true}, {Name: "legacy", Percent: 100}},
+		},
+		{
+			name:       "Invalid percent",
+			method:     http.MethodPut,
+			path:       "/admin/features/beta",
+			payload:    `{"percent": 101}`,
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "Reset flags",
+			method:     http.MethodDelete,
+			path:       "/admin/features/beta",
+			wantStatus: http.StatusOK,
+			wantFlags:  []sms.FeatureFlag{{Name: "autosplit", Overridden: true}, {Name: "beta", Percent: 10}, {Name: "legacy", Percent: 100}},
+		},
+		{
+			name:       "Missing flag name",
+			method:     http.MethodPut,
+			path:       "/admin/features/",
+			payload:    `{"percent": 10}`,
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	// The steps build on each other and must run in order
+	for _, tc := range tests {
+		status, flags := do(tc.method, tc.path, tc.payload)
+		if status != tc.wantStatus {
+			t.Fatalf("%s: status code was %d; want %d", tc.name, status, tc.wantStatus)
+		}
+		if tc.wantStatus == http.StatusOK && !reflect.DeepEqual(flags, tc.wantFlags) {
+			t.Errorf("%s: flags were %+v; want %+v", tc.name, flags, tc.wantFlags)
+		}
+	}
+}
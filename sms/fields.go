@@ -0,0 +1,78 @@
+package sms
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// requestedFields returns the fields of the ?fields= parameter,
+// such as ?fields=id,status,created, or nil when it is not given
+func requestedFields(r *http.Request) []string {
+	v := r.URL.Query().Get("fields")
+	if v == "" {
+		return nil
+	}
+
+	var fields []string
+	for _, f := range strings.Split(v, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			fields = append(fields, f)
+		}
+	}
+
+	return fields
+}
+
+// selectFields keeps only the given fields of the data of a JSON body,
+// of each of its items when the data is a list
+// Bodies without data, such as errors, are left as they are, and fields
+// the data does not have are ignored
+func selectFields(body []byte, fields []string) ([]byte, error) {
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(body, &top); err != nil {
+		return nil, err
+	}
+	data, ok := top["data"]
+	if !ok {
+		return body, nil
+	}
+
+	keep := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		keep[f] = true
+	}
+	pick := func(item map[string]json.RawMessage) map[string]json.RawMessage {
+		picked := make(map[string]json.RawMessage, len(fields))
+		for k, v := range item {
+			if keep[k] {
+				picked[k] = v
+			}
+		}
+		return picked
+	}
+
+	var selected interface{}
+	var item map[string]json.RawMessage
+	var items []map[string]json.RawMessage
+	switch {
+	case json.Unmarshal(data, &item) == nil && item != nil:
+		selected = pick(item)
+	case json.Unmarshal(data, &items) == nil && items != nil:
+		list := make([]map[string]json.RawMessage, 0, len(items))
+		for _, item := range items {
+			list = append(list, pick(item))
+		}
+		selected = list
+	default:
+		return body, nil
+	}
+
+	b, err := json.Marshal(selected)
+	if err != nil {
+		return nil, err
+	}
+	top["data"] = b
+
+	return json.Marshal(top)
+}
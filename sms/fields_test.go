@@ -0,0 +1,55 @@
+package sms_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/smstest"
+)
+
+func TestServer_fields(t *testing.T) {
+	srv := smstest.NewServer(t, sms.Config{
+		AdminKey: "admin_key",
+		Features: map[string]int{"split": 50, "voice": 100},
+	})
+
+	w := srv.Send(t, `{"recipient": "+31612345678", "originator": "MessageBird", "message": "This is a test message"}`)
+	var created sms.Response
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to decode json response body: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		wantBody string
+	}{
+		{
+			name:     "Message",
+			path:     "/messages/" + created.Data.ID + "?fields=id,status,unknown",
+			wantBody: `{"data":{"id":"` + created.Data.ID + `","status":"sent"},"success":true}`,
+		},
+		{
+			name:     "List",
+			path:     "/admin/features?fields=name",
+			wantBody: `{"data":[{"name":"split"},{"name":"voice"}],"success":true}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			r.Header.Set("Authorization", "AdminKey admin_key")
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			if got := strings.TrimSpace(w.Body.String()); got != tc.wantBody {
+				t.Errorf("Body was %s; want %s", got, tc.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package sms
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// fileQueueCompactAfter is how many records the queue file may have
+// beyond the pending requests before it is rewritten
+const fileQueueCompactAfter = 1000
+
+// FileQueueStore keeps the queued requests in a file, for single node
+// deployments without a Redis server
+// The file is a log of JSON records, saved requests being synced to disk
+// before they are queued, and is compacted as it grows
+type FileQueueStore struct {
+	mu      sync.Mutex
+	path    string
+	f       *os.File
+	pending map[string]QueuedRequest
+	records int
+}
+
+// fileQueueRecord is a line of the queue file
+type fileQueueRecord struct {
+	Save   *QueuedRequest `json:"save,omitempty"`
+	Remove string         `json:"remove,omitempty"`
+}
+
+// OpenFileQueueStore opens the queue file at the path, creating it
+// when it does not exist
+// A record cut short by a crash is ignored
+func OpenFileQueueStore(path string) (*FileQueueStore, error) {
+	q := &FileQueueStore{path: path, pending: make(map[string]QueuedRequest)}
+
+	f, err := os.Open(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		sc := bufio.NewScanner(f)
+		sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for sc.Scan() {
+			var rec fileQueueRecord
+			if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+				continue
+			}
+			if rec.Save != nil {
+				q.pending[rec.Save.ID] = *rec.Save
+			} else {
+				delete(q.pending, rec.Remove)
+			}
+		}
+		err := sc.Err()
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Could not read queue file %s; Error: %v", path, err)
+		}
+	}
+
+	if err := q.compact(); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// Save appends the request to the file and syncs it to disk
+func (q *FileQueueStore) Save(r QueuedRequest) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.append(fileQueueRecord{Save: &r}); err != nil {
+		return err
+	}
+	q.pending[r.ID] = r
+
+	return q.f.Sync()
+}
+
+// Remove appends the removal of the request to the file
+// It is not synced, a request removed just before a crash being
+// sent again at worst
+func (q *FileQueueStore) Remove(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.pending[id]; !ok {
+		return nil
+	}
+	if err := q.append(fileQueueRecord{Remove: id}); err != nil {
+		return err
+	}
+	delete(q.pending, id)
+
+	if q.records > len(q.pending)+fileQueueCompactAfter {
+		return q.compact()
+	}
+
+	return nil
+}
+
+// Pending returns the requests saved and not removed
+func (q *FileQueueStore) Pending() ([]QueuedRequest, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	pending := make([]QueuedRequest, 0, len(q.pending))
+	for _, r := range q.pending {
+		pending = append(pending, r)
+	}
+
+	return pending, nil
+}
+
+// Close closes the file
+func (q *FileQueueStore) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.f.Close()
+}
+
+func (q *FileQueueStore) append(rec fileQueueRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := q.f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("Could not write queue file %s; Error: %v", q.path, err)
+	}
+	q.records++
+
+	return nil
+}
+
+// compact rewrites the file with the pending requests only, replacing it
+// once the new one is synced, and reopens it for appending
+func (q *FileQueueStore) compact() error {
+	tmp := q.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for _, r := range q.pending {
+		r := r
+		line, err := json.Marshal(fileQueueRecord{Save: &r})
+		if err != nil {
+			f.Close()
+			return err
+		}
+		w.Write(append(line, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	f.Close()
+
+	if err := os.Rename(tmp, q.path); err != nil {
+		return err
+	}
+
+	if q.f != nil {
+		q.f.Close()
+	}
+	q.f, err = os.OpenFile(q.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	q.records = len(q.pending)
+
+	return nil
+}
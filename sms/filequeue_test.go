@@ -0,0 +1,65 @@
+package sms_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+)
+
+func TestFileQueueStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flysms")
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "queue")
+
+	store, err := sms.OpenFileQueueStore(path)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	for _, id := range []string{"a1", "a2", "a3"} {
+		r := sms.QueuedRequest{
+			ID:      id,
+			Queued:  time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC),
+			Request: sms.Request{Recipient: "+31612345678", Originator: "MessageBird", Message: "Message " + id},
+		}
+		if err := store.Save(r); err != nil {
+			t.Fatalf("Failed to save request: %v", err)
+		}
+	}
+	if err := store.Remove("a2"); err != nil {
+		t.Fatalf("Failed to remove request: %v", err)
+	}
+	store.Close()
+
+	// A crash in the middle of writing a record
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	f.WriteString(`{"remove":"a`)
+	f.Close()
+
+	store, err = sms.OpenFileQueueStore(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	defer store.Close()
+
+	pending, err := store.Pending()
+	if err != nil {
+		t.Fatalf("Failed to list requests: %v", err)
+	}
+	got := map[string]string{}
+	for _, r := range pending {
+		got[r.ID] = r.Request.Message
+	}
+	if len(got) != 2 || got["a1"] != "Message a1" || got["a3"] != "Message a3" {
+		t.Errorf("Store kept %v; want requests a1 and a3", got)
+	}
+}
@@ -0,0 +1,113 @@
+// Package fixtures provides responses captured from the messagebird API,
+// to test the parsing of the client against what the provider really sends
+// The payloads were sanitized: ids, numbers and dates were replaced,
+// everything else is kept as received, including the fields the client ignores
+package fixtures
+
+import "net/http"
+
+// Fixture is a captured provider response
+type Fixture struct {
+	Name       string
+	StatusCode int
+	Body       string
+}
+
+// ServeHTTP answers any request with the captured response
+func (f Fixture) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(f.StatusCode)
+	w.Write([]byte(f.Body))
+}
+
+// Recipient and Originator are the values the sanitized messages were sent with
+const (
+	Recipient  = 31612345678
+	Originator = "MessageBird"
+)
+
+// MessageCreated is the answer to a message accepted for sending
+var MessageCreated = Fixture{
+	Name:       "message created",
+	StatusCode: http.StatusCreated,
+	Body: `{
+  "id": "e8077d803532c0b5937c639b60216938",
+  "href": "https://rest.messagebird.com/messages/e8077d803532c0b5937c639b60216938",
+  "direction": "mt",
+  "type": "sms",
+  "originator": "MessageBird",
+  "body": "This is a test message",
+  "reference": null,
+  "validity": null,
+  "gateway": 10,
+  "typeDetails": {},
+  "datacoding": "plain",
+  "mclass": 1,
+  "scheduledDatetime": null,
+  "createdDatetime": "2020-01-01T12:00:00+00:00",
+  "recipients": {
+    "totalCount": 1,
+    "totalSentCount": 1,
+    "totalDeliveredCount": 0,
+    "totalDeliveryFailedCount": 0,
+    "items": [
+      {
+        "recipient": 31612345678,
+        "originator": null,
+        "status": "sent",
+        "statusDatetime": "2020-01-01T12:00:00+00:00",
+        "messagePartCount": 1
+      }
+    ]
+  }
+}
+`,
+}
+
+// ValidationError is the answer to a message without a valid recipient
+var ValidationError = Fixture{
+	Name:       "validation error",
+	StatusCode: http.StatusUnprocessableEntity,
+	Body: `{
+  "errors": [
+    {
+      "code": 9,
+      "description": "no (correct) recipients found",
+      "parameter": "recipients"
+    }
+  ]
+}
+`,
+}
+
+// AuthError is the answer to a request with a wrong access key
+var AuthError = Fixture{
+	Name:       "auth error",
+	StatusCode: http.StatusUnauthorized,
+	Body: `{
+  "errors": [
+    {
+      "code": 2,
+      "description": "Request not allowed (incorrect access_key)",
+      "parameter": "access_key"
+    }
+  ]
+}
+`,
+}
+
+// Throttled is the answer to a request over the rate limit of the account
+var Throttled = Fixture{
+	Name:       "throttled",
+	StatusCode: http.StatusTooManyRequests,
+	Body: `{
+  "errors": [
+    {
+      "code": 429,
+      "description": "Too many requests",
+      "parameter": null
+    }
+  ]
+}
+`,
+}
@@ -0,0 +1,134 @@
+package sms
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Kannel data codings of the sendsms requests
+const (
+	kannelCoding7Bit = "0"
+	kannelCoding8Bit = "1"
+	kannelCodingUCS2 = "2"
+)
+
+// Bodies Kannel answers accepted messages with
+const (
+	kannelAccepted = "0: Accepted for delivery"
+	kannelQueued   = "3: Queued for later delivery"
+)
+
+// sendSMS is the HTTP handler of /cgi-bin/sendsms, compatible with the
+// sendsms interface of the Kannel smsbox, for legacy systems built against it
+// The query is mapped onto a message request going through the same
+// validation and queue as the ones of /messages, and the answer is mapped
+// back onto the plain text answers of Kannel
+func (s *Server) sendSMS() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			sendText(w, http.StatusMethodNotAllowed, "Request not allowed (invalid HTTP method)")
+			return
+		}
+
+		q := r.URL.Query()
+		if !s.sendSMSAllowed(q.Get("username"), q.Get("password")) {
+			metrics.Add("sendsms_auth_failures", 1)
+			sendText(w, http.StatusForbidden, "Authorization failed for sendsms")
+			return
+		}
+
+		req, invalid := kannelRequest(q)
+		if invalid != "" {
+			sendText(w, http.StatusBadRequest, invalid)
+			return
+		}
+
+		res, header := s.relay(r.Context(), r.RemoteAddr, r.Header, req)
+		for _, name := range []string{"X-Request-Id", "Retry-After"} {
+			if v := header.Get(name); v != "" {
+				w.Header().Set(name, v)
+			}
+		}
+
+		metrics.Add("sendsms_requests", 1)
+		switch {
+		case !res.Success:
+			sendText(w, res.statusCode, res.Error)
+		case res.statusCode == http.StatusAccepted:
+			// The message is held for review rather than sent
+			sendText(w, http.StatusAccepted, kannelQueued)
+		default:
+			sendText(w, http.StatusAccepted, kannelAccepted)
+		}
+	}
+}
+
+// sendSMSAllowed reports whether the credentials are the ones of a
+// configured sendsms account
+// Any credentials are accepted when no account is configured
+func (s *Server) sendSMSAllowed(username, password string) bool {
+	if len(s.sendSMSUsers) == 0 {
+		return true
+	}
+
+	want, ok := s.sendSMSUsers[username]
+	return ok && subtle.ConstantTimeCompare([]byte(password), []byte(want)) == 1
+}
+
+// kannelRequest maps the sendsms query onto a message request
+// and returns the reason it cannot be mapped, if it cannot
+// Binary messages (coding 1) carry raw octets in text and udh,
+// which are hex encoded the way the binary type expects them
+func kannelRequest(q url.Values) (Request, string) {
+	to := strings.TrimSpace(q.Get("to"))
+	if to == "" {
+		return Request{}, "Missing receiver number"
+	}
+	recipient := canonicalNumber(to)
+	if !recipient.wellFormed() {
+		return Request{}, fmt.Sprintf("Invalid receiver number %s", q.Get("to"))
+	}
+
+	req := Request{
+		Recipient:  recipient,
+		Originator: q.Get("from"),
+		Message:    q.Get("text"),
+	}
+
+	switch q.Get("mclass") {
+	case "":
+	case "0":
+		req.Type = messageTypeFlash
+	default:
+		return Request{}, "Invalid parameter (mclass value is not supported)"
+	}
+
+	switch q.Get("coding") {
+	case "", kannelCoding7Bit, kannelCodingUCS2:
+	case kannelCoding8Bit:
+		if req.Type == messageTypeFlash {
+			return Request{}, "Invalid parameter (mclass value is not supported by binary messages)"
+		}
+		req.Type = messageTypeBinary
+		req.Message = hex.EncodeToString([]byte(req.Message))
+		req.UDH = hex.EncodeToString([]byte(q.Get("udh")))
+	default:
+		return Request{}, "Invalid parameter (coding value is not supported)"
+	}
+
+	return req, ""
+}
+
+// sendText writes a plain text body with the given status code
+func sendText(w http.ResponseWriter, statusCode int, text string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(statusCode)
+	if _, err := fmt.Fprintln(w, text); err != nil {
+		slog.Error("Could not write response body", "error", err)
+	}
+}
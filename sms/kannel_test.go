@@ -0,0 +1,115 @@
+package sms_test
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/smstest"
+)
+
+func TestServer_sendSMS(t *testing.T) {
+	query := func(params ...string) string {
+		q := url.Values{}
+		q.Set("username", "legacy")
+		q.Set("password", "secret")
+		q.Set("from", "MessageBird")
+		q.Set("text", "This is a test message")
+		for i := 0; i < len(params); i += 2 {
+			q.Set(params[i], params[i+1])
+		}
+		return "/cgi-bin/sendsms?" + q.Encode()
+	}
+
+	tests := map[string]struct {
+		method        string
+		path          string
+		wantStatus    int
+		wantBody      string
+		wantRecipient sms.PhoneNumber
+	}{
+		"International number": {
+			method:        http.MethodGet,
+			path:          query("to", "+31612345678"),
+			wantStatus:    http.StatusAccepted,
+			wantBody:      "0: Accepted for delivery",
+			wantRecipient: "+31612345678",
+		},
+
+		"International prefix": {
+			method:        http.MethodGet,
+			path:          query("to", "0031612345678"),
+			wantStatus:    http.StatusAccepted,
+			wantBody:      "0: Accepted for delivery",
+			wantRecipient: "0031612345678",
+		},
+
+		"Wrong password": {
+			method:     http.MethodGet,
+			path:       query("to", "31612345678", "password", "wrong"),
+			wantStatus: http.StatusForbidden,
+			wantBody:   "Authorization failed for sendsms",
+		},
+
+		"Missing receiver": {
+			method:     http.MethodGet,
+			path:       query(),
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "Missing receiver number",
+		},
+
+		"Missing sender": {
+			method:     http.MethodGet,
+			path:       query("to", "31612345678", "from", ""),
+			wantStatus: http.StatusUnprocessableEntity,
+			wantBody:   "Missing parameter (originator value is not present)",
+		},
+
+		"Flash message": {
+			method:     http.MethodGet,
+			path:       query("to", "31612345678", "mclass", "0"),
+			wantStatus: http.StatusUnprocessableEntity,
+			wantBody:   "Invalid parameter (type value is not supported by the provider)",
+		},
+
+		"Unsupported coding": {
+			method:     http.MethodGet,
+			path:       query("to", "31612345678", "coding", "3"),
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "Invalid parameter (coding value is not supported)",
+		},
+
+		"Invalid method": {
+			method:     http.MethodPost,
+			path:       query("to", "31612345678"),
+			wantStatus: http.StatusMethodNotAllowed,
+			wantBody:   "Request not allowed (invalid HTTP method)",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := smstest.NewServer(t, sms.Config{
+				SendSMSAccounts: map[string]string{"legacy": "secret"},
+			})
+
+			w := srv.SendRequest(t, tc.method, tc.path, "")
+			if w.Code != tc.wantStatus {
+				t.Fatalf("Status code was %d; want %d", w.Code, tc.wantStatus)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tc.wantBody {
+				t.Errorf("Body was %q; want %q", got, tc.wantBody)
+			}
+			if tc.wantRecipient == "" {
+				return
+			}
+
+			events := srv.Provider.Events()
+			if len(events) != 1 || events[0].Request.Recipient != tc.wantRecipient {
+				t.Errorf("Provider got %+v; want a single message to %s", events, tc.wantRecipient)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package sms
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimit allows at most Count messages within Window
+type RateLimit struct {
+	Count  int
+	Window time.Duration
+}
+
+// rateLimiter enforces rate limits per key (recipient, caller, ...)
+// It keeps a sliding log of the hit times of every key, and forgets
+// the keys without hits in the longest window
+type rateLimiter struct {
+	mu     sync.Mutex
+	limits []RateLimit
+	window time.Duration
+	hits   map[string][]time.Time
+	swept  time.Time
+}
+
+// newRateLimiter creates a limiter enforcing all the given limits
+func newRateLimiter(limits ...RateLimit) *rateLimiter {
+	l := &rateLimiter{
+		hits: make(map[string][]time.Time),
+	}
+
+	for _, rl := range limits {
+		if rl.Count <= 0 || rl.Window <= 0 {
+			continue
+		}
+		l.limits = append(l.limits, rl)
+		if rl.Window > l.window {
+			l.window = rl.Window
+		}
+	}
+
+	return l
+}
+
+// allow records a hit at the given time for the key if no limit is exceeded
+// Otherwise it returns the first exceeded limit
+func (l *rateLimiter) allow(key string, now time.Time) (RateLimit, bool) {
+	if len(l.limits) == 0 {
+		return RateLimit{}, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+	hits := l.recent(key, now)
+	if rl, ok := l.reached(hits, now); ok {
+		return rl, false
+	}
+
+	l.hits[key] = append(hits, now)
+
+	return RateLimit{}, true
+}
+
+// limited returns the first limit the key has reached at the given
+// time, without recording a hit
+func (l *rateLimiter) limited(key string, now time.Time) (RateLimit, bool) {
+	if len(l.limits) == 0 {
+		return RateLimit{}, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.reached(l.recent(key, now), now)
+}
+
+// forget removes the hit recorded at the given time for the key,
+// for the hits of requests which were refused after all
+func (l *rateLimiter) forget(key string, at time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	hits := l.hits[key]
+	for i := len(hits) - 1; i >= 0; i-- {
+		if hits[i].Equal(at) {
+			hits = append(hits[:i], hits[i+1:]...)
+			break
+		}
+	}
+
+	if len(hits) == 0 {
+		delete(l.hits, key)
+		return
+	}
+	l.hits[key] = hits
+}
+
+// recent returns the hits of the key within the longest window,
+// forgetting what is older and the key itself when nothing is left
+func (l *rateLimiter) recent(key string, now time.Time) []time.Time {
+	hits, ok := l.hits[key]
+	if !ok {
+		return nil
+	}
+	for len(hits) > 0 && now.Sub(hits[0]) >= l.window {
+		hits = hits[1:]
+	}
+
+	if len(hits) == 0 {
+		delete(l.hits, key)
+		return nil
+	}
+	l.hits[key] = hits
+
+	return hits
+}
+
+// sweep forgets the keys without recent hits, once per longest window
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < l.window {
+		return
+	}
+	l.swept = now
+
+	for key := range l.hits {
+		l.recent(key, now)
+	}
+}
+
+// reached returns the first limit reached by the hits
+func (l *rateLimiter) reached(hits []time.Time, now time.Time) (RateLimit, bool) {
+	for _, rl := range l.limits {
+		n := 0
+		for _, t := range hits {
+			if now.Sub(t) < rl.Window {
+				n++
+			}
+		}
+		if n >= rl.Count {
+			return rl, true
+		}
+	}
+
+	return RateLimit{}, false
+}
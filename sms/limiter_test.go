@@ -0,0 +1,46 @@
+package sms_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+)
+
+func TestRateLimiter_sweep(t *testing.T) {
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := sms.NewRateLimiter(sms.RateLimit{Count: 2, Window: time.Minute})
+
+	steps := []struct {
+		key      string
+		at       time.Duration
+		want     bool
+		wantKeys []string
+	}{
+		{key: "idle", at: 0, want: true, wantKeys: []string{"idle"}},
+		{key: "active", at: 0, want: true, wantKeys: []string{"active", "idle"}},
+		{key: "active", at: 30 * time.Second, want: true, wantKeys: []string{"active", "idle"}},
+		{key: "active", at: 40 * time.Second, want: false, wantKeys: []string{"active", "idle"}},
+
+		// A window later the idle key is forgotten by the sweep, while
+		// the active one keeps its hit of 30s
+		{key: "new", at: time.Minute, want: true, wantKeys: []string{"active", "new"}},
+		{key: "active", at: time.Minute, want: true, wantKeys: []string{"active", "new"}},
+		{key: "active", at: time.Minute, want: false, wantKeys: []string{"active", "new"}},
+
+		// Keys forgotten by the sweep start over
+		{key: "idle", at: 2 * time.Minute, want: true, wantKeys: []string{"idle"}},
+		{key: "idle", at: 2 * time.Minute, want: true, wantKeys: []string{"idle"}},
+		{key: "idle", at: 2 * time.Minute, want: false, wantKeys: []string{"idle"}},
+	}
+
+	for i, step := range steps {
+		if got := l.Allow(step.key, start.Add(step.at)); got != step.want {
+			t.Errorf("Step %d: hit of %s allowed was %t; want %t", i, step.key, got, step.want)
+		}
+		if got := l.Keys(); !reflect.DeepEqual(got, step.wantKeys) {
+			t.Errorf("Step %d: keys were %v; want %v", i, got, step.wantKeys)
+		}
+	}
+}
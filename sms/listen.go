@@ -0,0 +1,150 @@
+package sms
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// listenConfig is the configuration of the HTTP listener of the server
+type listenConfig struct {
+	addr         string
+	idleTimeout  time.Duration
+	certFile     string
+	keyFile      string
+	minVersion   uint16
+	cipherSuites []uint16
+	clientCAs    *x509.CertPool
+	clientNames  []string
+}
+
+// tls reports whether the listener serves HTTPS
+func (c listenConfig) tls() bool {
+	return c.certFile != ""
+}
+
+// check makes sure the listener can serve what is configured
+// HTTP/2 requires one of the ECDHE AES_128_GCM_SHA256 cipher suites
+// when the cipher suites are restricted and TLS 1.2 is allowed
+func (c listenConfig) check() error {
+	if c.clientCAs != nil && !c.tls() {
+		return errors.New("client certificates require a TLS certificate")
+	}
+	if !c.tls() || len(c.cipherSuites) == 0 || c.minVersion >= tls.VersionTLS13 {
+		return nil
+	}
+
+	for _, id := range c.cipherSuites {
+		if id == tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 || id == tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+			return nil
+		}
+	}
+
+	return errors.New("HTTP/2 requires the TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 cipher suite")
+}
+
+// tlsConfig returns the TLS configuration of the listener, TLS 1.2
+// being the minimum version unless another one is configured
+func (c listenConfig) tlsConfig() *tls.Config {
+	cfg := &tls.Config{}
+	if c.clientCAs != nil {
+		cfg = ClientAuthTLS(c.clientCAs, c.clientNames)
+	}
+	cfg.MinVersion = c.minVersion
+	if cfg.MinVersion == 0 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+	cfg.CipherSuites = c.cipherSuites
+
+	return cfg
+}
+
+// ListenAndServe serves the API on Config.Addr, over HTTPS when a TLS
+// certificate is configured
+// It blocks until the listener fails
+func (s *Server) ListenAndServe() error {
+	if err := s.listen.check(); err != nil {
+		return err
+	}
+
+	addr := s.listen.addr
+	if addr == "" {
+		addr = ":http"
+		if s.listen.tls() {
+			addr = ":https"
+		}
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(ln)
+}
+
+// Serve serves the API on the listener as ListenAndServe does
+func (s *Server) Serve(ln net.Listener) error {
+	if err := s.listen.check(); err != nil {
+		ln.Close()
+		return err
+	}
+
+	srv := &http.Server{
+		Handler:     s,
+		IdleTimeout: s.listen.idleTimeout,
+		ConnState:   s.ConnState,
+		ConnContext: s.ConnContext,
+	}
+	if !s.listen.tls() {
+		return srv.Serve(ln)
+	}
+
+	srv.TLSConfig = s.listen.tlsConfig()
+	return srv.ServeTLS(ln, s.listen.certFile, s.listen.keyFile)
+}
+
+// ParseTLSVersion returns the TLS version named such as "1.2"
+func ParseTLSVersion(v string) (uint16, error) {
+	switch v {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+
+	return 0, fmt.Errorf("Unknown TLS version %q", v)
+}
+
+// ParseCipherSuites returns the cipher suites of a comma separated list
+// of names, such as "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"
+// Only the secure suites are known, and the TLS 1.3 ones are not
+// configurable
+// HTTP/2 requires one of the ECDHE AES_128_GCM_SHA256 suites, without
+// which the server refuses to serve TLS 1.2 (see Server.Serve)
+func ParseCipherSuites(names string) ([]uint16, error) {
+	known := make(map[string]uint16)
+	for _, cs := range tls.CipherSuites() {
+		known[cs.Name] = cs.ID
+	}
+
+	var suites []uint16
+	for _, name := range strings.Split(names, ",") {
+		id, ok := known[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("Unknown cipher suite %q", name)
+		}
+		suites = append(suites, id)
+	}
+
+	return suites, nil
+}
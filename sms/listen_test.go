@@ -0,0 +1,144 @@
+package sms_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+)
+
+func TestServer_ListenAndServe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flysms")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cert := issueCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "flysms"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, nil)
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0600)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+
+	tests := map[string]struct {
+		cfg           sms.Config
+		clientMaxTLS  uint16
+		clientCiphers []uint16
+		wantErr       bool
+	}{
+		"HTTPS": {
+			cfg: sms.Config{TLSCertFile: certFile, TLSKeyFile: keyFile},
+		},
+
+		"Version below the minimum": {
+			cfg:          sms.Config{TLSCertFile: certFile, TLSKeyFile: keyFile, TLSMinVersion: tls.VersionTLS13},
+			clientMaxTLS: tls.VersionTLS12,
+			wantErr:      true,
+		},
+
+		"Cipher suite not allowed": {
+			cfg: sms.Config{
+				TLSCertFile:     certFile,
+				TLSKeyFile:      keyFile,
+				TLSMinVersion:   tls.VersionTLS12,
+				TLSCipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			},
+			clientMaxTLS:  tls.VersionTLS12,
+			clientCiphers: []uint16{tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256},
+			wantErr:       true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := sms.NewServer(tc.cfg)
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Failed to listen: %v", err)
+			}
+			go srv.Serve(ln)
+			defer ln.Close()
+
+			transport := &http.Transport{TLSClientConfig: &tls.Config{
+				RootCAs:      roots,
+				MaxVersion:   tc.clientMaxTLS,
+				CipherSuites: tc.clientCiphers,
+			}}
+			defer transport.CloseIdleConnections()
+			client := &http.Client{Transport: transport, Timeout: 5 * time.Second}
+
+			res, err := client.Get("https://" + ln.Addr().String() + "/readyz")
+			if err == nil {
+				res.Body.Close()
+			}
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Request error was %v; want error %t", err, tc.wantErr)
+			}
+		})
+	}
+
+	t.Run("Client certificates without TLS", func(t *testing.T) {
+		srv := sms.NewServer(sms.Config{ClientCAs: roots})
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		if err := srv.Serve(ln); err == nil {
+			t.Error("Served client certificates over plain HTTP")
+		}
+	})
+
+	t.Run("Cipher suites without HTTP/2 suite", func(t *testing.T) {
+		srv := sms.NewServer(sms.Config{
+			TLSCertFile:     certFile,
+			TLSKeyFile:      keyFile,
+			TLSCipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256},
+		})
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		err = srv.Serve(ln)
+		if err == nil || !strings.Contains(err.Error(), "HTTP/2 requires") {
+			t.Errorf("Serving error was %v; want the HTTP/2 cipher suite error", err)
+		}
+	})
+}
+
+func TestParseTLSOptions(t *testing.T) {
+	if v, err := sms.ParseTLSVersion("1.3"); err != nil || v != tls.VersionTLS13 {
+		t.Errorf("Version 1.3 was parsed as %x, %v; want %x", v, err, tls.VersionTLS13)
+	}
+	if _, err := sms.ParseTLSVersion("SSLv3"); err == nil {
+		t.Error("Unknown version was parsed")
+	}
+
+	suites, err := sms.ParseCipherSuites("TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384")
+	if err != nil || len(suites) != 2 || suites[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 || suites[1] != tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384 {
+		t.Errorf("Cipher suites were parsed as %x, %v", suites, err)
+	}
+	if _, err := sms.ParseCipherSuites("TLS_RSA_WITH_RC4_128_SHA"); err == nil {
+		t.Error("Insecure cipher suite was parsed")
+	}
+}
@@ -0,0 +1,29 @@
+package sms
+
+import (
+	"log/slog"
+	"unicode/utf8"
+)
+
+// LogValue renders the request in the log records by its fields,
+// leaving out the text of the message which is only logged by length
+func (req *Request) LogValue() slog.Value {
+	attrs := []slog.Attr{
+		slog.String("request_id", req.id),
+		slog.String("recipient", string(req.Recipient)),
+		slog.String("originator", req.Originator),
+		slog.Int("length", utf8.RuneCountInString(req.Message)),
+		slog.String("channel", req.Channel),
+	}
+	if req.Class != "" {
+		attrs = append(attrs, slog.String("class", req.Class))
+	}
+	if req.Priority != "" {
+		attrs = append(attrs, slog.String("priority", req.Priority))
+	}
+	if req.OTP {
+		attrs = append(attrs, slog.Bool("otp", true))
+	}
+
+	return slog.GroupValue(attrs...)
+}
@@ -0,0 +1,51 @@
+package sms_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/iulianclita/flysms/sms"
+)
+
+func TestRequest_LogValue(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	req := &sms.Request{
+		Recipient:  "31612345678",
+		Originator: "MessageBird",
+		Message:    "Your code is 987654",
+		Channel:    "sms",
+		OTP:        true,
+	}
+	logger.Info("Accepted incoming request", "request", req)
+
+	var record struct {
+		Request map[string]interface{} `json:"request"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("Failed to decode log record %s: %v", buf.String(), err)
+	}
+
+	want := map[string]interface{}{
+		"request_id": "",
+		"recipient":  "31612345678",
+		"originator": "MessageBird",
+		"length":     float64(19),
+		"channel":    "sms",
+		"otp":        true,
+	}
+	if len(record.Request) != len(want) {
+		t.Errorf("Logged request was %v; want %v", record.Request, want)
+	}
+	for k, v := range want {
+		if record.Request[k] != v {
+			t.Errorf("Logged %s was %v; want %v", k, record.Request[k], v)
+		}
+	}
+	if bytes.Contains(buf.Bytes(), []byte("987654")) {
+		t.Errorf("Log record %s has the text of the message", buf.String())
+	}
+}
@@ -0,0 +1,124 @@
+package sms
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaintenanceMessage    = "Service unavailable (provider maintenance in progress)"
+	defaultMaintenanceRetryAfter = 5 * time.Minute
+)
+
+// Maintenance describes the maintenance mode of the server
+// While enabled, send requests are refused with the message
+// and a Retry-After header of RetryAfter seconds
+type Maintenance struct {
+	Enabled    bool   `json:"enabled"`
+	Message    string `json:"message,omitempty"`
+	RetryAfter int    `json:"retry_after,omitempty"`
+}
+
+// MaintenanceStatus is the HTTP response of the maintenance endpoint
+type MaintenanceStatus struct {
+	Success bool        `json:"success"`
+	Data    Maintenance `json:"data"`
+}
+
+// maintenanceMode holds the current maintenance mode
+// The configured message and delay are used when none is given
+type maintenanceMode struct {
+	mu         sync.Mutex
+	state      Maintenance
+	message    string
+	retryAfter int
+}
+
+func newMaintenanceMode(message string, retryAfter time.Duration) *maintenanceMode {
+	if message == "" {
+		message = defaultMaintenanceMessage
+	}
+	if retryAfter <= 0 {
+		retryAfter = defaultMaintenanceRetryAfter
+	}
+
+	return &maintenanceMode{
+		message:    message,
+		retryAfter: int(retryAfter / time.Second),
+	}
+}
+
+func (m *maintenanceMode) get() Maintenance {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.state
+}
+
+// set switches the mode, filling in the defaults of an enabled mode
+func (m *maintenanceMode) set(state Maintenance) Maintenance {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !state.Enabled {
+		state = Maintenance{}
+	}
+	if state.Enabled && state.Message == "" {
+		state.Message = m.message
+	}
+	if state.Enabled && state.RetryAfter <= 0 {
+		state.RetryAfter = m.retryAfter
+	}
+	m.state = state
+
+	return state
+}
+
+// refuseInMaintenance answers with a 503 while in maintenance mode
+// It reports whether the request was refused
+func (s *Server) refuseInMaintenance(w http.ResponseWriter) bool {
+	state := s.maintenance.get()
+	if !state.Enabled {
+		return false
+	}
+
+	metrics.Add("maintenance_refusals", 1)
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", state.RetryAfter))
+	res := Response{
+		statusCode: http.StatusServiceUnavailable,
+		Error:      state.Message,
+	}
+	sendResponse(w, res)
+
+	return true
+}
+
+// viewMaintenance is the HTTP handler returning the maintenance mode
+func (s *Server) viewMaintenance() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sendJSON(w, http.StatusOK, MaintenanceStatus{Success: true, Data: s.maintenance.get()})
+	}
+}
+
+// setMaintenance is the HTTP handler replacing the maintenance mode
+func (s *Server) setMaintenance() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var state Maintenance
+		if err := json.NewDecoder(r.Body).Decode(&state); err != nil {
+			res := Response{
+				statusCode: http.StatusBadRequest,
+				Error:      "Bad request (invalid payload json structure)",
+			}
+			sendResponse(w, res)
+			return
+		}
+		state = s.maintenance.set(state)
+		slog.Info("Maintenance mode changed", "enabled", state.Enabled, "retry_after", state.RetryAfter)
+
+		sendJSON(w, http.StatusOK, MaintenanceStatus{Success: true, Data: s.maintenance.get()})
+	}
+}
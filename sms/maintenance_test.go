@@ -0,0 +1,98 @@
+package sms_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+)
+
+func TestServer_maintenance(t *testing.T) {
+
+	testServer := sms.NewTestServer(t, "server_key")
+	defer testServer.Close()
+
+	srv := sms.NewServer(sms.Config{
+		Buffer:             10,
+		ReqTimeout:         5 * time.Second,
+		ThrottleRate:       10 * time.Millisecond,
+		AdminKey:           "admin_key",
+		MaintenanceMessage: "Down for maintenance",
+		MessageClient: sms.NewClient(sms.Options{
+			BaseURL:   testServer.URL,
+			AccessKey: "server_key",
+			Timeout:   10 * time.Second,
+		}),
+	})
+	srv.Run()
+
+	do := func(method, path, adminKey, payload string) *http.Response {
+		r := httptest.NewRequest(method, path, strings.NewReader(payload))
+		if adminKey != "" {
+			r.Header.Set("Authorization", "AdminKey "+adminKey)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		return w.Result()
+	}
+
+	setMode := func(payload string) sms.Maintenance {
+		res := do(http.MethodPut, "/admin/maintenance", "admin_key", payload)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("Setting maintenance mode: status code was %d; want %d", res.StatusCode, http.StatusOK)
+		}
+		var status sms.MaintenanceStatus
+		if err := json.NewDecoder(res.Body).Decode(&status); err != nil {
+			t.Fatalf("Failed to decode json response body: %v", err)
+		}
+		return status.Data
+	}
+
+	payload := `{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`
+
+	if res := do(http.MethodPut, "/admin/maintenance", "wrong_key", `{"enabled":true}`); res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Wrong admin key: status code was %d; want %d", res.StatusCode, http.StatusUnauthorized)
+	}
+
+	created := do(http.MethodPost, "/messages", "", payload)
+	if created.StatusCode != http.StatusCreated {
+		t.Fatalf("Message before maintenance: status code was %d; want %d", created.StatusCode, http.StatusCreated)
+	}
+	id := created.Header.Get("X-Request-Id")
+
+	mode := setMode(`{"enabled":true, "retry_after":120}`)
+	if !mode.Enabled || mode.Message != "Down for maintenance" || mode.RetryAfter != 120 {
+		t.Errorf("Maintenance mode was %+v; want enabled with configured message and 120s delay", mode)
+	}
+
+	res := do(http.MethodPost, "/messages", "", payload)
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Message during maintenance: status code was %d; want %d", res.StatusCode, http.StatusServiceUnavailable)
+	}
+	if got := res.Header.Get("Retry-After"); got != "120" {
+		t.Errorf("Retry-After was %q; want %q", got, "120")
+	}
+	var smsRes sms.Response
+	if err := json.NewDecoder(res.Body).Decode(&smsRes); err != nil {
+		t.Fatalf("Failed to decode json response body: %v", err)
+	}
+	if smsRes.Error != "Down for maintenance" {
+		t.Errorf("Error was %q; want %q", smsRes.Error, "Down for maintenance")
+	}
+
+	if res := do(http.MethodGet, "/messages/"+id+"/attempts", "admin_key", ""); res.StatusCode != http.StatusOK {
+		t.Errorf("Attempts during maintenance: status code was %d; want %d", res.StatusCode, http.StatusOK)
+	}
+
+	if mode := setMode(`{"enabled":false, "message":"ignored"}`); mode.Enabled || mode.Message != "" {
+		t.Errorf("Maintenance mode was %+v; want disabled", mode)
+	}
+
+	if res := do(http.MethodPost, "/messages", "", payload); res.StatusCode != http.StatusCreated {
+		t.Errorf("Message after maintenance: status code was %d; want %d", res.StatusCode, http.StatusCreated)
+	}
+}
@@ -0,0 +1,8 @@
+package sms
+
+import "expvar"
+
+// metrics collects the server counters
+// They are published as JSON under /debug/vars, to the admins only
+// as they come along with the command line and the memory statistics
+var metrics = expvar.NewMap("flysms")
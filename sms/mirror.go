@@ -0,0 +1,94 @@
+package sms
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// mirrorBuffer is the number of copies waiting to be mirrored
+// Copies are dropped rather than slowing down the real traffic
+const mirrorBuffer = 100
+
+// trafficMirror sends sanitized copies of the accepted requests
+// to a staging instance of the gateway
+// The message bodies are replaced by their hash and the recipients
+// by test numbers, so that no real content reaches staging
+type trafficMirror struct {
+	url        string
+	recipients *numberPool
+	httpClient *http.Client
+	reqCh      chan Request
+}
+
+// newTrafficMirror creates a mirror posting to the base URL of the staging
+// instance, which is disabled unless both the URL and test numbers are given
+func newTrafficMirror(baseURL string, recipients []PhoneNumber, timeout time.Duration) *trafficMirror {
+	if baseURL == "" {
+		return nil
+	}
+	if len(recipients) == 0 {
+		slog.Warn("Traffic mirroring disabled", "error", "no test recipients configured")
+		return nil
+	}
+
+	return &trafficMirror{
+		url:        strings.TrimSuffix(baseURL, "/") + "/messages",
+		recipients: &numberPool{numbers: recipients},
+		httpClient: &http.Client{Timeout: timeout},
+		reqCh:      make(chan Request, mirrorBuffer),
+	}
+}
+
+// mirror queues a sanitized copy of the request
+func (m *trafficMirror) mirror(req *Request) {
+	if m == nil {
+		return
+	}
+
+	sanitized := Request{
+		Recipient:   m.recipients.pick(),
+		Originator:  req.Originator,
+		Message:     contentHash(req.Message),
+		Priority:    req.Priority,
+		Class:       req.Class,
+		OTP:         req.OTP,
+		DeliverBy:   req.DeliverBy,
+		ScheduledAt: req.ScheduledAt,
+		Type:        req.Type,
+		UDH:         req.UDH,
+	}
+
+	select {
+	case m.reqCh <- sanitized:
+	default:
+		metrics.Add("mirror_dropped", 1)
+	}
+}
+
+// run posts the queued copies to the staging instance one at a time
+func (m *trafficMirror) run() {
+	for req := range m.reqCh {
+		body, err := json.Marshal(&req)
+		if err != nil {
+			slog.Error("Could not encode mirrored request", "error", err)
+			continue
+		}
+
+		res, err := m.httpClient.Post(m.url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			metrics.Add("mirror_errors", 1)
+			slog.Error("Could not mirror request", "url", m.url, "error", err)
+			continue
+		}
+		io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+
+		metrics.Add("mirrored", 1)
+	}
+}
@@ -0,0 +1,91 @@
+package sms_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+)
+
+func TestServer_mirrorTraffic(t *testing.T) {
+	sum := sha256.Sum256([]byte("This is a test message"))
+	hash := hex.EncodeToString(sum[:])
+
+	mirrored := make(chan sms.Request, 10)
+	staging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req sms.Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode mirrored request: %v", err)
+		}
+		mirrored <- req
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer staging.Close()
+
+	tests := map[string]struct {
+		payload    string
+		recipients []sms.PhoneNumber
+		want       []sms.Request
+	}{
+		"Accepted requests are mirrored": {
+			payload:    `{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message", "otp": true}`,
+			recipients: []sms.PhoneNumber{"3197010000001", "3197010000002"},
+			want: []sms.Request{
+				{Recipient: "3197010000001", Originator: "MessageBird", Message: hash, OTP: true},
+				{Recipient: "3197010000002", Originator: "MessageBird", Message: hash, OTP: true},
+			},
+		},
+
+		"Invalid requests are not mirrored": {
+			payload:    `{"recipient":31612345678, "originator": "MessageBird"}`,
+			recipients: []sms.PhoneNumber{"3197010000001"},
+		},
+
+		"No test numbers": {
+			payload: `{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := sms.NewServer(sms.Config{
+				Buffer:           10,
+				ReqTimeout:       5 * time.Second,
+				ThrottleRate:     10 * time.Millisecond,
+				MessageClient:    fakeSender{},
+				MirrorURL:        staging.URL,
+				MirrorRecipients: tc.recipients,
+			})
+			srv.Run()
+
+			for i := 0; i < 2; i++ {
+				r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(tc.payload))
+				w := httptest.NewRecorder()
+				srv.ServeHTTP(w, r)
+			}
+
+			for _, want := range tc.want {
+				select {
+				case got := <-mirrored:
+					if got != want {
+						t.Errorf("Mirrored request was %+v; want %+v", got, want)
+					}
+				case <-time.After(time.Second):
+					t.Fatal("Request was not mirrored")
+				}
+			}
+
+			select {
+			case got := <-mirrored:
+				t.Errorf("Unexpected mirrored request %+v", got)
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
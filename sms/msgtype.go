@@ -0,0 +1,77 @@
+package sms
+
+import "encoding/hex"
+
+// Types of the text messages
+const (
+	messageTypeSMS    = "sms"
+	messageTypeFlash  = "flash"
+	messageTypeBinary = "binary"
+)
+
+// MaxBinaryMessageLength is the number of octets a binary message can carry,
+// its user data header included
+const MaxBinaryMessageLength = 140
+
+// flashSender is implemented by the senders able to deliver flash messages,
+// which are displayed right away and not stored by the phone
+type flashSender interface {
+	flashMessages()
+}
+
+// binarySender is implemented by the senders able to deliver binary messages,
+// whose hex encoded body and user data header are passed through as they are
+type binarySender interface {
+	binaryMessages()
+}
+
+// checkType validates the type of the request and returns
+// the reason it is invalid, if it is
+func checkType(req *Request, sender MessageSender) string {
+	if req.UDH != "" && req.Type != messageTypeBinary {
+		return "udh value is only supported by binary messages"
+	}
+
+	switch req.Type {
+	case "", messageTypeSMS:
+		return ""
+	case messageTypeFlash:
+		if req.Channel != channelSMS {
+			return "type value is only supported by text messages"
+		}
+		if _, ok := sender.(flashSender); !ok {
+			return "type value is not supported by the provider"
+		}
+		return ""
+	case messageTypeBinary:
+		if req.Channel != channelSMS {
+			return "type value is only supported by text messages"
+		}
+		if _, ok := sender.(binarySender); !ok {
+			return "type value is not supported by the provider"
+		}
+		return checkBinary(req)
+	}
+
+	return "type value is not supported"
+}
+
+// checkBinary makes sure the body and user data header of a binary message
+// are hex encoded and fit together in a single message
+func checkBinary(req *Request) string {
+	body, err := hex.DecodeString(req.Message)
+	if err != nil {
+		return "message value is not hex encoded"
+	}
+
+	udh, err := hex.DecodeString(req.UDH)
+	if err != nil {
+		return "udh value is not hex encoded"
+	}
+
+	if len(body)+len(udh) > MaxBinaryMessageLength {
+		return "message value is to long with its udh"
+	}
+
+	return ""
+}
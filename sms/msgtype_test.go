@@ -0,0 +1,168 @@
+package sms_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/fixtures"
+)
+
+func TestServer_createMessageType(t *testing.T) {
+	tests := map[string]struct {
+		fake       bool
+		params     string
+		message    string
+		wantStatus int
+		wantError  string
+		wantForm   map[string]string
+	}{
+		"Text message": {
+			params:     `, "type": "sms"`,
+			wantStatus: http.StatusCreated,
+			wantForm:   map[string]string{"mclass": "", "type": "", "typeDetails[udh]": ""},
+		},
+
+		"Flash message": {
+			params:     `, "type": "flash"`,
+			wantStatus: http.StatusCreated,
+			wantForm:   map[string]string{"mclass": "0"},
+		},
+
+		"Flash message too long": {
+			params:     `, "type": "flash"`,
+			message:    strings.Repeat("a", sms.MaxMessageLength+1),
+			wantStatus: http.StatusUnprocessableEntity,
+			wantError:  "Invalid parameter (message value is to long)",
+		},
+
+		"Flash voice message": {
+			params:     `, "type": "flash", "channel": "voice"`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantError:  "Invalid parameter (type value is only supported by text messages)",
+		},
+
+		"Provider without flash messages": {
+			fake:       true,
+			params:     `, "type": "flash"`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantError:  "Invalid parameter (type value is not supported by the provider)",
+		},
+
+		"Binary message": {
+			params:     `, "type": "binary", "udh": "050003cc0201"`,
+			message:    "cafebabe",
+			wantStatus: http.StatusCreated,
+			wantForm:   map[string]string{"type": "binary", "body": "cafebabe", "typeDetails[udh]": "050003cc0201"},
+		},
+
+		"Binary message filling the message": {
+			params:     `, "type": "binary", "udh": "050003cc0201"`,
+			message:    strings.Repeat("ff", sms.MaxBinaryMessageLength-6),
+			wantStatus: http.StatusCreated,
+		},
+
+		"Binary message too long with its udh": {
+			params:     `, "type": "binary", "udh": "050003cc0201"`,
+			message:    strings.Repeat("ff", sms.MaxBinaryMessageLength-5),
+			wantStatus: http.StatusUnprocessableEntity,
+			wantError:  "Invalid parameter (message value is to long with its udh)",
+		},
+
+		"Binary message too long": {
+			params:     `, "type": "binary"`,
+			message:    strings.Repeat("ff", sms.MaxBinaryMessageLength+1),
+			wantStatus: http.StatusUnprocessableEntity,
+			wantError:  "Invalid parameter (message value is to long)",
+		},
+
+		"Binary message not hex encoded": {
+			params:     `, "type": "binary"`,
+			message:    "not hex",
+			wantStatus: http.StatusUnprocessableEntity,
+			wantError:  "Invalid parameter (message value is not hex encoded)",
+		},
+
+		"Binary udh not hex encoded": {
+			params:     `, "type": "binary", "udh": "05000"`,
+			message:    "cafebabe",
+			wantStatus: http.StatusUnprocessableEntity,
+			wantError:  "Invalid parameter (udh value is not hex encoded)",
+		},
+
+		"Udh of a text message": {
+			params:     `, "udh": "050003cc0201"`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantError:  "Invalid parameter (udh value is only supported by binary messages)",
+		},
+
+		"Unknown type": {
+			params:     `, "type": "mms"`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantError:  "Invalid parameter (type value is not supported)",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			forms := make(chan map[string][]string, 1)
+			provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if err := r.ParseForm(); err != nil {
+					t.Errorf("Could not parse form; Error: %v", err)
+				}
+				forms <- r.PostForm
+				fixtures.MessageCreated.ServeHTTP(w, r)
+			}))
+			defer provider.Close()
+
+			var sender sms.MessageSender = sms.NewClient(sms.Options{BaseURL: provider.URL, Timeout: 10 * time.Second})
+			if tc.fake {
+				sender = fakeSender{}
+			}
+
+			srv := sms.NewServer(sms.Config{
+				Buffer:        10,
+				ReqTimeout:    5 * time.Second,
+				ThrottleRate:  10 * time.Millisecond,
+				MessageClient: sender,
+			})
+			srv.Run()
+
+			message := tc.message
+			if message == "" {
+				message = "This is a test message"
+			}
+			payload := fmt.Sprintf(`{"recipient":%d, "originator": %q, "message": %q%s}`, fixtures.Recipient, fixtures.Originator, message, tc.params)
+			r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(payload))
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			if w.Code != tc.wantStatus {
+				t.Fatalf("Status code was %d; want %d", w.Code, tc.wantStatus)
+			}
+
+			var smsRes sms.Response
+			if err := json.NewDecoder(w.Body).Decode(&smsRes); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+			if smsRes.Error != tc.wantError {
+				t.Errorf("Error was %q; want %q", smsRes.Error, tc.wantError)
+			}
+			if tc.wantStatus != http.StatusCreated {
+				return
+			}
+
+			form := <-forms
+			for key, want := range tc.wantForm {
+				if got := strings.Join(form[key], ","); got != want {
+					t.Errorf("Form value %s was %q; want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
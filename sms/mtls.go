@@ -0,0 +1,58 @@
+package sms
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+)
+
+// ClientAuthTLS returns the TLS configuration of a listener requiring
+// the callers to present a certificate issued by one of the client CAs
+// When names are given, the certificate must also carry one of them as
+// its common name or as one of its DNS, email or URI SANs
+// The certificate and key of the listener are left to the caller
+func ClientAuthTLS(clientCAs *x509.CertPool, names []string) *tls.Config {
+	cfg := &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	if len(names) > 0 {
+		cfg.VerifyPeerCertificate = verifyClientNames(names)
+	}
+
+	return cfg
+}
+
+// verifyClientNames accepts a verified client certificate only when
+// it names one of the allowed callers
+func verifyClientNames(names []string) func([][]byte, [][]*x509.Certificate) error {
+	allowed := make(map[string]bool, len(names))
+	for _, name := range names {
+		allowed[name] = true
+	}
+
+	return func(_ [][]byte, chains [][]*x509.Certificate) error {
+		if len(chains) > 0 && len(chains[0]) > 0 {
+			for _, name := range certNames(chains[0][0]) {
+				if allowed[name] {
+					return nil
+				}
+			}
+		}
+
+		metrics.Add("client_cert_refusals", 1)
+		return errors.New("client certificate does not name an allowed caller")
+	}
+}
+
+// certNames returns the common name and the SANs of a certificate
+func certNames(cert *x509.Certificate) []string {
+	names := []string{cert.Subject.CommonName}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		names = append(names, u.String())
+	}
+
+	return names
+}
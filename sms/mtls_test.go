@@ -0,0 +1,122 @@
+package sms_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+)
+
+// issueCert creates a certificate signed by the parent, self-signed when
+// there is none, along with its key
+func issueCert(t *testing.T, tmpl *x509.Certificate, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+
+	signer, signerKey := tmpl, interface{}(key)
+	if parent != nil {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestClientAuthTLS(t *testing.T) {
+	ca := issueCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Internal CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	otherCA := issueCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Other CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	client := func(issuer tls.Certificate, cn string, dnsNames ...string) *tls.Certificate {
+		cert := issueCert(t, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: cn},
+			DNSNames:    dnsNames,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, &issuer)
+		return &cert
+	}
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.Leaf)
+
+	listener := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	listener.TLS = sms.ClientAuthTLS(clientCAs, []string{"alerts", "billing.internal"})
+	listener.StartTLS()
+	defer listener.Close()
+
+	tests := map[string]struct {
+		cert   *tls.Certificate
+		wantOK bool
+	}{
+		"No client certificate": {},
+
+		"Certificate of an unknown CA": {
+			cert: client(otherCA, "alerts"),
+		},
+
+		"Allowed common name": {
+			cert:   client(ca, "alerts"),
+			wantOK: true,
+		},
+
+		"Allowed DNS name": {
+			cert:   client(ca, "billing", "billing.internal"),
+			wantOK: true,
+		},
+
+		"Caller not allowed": {
+			cert: client(ca, "marketing", "marketing.internal"),
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			transport := listener.Client().Transport.(*http.Transport).Clone()
+			if tc.cert != nil {
+				transport.TLSClientConfig.Certificates = []tls.Certificate{*tc.cert}
+			}
+			c := &http.Client{Transport: transport}
+			defer transport.CloseIdleConnections()
+
+			res, err := c.Get(listener.URL)
+			if err == nil {
+				res.Body.Close()
+			}
+			if ok := err == nil && res.StatusCode == http.StatusNoContent; ok != tc.wantOK {
+				t.Errorf("Request was accepted: %t (%v); want %t", ok, err, tc.wantOK)
+			}
+		})
+	}
+}
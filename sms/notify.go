@@ -0,0 +1,182 @@
+package sms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Kinds of notification sinks
+const (
+	SinkSlack = "slack"
+	SinkTeams = "teams"
+)
+
+// Kinds of operational events
+const (
+	opsQueueSaturated = "queue_saturated"
+	opsLowBalance     = "low_balance"
+	opsCanaryFailed   = "canary_failed"
+)
+
+const (
+	// opsNotifyEvery is how often an event of the same kind is notified at most
+	opsNotifyEvery = 30 * time.Minute
+	// balanceCheckEvery is how often the balance is checked against LowBalance
+	balanceCheckEvery = 15 * time.Minute
+	// opsBuffer is the number of events waiting to be posted
+	opsBuffer = 20
+)
+
+// NotificationSink is a chat webhook, of Slack or Microsoft Teams,
+// told about the operational events of the server such as the queue
+// being saturated, the balance running low or the canary failing
+// These are distinct from the status events of the messages
+type NotificationSink struct {
+	Kind string `json:"kind"`
+	URL  string `json:"url"`
+}
+
+// opsNotifier posts the operational events to the notification sinks
+// Events of a kind already notified recently are dropped, so that
+// a saturated queue does not flood the channels
+type opsNotifier struct {
+	sinks      []NotificationSink
+	httpClient *http.Client
+	clock      Clock
+	eventCh    chan string
+
+	mu       sync.Mutex
+	notified map[string]time.Time
+}
+
+func newOpsNotifier(sinks []NotificationSink, timeout time.Duration, clock Clock) *opsNotifier {
+	n := &opsNotifier{
+		httpClient: &http.Client{Timeout: timeout},
+		clock:      clock,
+		eventCh:    make(chan string, opsBuffer),
+		notified:   make(map[string]time.Time),
+	}
+	for _, sink := range sinks {
+		if (sink.Kind != SinkSlack && sink.Kind != SinkTeams) || !validCallbackURL(sink.URL) {
+			slog.Warn("Ignored notification sink", "kind", sink.Kind, "url", sink.URL)
+			continue
+		}
+		n.sinks = append(n.sinks, sink)
+	}
+
+	return n
+}
+
+// notify queues the text of an event of the kind, unless one
+// was notified recently
+func (n *opsNotifier) notify(kind, text string) {
+	if len(n.sinks) == 0 {
+		return
+	}
+
+	n.mu.Lock()
+	now := n.clock.Now()
+	if last, ok := n.notified[kind]; ok && now.Sub(last) < opsNotifyEvery {
+		n.mu.Unlock()
+		return
+	}
+	n.notified[kind] = now
+	n.mu.Unlock()
+
+	n.send(text)
+}
+
+// send queues the text for the sinks
+func (n *opsNotifier) send(text string) {
+	if len(n.sinks) == 0 {
+		return
+	}
+
+	select {
+	case n.eventCh <- text:
+	default:
+		metrics.Add("notifications_dropped", 1)
+	}
+}
+
+// run posts the queued events to every sink
+func (n *opsNotifier) run() {
+	for text := range n.eventCh {
+		for _, sink := range n.sinks {
+			if err := n.post(sink, text); err != nil {
+				metrics.Add("notification_errors", 1)
+				slog.Error("Could not notify", "kind", sink.Kind, "url", sink.URL, "error", err)
+				continue
+			}
+			metrics.Add("notifications_sent", 1)
+		}
+	}
+}
+
+// post sends the text in the message format of the sink
+func (n *opsNotifier) post(sink NotificationSink, text string) error {
+	var payload interface{}
+	switch sink.Kind {
+	case SinkSlack:
+		payload = map[string]string{"text": text}
+	case SinkTeams:
+		payload = map[string]string{
+			"@type":    "MessageCard",
+			"@context": "https://schema.org/extensions",
+			"summary":  "flysms",
+			"title":    "flysms",
+			"text":     text,
+		}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	res, err := n.httpClient.Post(sink.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("sink answered with status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// watchBalance checks the balance of the provider account every while,
+// notifying when it falls below the low balance
+func (s *Server) watchBalance() {
+	checker, ok := s.messageClient.(balanceChecker)
+	if !ok || s.lowBalance <= 0 {
+		return
+	}
+
+	ticker := s.clock.NewTicker(balanceCheckEvery)
+	defer ticker.Stop()
+
+	for range ticker.C() {
+		ctx, cancel := s.withTimeout(context.Background(), s.reqTimeout)
+		balance, err := checker.balance(ctx)
+		cancel()
+		if err != nil {
+			slog.Error("Could not check the balance", "error", err)
+			continue
+		}
+
+		if balance.Amount < s.lowBalance {
+			s.ops.notify(opsLowBalance, fmt.Sprintf("Balance of the %s account is low: %g %s left", providerName(s.messageClient), balance.Amount, balance.Type))
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package sms_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/smstest"
+)
+
+func TestServer_notificationSinks(t *testing.T) {
+	posted := make(chan map[string]string, 10)
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("Failed to decode notification: %v", err)
+		}
+		payload["path"] = r.URL.Path
+		posted <- payload
+	}))
+	defer sink.Close()
+
+	sinks := []sms.NotificationSink{
+		{Kind: sms.SinkSlack, URL: sink.URL + "/slack"},
+		{Kind: sms.SinkTeams, URL: sink.URL + "/teams"},
+	}
+
+	tests := map[string]struct {
+		cfg      sms.Config
+		requests int
+		wantText string
+	}{
+		"Canary failed": {
+			cfg: sms.Config{
+				ShadowClient:     fakeSender{err: errors.New("connection refused for AccessKey live_SECRETKEY")},
+				ShadowPercent:    100,
+				ShadowRecipients: []sms.PhoneNumber{"3197010000000"},
+			},
+			requests: 1,
+			wantText: "Canary provider sms_test.fakeSender failed: request failed",
+		},
+		"Queue saturated": {
+			cfg:      sms.Config{Buffer: 1},
+			requests: 4,
+			wantText: "Queue is saturated, incoming requests are dropped",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := tc.cfg
+			cfg.NotificationSinks = sinks
+			srv := smstest.NewServer(t, cfg)
+
+			// The requests wait for the clock, so that the queue fills up
+			// before it is advanced
+			for i := 0; i < tc.requests; i++ {
+				srv.Go(http.MethodPost, "/messages", `{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`)
+			}
+
+			got := map[string]map[string]string{}
+			timeout := time.After(time.Second)
+			for len(got) < len(sinks) {
+				select {
+				case payload := <-posted:
+					got[payload["path"]] = payload
+				case <-timeout:
+					t.Fatalf("Sinks got %v; want a notification each", got)
+				case <-time.After(20 * time.Millisecond):
+					srv.Clock.Advance(time.Second)
+				}
+			}
+
+			if text := got["/slack"]["text"]; !strings.HasPrefix(text, tc.wantText) {
+				t.Errorf("Slack text was %q; want %q", text, tc.wantText)
+			}
+			if card := got["/teams"]; card["@type"] != "MessageCard" || !strings.HasPrefix(card["text"], tc.wantText) {
+				t.Errorf("Teams card was %v; want a MessageCard with text %q", card, tc.wantText)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package sms
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	numberValid   = "valid"
+	numberInvalid = "invalid"
+)
+
+// PhoneNumber is a recipient as it was submitted, with its plus sign
+// or leading zeros, such as "+31612345678" or "0031612345678"
+// It is decoded from JSON strings as well as numbers, and is kept in
+// canonical form, without the spaces, dashes, dots and parentheses
+// people write numbers with
+type PhoneNumber string
+
+// UnmarshalJSON decodes a number given as a JSON string or number
+// Values which are no phone numbers are kept as they are and refused
+// by the validation of the requests
+func (p *PhoneNumber) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(data, []byte(`"`)) {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*p = canonicalNumber(s)
+		return nil
+	}
+
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*p = PhoneNumber(n)
+
+	return nil
+}
+
+// canonicalNumber removes the separators of a phone number
+// Anything else than a plus sign followed by digits is kept as it is,
+// as are numbers starting with a dash, which would read as negative
+func canonicalNumber(s string) PhoneNumber {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "-") {
+		return PhoneNumber(s)
+	}
+	canonical := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(" -.()", r) {
+			return -1
+		}
+		return r
+	}, s)
+
+	if !PhoneNumber(canonical).wellFormed() {
+		return PhoneNumber(s)
+	}
+
+	return PhoneNumber(canonical)
+}
+
+// wellFormed reports whether the number is digits,
+// after an optional plus sign
+func (p PhoneNumber) wellFormed() bool {
+	d := p.digits()
+	if d == "" {
+		return false
+	}
+	for _, r := range d {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// recipientProblem returns why the number cannot be a recipient,
+// empty when it can be one
+func recipientProblem(p PhoneNumber) string {
+	if !p.wellFormed() {
+		return "recipient value is not a phone number"
+	}
+
+	d := p.digits()
+	if strings.Trim(d, "0") == "" || len(d) < MinRecipientDigits || len(d) > MaxRecipientDigits {
+		return "recipient value is out of bounds"
+	}
+
+	return ""
+}
+
+// digits returns the number without its plus sign
+func (p PhoneNumber) digits() string {
+	return strings.TrimPrefix(string(p), "+")
+}
+
+// msisdn returns the number in international format without plus sign,
+// as most providers expect it, such as 31612345678
+// Numbers are taken to be international unless they start with a single
+// zero, which national numbers keep for the provider to resolve
+func (p PhoneNumber) msisdn() string {
+	d := p.digits()
+	if !strings.HasPrefix(string(p), "+") && strings.HasPrefix(d, "00") {
+		d = d[2:]
+	}
+
+	return d
+}
+
+// e164 returns the number with its plus sign, such as +31612345678
+func (p PhoneNumber) e164() string {
+	return "+" + p.msisdn()
+}
+
+// maxNumberCacheEntries bounds the number of remembered recipients
+const maxNumberCacheEntries = 100000
+
+// numberCache remembers what was recently learned about a recipient
+// Entries are forgotten after the configured TTL
+// Once the cache holds max entries the expired ones are dropped, and
+// while it is still full of fresh ones the new recipients are not
+// remembered, so they are sent to as if the cache was disabled, while
+// the statuses of the remembered ones keep being updated
+type numberCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	max     int
+	clock   Clock
+	entries map[string]numberEntry
+}
+
+type numberEntry struct {
+	status  string
+	expires time.Time
+}
+
+// newNumberCache creates a number cache keeping entries for the given TTL
+// A zero TTL disables the cache
+func newNumberCache(ttl time.Duration, clock Clock) *numberCache {
+	return &numberCache{
+		ttl:     ttl,
+		max:     maxNumberCacheEntries,
+		clock:   clock,
+		entries: make(map[string]numberEntry),
+	}
+}
+
+// set records the status of a recipient, dropping the expired entries
+// once the cache is full
+func (c *numberCache) set(recipient PhoneNumber, status string) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	key := recipient.msisdn()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.max {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= c.max {
+			return
+		}
+	}
+
+	c.entries[key] = numberEntry{
+		status:  status,
+		expires: now.Add(c.ttl),
+	}
+}
+
+// get returns the status of a recipient if it is still known
+func (c *numberCache) get(recipient PhoneNumber) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[recipient.msisdn()]
+	if !ok {
+		return "", false
+	}
+
+	if c.clock.Now().After(e.expires) {
+		delete(c.entries, recipient.msisdn())
+		return "", false
+	}
+
+	return e.status, true
+}
+
+// numberPool hands out test numbers in turn
+type numberPool struct {
+	numbers []PhoneNumber
+	next    uint64
+}
+
+// pick returns the next test number
+// The pool must not be empty
+func (p *numberPool) pick() PhoneNumber {
+	n := atomic.AddUint64(&p.next, 1)
+	return p.numbers[(n-1)%uint64(len(p.numbers))]
+}
@@ -0,0 +1,44 @@
+package sms_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/smstest"
+)
+
+func TestNumberCache_full(t *testing.T) {
+	clock := smstest.NewClock(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+	c := sms.NewNumberCache(time.Minute, 2, clock)
+
+	c.Set("31611111111", "valid")
+	c.Set("31622222222", "invalid")
+
+	// Full of fresh entries, the cache does not remember new recipients
+	// but still updates the remembered ones
+	clock.Advance(30 * time.Second)
+	c.Set("31633333333", "valid")
+	c.Set("31611111111", "invalid")
+
+	if status, ok := c.Get("31633333333"); ok {
+		t.Errorf("New recipient of the full cache was remembered as %s", status)
+	}
+	if status, _ := c.Get("31611111111"); status != "invalid" {
+		t.Errorf("Updated recipient was %q; want %q", status, "invalid")
+	}
+
+	// Once some entries expired, they make room for the new recipients
+	clock.Advance(31 * time.Second)
+	c.Set("31633333333", "valid")
+
+	if status, _ := c.Get("31633333333"); status != "valid" {
+		t.Errorf("New recipient was %q; want %q", status, "valid")
+	}
+	if status, ok := c.Get("31622222222"); ok {
+		t.Errorf("Expired recipient was still remembered as %s", status)
+	}
+	if got := c.Len(); got != 2 {
+		t.Errorf("Cache held %d entries; want 2", got)
+	}
+}
@@ -0,0 +1,230 @@
+package sms
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// teamPrefix starts the recipients of alerts which are a team,
+// such as team:payments, sent to whoever of the team is on call
+const teamPrefix = "team:"
+
+// Rotation is the on-call schedule of a team
+// Members take turns of PeriodHours hours, the first one from Start,
+// unless an override covers the time
+type Rotation struct {
+	Members     []PhoneNumber `json:"members"`
+	Start       time.Time     `json:"start"`
+	PeriodHours int           `json:"period_hours"`
+	Overrides   []Override    `json:"overrides,omitempty"`
+}
+
+// Override puts a member on call from Start until End,
+// such as to cover someone's holiday
+type Override struct {
+	Member PhoneNumber `json:"member"`
+	Start  time.Time   `json:"start"`
+	End    time.Time   `json:"end"`
+}
+
+// OnCall is a team along with its rotation and who is on call
+type OnCall struct {
+	Team     string      `json:"team"`
+	Current  PhoneNumber `json:"current,omitempty"`
+	Rotation Rotation    `json:"rotation"`
+}
+
+// OnCallList is the HTTP response of the on-call endpoints
+type OnCallList struct {
+	Success bool     `json:"success"`
+	Data    []OnCall `json:"data"`
+}
+
+// check returns what is wrong with the rotation, if anything
+func (r Rotation) check() string {
+	if len(r.Members) == 0 {
+		return "members value is not present"
+	}
+	if r.PeriodHours <= 0 {
+		return "period_hours value must be positive"
+	}
+	for _, m := range r.Members {
+		if !m.wellFormed() {
+			return "members value is not a list of phone numbers"
+		}
+	}
+	for _, o := range r.Overrides {
+		if !o.Member.wellFormed() {
+			return "override member value is not a phone number"
+		}
+		if !o.End.After(o.Start) {
+			return "override end value is not after its start"
+		}
+	}
+
+	return ""
+}
+
+// onCall returns the member on call at the given time
+// The latest override covering the time wins over the rotation
+func (r Rotation) onCall(at time.Time) PhoneNumber {
+	for i := len(r.Overrides) - 1; i >= 0; i-- {
+		o := r.Overrides[i]
+		if !at.Before(o.Start) && at.Before(o.End) {
+			return o.Member
+		}
+	}
+
+	period := time.Duration(r.PeriodHours) * time.Hour
+	elapsed := at.Sub(r.Start)
+	turn := int64(elapsed / period)
+	if elapsed%period < 0 {
+		// Turns before the start go backwards through the members
+		turn--
+	}
+	n := int64(len(r.Members))
+
+	return r.Members[(turn%n+n)%n]
+}
+
+// rotationStore holds the rotations of the teams
+type rotationStore struct {
+	mu        sync.Mutex
+	clock     Clock
+	rotations map[string]Rotation
+}
+
+func newRotationStore(rotations map[string]Rotation, clock Clock) *rotationStore {
+	s := &rotationStore{
+		clock:     clock,
+		rotations: make(map[string]Rotation),
+	}
+	for team, r := range rotations {
+		if invalid := r.check(); invalid != "" {
+			slog.Warn("Ignored rotation", "team", team, "reason", invalid)
+			continue
+		}
+		s.rotations[team] = r
+	}
+
+	return s
+}
+
+func (s *rotationStore) set(team string, r Rotation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rotations[team] = r
+}
+
+func (s *rotationStore) remove(team string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.rotations[team]
+	delete(s.rotations, team)
+
+	return ok
+}
+
+// current returns the member of the team on call now
+func (s *rotationStore) current(team string) (PhoneNumber, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.rotations[team]
+	if !ok {
+		return "", false
+	}
+
+	return r.onCall(s.clock.Now()), true
+}
+
+// list returns the teams by name, with who is on call now
+func (s *rotationStore) list() []OnCall {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	list := make([]OnCall, 0, len(s.rotations))
+	for team, r := range s.rotations {
+		list = append(list, OnCall{Team: team, Current: r.onCall(now), Rotation: r})
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Team < list[j].Team })
+
+	return list
+}
+
+// resolveRecipient returns the number to send an alert to, which is
+// the member on call for team recipients such as team:payments
+func (s *Server) resolveRecipient(recipient PhoneNumber) (PhoneNumber, bool) {
+	team := string(recipient)
+	if !strings.HasPrefix(team, teamPrefix) {
+		return recipient, true
+	}
+
+	return s.rotations.current(strings.TrimPrefix(team, teamPrefix))
+}
+
+// listOnCall is the HTTP handler listing the teams and who is on call
+func (s *Server) listOnCall() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sendJSON(w, http.StatusOK, OnCallList{Success: true, Data: s.rotations.list()})
+	}
+}
+
+// setRotation is the HTTP handler of PUT /admin/oncall/{team},
+// setting the rotation of the team
+func (s *Server) setRotation() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var res Response
+		team := pathParam(r, 2)
+
+		var rotation Rotation
+		if err := json.NewDecoder(r.Body).Decode(&rotation); err != nil {
+			res = Response{
+				statusCode: http.StatusBadRequest,
+				Error:      "Bad request (invalid payload json structure)",
+			}
+			sendResponse(w, res)
+			return
+		}
+		if invalid := rotation.check(); invalid != "" {
+			res = Response{
+				statusCode: http.StatusUnprocessableEntity,
+				Error:      "Invalid parameter (" + invalid + ")",
+			}
+			sendResponse(w, res)
+			return
+		}
+		s.rotations.set(team, rotation)
+		slog.Info("Rotation set", "team", team, "members", len(rotation.Members))
+
+		sendJSON(w, http.StatusOK, OnCallList{Success: true, Data: s.rotations.list()})
+	}
+}
+
+// removeRotation is the HTTP handler of DELETE /admin/oncall/{team},
+// removing the rotation of the team
+func (s *Server) removeRotation() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		team := pathParam(r, 2)
+		if !s.rotations.remove(team) {
+			res := Response{
+				statusCode: http.StatusNotFound,
+				Error:      "Not found (no rotation for this team)",
+			}
+			sendResponse(w, res)
+			return
+		}
+		slog.Info("Rotation removed", "team", team)
+
+		sendJSON(w, http.StatusOK, OnCallList{Success: true, Data: s.rotations.list()})
+	}
+}
@@ -0,0 +1,122 @@
+package sms_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/smstest"
+)
+
+func TestServer_onCall(t *testing.T) {
+	// The clock of the test server reads 2020-01-01T12:00:00Z,
+	// the third day of the rotation
+	srv := smstest.NewServer(t, sms.Config{
+		AdminKey: "admin_key",
+		Rotations: map[string]sms.Rotation{
+			"payments": {
+				Members:     []sms.PhoneNumber{"31611111111", "31622222222", "31633333333"},
+				Start:       time.Date(2019, 12, 30, 0, 0, 0, 0, time.UTC),
+				PeriodHours: 24,
+			},
+		},
+		AlertmanagerRoutes: []sms.AlertmanagerRoute{{Recipients: []sms.PhoneNumber{"team:payments"}}},
+	})
+
+	admin := func(method, path, payload string) (int, []sms.OnCall) {
+		r := httptest.NewRequest(method, path, strings.NewReader(payload))
+		r.Header.Set("Authorization", "AdminKey admin_key")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+
+		var list sms.OnCallList
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+		}
+		return w.Code, list.Data
+	}
+
+	alert := func() (int, sms.PhoneNumber) {
+		before := len(srv.Provider.Events())
+		w := srv.SendRequest(t, http.MethodPost, "/webhooks/alertmanager", `{"version": "4", "status": "firing", "alerts": [{"status": "firing", "labels": {"alertname": "PaymentsDown"}}]}`)
+
+		events := srv.Provider.Events()
+		if len(events) == before {
+			return w.Code, ""
+		}
+		return w.Code, events[len(events)-1].Request.Recipient
+	}
+
+	tests := []struct {
+		name          string
+		method        string
+		payload       string
+		wantStatus    int
+		wantCurrent   sms.PhoneNumber
+		wantAlert     int
+		wantRecipient sms.PhoneNumber
+	}{
+		{
+			name:          "Configured rotation",
+			method:        http.MethodGet,
+			wantStatus:    http.StatusOK,
+			wantCurrent:   "31633333333",
+			wantAlert:     http.StatusOK,
+			wantRecipient: "31633333333",
+		},
+		{
+			name:          "Override",
+			method:        http.MethodPut,
+			payload:       `{"members": ["31611111111", "31622222222"], "start": "2019-12-30T00:00:00Z", "period_hours": 24, "overrides": [{"member": "+31644444444", "start": "2020-01-01T08:00:00Z", "end": "2020-01-01T20:00:00Z"}]}`,
+			wantStatus:    http.StatusOK,
+			wantCurrent:   "+31644444444",
+			wantAlert:     http.StatusOK,
+			wantRecipient: "+31644444444",
+		},
+		{
+			name:          "Invalid rotation",
+			method:        http.MethodPut,
+			payload:       `{"members": [], "period_hours": 24}`,
+			wantStatus:    http.StatusUnprocessableEntity,
+			wantAlert:     http.StatusOK,
+			wantRecipient: "+31644444444",
+		},
+		{
+			name:       "Removed rotation",
+			method:     http.MethodDelete,
+			wantStatus: http.StatusOK,
+			wantAlert:  http.StatusBadGateway,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := "/admin/oncall/payments"
+			if tc.method == http.MethodGet {
+				path = "/admin/oncall"
+			}
+
+			status, list := admin(tc.method, path, tc.payload)
+			if status != tc.wantStatus {
+				t.Fatalf("Status code was %d; want %d", status, tc.wantStatus)
+			}
+			if tc.wantCurrent != "" && (len(list) != 1 || list[0].Current != tc.wantCurrent) {
+				t.Errorf("On call was %+v; want %s", list, tc.wantCurrent)
+			}
+
+			status, recipient := alert()
+			if status != tc.wantAlert {
+				t.Fatalf("Alert status code was %d; want %d", status, tc.wantAlert)
+			}
+			if recipient != tc.wantRecipient {
+				t.Errorf("Alert was sent to %q; want %q", recipient, tc.wantRecipient)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package sms
+
+import "strings"
+
+// OriginatorRule tells what happens to the alphanumeric originators of the
+// messages to a country, where they are not allowed, such as the US
+// The originator is replaced by the number of the rule, or the message
+// is refused when the rule has none
+type OriginatorRule struct {
+	Originator string `json:"originator"`
+}
+
+// originatorRule returns the rule of the recipient country, found by the
+// longest country prefix of the recipient, such as 1 or 1876
+// National numbers, starting with a single zero, have no known country
+func (s *Server) originatorRule(recipient PhoneNumber) (OriginatorRule, bool) {
+	msisdn := recipient.msisdn()
+	if strings.HasPrefix(msisdn, "0") {
+		return OriginatorRule{}, false
+	}
+
+	var rule OriginatorRule
+	found := ""
+	for prefix, r := range s.origRules {
+		if len(prefix) > len(found) && strings.HasPrefix(msisdn, prefix) {
+			rule, found = r, prefix
+		}
+	}
+
+	return rule, found != ""
+}
+
+// applyOriginatorRule replaces the alphanumeric originator of the request
+// following the rule of its recipient country, and reports whether
+// the request may be sent
+// Numeric originators, such as +14155550100, are allowed everywhere
+func (s *Server) applyOriginatorRule(req *Request) bool {
+	if req.Channel != channelSMS || !alphanumeric(req.Originator) {
+		return true
+	}
+
+	rule, ok := s.originatorRule(req.Recipient)
+	if !ok {
+		return true
+	}
+	if rule.Originator == "" {
+		metrics.Add("originators_rejected", 1)
+		return false
+	}
+
+	metrics.Add("originators_rewritten", 1)
+	req.Originator = rule.Originator
+
+	return true
+}
+
+// alphanumeric reports whether the originator is a sender id
+// rather than a number
+func alphanumeric(originator string) bool {
+	return !PhoneNumber(originator).wellFormed()
+}
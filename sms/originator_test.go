@@ -0,0 +1,75 @@
+package sms_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/smstest"
+)
+
+func TestServer_originatorRules(t *testing.T) {
+	rules := map[string]sms.OriginatorRule{
+		"1":    {Originator: "+14155550100"},
+		"1876": {},
+	}
+
+	tests := map[string]struct {
+		payload        string
+		wantStatus     int
+		wantError      string
+		wantOriginator string
+	}{
+		"Alphanumeric originator rewritten": {
+			payload:        `{"recipient":"+14155550123", "originator": "MessageBird", "message": "This is a test message"}`,
+			wantStatus:     http.StatusCreated,
+			wantOriginator: "+14155550100",
+		},
+
+		"Numeric originator kept": {
+			payload:        `{"recipient":14155550123, "originator": "14155550999", "message": "This is a test message"}`,
+			wantStatus:     http.StatusCreated,
+			wantOriginator: "14155550999",
+		},
+
+		"Alphanumeric originator refused": {
+			payload:    `{"recipient":"+18765550123", "originator": "MessageBird", "message": "This is a test message"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantError:  "Invalid parameter (originator value is not allowed in the recipient country)",
+		},
+
+		"Country without rule": {
+			payload:        `{"recipient":"0031612345678", "originator": "MessageBird", "message": "This is a test message"}`,
+			wantStatus:     http.StatusCreated,
+			wantOriginator: "MessageBird",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := smstest.NewServer(t, sms.Config{OriginatorRules: rules})
+
+			w := srv.Send(t, tc.payload)
+			if w.Code != tc.wantStatus {
+				t.Fatalf("Status code was %d; want %d", w.Code, tc.wantStatus)
+			}
+
+			var smsRes sms.Response
+			if err := json.NewDecoder(w.Body).Decode(&smsRes); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+			if smsRes.Error != tc.wantError {
+				t.Errorf("Error was %q; want %q", smsRes.Error, tc.wantError)
+			}
+			if tc.wantOriginator == "" {
+				return
+			}
+
+			events := srv.Provider.Events()
+			if len(events) != 1 || events[0].Request.Originator != tc.wantOriginator {
+				t.Errorf("Provider got %+v; want a single message from %s", events, tc.wantOriginator)
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package sms
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// ProfilingHandler serves the net/http/pprof profiles under /debug/pprof/
+// It has no authentication, so it is meant for an admin port of its own
+// bound to a private address, never for the public listener
+func ProfilingHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	return mux
+}
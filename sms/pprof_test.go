@@ -0,0 +1,37 @@
+package sms_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/iulianclita/flysms/sms"
+)
+
+func TestProfilingHandler(t *testing.T) {
+	h := sms.ProfilingHandler()
+
+	tests := map[string]struct {
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		"Index":      {path: "/debug/pprof/", wantStatus: http.StatusOK, wantBody: "goroutine"},
+		"Goroutines": {path: "/debug/pprof/goroutine?debug=1", wantStatus: http.StatusOK, wantBody: "goroutine profile:"},
+		"Outside":    {path: "/messages", wantStatus: http.StatusNotFound},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			if w.Code != tc.wantStatus {
+				t.Errorf("Status code was %d; want %d", w.Code, tc.wantStatus)
+			}
+			if !strings.Contains(w.Body.String(), tc.wantBody) {
+				t.Errorf("Body does not contain %q", tc.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package sms
+
+import (
+	"container/heap"
+	"context"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// QueuedRequest is a request accepted into the queue, as kept by
+// a QueueStore until it leaves the queue
+type QueuedRequest struct {
+	ID      string    `json:"id"`
+	Split   bool      `json:"split,omitempty"`
+	Queued  time.Time `json:"queued"`
+	Request Request   `json:"request"`
+}
+
+// QueueStore keeps the requests waiting in the queue outside the process,
+// so that the ones still waiting when the server stops are sent once it
+// starts again
+// Implementations must be safe for concurrent use
+type QueueStore interface {
+	Save(r QueuedRequest) error
+	Remove(id string) error
+	Pending() ([]QueuedRequest, error)
+}
+
+// requestQueue holds the pending requests ordered by delivery deadline
+// Requests without a deadline are sent after the ones having a deadline
+// and requests with the same deadline keep their arrival order
+// It implements heap.Interface
+type requestQueue []*Request
+
+func (q requestQueue) Len() int {
+	return len(q)
+}
+
+func (q requestQueue) Less(i, j int) bool {
+	a, b := q[i].DeliverBy, q[j].DeliverBy
+	switch {
+	case a != nil && b != nil && !a.Equal(*b):
+		return a.Before(*b)
+	case a != nil && b == nil:
+		return true
+	case a == nil && b != nil:
+		return false
+	}
+
+	return q[i].seq < q[j].seq
+}
+
+func (q requestQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *requestQueue) Push(x interface{}) {
+	*q = append(*q, x.(*Request))
+}
+
+func (q *requestQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	req := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+
+	return req
+}
+
+// shedStale drops the pending requests queued for longer than the
+// maximum age of the queue, answering them as expired
+// Nobody needs a stale message, such as a one time password sent
+// after its code expired, so fresh traffic is sent instead
+func (s *Server) shedStale(q *classQueue, pending *requestQueue) {
+	if q.maxQueueAge <= 0 {
+		return
+	}
+
+	now := s.clock.Now()
+	var stale []*Request
+	kept := (*pending)[:0]
+	for _, req := range *pending {
+		if now.Sub(req.queued) > q.maxQueueAge {
+			stale = append(stale, req)
+		} else {
+			kept = append(kept, req)
+		}
+	}
+	if len(stale) == 0 {
+		return
+	}
+	for i := len(kept); i < len(*pending); i++ {
+		(*pending)[i] = nil
+	}
+	*pending = kept
+	heap.Init(pending)
+
+	for _, req := range stale {
+		// The request was cancelled while waiting in the queue
+		if _, ok := s.takeQueued(req.id); !ok {
+			continue
+		}
+
+		metrics.Add("queue_shed", 1)
+		slog.Warn("The API request expired in the queue", "request", req, "latency", now.Sub(req.queued))
+		expired := Content{
+			ID:         req.id,
+			Recipient:  req.Recipient,
+			Originator: req.Originator,
+			Message:    req.Message,
+			Status:     "expired",
+			Created:    req.queued.Format(time.RFC3339),
+		}
+		s.deliveries.add(expired, req.CallbackURL, now)
+		s.audit(auditExpired, expired)
+
+		res := Response{
+			statusCode: http.StatusGatewayTimeout,
+			Error:      "Queue age exceeded (message expired before sending)",
+		}
+		s.reports.failed(res.Error)
+		select {
+		case req.resCh <- res:
+		case <-req.ctx.Done():
+		}
+	}
+}
+
+// queuedRequests tracks the requests waiting in the queue by id,
+// so that they can be cancelled before being sent
+// Whoever takes a request out first owns it: the dispatcher sends it,
+// anyone else answers it as cancelled
+type queuedRequests struct {
+	mu   sync.Mutex
+	reqs map[string]*Request
+}
+
+func newQueuedRequests() *queuedRequests {
+	return &queuedRequests{reqs: make(map[string]*Request)}
+}
+
+// add tracks the request
+// It reports false if a request with the same id is already queued
+func (q *queuedRequests) add(req *Request) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.reqs[req.id]; ok {
+		return false
+	}
+	q.reqs[req.id] = req
+	return true
+}
+
+// take removes the request from the tracked ones
+// It reports false if the request was already taken
+func (q *queuedRequests) take(id string) (*Request, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	req, ok := q.reqs[id]
+	delete(q.reqs, id)
+	return req, ok
+}
+
+// storeQueued saves the request in the queue store, if there is one
+func (s *Server) storeQueued(req *Request) error {
+	if s.queueStore == nil {
+		return nil
+	}
+
+	return s.queueStore.Save(QueuedRequest{ID: req.id, Split: req.split, Queued: req.queued, Request: *req})
+}
+
+// forgetQueued removes the request from the queue store, if there is one
+func (s *Server) forgetQueued(id string) {
+	if s.queueStore == nil {
+		return
+	}
+
+	if err := s.queueStore.Remove(id); err != nil {
+		slog.Error("Could not remove request from the queue store", "request_id", id, "error", err)
+	}
+}
+
+// recoverQueued queues again the requests left in the queue store
+// by a previous run, in the order they were accepted
+// Nobody waits for their responses anymore, so the outcome is only logged,
+// while their status changes still reach their callback URL
+func (s *Server) recoverQueued() {
+	if s.queueStore == nil {
+		return
+	}
+
+	pending, err := s.queueStore.Pending()
+	if err != nil {
+		slog.Error("Could not recover the requests of the queue store", "error", err)
+		return
+	}
+	sort.SliceStable(pending, func(i, j int) bool { return pending[i].Queued.Before(pending[j].Queued) })
+
+	for _, qr := range pending {
+		req := qr.Request
+		req.id = qr.ID
+		req.split = qr.Split
+		req.queued = qr.Queued
+		req.ctx = context.Background()
+		req.resCh = make(chan Response, 1)
+		if !s.queued.add(&req) {
+			continue
+		}
+
+		metrics.Add("requests_recovered", 1)
+		s.queueOf(&req).reqCh <- &req
+
+		go func(req *Request) {
+			res := <-req.resCh
+			s.forgetQueued(req.id)
+			if res.statusCode >= http.StatusBadRequest {
+				slog.Error("Recovered request failed", "request_id", req.id, "error", res.Error)
+				return
+			}
+			slog.Info("Recovered request sent", "request_id", req.id, "message_id", res.Data.ID)
+		}(&req)
+	}
+
+	if len(pending) > 0 {
+		slog.Info("Recovered requests from the queue store", "requests", len(pending))
+	}
+}
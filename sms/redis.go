@@ -0,0 +1,245 @@
+package sms
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRedisTimeout  = 5 * time.Second
+	defaultRedisQueueKey = "flysms:queue"
+)
+
+// RedisQueueStore keeps the queued requests in a Redis hash, by request id
+// Replicas sharing a Redis server take over each other's requests through
+// the queue name: a replica starting with the name of a stopped one sends
+// the requests it left, so each running replica needs a name of its own
+type RedisQueueStore struct {
+	key    string
+	client *redisClient
+}
+
+// NewRedisQueueStore creates a queue store from a Redis URL, such as
+// redis://:password@localhost:6379/0, keeping the requests of the named queue
+func NewRedisQueueStore(rawurl, name string) (*RedisQueueStore, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil || (u.Scheme != "redis" && u.Scheme != "tcp") || u.Host == "" {
+		return nil, fmt.Errorf("Invalid Redis URL %s", rawurl)
+	}
+
+	c := &redisClient{
+		addr:    u.Host,
+		timeout: defaultRedisTimeout,
+		dialer:  &net.Dialer{Timeout: defaultRedisTimeout, KeepAlive: 30 * time.Second},
+	}
+	if !strings.Contains(c.addr, ":") {
+		c.addr += ":6379"
+	}
+	if u.User != nil {
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("Invalid Redis database %s", db)
+		}
+	}
+
+	key := defaultRedisQueueKey
+	if name != "" {
+		key += ":" + name
+	}
+
+	return &RedisQueueStore{key: key, client: c}, nil
+}
+
+// Save stores the request until it is removed
+func (q *RedisQueueStore) Save(r QueuedRequest) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.client.do("HSET", q.key, r.ID, string(data))
+	return err
+}
+
+// Remove drops the request from the store
+func (q *RedisQueueStore) Remove(id string) error {
+	_, err := q.client.do("HDEL", q.key, id)
+	return err
+}
+
+// Pending returns the stored requests
+func (q *RedisQueueStore) Pending() ([]QueuedRequest, error) {
+	reply, err := q.client.do("HGETALL", q.key)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, ok := reply.([]interface{})
+	if !ok || len(fields)%2 != 0 {
+		return nil, fmt.Errorf("Unexpected Redis reply %v to HGETALL", reply)
+	}
+
+	pending := make([]QueuedRequest, 0, len(fields)/2)
+	for i := 1; i < len(fields); i += 2 {
+		data, _ := fields[i].([]byte)
+		var r QueuedRequest
+		if err := json.Unmarshal(data, &r); err != nil {
+			return nil, fmt.Errorf("Invalid queued request %s; Error: %v", fields[i-1], err)
+		}
+		pending = append(pending, r)
+	}
+
+	return pending, nil
+}
+
+// redisError is an error reply of the Redis server
+type redisError string
+
+func (e redisError) Error() string {
+	return "Redis error: " + string(e)
+}
+
+var errRedisProtocol = errors.New("invalid Redis reply")
+
+// redisClient runs commands over a single connection to a Redis server
+// The connection is made on the first command and made again once lost
+type redisClient struct {
+	addr     string
+	password string
+	db       int
+	timeout  time.Duration
+	dialer   *net.Dialer
+
+	mu   sync.Mutex
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+// do runs the command and returns its reply, which is a string,
+// an int64, a []byte, nil or a []interface{} of those
+func (c *redisClient) do(args ...string) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return nil, fmt.Errorf("Could not connect to Redis %s; Error: %v", c.addr, err)
+		}
+	}
+
+	reply, err := c.call(args...)
+	if _, ok := err.(redisError); err != nil && !ok {
+		// The connection is in an unknown state after I/O errors
+		c.conn.Close()
+		c.conn = nil
+	}
+
+	return reply, err
+}
+
+func (c *redisClient) connect() error {
+	conn, err := c.dialer.Dial("tcp", c.addr)
+	if err != nil {
+		return err
+	}
+	c.conn, c.rd = conn, bufio.NewReader(conn)
+
+	if c.password != "" {
+		if _, err := c.call("AUTH", c.password); err != nil {
+			c.conn.Close()
+			c.conn = nil
+			return err
+		}
+	}
+	if c.db != 0 {
+		if _, err := c.call("SELECT", strconv.Itoa(c.db)); err != nil {
+			c.conn.Close()
+			c.conn = nil
+			return err
+		}
+	}
+
+	return nil
+}
+
+// call writes the command as an array of bulk strings and reads its reply
+func (c *redisClient) call(args ...string) (interface{}, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	if _, err := io.WriteString(c.conn, b.String()); err != nil {
+		return nil, err
+	}
+
+	return readRedisReply(c.rd)
+}
+
+// readRedisReply reads a RESP reply
+func readRedisReply(rd *bufio.Reader) (interface{}, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, errRedisProtocol
+	}
+	kind, line := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, redisError(line)
+	case ':':
+		n, err := strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			return nil, errRedisProtocol
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < -1 {
+			return nil, errRedisProtocol
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(rd, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < -1 {
+			return nil, errRedisProtocol
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readRedisReply(rd); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+
+	return nil, errRedisProtocol
+}
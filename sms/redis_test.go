@@ -0,0 +1,153 @@
+package sms_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/smstest"
+)
+
+// fakeRedis is a Redis server knowing the hash commands of the queue store
+type fakeRedis struct {
+	net.Listener
+	mu     sync.Mutex
+	hashes map[string]map[string]string
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	r := &fakeRedis{Listener: l, hashes: make(map[string]map[string]string)}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+
+	return r
+}
+
+func (r *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		args := make([]string, n)
+		for i := range args {
+			line, _ := rd.ReadString('\n')
+			size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+			b := make([]byte, size+2)
+			if _, err := io.ReadFull(rd, b); err != nil {
+				return
+			}
+			args[i] = string(b[:size])
+		}
+
+		r.mu.Lock()
+		h := r.hashes[args[1]]
+		if h == nil {
+			h = make(map[string]string)
+			r.hashes[args[1]] = h
+		}
+		switch strings.ToUpper(args[0]) {
+		case "HSET":
+			h[args[2]] = args[3]
+			fmt.Fprint(conn, ":1\r\n")
+		case "HDEL":
+			delete(h, args[2])
+			fmt.Fprint(conn, ":1\r\n")
+		case "HGETALL":
+			fmt.Fprintf(conn, "*%d\r\n", 2*len(h))
+			for k, v := range h {
+				fmt.Fprintf(conn, "$%d\r\n%s\r\n$%d\r\n%s\r\n", len(k), k, len(v), v)
+			}
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+		}
+		r.mu.Unlock()
+	}
+}
+
+func TestRedisQueueStore(t *testing.T) {
+	redis := newFakeRedis(t)
+	defer redis.Close()
+
+	store, err := sms.NewRedisQueueStore("redis://"+redis.Addr().String(), "replica-1")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	queued := sms.QueuedRequest{
+		ID:      "a1",
+		Queued:  time.Date(2020, 1, 1, 11, 0, 0, 0, time.UTC),
+		Request: sms.Request{Recipient: "+31612345678", Originator: "MessageBird", Message: "Left in the queue"},
+	}
+	if err := store.Save(queued); err != nil {
+		t.Fatalf("Failed to save request: %v", err)
+	}
+
+	srv := smstest.NewServer(t, sms.Config{QueueStore: store})
+
+	// The recovered request waits for the throttle like any other
+	for deadline := time.Now().Add(time.Second); len(srv.Provider.Events()) == 0 && time.Now().Before(deadline); {
+		srv.Clock.Advance(time.Second)
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	events := srv.Provider.Events()
+	if len(events) != 1 || events[0].Request.Recipient != queued.Request.Recipient || events[0].Request.Message != queued.Request.Message {
+		t.Fatalf("Provider got %+v; want the recovered request", events)
+	}
+
+	w := srv.Send(t, `{"recipient": "+31687654321", "originator": "MessageBird", "message": "Sent right away"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Status code was %d; want %d", w.Code, http.StatusCreated)
+	}
+
+	var pending []sms.QueuedRequest
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if pending, err = store.Pending(); err != nil || len(pending) == 0 {
+			break
+		}
+	}
+	if err != nil || len(pending) != 0 {
+		t.Errorf("Store kept %+v (%v); want the sent requests removed", pending, err)
+	}
+}
+
+func TestRedisQueueStoreDown(t *testing.T) {
+	redis := newFakeRedis(t)
+	redis.Close()
+
+	store, err := sms.NewRedisQueueStore("redis://"+redis.Addr().String(), "")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	srv := smstest.NewServer(t, sms.Config{QueueStore: store})
+
+	w := srv.Do(http.MethodPost, "/messages", `{"recipient": "+31612345678", "originator": "MessageBird", "message": "This is a test message"}`)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Status code was %d; want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,76 @@
+package sms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net/http"
+)
+
+// relay runs a message request received by another interface than
+// /messages through the same validation and queue, and returns the
+// response it got along with its headers
+// Requests without channel are sent as SMS
+// The request goes to the handler rather than through Server.ServeHTTP
+// on purpose: the kannel route is already within the concurrency limits,
+// which it would otherwise take twice, the other interfaces are bounded
+// by the queue admission like any message, and the connections checked
+// for their age are those of the HTTP callers only
+func (s *Server) relay(ctx context.Context, remoteAddr string, header http.Header, req Request) (Response, http.Header) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		log.Fatalf("Could not encode value %#v; Error: %v", req, err)
+	}
+
+	r, err := http.NewRequest(http.MethodPost, "/messages", bytes.NewReader(body))
+	if err != nil {
+		log.Fatalf("Could not create POST request for url /messages; Error: %v", err)
+	}
+	r = r.WithContext(ctx)
+	r.RemoteAddr = remoteAddr
+	if header != nil {
+		r.Header = header.Clone()
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	buf := newResponseBuffer()
+	s.acceptMessage(req.Channel)(buf, r)
+
+	var res Response
+	if err := json.Unmarshal(buf.body.Bytes(), &res); err != nil {
+		slog.Error("Could not decode relayed response", "status", buf.statusCode, "error", err)
+		return Response{
+			statusCode: http.StatusInternalServerError,
+			Error:      "Internal error (relayed response could not be read)",
+		}, buf.header
+	}
+	res.statusCode = buf.statusCode
+
+	return res, buf.header
+}
+
+// responseBuffer keeps what a handler answers, so that it can be
+// translated before reaching the caller
+type responseBuffer struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func newResponseBuffer() *responseBuffer {
+	return &responseBuffer{header: make(http.Header), statusCode: http.StatusOK}
+}
+
+func (b *responseBuffer) Header() http.Header {
+	return b.header
+}
+
+func (b *responseBuffer) WriteHeader(statusCode int) {
+	b.statusCode = statusCode
+}
+
+func (b *responseBuffer) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
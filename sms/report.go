@@ -0,0 +1,216 @@
+package sms
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Periods of the reports
+const (
+	ReportDaily  = "daily"
+	ReportWeekly = "weekly"
+)
+
+// reportTopFailures is how many failure reasons a report lists
+const reportTopFailures = 3
+
+// Report summarizes the traffic of a time window
+// Spend is estimated from the parts sent and Config.PricePerPart
+type Report struct {
+	Period      string         `json:"period"`
+	Start       time.Time      `json:"start"`
+	End         time.Time      `json:"end"`
+	Sent        int            `json:"sent"`
+	Failed      int            `json:"failed"`
+	Parts       int            `json:"parts"`
+	Delivered   int            `json:"delivered"`
+	Undelivered int            `json:"undelivered"`
+	Spend       float64        `json:"spend"`
+	Failures    map[string]int `json:"failures,omitempty"`
+}
+
+// DeliveryRate is the share of the messages with a final status
+// which were delivered
+func (r Report) DeliveryRate() float64 {
+	if r.Delivered+r.Undelivered == 0 {
+		return 0
+	}
+
+	return float64(r.Delivered) / float64(r.Delivered+r.Undelivered)
+}
+
+// TopFailures returns the most frequent failure reasons, most frequent first
+func (r Report) TopFailures(n int) []string {
+	reasons := make([]string, 0, len(r.Failures))
+	for reason := range r.Failures {
+		reasons = append(reasons, reason)
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		a, b := r.Failures[reasons[i]], r.Failures[reasons[j]]
+		return a > b || (a == b && reasons[i] < reasons[j])
+	})
+	if len(reasons) > n {
+		reasons = reasons[:n]
+	}
+
+	return reasons
+}
+
+// String renders the report as the text of a notification
+func (r Report) String() string {
+	var b strings.Builder
+	period := strings.Title(r.Period)
+	fmt.Fprintf(&b, "%s report %s to %s: %d messages sent (%d parts), %d failed",
+		period, r.Start.Format("2006-01-02"), r.End.Format("2006-01-02"), r.Sent, r.Parts, r.Failed)
+	if r.Delivered+r.Undelivered > 0 {
+		fmt.Fprintf(&b, "; delivery rate %.1f%% of %d reported", 100*r.DeliveryRate(), r.Delivered+r.Undelivered)
+	}
+	if r.Spend > 0 {
+		fmt.Fprintf(&b, "; spend %.2f", r.Spend)
+	}
+	if top := r.TopFailures(reportTopFailures); len(top) > 0 {
+		b.WriteString("; top failures:")
+		for i, reason := range top {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			fmt.Fprintf(&b, " %s (%d)", reason, r.Failures[reason])
+		}
+	}
+
+	return b.String()
+}
+
+// reporter compiles the reports of its periods, the windows of which
+// end at midnight UTC, on Mondays for the weekly ones
+type reporter struct {
+	mu           sync.Mutex
+	pricePerPart float64
+	windows      map[string]*Report
+}
+
+func newReporter(periods []string, pricePerPart float64, now time.Time) *reporter {
+	r := &reporter{pricePerPart: pricePerPart, windows: make(map[string]*Report)}
+	for _, period := range periods {
+		if period != ReportDaily && period != ReportWeekly {
+			slog.Warn("Ignored report period", "period", period)
+			continue
+		}
+		r.windows[period] = &Report{Period: period, Start: now.UTC(), End: reportEnd(period, now)}
+	}
+
+	return r
+}
+
+// reportEnd returns the end of the window of the period around the time
+func reportEnd(period string, t time.Time) time.Time {
+	t = t.UTC()
+	end := time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+	if period == ReportWeekly {
+		// Days until the next Monday, a whole week on Mondays
+		end = end.AddDate(0, 0, (8-int(end.Weekday()))%7)
+	}
+
+	return end
+}
+
+// record adds to the windows of every period
+func (r *reporter) record(f func(w *Report)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, w := range r.windows {
+		f(w)
+	}
+}
+
+func (r *reporter) sent(parts int) {
+	if parts < 1 {
+		parts = 1
+	}
+	r.record(func(w *Report) {
+		w.Sent++
+		w.Parts += parts
+		w.Spend = float64(w.Parts) * r.pricePerPart
+	})
+}
+
+func (r *reporter) failed(reason string) {
+	r.record(func(w *Report) {
+		w.Failed++
+		if w.Failures == nil {
+			w.Failures = make(map[string]int)
+		}
+		w.Failures[reason]++
+	})
+}
+
+// delivered counts the final statuses of the messages
+func (r *reporter) delivered(status string) {
+	r.record(func(w *Report) {
+		switch status {
+		case "delivered":
+			w.Delivered++
+		case "delivery_failed", "expired":
+			w.Undelivered++
+		}
+	})
+}
+
+// due returns the reports of the windows ended by the time,
+// starting the next windows
+func (r *reporter) due(now time.Time) []Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var reports []Report
+	for period, w := range r.windows {
+		if now.Before(w.End) {
+			continue
+		}
+		reports = append(reports, *w)
+		r.windows[period] = &Report{Period: period, Start: w.End, End: reportEnd(period, now)}
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Period < reports[j].Period })
+
+	return reports
+}
+
+// next returns when the first of the windows ends
+func (r *reporter) next() (time.Time, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var next time.Time
+	for _, w := range r.windows {
+		if next.IsZero() || w.End.Before(next) {
+			next = w.End
+		}
+	}
+
+	return next, !next.IsZero()
+}
+
+// scheduleReports sends the reports to the notification sinks as their
+// windows end
+func (s *Server) scheduleReports() {
+	next, ok := s.reports.next()
+	if !ok {
+		return
+	}
+
+	s.clock.AfterFunc(next.Sub(s.clock.Now()), func() {
+		// Timers of the test clocks run their functions while it is advanced
+		go func() {
+			for _, r := range s.reports.due(s.clock.Now()) {
+				metrics.Add("reports_sent", 1)
+				s.ops.send(r.String())
+			}
+			s.scheduleReports()
+		}()
+	})
+}
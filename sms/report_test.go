@@ -0,0 +1,47 @@
+package sms_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/smstest"
+)
+
+func TestServer_reports(t *testing.T) {
+	posted := make(chan string, 10)
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("Failed to decode notification: %v", err)
+		}
+		posted <- payload["text"]
+	}))
+	defer sink.Close()
+
+	srv := smstest.NewServer(t, sms.Config{
+		NotificationSinks: []sms.NotificationSink{{Kind: sms.SinkSlack, URL: sink.URL}},
+		Reports:           []string{sms.ReportDaily},
+		PricePerPart:      0.05,
+	})
+
+	for i := 0; i < 2; i++ {
+		srv.Send(t, `{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`)
+	}
+
+	// The day ends 12 hours after the clock of the test server
+	srv.Clock.Advance(12 * time.Hour)
+
+	want := "Daily report 2020-01-01 to 2020-01-02: 2 messages sent (2 parts), 0 failed; spend 0.10"
+	select {
+	case text := <-posted:
+		if text != want {
+			t.Errorf("Report was %q; want %q", text, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Sink got no report")
+	}
+}
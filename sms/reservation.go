@@ -0,0 +1,182 @@
+package sms
+
+import (
+	"container/heap"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+// tenantOf returns the tenant of the HTTP request, the common name of
+// its verified client certificate (see ClientAuthTLS)
+// Requests without one belong to no tenant
+func tenantOf(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName
+}
+
+// validReservations returns the reservations whose fractions are
+// between 0 and 1, the others being ignored
+func validReservations(reservations map[string]float64) map[string]float64 {
+	valid := make(map[string]float64)
+	for tenant, fraction := range reservations {
+		if tenant == "" || fraction <= 0 || fraction > 1 {
+			slog.Warn("Ignored capacity reservation", "tenant", tenant, "fraction", fraction)
+			continue
+		}
+		valid[tenant] = fraction
+	}
+
+	return valid
+}
+
+// checkReservations makes sure the reservations leave something to share
+func checkReservations(reservations map[string]float64) error {
+	var total float64
+	for _, fraction := range reservations {
+		total += fraction
+	}
+	if total > 1 {
+		return fmt.Errorf("capacity reservations add up to %g, more than the whole capacity", total)
+	}
+
+	return nil
+}
+
+// admission hands out the slots of a queue to the requests of the tenants
+// A tenant may always take its reserved slots, while the others are shared
+// by the requests without a reservation and those beyond it
+type admission struct {
+	mu       sync.Mutex
+	reserved map[string]int
+	shared   int
+	inQueue  map[string]int
+}
+
+// newAdmission splits the capacity of a queue between the tenants
+// It returns nil, admitting every request, when nothing is reserved
+func newAdmission(capacity int, reservations map[string]float64) *admission {
+	if len(reservations) == 0 {
+		return nil
+	}
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	a := &admission{
+		reserved: make(map[string]int),
+		shared:   capacity,
+		inQueue:  make(map[string]int),
+	}
+	for tenant, fraction := range reservations {
+		slots := int(fraction * float64(capacity))
+		a.reserved[tenant] = slots
+		a.shared -= slots
+	}
+
+	return a
+}
+
+// acquire takes a slot for a request of the tenant, reporting false
+// when the tenant has none left
+func (a *admission) acquire(tenant string) bool {
+	if a == nil {
+		return true
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.inQueue[tenant] >= a.reserved[tenant] && a.sharedInUse() >= a.shared {
+		return false
+	}
+	a.inQueue[tenant]++
+
+	return true
+}
+
+func (a *admission) release(tenant string) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.inQueue[tenant]--
+}
+
+// sharedInUse counts the requests beyond the reservations of their tenant
+func (a *admission) sharedInUse() int {
+	n := 0
+	for tenant, count := range a.inQueue {
+		if count > a.reserved[tenant] {
+			n += count - a.reserved[tenant]
+		}
+	}
+
+	return n
+}
+
+// takeQueued takes the request out of the tracked ones, giving its
+// slot back to its tenant
+func (s *Server) takeQueued(id string) (*Request, bool) {
+	req, ok := s.queued.take(id)
+	if ok && req.admitted {
+		s.queueOf(req).admission.release(req.tenant)
+	}
+
+	return req, ok
+}
+
+// nextRequest pops the request to dispatch from the pending ones
+// The most urgent request of the tenant furthest below its reserved
+// share of the recent dispatches of the queue goes first, and the most
+// urgent request of all when every tenant got its share
+func (s *Server) nextRequest(q *classQueue, pending *requestQueue) *Request {
+	if len(s.reservations) == 0 {
+		return heap.Pop(pending).(*Request)
+	}
+
+	total := 0
+	dispatched := make(map[string]int)
+	for k, n := range s.dispatches.counts() {
+		if s.queueOfClass(k.class) == q {
+			total += n
+			dispatched[k.tenant] += n
+		}
+	}
+
+	next, deficit := -1, 0.0
+	for tenant, fraction := range s.reservations {
+		d := fraction*float64(total+1) - float64(dispatched[tenant])
+		if d <= deficit {
+			continue
+		}
+		if i := pending.mostUrgent(tenant); i >= 0 {
+			next, deficit = i, d
+		}
+	}
+	if next < 0 {
+		return heap.Pop(pending).(*Request)
+	}
+
+	metrics.Add("reserved_dispatches", 1)
+	return heap.Remove(pending, next).(*Request)
+}
+
+// mostUrgent returns the index of the most urgent pending request
+// of the tenant, -1 when it has none
+func (q requestQueue) mostUrgent(tenant string) int {
+	best := -1
+	for i, req := range q {
+		if req.tenant == tenant && (best < 0 || q.Less(i, best)) {
+			best = i
+		}
+	}
+
+	return best
+}
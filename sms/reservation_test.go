@@ -0,0 +1,98 @@
+package sms_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/fixtures"
+	"github.com/iulianclita/flysms/sms/smstest"
+)
+
+func TestServer_reservations(t *testing.T) {
+	clock := smstest.NewClock(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+	sender := blockingSender{received: make(chan *sms.Request, 10), release: make(chan struct{})}
+	defer close(sender.release)
+
+	srv := sms.NewServer(sms.Config{
+		Buffer:        10,
+		ReqTimeout:    time.Minute,
+		ThrottleRate:  time.Second,
+		Reservations:  map[string]float64{"otp-service": 0.3},
+		AdminKey:      "admin_key",
+		MessageClient: sender,
+		Clock:         clock,
+	})
+	srv.Run()
+
+	// The tenant is the common name of the verified client certificate
+	post := func(tenant, message string) <-chan *httptest.ResponseRecorder {
+		done := make(chan *httptest.ResponseRecorder, 1)
+		payload := fmt.Sprintf(`{"recipient":%d, "originator": %q, "message": %q}`, fixtures.Recipient, fixtures.Originator, message)
+		r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(payload))
+		if tenant != "" {
+			cert := &x509.Certificate{Subject: pkix.Name{CommonName: tenant}}
+			r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		}
+		go func() {
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+			done <- w
+		}()
+		return done
+	}
+	waitQueued := func(n int) {
+		deadline := time.Now().Add(time.Second)
+		for {
+			r := httptest.NewRequest(http.MethodGet, "/admin/fairness", nil)
+			r.Header.Set("Authorization", "AdminKey admin_key")
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			var status sms.QueueFairnessStatus
+			if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+			if status.Data.Queued >= n {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Got %d queued requests; want %d", status.Data.Queued, n)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// Three of the ten slots are kept for the tenant
+	for i := 0; i < 7; i++ {
+		post("", "Shared message")
+		waitQueued(i + 1)
+	}
+	w := <-post("marketing-service", "Shared message")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Status code beyond the shared slots was %d; want %d", w.Code, http.StatusTooManyRequests)
+	}
+	post("otp-service", "Reserved message")
+	waitQueued(8)
+
+	// The tenant is owed its share of the dispatches, even though
+	// its request came last
+	clock.Advance(time.Second)
+	if req := <-sender.received; req.Message != "Reserved message" {
+		t.Errorf("Dispatched message was %q; want the reserved one first", req.Message)
+	}
+
+	// The shared slot freed by the dispatch can be taken again
+	clock.Advance(time.Second)
+	<-sender.received
+	post("", "Shared message")
+	waitQueued(7)
+}
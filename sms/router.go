@@ -0,0 +1,154 @@
+package sms
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// router dispatches the requests by method and path
+// Patterns are made of literal segments and {name} parameters matching
+// any non empty segment, which the handlers read with pathParam
+// Requests of a routed path but another method are answered 405 with
+// the methods allowed, and the unrouted paths 404
+// Matching does not allocate, so that bad traffic is turned away cheaply
+type router struct {
+	routes []*route
+}
+
+// route is a pattern and its handlers by method, the empty method
+// standing for any method
+type route struct {
+	segments []string
+	handlers map[string]http.Handler
+	allow    []string
+}
+
+func newRouter() *router {
+	return &router{}
+}
+
+// Handle routes the requests of the method, any when empty, matching the pattern
+func (rt *router) Handle(method, pattern string, h http.Handler) {
+	segments := strings.Split(strings.Trim(pattern, "/"), "/")
+	for _, r := range rt.routes {
+		if equalSegments(r.segments, segments) {
+			r.add(method, h)
+			return
+		}
+	}
+
+	r := &route{segments: segments, handlers: make(map[string]http.Handler)}
+	r.add(method, h)
+	rt.routes = append(rt.routes, r)
+}
+
+// HandleFunc routes the requests of the method matching the pattern to the function
+func (rt *router) HandleFunc(method, pattern string, h http.HandlerFunc) {
+	rt.Handle(method, pattern, h)
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, route := range rt.routes {
+		if !route.match(r.URL.Path) {
+			continue
+		}
+
+		h, ok := route.handlers[r.Method]
+		if !ok {
+			h, ok = route.handlers[""]
+		}
+		if !ok {
+			w.Header()["Allow"] = route.allow
+			res := Response{
+				statusCode: http.StatusMethodNotAllowed,
+				Error:      "Request not allowed (invalid HTTP method)",
+			}
+			sendResponse(w, res)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+		return
+	}
+
+	res := Response{
+		statusCode: http.StatusNotFound,
+		Error:      "Not found (unknown resource)",
+	}
+	sendResponse(w, res)
+}
+
+func (r *route) add(method string, h http.Handler) {
+	r.handlers[method] = h
+
+	methods := make([]string, 0, len(r.handlers))
+	for m := range r.handlers {
+		if m != "" {
+			methods = append(methods, m)
+		}
+	}
+	sort.Strings(methods)
+	r.allow = []string{strings.Join(methods, ", ")}
+}
+
+// match reports whether the path matches the segments of the route
+func (r *route) match(path string) bool {
+	path = strings.TrimPrefix(path, "/")
+	for i, seg := range r.segments {
+		part := path
+		j := strings.IndexByte(path, '/')
+		if j >= 0 {
+			part, path = path[:j], path[j+1:]
+		}
+		// The path has segments left over, or too few of them
+		if last := i == len(r.segments)-1; (j >= 0) == last {
+			return false
+		}
+
+		if isParam(seg) {
+			if part == "" {
+				return false
+			}
+		} else if part != seg {
+			return false
+		}
+	}
+
+	return true
+}
+
+// pathParam returns the segment of the path at the index of the parameter
+// in its pattern, such as 1 for the id of /messages/{id}
+func pathParam(r *http.Request, i int) string {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	for ; i > 0; i-- {
+		j := strings.IndexByte(path, '/')
+		if j < 0 {
+			return ""
+		}
+		path = path[j+1:]
+	}
+	if j := strings.IndexByte(path, '/'); j >= 0 {
+		path = path[:j]
+	}
+
+	return path
+}
+
+func isParam(seg string) bool {
+	return strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}")
+}
+
+func equalSegments(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
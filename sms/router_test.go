@@ -0,0 +1,99 @@
+package sms_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/smstest"
+)
+
+// headerWriter is a response writer keeping its header between responses
+type headerWriter http.Header
+
+func (w headerWriter) Header() http.Header         { return http.Header(w) }
+func (w headerWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w headerWriter) WriteHeader(int)             {}
+
+func TestServer_routing(t *testing.T) {
+	srv := smstest.NewServer(t, sms.Config{AdminKey: "secret"})
+
+	tests := map[string]struct {
+		method     string
+		path       string
+		wantStatus int
+		wantAllow  string
+		wantError  string
+	}{
+		"Method not allowed": {
+			method:     http.MethodPatch,
+			path:       "/admin/features/beta",
+			wantStatus: http.StatusMethodNotAllowed,
+			wantAllow:  "DELETE, PUT",
+			wantError:  "Request not allowed (invalid HTTP method)",
+		},
+		"Unknown resource": {
+			method:     http.MethodGet,
+			path:       "/unknown",
+			wantStatus: http.StatusNotFound,
+			wantError:  "Not found (unknown resource)",
+		},
+		"Missing parameter": {
+			method:     http.MethodGet,
+			path:       "/messages/",
+			wantStatus: http.StatusNotFound,
+			wantError:  "Not found (unknown resource)",
+		},
+		"Segments left over": {
+			method:     http.MethodGet,
+			path:       "/messages/abc/attempts/1",
+			wantStatus: http.StatusNotFound,
+			wantError:  "Not found (unknown resource)",
+		},
+		"Parameter": {
+			method:     http.MethodGet,
+			path:       "/alerts/abc/ack",
+			wantStatus: http.StatusNotFound,
+			wantError:  "Not found (no alert waiting for acknowledgment with this code)",
+		},
+		"Routed": {
+			method:     http.MethodGet,
+			path:       "/admin/features",
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "Request not allowed (incorrect admin key)",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := srv.Do(tc.method, tc.path, "")
+			if w.Code != tc.wantStatus {
+				t.Errorf("Status code was %d; want %d", w.Code, tc.wantStatus)
+			}
+			if got := w.Header().Get("Allow"); got != tc.wantAllow {
+				t.Errorf("Allow was %q; want %q", got, tc.wantAllow)
+			}
+			var res sms.Response
+			if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+			if res.Error != tc.wantError {
+				t.Errorf("Error was %q; want %q", res.Error, tc.wantError)
+			}
+		})
+	}
+
+	t.Run("No allocations", func(t *testing.T) {
+		w := headerWriter{}
+		for _, r := range []*http.Request{
+			httptest.NewRequest(http.MethodPut, "/messages", nil),
+			httptest.NewRequest(http.MethodGet, "/unknown/path", nil),
+		} {
+			if n := testing.AllocsPerRun(100, func() { srv.ServeHTTP(w, r) }); n != 0 {
+				t.Errorf("%s %s allocated %g times; want none", r.Method, r.URL.Path, n)
+			}
+		}
+	})
+}
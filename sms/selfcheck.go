@@ -0,0 +1,238 @@
+package sms
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Self-checks of the server
+const (
+	CheckConfig     = "config"
+	CheckStorage    = "storage"
+	CheckQueueStore = "queue_store"
+	CheckProvider   = "provider"
+	CheckClock      = "clock"
+)
+
+// minClockTime is the time before which the clock is certainly wrong
+var minClockTime = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// CheckResult is the outcome of a self-check
+// Skipped checks do not apply to the configuration, such as the
+// provider check of the senders unable to report their balance
+type CheckResult struct {
+	Name       string `json:"name"`
+	OK         bool   `json:"ok"`
+	Skipped    bool   `json:"skipped,omitempty"`
+	Detail     string `json:"detail,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// SelfCheckReport is the outcome of the self-checks run on startup
+type SelfCheckReport struct {
+	OK      bool          `json:"ok"`
+	Checked time.Time     `json:"checked"`
+	Checks  []CheckResult `json:"checks"`
+}
+
+// String renders the report with a line per check
+func (r SelfCheckReport) String() string {
+	var b strings.Builder
+	status := "passed"
+	if !r.OK {
+		status = "failed"
+	}
+	fmt.Fprintf(&b, "Self-check %s at %s", status, r.Checked.Format(time.RFC3339))
+	for _, c := range r.Checks {
+		outcome := "ok"
+		switch {
+		case c.Skipped:
+			outcome = "skipped"
+		case !c.OK:
+			outcome = "FAILED"
+		}
+		fmt.Fprintf(&b, "\n  %-12s %-7s %s", c.Name, outcome, c.Detail)
+	}
+
+	return b.String()
+}
+
+// ReadinessStatus is the HTTP response of /readyz, with the report
+// of the self-checks when verbose
+type ReadinessStatus struct {
+	Success bool             `json:"success"`
+	Data    *SelfCheckReport `json:"data,omitempty"`
+}
+
+// selfChecks keeps the report of the last self-check run
+type selfChecks struct {
+	mu     sync.Mutex
+	report *SelfCheckReport
+}
+
+func (c *selfChecks) set(r SelfCheckReport) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.report = &r
+}
+
+func (c *selfChecks) get() (SelfCheckReport, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.report == nil {
+		return SelfCheckReport{}, false
+	}
+	return *c.report, true
+}
+
+// SelfCheck checks the configuration, the storage, the queue store,
+// the provider credentials and the clock, and keeps the report for /readyz
+// The server is not ready until a self-check passed
+func (s *Server) SelfCheck(ctx context.Context) SelfCheckReport {
+	report := SelfCheckReport{OK: true, Checked: s.clock.Now()}
+	checks := []struct {
+		name  string
+		check func(ctx context.Context) (detail string, skipped bool, err error)
+	}{
+		{CheckConfig, s.checkConfig},
+		{CheckStorage, s.checkStorage},
+		{CheckQueueStore, s.checkQueueStore},
+		{CheckProvider, s.checkProvider},
+		{CheckClock, s.checkClock},
+	}
+
+	for _, c := range checks {
+		start := time.Now()
+		detail, skipped, err := c.check(ctx)
+		res := CheckResult{
+			Name:       c.name,
+			OK:         err == nil,
+			Skipped:    skipped,
+			Detail:     detail,
+			DurationMS: int64(time.Since(start) / time.Millisecond),
+		}
+		if err != nil {
+			res.Detail = err.Error()
+			report.OK = false
+			metrics.Add("self_check_failures", 1)
+		}
+		report.Checks = append(report.Checks, res)
+	}
+	s.checks.set(report)
+
+	return report
+}
+
+func (s *Server) checkConfig(ctx context.Context) (string, bool, error) {
+	var problems []string
+	if s.messageClient == nil {
+		problems = append(problems, "no message client")
+	}
+	if s.reqTimeout <= 0 {
+		problems = append(problems, "request timeout is not positive")
+	}
+	for _, q := range []*classQueue{s.txQueue, s.mktQueue} {
+		if q != nil && q.throttleRate <= 0 {
+			problems = append(problems, q.class+" throttle rate is not positive")
+		}
+	}
+	if err := checkReservations(s.reservations); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := compileAlertRoutes(s.alertRoutes, s.escalations); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := compileAlertmanagerRoutes(s.amRoutes, s.groups, s.escalations); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if len(problems) > 0 {
+		return "", false, fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+
+	return "valid", false, nil
+}
+
+// checkStorage makes sure the audit log can still be written
+func (s *Server) checkStorage(ctx context.Context) (string, bool, error) {
+	if s.auditLog == nil {
+		return "no audit log", true, nil
+	}
+
+	s.auditLog.mu.Lock()
+	defer s.auditLog.mu.Unlock()
+	if _, err := s.auditLog.f.Stat(); err != nil {
+		return "", false, fmt.Errorf("audit log %s: %v", s.auditLog.path, err)
+	}
+
+	return "audit log " + s.auditLog.path, false, nil
+}
+
+func (s *Server) checkQueueStore(ctx context.Context) (string, bool, error) {
+	if s.queueStore == nil {
+		return "no queue store", true, nil
+	}
+
+	pending, err := s.queueStore.Pending()
+	if err != nil {
+		return "", false, err
+	}
+
+	return fmt.Sprintf("%d requests pending", len(pending)), false, nil
+}
+
+// checkProvider makes sure the provider accepts the credentials,
+// through the balance lookup as it sends nothing
+func (s *Server) checkProvider(ctx context.Context) (string, bool, error) {
+	checker, ok := s.messageClient.(balanceChecker)
+	if !ok {
+		return providerName(s.messageClient) + " does not report the balance", true, nil
+	}
+
+	ctx, cancel := s.withTimeout(ctx, s.reqTimeout)
+	defer cancel()
+	balance, err := checker.balance(ctx)
+	if err != nil {
+		if e, ok := err.(*ProviderError); ok {
+			return "", false, fmt.Errorf("%s: %s", providerName(s.messageClient), e.Description())
+		}
+		return "", false, fmt.Errorf("%s: %v", providerName(s.messageClient), err)
+	}
+
+	return fmt.Sprintf("%s balance %g %s", providerName(s.messageClient), balance.Amount, balance.Type), false, nil
+}
+
+// checkClock makes sure the clock is set and does not go backwards
+func (s *Server) checkClock(ctx context.Context) (string, bool, error) {
+	now := s.clock.Now()
+	if now.Before(minClockTime) {
+		return "", false, fmt.Errorf("clock is not set: %s", now.Format(time.RFC3339))
+	}
+	if later := s.clock.Now(); later.Before(now) {
+		return "", false, fmt.Errorf("clock went backwards by %v", now.Sub(later))
+	}
+
+	return now.UTC().Format(time.RFC3339), false, nil
+}
+
+// readiness is the HTTP handler of /readyz, answering 503 until a
+// self-check passed, with the report when asked for ?verbose=1
+func (s *Server) readiness() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, ok := s.checks.get()
+		status := ReadinessStatus{Success: ok && report.OK}
+		if ok && r.URL.Query().Get("verbose") == "1" {
+			status.Data = &report
+		}
+
+		code := http.StatusOK
+		if !status.Success {
+			code = http.StatusServiceUnavailable
+		}
+		sendJSON(w, code, status)
+	}
+}
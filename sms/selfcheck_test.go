@@ -0,0 +1,92 @@
+package sms_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/smstest"
+)
+
+// brokenStore is a queue store which cannot be reached
+type brokenStore struct{}
+
+func (brokenStore) Save(sms.QueuedRequest) error { return errors.New("connection refused") }
+func (brokenStore) Remove(string) error          { return errors.New("connection refused") }
+func (brokenStore) Pending() ([]sms.QueuedRequest, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestServer_selfCheck(t *testing.T) {
+	testServer := sms.NewTestServer(t, "server_key")
+	defer testServer.Close()
+
+	tests := map[string]struct {
+		cfg        sms.Config
+		wantOK     bool
+		wantStatus int
+		wantFailed string
+	}{
+		"Passing": {
+			cfg:        sms.Config{},
+			wantOK:     true,
+			wantStatus: http.StatusOK,
+		},
+		"Queue store unreachable": {
+			cfg:        sms.Config{QueueStore: brokenStore{}},
+			wantStatus: http.StatusServiceUnavailable,
+			wantFailed: sms.CheckQueueStore,
+		},
+		"Provider refusing the credentials": {
+			cfg: sms.Config{
+				MessageClient: sms.NewClient(sms.Options{AccessKey: "wrong_key", BaseURL: testServer.URL}),
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantFailed: sms.CheckProvider,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := smstest.NewServer(t, tc.cfg)
+
+			// The server is not ready before it checked itself
+			if w := srv.Do(http.MethodGet, "/readyz", ""); w.Code != http.StatusServiceUnavailable {
+				t.Errorf("Status code before the self-check was %d; want %d", w.Code, http.StatusServiceUnavailable)
+			}
+
+			report := srv.SelfCheck(context.Background())
+			if report.OK != tc.wantOK {
+				t.Errorf("Report was OK %v; want %v:\n%s", report.OK, tc.wantOK, report)
+			}
+			for _, c := range report.Checks {
+				if failed := !c.OK; failed != (c.Name == tc.wantFailed) {
+					t.Errorf("Check %s was OK %v: %s", c.Name, c.OK, c.Detail)
+				}
+			}
+			if tc.wantFailed != "" && !strings.Contains(report.String(), tc.wantFailed+" ") {
+				t.Errorf("Report %q does not name check %s", report, tc.wantFailed)
+			}
+
+			w := srv.Do(http.MethodGet, "/readyz?verbose=1", "")
+			if w.Code != tc.wantStatus {
+				t.Errorf("Status code was %d; want %d", w.Code, tc.wantStatus)
+			}
+			var status sms.ReadinessStatus
+			if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+			if status.Data == nil || len(status.Data.Checks) != len(report.Checks) {
+				t.Errorf("Verbose readiness was %+v; want the report", status)
+			}
+
+			if w := srv.Do(http.MethodGet, "/readyz", ""); strings.Contains(w.Body.String(), "checks") {
+				t.Errorf("Readiness was %s; want no report unless verbose", w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package sms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// MessageSender sends messages through an SMS provider
+// Failures are reported as *ProviderError when the provider refused the
+// message, as *ContractError when its answer could not be understood and
+// as *RequestError when it could not be reached
+type MessageSender interface {
+	CreateMessage(ctx context.Context, r *Request) (Result, error)
+}
+
+// Supported providers
+const (
+	ProviderMessageBird = "messagebird"
+	ProviderTwilio      = "twilio"
+	ProviderSNS         = "sns"
+	ProviderSMPP        = "smpp"
+)
+
+// NewSender creates the client of the provider named in the options
+// MessageBird is used when no provider is given
+func NewSender(opts Options) (MessageSender, error) {
+	switch opts.Provider {
+	case "", ProviderMessageBird:
+		return NewClient(opts), nil
+	case ProviderTwilio:
+		if opts.Compress {
+			return nil, fmt.Errorf("The %s provider does not accept compressed requests", ProviderTwilio)
+		}
+		return NewTwilioClient(opts), nil
+	case ProviderSNS:
+		creds := AWSCredentials{AccessKeyID: opts.AccessKey, SecretAccessKey: opts.SecretKey}
+		if creds.AccessKeyID == "" {
+			var err error
+			if creds, err = LoadAWSCredentials(); err != nil {
+				return nil, err
+			}
+		}
+		client, err := NewSNSClient(opts, creds)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	case ProviderSMPP:
+		return NewSMPPClient(opts), nil
+	}
+
+	return nil, fmt.Errorf("Unknown SMS provider %q", opts.Provider)
+}
+
+// Result is what the provider reports about a created message
+// Scheduled is zero unless the message is held until that time,
+// Parts unless the message was split in that many parts, and PartsSent
+// unless only that many of them were sent
+type Result struct {
+	StatusCode int
+	ID         string
+	Recipient  PhoneNumber
+	Originator string
+	Message    string
+	Status     string
+	Created    time.Time
+	Scheduled  time.Time
+	Parts      int
+	PartsSent  int
+}
+
+// messageScheduler is implemented by the senders able to hold
+// messages until their scheduled time
+type messageScheduler interface {
+	scheduleMessages()
+}
+
+// ErrorKind classifies the errors returned by the provider
+type ErrorKind int
+
+const (
+	// KindUnknown is an error the client does not know how to classify
+	KindUnknown ErrorKind = iota
+	// KindAuth means the access key was refused
+	KindAuth
+	// KindValidation means the message parameters were refused
+	KindValidation
+	// KindThrottled means the provider rate limited the client
+	KindThrottled
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case KindAuth:
+		return "auth"
+	case KindValidation:
+		return "validation"
+	case KindThrottled:
+		return "throttled"
+	}
+	return "unknown"
+}
+
+// ProviderError is returned when the provider refuses to create the message
+type ProviderError struct {
+	Kind       ErrorKind
+	StatusCode int
+	Errors     []MessageError
+}
+
+func (e *ProviderError) Error() string {
+	return fmt.Sprintf("Provider refused the message (%s error, status %d): %s", e.Kind, e.StatusCode, e.Description())
+}
+
+// Description returns the description of the first error in the bag
+func (e *ProviderError) Description() string {
+	if len(e.Errors) == 0 {
+		return ""
+	}
+	return e.Errors[0].Description
+}
+
+// invalidRecipient reports whether the provider refused the recipient
+func (e *ProviderError) invalidRecipient() bool {
+	if e.Kind != KindValidation {
+		return false
+	}
+	for _, me := range e.Errors {
+		if me.Parameter == "recipient" {
+			return true
+		}
+	}
+	return false
+}
+
+// statusKind classifies a provider error by its HTTP status code
+func statusKind(statusCode int) ErrorKind {
+	switch statusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return KindAuth
+	case http.StatusTooManyRequests:
+		return KindThrottled
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return KindValidation
+	}
+
+	return KindUnknown
+}
+
+// ContractError is returned when the provider answers with a payload
+// that does not match the documented API schema
+// The raw body is kept to help figuring out what the provider changed
+type ContractError struct {
+	StatusCode int
+	Body       []byte
+	Reason     string
+}
+
+func (e *ContractError) Error() string {
+	return fmt.Sprintf("Provider contract violation (status %d): %s; Body: %s", e.StatusCode, e.Reason, string(e.Body))
+}
+
+// RequestError is returned when the provider could not be reached, or its
+// response could not be read
+// The request is only known by its method and URL, without query and user
+// information, so that the credentials it carries never end up in the logs
+type RequestError struct {
+	Method   string
+	URL      string
+	ReadBody bool
+	Err      error
+}
+
+// newRequestError describes the failure of the request
+func newRequestError(req *http.Request, readBody bool, err error) *RequestError {
+	// The URL errors of the HTTP client repeat the whole URL
+	var uerr *url.Error
+	if errors.As(err, &uerr) {
+		err = uerr.Err
+	}
+
+	u := *req.URL
+	u.User, u.RawQuery, u.Fragment = nil, "", ""
+
+	return &RequestError{Method: req.Method, URL: u.String(), ReadBody: readBody, Err: err}
+}
+
+func (e *RequestError) Error() string {
+	if e.ReadBody {
+		return fmt.Sprintf("Could not read response body of %s %s; Error: %v", e.Method, e.URL, e.Err)
+	}
+	return fmt.Sprintf("Could not get response for %s %s; Error: %v", e.Method, e.URL, e.Err)
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
@@ -1,38 +1,93 @@
 package sms
 
 import (
+	"container/heap"
 	"context"
+	"crypto/x509"
 	"encoding/json"
+	"expvar"
 	"fmt"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"time"
 )
 
+const (
+	priorityNormal = "normal"
+	priorityHigh   = "high"
+)
+
+// Limits of the message parameters accepted by the server
+// Originators are counted in bytes, and text messages in GSM-7 septets,
+// or in UCS-2 characters when they do not fit the GSM-7 alphabet
+const (
+	MinRecipientDigits   = 7
+	MaxRecipientDigits   = 15
+	MaxOriginatorLength  = 11
+	MaxMessageLength     = 160
+	MaxUCS2MessageLength = 70
+)
+
+// MaxVoiceMessageLength is the length limit of the text read in voice calls
+const MaxVoiceMessageLength = 1000
+
+// DefaultWorkers is the number of requests sent to the provider at once
+// when Config.Workers is not set
+const DefaultWorkers = 10
+
 // Request is the representation of an SMS request
 // and is extracted from the HTTP request body
 type Request struct {
-	ctx        context.Context
-	resCh      chan Response
-	Recipient  int64  `json:"recipient"`
-	Originator string `json:"originator"`
-	Message    string `json:"message"`
+	ctx         context.Context
+	resCh       chan Response
+	seq         uint64
+	validated   time.Time
+	queued      time.Time
+	dispatched  time.Time
+	responded   time.Time
+	trace       spanContext
+	id          string
+	tenant      string
+	admitted    bool
+	split       bool
+	Recipient   PhoneNumber `json:"recipient"`
+	Originator  string      `json:"originator"`
+	Message     string      `json:"message"`
+	Priority    string      `json:"priority,omitempty"`
+	Class       string      `json:"class,omitempty"`
+	OTP         bool        `json:"otp,omitempty"`
+	DeliverBy   *time.Time  `json:"deliver_by,omitempty"`
+	ScheduledAt *time.Time  `json:"scheduled_at,omitempty"`
+	CallbackURL string      `json:"callback_url,omitempty"`
+	Channel     string      `json:"channel,omitempty"`
+	Type        string      `json:"type,omitempty"`
+	UDH         string      `json:"udh,omitempty"`
+	Language    string      `json:"language,omitempty"`
+	Voice       string      `json:"voice,omitempty"`
 }
 
 // Content keeps together all the parameters associated with a SMS
 type Content struct {
-	ID         string `json:"id"`
-	Recipient  int64  `json:"recipient"`
-	Originator string `json:"originator"`
-	Message    string `json:"message"`
-	Status     string `json:"status"`
-	Created    string `json:"created"`
+	ID          string      `json:"id"`
+	Recipient   PhoneNumber `json:"recipient"`
+	Originator  string      `json:"originator"`
+	Message     string      `json:"message"`
+	Status      string      `json:"status"`
+	Created     string      `json:"created"`
+	ScheduledAt string      `json:"scheduled_at,omitempty"`
+	Region      string      `json:"region,omitempty"`
+	Parts       int         `json:"parts,omitempty"`
+	PartsSent   int         `json:"parts_sent,omitempty"`
+	Timeline    *Timeline   `json:"timeline,omitempty"`
 }
 
 // Response is the representation of an HTTP response
 // after succesfully handling a HTTP SMS request
 type Response struct {
 	statusCode int
+	requestID  string
 	Success    bool    `json:"success"`
 	Data       Content `json:"data,omitempty"`
 	Error      string  `json:"error,omitempty"`
@@ -40,46 +95,322 @@ type Response struct {
 
 // Server is the frontend server that communicates to our SMS API
 type Server struct {
-	*http.ServeMux
-	reqCh         chan *Request
-	done          chan struct{}
-	buf           int
-	reqTimeout    time.Duration
-	throttleRate  time.Duration
-	messageClient *Client
+	*router
+	concurrency    *concurrencyLimiter
+	listen         listenConfig
+	conns          *connTracker
+	checks         *selfChecks
+	queued         *queuedRequests
+	dispatches     *dispatchLog
+	reservations   map[string]float64
+	done           chan struct{}
+	reqTimeout     time.Duration
+	txQueue        *classQueue
+	warmUp         time.Duration
+	mktQueue       *classQueue
+	region         string
+	hedgeDelay     time.Duration
+	numbers        *numberCache
+	otpLimiter     *rateLimiter
+	rcptLimiter    *rateLimiter
+	detector       AnomalyDetector
+	held           *holdStore
+	adminKey       string
+	cursors        *cursorSigner
+	attempts       *attemptStore
+	deliveries     *deliveryStore
+	lookups        *lookupCache
+	callbacks      *callbackNotifier
+	captureTTL     time.Duration
+	maintenance    *maintenanceMode
+	features       *featureFlags
+	shadow         *shadowTraffic
+	mirror         *trafficMirror
+	branding       map[string]Branding
+	maxParts       int
+	emailDomain    string
+	emailOrig      string
+	sendSMSUsers   map[string]string
+	alertRoutes    []AlertRoute
+	amRoutes       []AlertmanagerRoute
+	amToken        string
+	groups         map[string][]PhoneNumber
+	origRules      map[string]OriginatorRule
+	rotations      *rotationStore
+	escalations    *escalationStore
+	ackURL         string
+	backupKey      []byte
+	queueStore     QueueStore
+	auditLog       *AuditLog
+	crypto         Crypto
+	ops            *opsNotifier
+	lowBalance     float64
+	reports        *reporter
+	tracer         *tracer
+	clock          Clock
+	messageClient  MessageSender
+	fallbackClient MessageSender
+	deprecated     map[string][]int
 }
 
 // Config is a collection of configuration options for the server
+// RecipientLimits cap the messages of any kind sent to the same recipient
+// DebugCaptureTTL enables debug mode, where redacted snippets of the
+// provider exchanges of failed attempts are kept for that long
+// MaintenanceMessage and MaintenanceRetryAfter are the defaults
+// of the maintenance mode toggled through /admin/maintenance
+// Features maps feature flags, such as FeatureAutoSplit, to the percentage
+// of tenants they are rolled out to, which can be overridden through
+// /admin/features
+// ShadowPercent of the messages are also sent through the ShadowClient
+// to evaluate it, to the ShadowRecipients test numbers without which
+// nothing is shadowed
+// MirrorURL is the base URL of a staging instance receiving sanitized copies
+// of the accepted requests, sent to the MirrorRecipients test numbers
+// LookupCacheTTL enables caching the message and balance lookups
+// for that long
+// Branding maps originators to the text added around their messages,
+// with the empty originator for the default branding
+// CallbackURL receives the status changes of the messages
+// not having a callback_url of their own
+// The callback_url of the messages must resolve to public addresses,
+// unless its host is the one of CallbackURL or one of the CallbackHosts
+// CallbackTemplates maps callback URL prefixes, such as
+// https://hooks.slack.com/, to the text/template of their payload,
+// executed with the StatusEvent
+// MaxMessageParts enables splitting the text messages too long for a single
+// message in up to that many parts, sent as a concatenated message
+// EmailDomain is the domain of the addresses of the SMTP listener,
+// sms.local by default, and EmailOriginator the originator of its messages
+// SendSMSAccounts maps the usernames of the Kannel compatible
+// /cgi-bin/sendsms endpoint to their password, any being accepted when empty
+// BackupKey encrypts the backups of /admin/backup, which are only
+// checksummed without it
+// QueueStore keeps the queued requests so that they survive restarts
+// AuditLog records the messages sent and their status changes
+// Crypto defaults to StandardCrypto, see NewFIPSCrypto for regulated deployments
+// NotificationSinks are told about operational events, such as the balance
+// falling below LowBalance, and sent the daily or weekly Reports, their
+// spend estimated from PricePerPart
+// MaxQueueAge sheds the requests waiting in the queue for longer,
+// answering them as expired so that fresh traffic goes first
+// SpanExporter receives the spans of the message requests, from the
+// HTTP handler through the queue to the provider calls
+// MaxConcurrentRequests caps the HTTP requests processed at once, and
+// RouteConcurrency those of the route families, such as "messages" or
+// "admin", requests beyond them being answered 503
+// MaxConnLifetime closes the client connections older than that once
+// their current request is answered, see Server.ConnState
+// WarmUp ramps the send rate up after startup, from a tenth of the
+// throttling rate to all of it, so that a backlog is not sent in a burst
+// Addr is the address ListenAndServe listens on, serving HTTPS with the
+// TLSCertFile and TLSKeyFile, TLS 1.2 or the TLSMinVersion and the
+// TLSCipherSuites, and requiring client certificates of the ClientCAs
+// naming one of the ClientNames when set (see ClientAuthTLS)
+// IdleTimeout closes the keep-alive connections idle for longer
+// DeprecatedCodes lists by provider name the error codes reporting a
+// retired API, kept as deprecation notices under /admin/deprecations
+// Reservations guarantees to the tenants, the callers named by the common
+// name of their client certificate (see ClientAuthTLS), a fraction of the
+// Buffer slots and of the dispatches of every queue
+// MaxHeld caps the messages quarantined by the Detector and held for
+// approval, DefaultMaxHeld by default, and HoldTTL rejects those not
+// reviewed in time, DefaultHoldTTL by default
+// Workers caps the requests sent to the provider at once, DefaultWorkers
+// by default
+// These apply to the transactional messages, and to the marketing ones
+// unless they have a MarketingQueue of their own
+// Clock defaults to the wall clock
 type Config struct {
-	Buffer        int
-	ReqTimeout    time.Duration
-	ThrottleRate  time.Duration
-	MessageClient *Client
+	Addr                  string
+	IdleTimeout           time.Duration
+	TLSCertFile           string
+	TLSKeyFile            string
+	TLSMinVersion         uint16
+	TLSCipherSuites       []uint16
+	ClientCAs             *x509.CertPool
+	ClientNames           []string
+	MaxConcurrentRequests int
+	RouteConcurrency      map[string]int
+	MaxConnLifetime       time.Duration
+	Buffer                int
+	Workers               int
+	Reservations          map[string]float64
+	MaxQueueAge           time.Duration
+	WarmUp                time.Duration
+	MarketingQueue        ClassQueue
+	ReqTimeout            time.Duration
+	ThrottleRate          time.Duration
+	Region                string
+	HedgeDelay            time.Duration
+	NumberCacheTTL        time.Duration
+	OTPLimits             []RateLimit
+	RecipientLimits       []RateLimit
+	Detector              AnomalyDetector
+	MaxHeld               int
+	HoldTTL               time.Duration
+	AdminKey              string
+	CursorKey             string
+	AttemptHistory        int
+	LookupCacheTTL        time.Duration
+	DebugCaptureTTL       time.Duration
+	MaintenanceMessage    string
+	MaintenanceRetryAfter time.Duration
+	Features              map[string]int
+	MessageClient         MessageSender
+	FallbackClient        MessageSender
+	DeprecatedCodes       map[string][]int
+	ShadowClient          MessageSender
+	ShadowPercent         int
+	ShadowRecipients      []PhoneNumber
+	MirrorURL             string
+	MirrorRecipients      []PhoneNumber
+	CallbackURL           string
+	CallbackTemplates     map[string]string
+	CallbackHosts         []string
+	NotificationSinks     []NotificationSink
+	LowBalance            float64
+	Reports               []string
+	PricePerPart          float64
+	Branding              map[string]Branding
+	MaxMessageParts       int
+	EmailDomain           string
+	EmailOriginator       string
+	SendSMSAccounts       map[string]string
+	AlertRoutes           []AlertRoute
+	AlertmanagerRoutes    []AlertmanagerRoute
+	AlertmanagerToken     string
+	RecipientGroups       map[string][]PhoneNumber
+	OriginatorRules       map[string]OriginatorRule
+	Rotations             map[string]Rotation
+	EscalationPolicies    map[string]EscalationPolicy
+	AckURL                string
+	BackupKey             string
+	QueueStore            QueueStore
+	AuditLog              *AuditLog
+	Crypto                Crypto
+	SpanExporter          SpanExporter
+	Clock                 Clock
 }
 
 // NewServer creates a new server from the given config
 func NewServer(cfg Config) *Server {
+	clock := cfg.Clock
+	if clock == nil {
+		clock = realClock{}
+	}
+	crypto := orStandardCrypto(cfg.Crypto)
+
+	txQueue := newClassQueue(ClassTransactional, ClassQueue{
+		Buffer:       cfg.Buffer,
+		ThrottleRate: cfg.ThrottleRate,
+		MaxQueueAge:  cfg.MaxQueueAge,
+		Workers:      cfg.Workers,
+	})
+	var mktQueue *classQueue
+	if cfg.MarketingQueue.Buffer > 0 {
+		mktQueue = newClassQueue(ClassMarketing, cfg.MarketingQueue)
+	}
+	reservations := validReservations(cfg.Reservations)
+	for _, q := range []*classQueue{txQueue, mktQueue} {
+		if q != nil {
+			q.admission = newAdmission(q.buf, reservations)
+		}
+	}
+
+	emailDomain := cfg.EmailDomain
+	if emailDomain == "" {
+		emailDomain = defaultEmailDomain
+	}
+	emailOrig := cfg.EmailOriginator
+	if emailOrig == "" {
+		emailOrig = defaultEmailOriginator
+	}
+
+	listen := listenConfig{
+		addr:         cfg.Addr,
+		idleTimeout:  cfg.IdleTimeout,
+		certFile:     cfg.TLSCertFile,
+		keyFile:      cfg.TLSKeyFile,
+		minVersion:   cfg.TLSMinVersion,
+		cipherSuites: cfg.TLSCipherSuites,
+		clientCAs:    cfg.ClientCAs,
+		clientNames:  cfg.ClientNames,
+	}
+
 	return &Server{
-		ServeMux:      http.NewServeMux(),
-		reqCh:         make(chan *Request, cfg.Buffer),
-		done:          make(chan struct{}),
-		reqTimeout:    cfg.ReqTimeout,
-		throttleRate:  cfg.ThrottleRate,
-		messageClient: cfg.MessageClient,
+		router:         newRouter(),
+		concurrency:    newConcurrencyLimiter(cfg.MaxConcurrentRequests, cfg.RouteConcurrency),
+		listen:         listen,
+		conns:          newConnTracker(cfg.MaxConnLifetime, clock),
+		checks:         &selfChecks{},
+		queued:         newQueuedRequests(),
+		dispatches:     newDispatchLog(clock),
+		reservations:   reservations,
+		done:           make(chan struct{}),
+		reqTimeout:     cfg.ReqTimeout,
+		txQueue:        txQueue,
+		warmUp:         cfg.WarmUp,
+		mktQueue:       mktQueue,
+		region:         cfg.Region,
+		hedgeDelay:     cfg.HedgeDelay,
+		numbers:        newNumberCache(cfg.NumberCacheTTL, clock),
+		otpLimiter:     newRateLimiter(cfg.OTPLimits...),
+		rcptLimiter:    newRateLimiter(cfg.RecipientLimits...),
+		detector:       cfg.Detector,
+		held:           newHoldStore(cfg.MaxHeld, cfg.HoldTTL, clock),
+		adminKey:       cfg.AdminKey,
+		cursors:        newCursorSigner(cfg.CursorKey, crypto),
+		attempts:       newAttemptStore(cfg.AttemptHistory, clock),
+		deliveries:     newDeliveryStore(cfg.AttemptHistory),
+		lookups:        newLookupCache(cfg.LookupCacheTTL, clock),
+		callbacks:      newCallbackNotifier(cfg.CallbackURL, cfg.CallbackTemplates, cfg.CallbackHosts, cfg.ReqTimeout, clock),
+		captureTTL:     cfg.DebugCaptureTTL,
+		maintenance:    newMaintenanceMode(cfg.MaintenanceMessage, cfg.MaintenanceRetryAfter),
+		features:       newFeatureFlags(cfg.Features),
+		shadow:         newShadowTraffic(cfg.ShadowClient, cfg.ShadowPercent, cfg.ShadowRecipients),
+		mirror:         newTrafficMirror(cfg.MirrorURL, cfg.MirrorRecipients, cfg.ReqTimeout),
+		branding:       cfg.Branding,
+		maxParts:       cfg.MaxMessageParts,
+		emailDomain:    emailDomain,
+		emailOrig:      emailOrig,
+		sendSMSUsers:   cfg.SendSMSAccounts,
+		alertRoutes:    cfg.AlertRoutes,
+		amRoutes:       cfg.AlertmanagerRoutes,
+		amToken:        cfg.AlertmanagerToken,
+		groups:         cfg.RecipientGroups,
+		origRules:      cfg.OriginatorRules,
+		rotations:      newRotationStore(cfg.Rotations, clock),
+		escalations:    newEscalationStore(cfg.EscalationPolicies),
+		ackURL:         cfg.AckURL,
+		backupKey:      newBackupKey(crypto, cfg.BackupKey),
+		queueStore:     cfg.QueueStore,
+		auditLog:       cfg.AuditLog,
+		crypto:         crypto,
+		ops:            newOpsNotifier(cfg.NotificationSinks, cfg.ReqTimeout, clock),
+		lowBalance:     cfg.LowBalance,
+		reports:        newReporter(cfg.Reports, cfg.PricePerPart, clock.Now()),
+		tracer:         newTracer(cfg.SpanExporter, clock),
+		clock:          clock,
+		messageClient:  cfg.MessageClient,
+		fallbackClient: cfg.FallbackClient,
+		deprecated:     cfg.DeprecatedCodes,
 	}
 }
 
 // createMessage is the HTTP handler for message creation
 func (s *Server) createMessage() http.HandlerFunc {
+	return s.acceptMessage("")
+}
+
+// acceptMessage is the HTTP handler validating and queueing the messages
+// A channel is forced on the messages unless empty, otherwise they
+// are delivered over the channel they ask for, SMS by default
+func (s *Server) acceptMessage(channel string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var res Response
-		// Validate HTTP method
-		if r.Method != http.MethodPost {
-			res = Response{
-				statusCode: http.StatusMethodNotAllowed,
-				Error:      "Request not allowed (invalid HTTP method)",
-			}
-			sendResponse(w, res)
+		// Refuse to send anything during provider maintenance
+		if s.refuseInMaintenance(w) {
 			return
 		}
 
@@ -94,14 +425,44 @@ func (s *Server) createMessage() http.HandlerFunc {
 			return
 		}
 
+		// The features being rolled out are gated by tenant
+		req.tenant = tenantOf(r)
+
+		// Validate channel property value in json input
+		// Make sure it is supported, and the one of the endpoint if it has one
+		if req.Channel == "" {
+			req.Channel = channel
+		}
+		if req.Channel == "" {
+			req.Channel = channelSMS
+		}
+		if !knownChannel(req.Channel) || channel != "" && req.Channel != channel {
+			res = Response{
+				statusCode: http.StatusUnprocessableEntity,
+				Error:      "Invalid parameter (channel value is not supported)",
+			}
+			sendResponse(w, res)
+			return
+		}
+
 		// Validate recipient property value in json input
-		// Make sure its length is between 7 and 15
-		recp := fmt.Sprintf("%d", req.Recipient)
+		// Make sure it is made of digits, after an optional plus sign,
+		// and that there are between 7 and 15 of them
+		if problem := recipientProblem(req.Recipient); problem != "" {
+			res = Response{
+				statusCode: http.StatusUnprocessableEntity,
+				Error:      "Invalid parameter (" + problem + ")",
+			}
+			sendResponse(w, res)
+			return
+		}
 
-		if len(recp) < 7 || len(recp) > 15 {
+		// Reject recipients that were recently confirmed invalid
+		// There is no point paying for a message that cannot be delivered
+		if status, ok := s.numbers.get(req.Recipient); ok && status == numberInvalid && s.featureEnabled(FeatureRejectKnownInvalid, &req) {
 			res = Response{
 				statusCode: http.StatusUnprocessableEntity,
-				Error:      "Invalid parameter (recipient value is out of bounds)",
+				Error:      "Invalid parameter (recipient was recently confirmed invalid)",
 			}
 			sendResponse(w, res)
 			return
@@ -120,7 +481,7 @@ func (s *Server) createMessage() http.HandlerFunc {
 
 		// Validate originator property value in json input
 		// Make sure it's length does not go beyond 11 characters
-		if len(req.Originator) > 11 {
+		if len(req.Originator) > MaxOriginatorLength {
 			res = Response{
 				statusCode: http.StatusUnprocessableEntity,
 				Error:      "Invalid parameter (originator value is to long)",
@@ -141,8 +502,10 @@ func (s *Server) createMessage() http.HandlerFunc {
 		}
 
 		// Validate message property value in json input
-		// Make sure it's length does not go beyond 160 characters
-		if len(req.Message) > 160 {
+		// Make sure it's length does not go beyond 160 GSM-7 characters,
+		// 70 UCS-2 ones, or the limit of the channel for other channels
+		// Longer text messages are split when the server is configured so
+		if length, limit := messageLength(&req); length > limit && !s.splittable(&req) {
 			res = Response{
 				statusCode: http.StatusUnprocessableEntity,
 				Error:      "Invalid parameter (message value is to long)",
@@ -151,63 +514,499 @@ func (s *Server) createMessage() http.HandlerFunc {
 			return
 		}
 
-		ctx, cancel := context.WithTimeout(context.TODO(), s.reqTimeout)
-		defer cancel()
+		// Add the branding of the originator to the message
+		// Make sure it still fits once branded, as the branding
+		// may also need the message to be UCS-2 encoded
+		s.brand(&req)
+		if length, limit := messageLength(&req); length > limit && !s.splittable(&req) {
+			res = Response{
+				statusCode: http.StatusUnprocessableEntity,
+				Error:      "Invalid parameter (message value is to long with its branding)",
+			}
+			sendResponse(w, res)
+			return
+		} else if length > limit {
+			req.split = true
+		}
 
-		req.ctx = ctx
-		req.resCh = make(chan Response)
+		// Make sure a split message does not go beyond the parts allowed
+		if req.split && len(splitMessage(req.Message)) > s.maxParts {
+			res = Response{
+				statusCode: http.StatusUnprocessableEntity,
+				Error:      fmt.Sprintf("Invalid parameter (message value is to long for %d parts)", s.maxParts),
+			}
+			sendResponse(w, res)
+			return
+		}
 
-		select {
-		case s.reqCh <- &req:
-			log.Printf("Accepted incoming request: %#v\n", req)
-		default:
-			log.Printf("Dropped incoming request: %#v\n", req)
+		// Apply the originator rules of the recipient country
+		// Make sure alphanumeric originators are only sent where allowed
+		if !s.applyOriginatorRule(&req) {
 			res = Response{
-				statusCode: http.StatusTooManyRequests,
-				Error:      "Request limit exceeded (request has been dropped)",
+				statusCode: http.StatusUnprocessableEntity,
+				Error:      "Invalid parameter (originator value is not allowed in the recipient country)",
 			}
 			sendResponse(w, res)
 			return
 		}
 
-		select {
-		case res := <-req.resCh:
+		// Make sure the provider can deliver over the channel
+		if !supportsChannel(s.messageClient, req.Channel) {
+			res = Response{
+				statusCode: http.StatusNotImplemented,
+				Error:      "Not implemented (provider does not support " + req.Channel + " messages)",
+			}
 			sendResponse(w, res)
-		case <-ctx.Done():
+			return
+		}
+
+		// Validate language and voice property values in json input
+		// Make sure they are only given for voice messages, and supported
+		if invalid := checkVoice(&req); invalid != "" {
 			res = Response{
-				statusCode: http.StatusRequestTimeout,
-				Error:      "Request timeout (process took to long to finish)",
+				statusCode: http.StatusUnprocessableEntity,
+				Error:      "Invalid parameter (" + invalid + ")",
 			}
 			sendResponse(w, res)
+			return
+		}
+
+		// Validate type and udh property values in json input
+		// Make sure the provider can deliver messages of that type,
+		// and that binary messages are hex encoded and fit in one message
+		if invalid := checkType(&req, s.messageClient); invalid != "" {
+			res = Response{
+				statusCode: http.StatusUnprocessableEntity,
+				Error:      "Invalid parameter (" + invalid + ")",
+			}
+			sendResponse(w, res)
+			return
+		}
+
+		// Validate priority property value in json input
+		// Make sure it is one of the supported priorities
+		if req.Priority != "" && req.Priority != priorityNormal && req.Priority != priorityHigh {
+			res = Response{
+				statusCode: http.StatusUnprocessableEntity,
+				Error:      "Invalid parameter (priority value is not supported)",
+			}
+			sendResponse(w, res)
+			return
+		}
+
+		// Validate class property value in json input
+		// Make sure it is one of the supported classes
+		if !knownClass(req.Class) {
+			res = Response{
+				statusCode: http.StatusUnprocessableEntity,
+				Error:      "Invalid parameter (class value is not supported)",
+			}
+			sendResponse(w, res)
+			return
+		}
+
+		// Validate deliver_by property value in json input
+		// Make sure the deadline has not already passed
+		if req.DeliverBy != nil && !req.DeliverBy.After(s.clock.Now()) {
+			res = Response{
+				statusCode: http.StatusUnprocessableEntity,
+				Error:      "Invalid parameter (deliver_by value is in the past)",
+			}
+			sendResponse(w, res)
+			return
+		}
+
+		// Validate scheduled_at property value in json input
+		// Make sure it is in the future, before the delivery deadline,
+		// and that the provider can hold messages until then
+		if req.ScheduledAt != nil {
+			var invalid string
+			if _, ok := s.messageClient.(messageScheduler); !ok {
+				invalid = "scheduled_at value is not supported by the provider"
+			} else if !req.ScheduledAt.After(s.clock.Now()) {
+				invalid = "scheduled_at value is in the past"
+			} else if req.DeliverBy != nil && !req.DeliverBy.After(*req.ScheduledAt) {
+				invalid = "scheduled_at value is after deliver_by"
+			}
+
+			if invalid != "" {
+				res = Response{
+					statusCode: http.StatusUnprocessableEntity,
+					Error:      "Invalid parameter (" + invalid + ")",
+				}
+				sendResponse(w, res)
+				return
+			}
+		}
+
+		// Validate callback_url property value in json input
+		// Make sure status changes can be posted to it
+		if req.CallbackURL != "" && !validCallbackURL(req.CallbackURL) {
+			res = Response{
+				statusCode: http.StatusUnprocessableEntity,
+				Error:      "Invalid parameter (callback_url value is not a HTTP URL)",
+			}
+			sendResponse(w, res)
+			return
+		}
+
+		// Callers may name the request themselves, which lets them cancel it
+		// while it is queued since the id is only returned once it is sent
+		if id := r.Header.Get("X-Request-Id"); id != "" {
+			if !validRequestID(id) {
+				res = Response{
+					statusCode: http.StatusBadRequest,
+					Error:      "Bad request (invalid X-Request-Id header)",
+				}
+				sendResponse(w, res)
+				return
+			}
+			req.id = id
+		}
+		req.trace = spanFromContext(r.Context())
+
+		// Throttle one-time passwords sent to the same recipient
+		// This protects against OTP pumping and resend loops
+		// The hit is only recorded once the request is queued (see submit)
+		if req.OTP {
+			if rl, ok := s.otpLimiter.limited(req.Recipient.msisdn(), s.clock.Now()); ok {
+				sendResponse(w, otpLimitExceeded(rl))
+				return
+			}
+		}
+
+		// Throttle any message sent to the same recipient
+		// This protects the end users from a misbehaving upstream service
+		if rl, ok := s.rcptLimiter.allow(req.Recipient.msisdn(), s.clock.Now()); !ok {
+			metrics.Add("recipient_throttled", 1)
+			res = Response{
+				statusCode: http.StatusTooManyRequests,
+				Error:      fmt.Sprintf("Request limit exceeded (at most %d messages per %s for recipient)", rl.Count, rl.Window),
+			}
+			sendResponse(w, res)
+			return
+		}
+
+		// Let the anomaly detector look at the traffic before it is queued
+		if s.detector != nil {
+			ev := SendEvent{
+				Recipient:   req.Recipient,
+				Originator:  req.Originator,
+				ContentHash: contentHash(req.Message),
+				Caller:      callerAddr(r),
+				Time:        s.clock.Now(),
+			}
+			if verdict, reason := s.detector.Inspect(ev); verdict == VerdictQuarantine {
+				metrics.Add("quarantined", 1)
+				slog.Warn("Quarantined incoming request", "request", &req, "reason", reason)
+				sendResponse(w, s.held.hold(&req, reason))
+				return
+			}
+		}
+
+		sendResponse(w, s.submit(r.Context(), &req))
+	}
+}
+
+// submit queues the request for sending and waits for its response
+// It also deals with request cancellation, the deadline as well as the
+// caller going away, which also abandons the provider call
+func (s *Server) submit(ctx context.Context, req *Request) Response {
+	ctx, cancel := s.withTimeout(withSpan(ctx, req.trace), s.reqTimeout)
+	defer cancel()
+
+	req.ctx = ctx
+	req.validated = s.clock.Now()
+	if req.id == "" {
+		req.id = newID()
+	}
+	// The response may be ready before the handler starts waiting for it
+	req.resCh = make(chan Response, 1)
+
+	// The tenant may take its reserved slots of the queue, or the shared ones
+	q := s.queueOf(req)
+	if !q.admission.acquire(req.tenant) {
+		metrics.Add("reservation_refusals", 1)
+		return Response{
+			statusCode: http.StatusTooManyRequests,
+			Error:      "Request limit exceeded (queue slots of the tenant are in use)",
+		}
+	}
+	req.admitted = true
+	if !s.queued.add(req) {
+		q.admission.release(req.tenant)
+		return Response{
+			statusCode: http.StatusConflict,
+			Error:      "Request conflict (request id is already queued)",
 		}
 	}
+	defer s.takeQueued(req.id)
+
+	req.queued = s.clock.Now()
+	if err := s.storeQueued(req); err != nil {
+		slog.Error("Could not store incoming request", "request", req, "error", err)
+		return Response{
+			statusCode: http.StatusServiceUnavailable,
+			Error:      "Service unavailable (request queue store failed)",
+		}
+	}
+	// The request cannot be sent anymore once the caller got its response
+	defer s.forgetQueued(req.id)
+
+	// One-time passwords count against the limits of the recipient only
+	// when queued, so that the requests refused on the way to the queue
+	// do not lock it out
+	otpHit := s.clock.Now()
+	if req.OTP {
+		if rl, ok := s.otpLimiter.allow(req.Recipient.msisdn(), otpHit); !ok {
+			return otpLimitExceeded(rl)
+		}
+	}
+
+	select {
+	case q.reqCh <- req:
+		slog.Info("Accepted incoming request", "request", req)
+		if req.Channel == channelSMS {
+			s.mirror.mirror(req)
+		}
+	default:
+		if req.OTP {
+			s.otpLimiter.forget(req.Recipient.msisdn(), otpHit)
+		}
+		slog.Warn("Dropped incoming request", "request", req)
+		s.ops.notify(opsQueueSaturated, "Queue is saturated, incoming requests are dropped")
+		return Response{
+			statusCode: http.StatusTooManyRequests,
+			Error:      "Request limit exceeded (request has been dropped)",
+		}
+	}
+
+	var res Response
+	select {
+	case res = <-req.resCh:
+		if res.Success {
+			s.deliveries.setTimeline(res.Data.ID, req.timeline(s.clock.Now()))
+		}
+	case <-ctx.Done():
+		if ctx.Err() == context.Canceled {
+			metrics.Add("requests_abandoned", 1)
+			slog.Info("The caller went away before the response", "request_id", req.id)
+		}
+		res = Response{
+			statusCode: http.StatusRequestTimeout,
+			Error:      "Request timeout (process took to long to finish)",
+		}
+	}
+	res.requestID = req.id
+
+	return res
+}
+
+// otpLimitExceeded is the response to the one-time passwords beyond the
+// limits of their recipient
+func otpLimitExceeded(rl RateLimit) Response {
+	return Response{
+		statusCode: http.StatusTooManyRequests,
+		Error:      fmt.Sprintf("Request limit exceeded (at most %d one-time passwords per %s for recipient)", rl.Count, rl.Window),
+	}
 }
 
 // Run the server
 func (s *Server) Run() {
-	s.HandleFunc("/messages", s.createMessage())
-	go s.handleRequests()
+	s.HandleFunc(http.MethodGet, "/messages", s.listMessages())
+	s.HandleFunc(http.MethodPost, "/messages", s.traced(s.createMessage()))
+	s.HandleFunc(http.MethodGet, "/messages/{id}", s.viewMessage())
+	s.HandleFunc(http.MethodDelete, "/messages/{id}", s.cancelMessage())
+	s.HandleFunc(http.MethodGet, "/messages/{id}/attempts", s.adminOnly(s.messageAttempts()))
+	s.HandleFunc(http.MethodPost, "/voice", s.traced(s.acceptMessage(channelVoice)))
+	s.HandleFunc(http.MethodPost, "/suppressions/check", s.checkSuppressions())
+	// Kannel answers the other methods in plain text itself
+	s.HandleFunc("", "/cgi-bin/sendsms", s.sendSMS())
+	s.HandleFunc(http.MethodGet, "/webhooks/dlr", s.deliveryReport())
+	s.HandleFunc(http.MethodPost, "/webhooks/alertmanager", s.alertmanagerWebhook())
+	s.HandleFunc(http.MethodGet, "/webhooks/inbound", s.inboundMessage())
+	s.HandleFunc(http.MethodPost, "/webhooks/inbound", s.inboundMessage())
+	s.HandleFunc(http.MethodGet, "/alerts/{code}/ack", s.acknowledgeAlert())
+	s.HandleFunc(http.MethodPost, "/alerts/{code}/ack", s.acknowledgeAlert())
+	s.HandleFunc(http.MethodGet, "/balance", s.adminOnly(s.viewBalance()))
+	s.Handle("", "/debug/vars", s.adminOnly(expvar.Handler().ServeHTTP))
+	s.HandleFunc(http.MethodGet, "/readyz", s.readiness())
+	s.HandleFunc(http.MethodGet, "/admin/held", s.adminOnly(s.listHeld()))
+	s.HandleFunc(http.MethodPost, "/admin/held/{id}/approve", s.adminOnly(s.reviewHeld()))
+	s.HandleFunc(http.MethodPost, "/admin/held/{id}/reject", s.adminOnly(s.reviewHeld()))
+	s.HandleFunc(http.MethodGet, "/admin/maintenance", s.adminOnly(s.viewMaintenance()))
+	s.HandleFunc(http.MethodPut, "/admin/maintenance", s.adminOnly(s.setMaintenance()))
+	s.HandleFunc(http.MethodGet, "/admin/features", s.adminOnly(s.listFeatures()))
+	s.HandleFunc(http.MethodPut, "/admin/features/{name}", s.adminOnly(s.overrideFeature()))
+	s.HandleFunc(http.MethodDelete, "/admin/features/{name}", s.adminOnly(s.resetFeature()))
+	s.HandleFunc(http.MethodGet, "/admin/oncall", s.adminOnly(s.listOnCall()))
+	s.HandleFunc(http.MethodPut, "/admin/oncall/{team}", s.adminOnly(s.setRotation()))
+	s.HandleFunc(http.MethodDelete, "/admin/oncall/{team}", s.adminOnly(s.removeRotation()))
+	s.HandleFunc(http.MethodPut, "/admin/apply", s.adminOnly(s.applyResources()))
+	s.HandleFunc(http.MethodPost, "/admin/apply", s.adminOnly(s.applyResources()))
+	s.HandleFunc(http.MethodGet, "/admin/connections", s.adminOnly(s.listConnections()))
+	s.HandleFunc(http.MethodGet, "/admin/deprecations", s.adminOnly(s.listDeprecations()))
+	s.HandleFunc(http.MethodGet, "/admin/fairness", s.adminOnly(s.viewFairness()))
+	s.HandleFunc(http.MethodGet, "/admin/backup", s.adminOnly(s.backupState()))
+	s.HandleFunc(http.MethodPut, "/admin/restore", s.adminOnly(s.restoreState()))
+	s.HandleFunc(http.MethodPost, "/admin/restore", s.adminOnly(s.restoreState()))
+	if s.mirror != nil {
+		go s.mirror.run()
+	}
+	for _, sender := range []MessageSender{s.messageClient, s.fallbackClient} {
+		if rs, ok := sender.(receiptSource); ok {
+			rs.onReceipt(func(id string, recipient PhoneNumber, status string, at time.Time) {
+				s.recordDelivery(id, recipient, status, at)
+			})
+		}
+	}
+	go s.callbacks.run()
+	for _, q := range []*classQueue{s.txQueue, s.mktQueue} {
+		if q == nil {
+			continue
+		}
+		for i := 0; i < q.workers; i++ {
+			go s.processRequests(q)
+		}
+		go s.handleRequests(q)
+	}
+	go s.recoverQueued()
+	go s.ops.run()
+	go s.tracer.run()
+	go s.watchBalance()
+	s.scheduleReports()
 }
 
-// handleRequests starts fetches requests from the buffer
+// handleRequests starts fetches requests from the buffer of the queue
 // and throttles them when accesing the external API
+// Requests closest to their delivery deadline are sent first
+func (s *Server) handleRequests(q *classQueue) {
+	ticker := s.clock.NewTicker(q.throttleRate)
+	defer ticker.Stop()
+
+	var pending requestQueue
+	var seq uint64
+
+	limit := q.buf
+	if limit < 1 {
+		limit = 1
+	}
+
+	// The share of the ticks earned towards the next dispatch,
+	// less than one per tick while warming up
+	started := s.clock.Now()
+	var credit float64
+
+	for {
+		// Only take requests out of the buffer while there is room for them
+		// and only wait for the ticker while there is something to send
+		var in chan *Request
+		if pending.Len() < limit {
+			in = q.reqCh
+		}
+
+		var tick <-chan time.Time
+		if pending.Len() > 0 {
+			tick = ticker.C()
+		}
+
+		select {
+		case req := <-in:
+			seq++
+			req.seq = seq
+			heap.Push(&pending, req)
+		case at := <-tick:
+			s.shedStale(q, &pending)
+			credit += s.warmUpShare(at.Sub(started))
+			if credit >= 1 {
+				credit--
+				s.dispatchNext(q, &pending)
+			}
+		}
+	}
+}
+
+// warmUpStart is the share of the throttling rate sent at startup
+const warmUpStart = 0.1
+
+// warmUpShare returns the share of the throttling rate sent once
+// the server has been running for that long, growing linearly
+// over the warm-up period
+func (s *Server) warmUpShare(running time.Duration) float64 {
+	if running >= s.warmUp {
+		return 1
+	}
+
+	return warmUpStart + (1-warmUpStart)*float64(running)/float64(s.warmUp)
+}
+
+// dispatchNext sends the most urgent pending request to the external API
 // It also deals with request cancellation (deadline)
-func (s *Server) handleRequests() {
-	ticker := time.Tick(s.throttleRate)
+// and expires the requests that missed their delivery deadline
+func (s *Server) dispatchNext(q *classQueue, pending *requestQueue) {
+	for pending.Len() > 0 {
+		req := s.nextRequest(q, pending)
 
-	for req := range s.reqCh {
+		if err := req.ctx.Err(); err != nil {
+			slog.Info("The API request was cancelled", "request_id", req.id, "error", err)
+			continue
+		}
+
+		// The request was cancelled while waiting in the queue
+		if _, ok := s.takeQueued(req.id); !ok {
+			continue
+		}
+
+		if req.DeliverBy != nil && s.clock.Now().After(*req.DeliverBy) {
+			metrics.Add("deadline_misses", 1)
+			slog.Warn("The API request expired before sending", "request", req)
+			res := Response{
+				statusCode: http.StatusGatewayTimeout,
+				Error:      "Delivery deadline exceeded (message expired before sending)",
+			}
+			select {
+			case req.resCh <- res:
+			case <-req.ctx.Done():
+			}
+			continue
+		}
+
+		// Wait for a worker to be free, the throttling ticks missed
+		// meanwhile being dropped
 		select {
-		case <-ticker:
-			go s.processRequest(req)
+		case q.work <- req:
 		case <-req.ctx.Done():
-			log.Println("The API request was cancelled:", req.ctx.Err())
+			slog.Info("The API request was cancelled", "request_id", req.id, "error", req.ctx.Err())
 		}
+		return
+	}
+}
+
+// processRequests is a worker of the pool of the queue, sending
+// the requests dispatched to it one at a time
+func (s *Server) processRequests(q *classQueue) {
+	for req := range q.work {
+		s.processRequest(req)
 	}
 }
 
 // processRequest makes a request to the external API
 // It also deals with request cancellation (deadline)
 func (s *Server) processRequest(req *Request) {
+	if req.Channel == channelSMS && s.shadow.sample() {
+		go s.shadowMessage(req)
+	}
+
+	// The request waited in the queue from the time it was accepted
+	if s.tracer != nil && req.trace.valid() {
+		wait := s.tracer.start(req.trace, "queue", SpanInternal)
+		wait.Start = req.queued
+		wait.set("messaging.class", s.queueOf(req).class)
+		wait.end()
+	}
+
+	req.dispatched = s.clock.Now()
+	s.dispatches.record(req)
 	done := make(chan struct{})
 	var res Response
 
@@ -222,59 +1021,249 @@ func (s *Server) processRequest(req *Request) {
 			return
 		}
 		// Make the API call
-		msgRes, statusCode, err := s.messageClient.createMessage(req)
-		if err != nil {
+		result, err := s.sendParts(req)
+		req.responded = s.clock.Now()
+		switch e := err.(type) {
+		case nil:
+		case *ProviderError:
+			metrics.Add("provider_errors_"+e.Kind.String(), 1)
+			if e.invalidRecipient() {
+				s.numbers.set(req.Recipient, numberInvalid)
+			}
+			res = Response{
+				statusCode: e.StatusCode,
+				Success:    false,
+				Error:      e.Description(),
+			}
+			return
+		case *ContractError:
+			metrics.Add("contract_violations", 1)
+			res = Response{
+				statusCode: http.StatusBadGateway,
+				Error:      "Bad gateway (provider contract violation)",
+			}
+			slog.Error("Unexpected API response", "request", req, "error", e)
+			return
+		default:
 			res = Response{
 				statusCode: http.StatusInternalServerError,
 				Error:      "Internal error (API request failed)",
 			}
-			log.Printf("Failed creating SMS message through API for request %#v; Error: %v\n", req, err)
+			slog.Error("Failed creating SMS message through API", "request", req, "error", err)
 			return
 		}
 
-		switch v := msgRes.(type) {
-		case MessageCreated:
-			res = Response{
-				statusCode: statusCode,
-				Success:    true,
-				Data: Content{
-					ID:         v.ID,
-					Originator: v.Originator,
-					Message:    v.Body,
-					Created:    v.CreatedDateTime.Format(time.RFC3339),
-					Recipient:  v.Recipients.Items[0].Recipient,
-					Status:     v.Recipients.Items[0].Status,
-				},
-			}
-		case MessageErrors:
-			res = Response{
-				statusCode: statusCode,
-				Success:    false,
-				Error:      v.Errors[0].Description,
-			}
+		s.numbers.set(req.Recipient, numberValid)
+		// Providers report the number in their own format, while the
+		// caller gets it back the way it was submitted
+		result.Recipient = req.Recipient
+		res = Response{
+			statusCode: result.StatusCode,
+			Success:    true,
+			Data:       s.content(result),
 		}
+		s.deliveries.add(res.Data, req.CallbackURL, result.Created)
+		s.audit(auditSent, res.Data)
 	}()
 
 	select {
 	case <-done:
+		if res.Success && res.Data.PartsSent > 0 {
+			s.reports.sent(res.Data.PartsSent)
+		} else if res.Success {
+			s.reports.sent(res.Data.Parts)
+		} else {
+			s.reports.failed(res.Error)
+		}
 		select {
 		case req.resCh <- res:
-			log.Println("Succesfully sent the response")
+			slog.Info("Succesfully sent the response", "request_id", req.id, "status", res.statusCode, "latency", s.since(req.queued))
 		default:
 			// In theory, this should never happen
-			log.Printf("Failed to send response %#v for request %#v\n", res, req)
+			slog.Error("Failed to send response", "request", req, "status", res.statusCode)
 		}
 	case <-req.ctx.Done():
-		log.Println("The API request was cancelled:", req.ctx.Err())
+		slog.Info("The API request was cancelled", "request_id", req.id, "error", req.ctx.Err())
+		// Keep the worker until the call returns, so that the
+		// provider is not sent more requests than there are workers
+		<-done
+	}
+}
+
+// sendMessage forwards the request to the API client
+// High priority requests are hedged when a fallback client is configured
+func (s *Server) sendMessage(req *Request) (Result, error) {
+	if req.Priority != priorityHigh || s.fallbackClient == nil {
+		return s.tryMessage(req.ctx, req, "primary", s.messageClient)
+	}
+
+	return s.hedgeMessage(req)
+}
+
+// hedgeMessage sends the request to the primary API client and, if no answer
+// arrived within the hedge delay, sends a second request to the fallback client
+// The first answer received wins and the other request is cancelled
+func (s *Server) hedgeMessage(req *Request) (Result, error) {
+	ctx, cancel := context.WithCancel(req.ctx)
+	defer cancel()
+
+	type result struct {
+		res Result
+		err error
+	}
+
+	results := make(chan result, 2)
+	attempt := func(role string, c MessageSender) {
+		res, err := s.tryMessage(ctx, req, role, c)
+		results <- result{res, err}
+	}
+
+	go attempt("primary", s.messageClient)
+	pending := 1
+
+	slow := make(chan struct{})
+	timer := s.clock.AfterFunc(s.hedgeDelay, func() { close(slow) })
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-slow:
+			slog.Info("Primary API request is slow, sending hedged request to fallback client", "request_id", req.id)
+			go attempt("fallback", s.fallbackClient)
+			pending++
+			slow = nil
+		case res := <-results:
+			pending--
+			// A failed attempt only decides the outcome if it was the last one
+			if res.err == nil || pending == 0 {
+				return res.res, res.err
+			}
+			slog.Warn("Hedged API request failed, waiting for the other one", "request_id", req.id, "error", res.err)
+		}
+	}
+}
+
+// content normalizes what the provider reported about a message
+func (s *Server) content(result Result) Content {
+	c := Content{
+		ID:         result.ID,
+		Originator: result.Originator,
+		Message:    result.Message,
+		Created:    result.Created.Format(time.RFC3339),
+		Recipient:  result.Recipient,
+		Status:     result.Status,
+		Region:     s.region,
+		Parts:      result.Parts,
+		PartsSent:  result.PartsSent,
+	}
+	if !result.Scheduled.IsZero() {
+		c.ScheduledAt = result.Scheduled.Format(time.RFC3339)
+	}
+
+	return c
+}
+
+// validRequestID reports whether the request id given by a caller
+// is short and only made of letters, digits, dashes, dots and underscores
+func validRequestID(id string) bool {
+	if len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '.', c == '_':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// callerAddr identifies the caller by its remote host
+func callerAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// fixedErrors are the errors answered to invalid requests before
+// anything is known of them, pre-marshaled so that bad traffic is
+// rejected without encoding the same body for every request
+var fixedErrors = marshalErrors(
+	"Request not allowed (invalid HTTP method)",
+	"Not found (unknown resource)",
+	"Bad request (invalid payload json structure)",
+	"Invalid parameter (channel value is not supported)",
+	"Invalid parameter (class value is not supported)",
+	"Invalid parameter (recipient value is not a phone number)",
+	"Invalid parameter (recipient value is out of bounds)",
+	"Invalid parameter (recipient was recently confirmed invalid)",
+	"Missing parameter (originator value is not present)",
+	"Invalid parameter (originator value is to long)",
+	"Missing parameter (message value is not present)",
+	"Invalid parameter (message value is to long)",
+	"Invalid parameter (message value is to long with its branding)",
+	"Invalid parameter (originator value is not allowed in the recipient country)",
+	"Service unavailable (too many concurrent requests)",
+)
+
+// Header values of the JSON responses, shared rather than allocated
+// for every response
+var (
+	jsonContentType = []string{"application/json"}
+	jsonAccept      = []string{"application/json"}
+)
+
+// marshalErrors returns the bodies of the error responses, as encoded
+// by sendJSON, by error
+func marshalErrors(errs ...string) map[string][]byte {
+	bodies := make(map[string][]byte, len(errs))
+	for _, e := range errs {
+		body, err := json.Marshal(Response{Error: e})
+		if err != nil {
+			log.Fatalf("Could not encode error %s; Error: %v", e, err)
+		}
+		bodies[e] = append(body, '\n')
 	}
+
+	return bodies
 }
 
 // sendResponse delivers the response back to the client
+// Bare fixed errors are written from their pre-marshaled bodies
 func sendResponse(w http.ResponseWriter, res Response) {
-	w.WriteHeader(res.statusCode)
+	if body, ok := fixedErrors[res.Error]; ok && !res.Success && res.requestID == "" && res.Data == (Content{}) {
+		h := w.Header()
+		h["Content-Type"] = jsonContentType
+		h["Accept"] = jsonAccept
+		w.WriteHeader(res.statusCode)
+		w.Write(body)
+		return
+	}
+
+	encodeResponse(w, res)
+}
+
+// encodeResponse encodes the response, apart from sendResponse so that
+// the fixed errors do not move their response to the heap
+func encodeResponse(w http.ResponseWriter, res Response) {
+	if res.requestID != "" {
+		w.Header().Set("X-Request-Id", res.requestID)
+	}
+	sendJSON(w, res.statusCode, &res)
+}
+
+// sendJSON writes the value as a JSON body with the given status code
+func sendJSON(w http.ResponseWriter, statusCode int, v interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Accept", "application/json")
-	if err := json.NewEncoder(w).Encode(&res); err != nil {
-		log.Fatalf("Could not encode value %#v; Error: %v", res, err)
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Fatalf("Could not encode value %#v; Error: %v", v, err)
 	}
 }
@@ -1,17 +1,23 @@
 package sms_test
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/smstest"
 )
 
 func TestServer_createMessage(t *testing.T) {
@@ -33,7 +39,7 @@ func TestServer_createMessage(t *testing.T) {
 		want          wantType
 	}{
 		"HTTP Method not allowed": {
-			httpMethod: http.MethodGet,
+			httpMethod: http.MethodPut,
 			path:       "/messages",
 			payload:    nil,
 			serverConfig: sms.Config{
@@ -245,13 +251,97 @@ func TestServer_createMessage(t *testing.T) {
 				response: sms.Response{
 					Success: true,
 					Data: sms.Content{
-						Recipient:  31612345678,
+						Recipient:  "31612345678",
 						Originator: "MessageBird",
 						Message:    "This is a test message",
 					},
 				},
 			},
 		},
+
+		"Created SMS to a number with plus sign": {
+			httpMethod: http.MethodPost,
+			path:       "/messages",
+			payload:    strings.NewReader(`{"recipient":"+31 6 1234 5678", "originator": "MessageBird", "message": "This is a test message"}`),
+			serverConfig: sms.Config{
+				Buffer:       10,
+				ReqTimeout:   5 * time.Second,
+				ThrottleRate: time.Second,
+			},
+			clientOptions: sms.Options{
+				BaseURL:   testServer.URL,
+				AccessKey: "server_key",
+				Timeout:   10 * time.Second,
+			},
+			want: wantType{
+				statusCode: http.StatusCreated,
+				response: sms.Response{
+					Success: true,
+					Data: sms.Content{
+						Recipient:  "+31612345678",
+						Originator: "MessageBird",
+						Message:    "This is a test message",
+					},
+				},
+			},
+		},
+
+		"Created SMS with compressed request": {
+			httpMethod: http.MethodPost,
+			path:       "/messages",
+			payload:    strings.NewReader(`{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`),
+			serverConfig: sms.Config{
+				Buffer:       10,
+				ReqTimeout:   5 * time.Second,
+				ThrottleRate: time.Second,
+			},
+			clientOptions: sms.Options{
+				BaseURL:   testServer.URL,
+				AccessKey: "server_key",
+				Timeout:   10 * time.Second,
+				Compress:  true,
+			},
+			want: wantType{
+				statusCode: http.StatusCreated,
+				response: sms.Response{
+					Success: true,
+					Data: sms.Content{
+						Recipient:  "31612345678",
+						Originator: "MessageBird",
+						Message:    "This is a test message",
+					},
+				},
+			},
+		},
+
+		"Created SMS tagged with region": {
+			httpMethod: http.MethodPost,
+			path:       "/messages",
+			payload:    strings.NewReader(`{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`),
+			serverConfig: sms.Config{
+				Buffer:       10,
+				ReqTimeout:   5 * time.Second,
+				ThrottleRate: time.Second,
+				Region:       "eu-west-1",
+			},
+			clientOptions: sms.Options{
+				BaseURL:   testServer.URL,
+				AccessKey: "server_key",
+				Timeout:   10 * time.Second,
+			},
+			want: wantType{
+				statusCode: http.StatusCreated,
+				response: sms.Response{
+					Success: true,
+					Data: sms.Content{
+						Recipient:  "31612345678",
+						Originator: "MessageBird",
+						Message:    "This is a test message",
+						Region:     "eu-west-1",
+					},
+				},
+			},
+		},
 	}
 
 	for name, tc := range tests {
@@ -291,7 +381,7 @@ func TestServer_createMessage(t *testing.T) {
 					t.Errorf("Success was %t; want %t", smsRes.Success, tc.want.response.Success)
 				}
 				if smsRes.Data.Recipient != tc.want.response.Data.Recipient {
-					t.Errorf("Recipient was %d; want %d", smsRes.Data.Recipient, tc.want.response.Data.Recipient)
+					t.Errorf("Recipient was %s; want %s", smsRes.Data.Recipient, tc.want.response.Data.Recipient)
 				}
 				if smsRes.Data.Originator != tc.want.response.Data.Originator {
 					t.Errorf("Originator was %s; want %s", smsRes.Data.Originator, tc.want.response.Data.Originator)
@@ -299,6 +389,9 @@ func TestServer_createMessage(t *testing.T) {
 				if smsRes.Data.Message != tc.want.response.Data.Message {
 					t.Errorf("Message was %s; want %s", smsRes.Data.Message, tc.want.response.Data.Message)
 				}
+				if smsRes.Data.Region != tc.want.response.Data.Region {
+					t.Errorf("Region was %q; want %q", smsRes.Data.Region, tc.want.response.Data.Region)
+				}
 			} else {
 				if smsRes != tc.want.response {
 					t.Errorf("HTTP json response was %#v; want %#v", smsRes, tc.want.response)
@@ -308,3 +401,1181 @@ func TestServer_createMessage(t *testing.T) {
 		})
 	}
 }
+
+func TestServer_createMessageHedging(t *testing.T) {
+
+	fallbackServer := sms.NewTestServer(t, "server_key")
+	defer fallbackServer.Close()
+
+	// The primary server never answers in time
+	slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Consume the body so that a cancelled request is noticed
+		ioutil.ReadAll(r.Body)
+		select {
+		case <-time.After(10 * time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer slowServer.Close()
+
+	tests := map[string]struct {
+		payload    string
+		wantStatus int
+	}{
+		"Normal priority waits for primary": {
+			payload:    `{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`,
+			wantStatus: http.StatusRequestTimeout,
+		},
+
+		"High priority is hedged to fallback": {
+			payload:    `{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message", "priority": "high"}`,
+			wantStatus: http.StatusCreated,
+		},
+
+		"Unsupported priority": {
+			payload:    `{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message", "priority": "urgent"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(tc.payload))
+			w := httptest.NewRecorder()
+
+			srv := sms.NewServer(sms.Config{
+				Buffer:       10,
+				ReqTimeout:   2 * time.Second,
+				ThrottleRate: 100 * time.Millisecond,
+				HedgeDelay:   200 * time.Millisecond,
+				MessageClient: sms.NewClient(sms.Options{
+					BaseURL:   slowServer.URL,
+					AccessKey: "server_key",
+					Timeout:   10 * time.Second,
+				}),
+				FallbackClient: sms.NewClient(sms.Options{
+					BaseURL:   fallbackServer.URL,
+					AccessKey: "server_key",
+					Timeout:   10 * time.Second,
+				}),
+			})
+			srv.Run()
+
+			srv.ServeHTTP(w, r)
+
+			if got := w.Result().StatusCode; got != tc.wantStatus {
+				t.Errorf("Status code was %d; want %d", got, tc.wantStatus)
+			}
+		})
+	}
+}
+
+func TestServer_createMessageDeliverBy(t *testing.T) {
+
+	testServer := sms.NewTestServer(t, "server_key")
+	defer testServer.Close()
+
+	tests := map[string]struct {
+		deliverIn  time.Duration
+		wantStatus int
+		wantMisses int64
+	}{
+		"Deadline in the past": {
+			deliverIn:  -time.Minute,
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+
+		"Deadline missed while queued": {
+			deliverIn:  500 * time.Millisecond,
+			wantStatus: http.StatusGatewayTimeout,
+			wantMisses: 1,
+		},
+
+		"Deadline met": {
+			deliverIn:  time.Minute,
+			wantStatus: http.StatusCreated,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			payload := fmt.Sprintf(`{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message", "deliver_by": %q}`, time.Now().Add(tc.deliverIn).Format(time.RFC3339Nano))
+			r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(payload))
+			w := httptest.NewRecorder()
+
+			srv := sms.NewServer(sms.Config{
+				Buffer:       10,
+				ReqTimeout:   5 * time.Second,
+				ThrottleRate: time.Second,
+				MessageClient: sms.NewClient(sms.Options{
+					BaseURL:   testServer.URL,
+					AccessKey: "server_key",
+					Timeout:   10 * time.Second,
+				}),
+			})
+			srv.Run()
+
+			before := counter("deadline_misses")
+			srv.ServeHTTP(w, r)
+
+			if got := w.Result().StatusCode; got != tc.wantStatus {
+				t.Errorf("Status code was %d; want %d", got, tc.wantStatus)
+			}
+			if got := counter("deadline_misses") - before; got != tc.wantMisses {
+				t.Errorf("Deadline misses increased by %d; want %d", got, tc.wantMisses)
+			}
+		})
+	}
+}
+
+func TestServer_metrics(t *testing.T) {
+	srv := sms.NewServer(sms.Config{
+		Buffer:        10,
+		ReqTimeout:    5 * time.Second,
+		ThrottleRate:  time.Second,
+		AdminKey:      "admin_key",
+		MessageClient: sms.NewClient(sms.Options{AccessKey: "server_key"}),
+	})
+	srv.Run()
+
+	tests := map[string]struct {
+		auth       string
+		wantStatus int
+	}{
+		"Admin": {
+			auth:       "AdminKey admin_key",
+			wantStatus: http.StatusOK,
+		},
+
+		"Wrong admin key": {
+			auth:       "AdminKey wrong_key",
+			wantStatus: http.StatusUnauthorized,
+		},
+
+		"Anonymous": {
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/debug/vars", nil)
+			if tc.auth != "" {
+				r.Header.Set("Authorization", tc.auth)
+			}
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			if got := w.Result().StatusCode; got != tc.wantStatus {
+				t.Errorf("Status code was %d; want %d", got, tc.wantStatus)
+			}
+			if leaked := strings.Contains(w.Body.String(), "memstats"); leaked != (tc.wantStatus == http.StatusOK) {
+				t.Errorf("Body showing the memory statistics was %t; want %t", leaked, !leaked)
+			}
+		})
+	}
+}
+
+func TestServer_createMessageScheduledAt(t *testing.T) {
+
+	testServer := sms.NewTestServer(t, "server_key")
+	defer testServer.Close()
+
+	messageBird := sms.NewClient(sms.Options{
+		BaseURL:   testServer.URL,
+		AccessKey: "server_key",
+		Timeout:   10 * time.Second,
+	})
+
+	tests := map[string]struct {
+		scheduleIn time.Duration
+		deliverIn  time.Duration
+		sender     sms.MessageSender
+		wantStatus int
+		wantError  string
+	}{
+		"Scheduled message": {
+			scheduleIn: time.Hour,
+			sender:     messageBird,
+			wantStatus: http.StatusCreated,
+		},
+
+		"Scheduled before the delivery deadline": {
+			scheduleIn: time.Hour,
+			deliverIn:  2 * time.Hour,
+			sender:     messageBird,
+			wantStatus: http.StatusCreated,
+		},
+
+		"Scheduled in the past": {
+			scheduleIn: -time.Minute,
+			sender:     messageBird,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantError:  "Invalid parameter (scheduled_at value is in the past)",
+		},
+
+		"Scheduled after the delivery deadline": {
+			scheduleIn: 2 * time.Hour,
+			deliverIn:  time.Hour,
+			sender:     messageBird,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantError:  "Invalid parameter (scheduled_at value is after deliver_by)",
+		},
+
+		"Provider without scheduling": {
+			scheduleIn: time.Hour,
+			sender:     fakeSender{},
+			wantStatus: http.StatusUnprocessableEntity,
+			wantError:  "Invalid parameter (scheduled_at value is not supported by the provider)",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			scheduledAt := time.Now().Add(tc.scheduleIn).UTC().Truncate(time.Second)
+			payload := fmt.Sprintf(`{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message", "scheduled_at": %q`, scheduledAt.Format(time.RFC3339))
+			if tc.deliverIn != 0 {
+				payload += fmt.Sprintf(`, "deliver_by": %q`, time.Now().Add(tc.deliverIn).Format(time.RFC3339))
+			}
+			payload += "}"
+
+			srv := sms.NewServer(sms.Config{
+				Buffer:        10,
+				ReqTimeout:    5 * time.Second,
+				ThrottleRate:  10 * time.Millisecond,
+				MessageClient: tc.sender,
+			})
+			srv.Run()
+
+			r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(payload))
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			if w.Code != tc.wantStatus {
+				t.Fatalf("Status code was %d; want %d", w.Code, tc.wantStatus)
+			}
+
+			var smsRes sms.Response
+			if err := json.NewDecoder(w.Body).Decode(&smsRes); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+			if smsRes.Error != tc.wantError {
+				t.Errorf("Error was %q; want %q", smsRes.Error, tc.wantError)
+			}
+			if tc.wantStatus != http.StatusCreated {
+				return
+			}
+
+			if want := scheduledAt.Format(time.RFC3339); smsRes.Data.ScheduledAt != want || smsRes.Data.Status != "scheduled" {
+				t.Errorf("Message was %+v; want status scheduled at %s", smsRes.Data, want)
+			}
+		})
+	}
+}
+
+// counter reads a server counter published through expvar
+func counter(name string) int64 {
+	v := expvar.Get("flysms").(*expvar.Map).Get(name)
+	if v == nil {
+		return 0
+	}
+
+	return v.(*expvar.Int).Value()
+}
+
+func TestServer_createMessageNumberCache(t *testing.T) {
+
+	testServer := sms.NewTestServer(t, "server_key")
+	defer testServer.Close()
+
+	tests := map[string]struct {
+		ttl        time.Duration
+		wantStatus []int
+	}{
+		"Cache disabled": {
+			ttl:        0,
+			wantStatus: []int{http.StatusUnprocessableEntity, http.StatusUnprocessableEntity},
+		},
+
+		"Invalid number is rejected locally": {
+			ttl:        time.Minute,
+			wantStatus: []int{http.StatusUnprocessableEntity, http.StatusUnprocessableEntity},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := sms.NewServer(sms.Config{
+				Buffer:         10,
+				ReqTimeout:     5 * time.Second,
+				ThrottleRate:   100 * time.Millisecond,
+				NumberCacheTTL: tc.ttl,
+				MessageClient: sms.NewClient(sms.Options{
+					BaseURL:   testServer.URL,
+					AccessKey: "server_key",
+					Timeout:   10 * time.Second,
+				}),
+			})
+			srv.Run()
+
+			var errs []string
+			for i, want := range tc.wantStatus {
+				r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(`{"recipient":9991234567, "originator": "MessageBird", "message": "This is a test message"}`))
+				w := httptest.NewRecorder()
+				srv.ServeHTTP(w, r)
+
+				res := w.Result()
+				if res.StatusCode != want {
+					t.Errorf("Request %d: status code was %d; want %d", i, res.StatusCode, want)
+				}
+
+				var smsRes sms.Response
+				if err := json.NewDecoder(res.Body).Decode(&smsRes); err != nil {
+					t.Fatalf("Failed to decode json response body: %v", err)
+				}
+				errs = append(errs, smsRes.Error)
+			}
+
+			// Only a cached number gets rejected without asking the API
+			cached := errs[len(errs)-1] == "Invalid parameter (recipient was recently confirmed invalid)"
+			if cached != (tc.ttl > 0) {
+				t.Errorf("Last error was %q; cache enabled %t", errs[len(errs)-1], tc.ttl > 0)
+			}
+		})
+	}
+}
+
+func TestServer_createMessageOTPLimits(t *testing.T) {
+
+	testServer := sms.NewTestServer(t, "server_key")
+	defer testServer.Close()
+
+	srv := sms.NewServer(sms.Config{
+		Buffer:       10,
+		ReqTimeout:   5 * time.Second,
+		ThrottleRate: 50 * time.Millisecond,
+		OTPLimits: []sms.RateLimit{
+			{Count: 1, Window: 300 * time.Millisecond},
+			{Count: 2, Window: time.Hour},
+		},
+		MessageClient: sms.NewClient(sms.Options{
+			BaseURL:   testServer.URL,
+			AccessKey: "server_key",
+			Timeout:   10 * time.Second,
+		}),
+	})
+	srv.Run()
+
+	steps := []struct {
+		payload    string
+		wait       time.Duration
+		wantStatus int
+		wantError  string
+	}{
+		{
+			payload:    `{"recipient":31612345678, "originator": "MessageBird", "message": "Your code is 1234", "otp": true}`,
+			wantStatus: http.StatusCreated,
+		},
+		{
+			payload:    `{"recipient":31612345678, "originator": "MessageBird", "message": "Your code is 1234", "otp": true}`,
+			wantStatus: http.StatusTooManyRequests,
+			wantError:  "Request limit exceeded (at most 1 one-time passwords per 300ms for recipient)",
+		},
+		{
+			payload:    `{"recipient":31612345678, "originator": "MessageBird", "message": "Not a one-time password"}`,
+			wantStatus: http.StatusCreated,
+		},
+		{
+			payload:    `{"recipient":31612345678, "originator": "MessageBird", "message": "Your code is 1234", "otp": true}`,
+			wait:       400 * time.Millisecond,
+			wantStatus: http.StatusCreated,
+		},
+		{
+			payload:    `{"recipient":31612345678, "originator": "MessageBird", "message": "Your code is 1234", "otp": true}`,
+			wait:       400 * time.Millisecond,
+			wantStatus: http.StatusTooManyRequests,
+			wantError:  "Request limit exceeded (at most 2 one-time passwords per 1h0m0s for recipient)",
+		},
+	}
+
+	for i, step := range steps {
+		time.Sleep(step.wait)
+
+		r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(step.payload))
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+
+		res := w.Result()
+		if res.StatusCode != step.wantStatus {
+			t.Errorf("Step %d: status code was %d; want %d", i, res.StatusCode, step.wantStatus)
+		}
+
+		var smsRes sms.Response
+		if err := json.NewDecoder(res.Body).Decode(&smsRes); err != nil {
+			t.Fatalf("Failed to decode json response body: %v", err)
+		}
+		if smsRes.Error != step.wantError {
+			t.Errorf("Step %d: error was %q; want %q", i, smsRes.Error, step.wantError)
+		}
+	}
+}
+
+func TestServer_createMessageOTPLimitsRefused(t *testing.T) {
+	srv := smstest.NewServer(t, sms.Config{
+		OTPLimits:       []sms.RateLimit{{Count: 2, Window: time.Hour}},
+		RecipientLimits: []sms.RateLimit{{Count: 1, Window: time.Minute}},
+	})
+
+	// The one-time password refused by the recipient limits is not
+	// counted against the one-time password limits
+	steps := []struct {
+		advance    time.Duration
+		wantStatus int
+		wantError  string
+	}{
+		{wantStatus: http.StatusCreated},
+		{
+			wantStatus: http.StatusTooManyRequests,
+			wantError:  "Request limit exceeded (at most 1 messages per 1m0s for recipient)",
+		},
+		{advance: time.Minute, wantStatus: http.StatusCreated},
+		{
+			advance:    time.Minute,
+			wantStatus: http.StatusTooManyRequests,
+			wantError:  "Request limit exceeded (at most 2 one-time passwords per 1h0m0s for recipient)",
+		},
+	}
+
+	for i, step := range steps {
+		srv.Clock.Advance(step.advance)
+
+		w := srv.Send(t, `{"recipient":31612345678, "originator": "MessageBird", "message": "Your code is 1234", "otp": true}`)
+		if w.Code != step.wantStatus {
+			t.Errorf("Step %d: status code was %d; want %d", i, w.Code, step.wantStatus)
+		}
+
+		var smsRes sms.Response
+		if err := json.NewDecoder(w.Body).Decode(&smsRes); err != nil {
+			t.Fatalf("Failed to decode json response body: %v", err)
+		}
+		if smsRes.Error != step.wantError {
+			t.Errorf("Step %d: error was %q; want %q", i, smsRes.Error, step.wantError)
+		}
+	}
+}
+
+func TestServer_createMessageRecipientLimits(t *testing.T) {
+	srv := smstest.NewServer(t, sms.Config{
+		RecipientLimits: []sms.RateLimit{{Count: 2, Window: time.Minute}},
+	})
+
+	steps := []struct {
+		recipient  string
+		advance    time.Duration
+		wantStatus int
+		wantError  string
+	}{
+		{recipient: "31612345678", wantStatus: http.StatusCreated},
+		{recipient: "31612345678", wantStatus: http.StatusCreated},
+		{
+			recipient:  "31612345678",
+			wantStatus: http.StatusTooManyRequests,
+			wantError:  "Request limit exceeded (at most 2 messages per 1m0s for recipient)",
+		},
+		{recipient: "31687654321", wantStatus: http.StatusCreated},
+		{recipient: "31612345678", advance: time.Minute, wantStatus: http.StatusCreated},
+	}
+
+	for i, step := range steps {
+		srv.Clock.Advance(step.advance)
+
+		w := srv.Send(t, `{"recipient":`+step.recipient+`, "originator": "MessageBird", "message": "This is a test message"}`)
+		if w.Code != step.wantStatus {
+			t.Errorf("Step %d: status code was %d; want %d", i, w.Code, step.wantStatus)
+		}
+
+		var smsRes sms.Response
+		if err := json.NewDecoder(w.Body).Decode(&smsRes); err != nil {
+			t.Fatalf("Failed to decode json response body: %v", err)
+		}
+		if smsRes.Error != step.wantError {
+			t.Errorf("Step %d: error was %q; want %q", i, smsRes.Error, step.wantError)
+		}
+	}
+}
+
+// fakeSender is a provider answering from memory
+type fakeSender struct {
+	err error
+}
+
+func (f fakeSender) CreateMessage(ctx context.Context, r *sms.Request) (sms.Result, error) {
+	if f.err != nil {
+		return sms.Result{}, f.err
+	}
+
+	return sms.Result{
+		StatusCode: http.StatusCreated,
+		ID:         "fake",
+		Recipient:  r.Recipient,
+		Originator: r.Originator,
+		Message:    r.Message,
+		Status:     "sent",
+		Created:    time.Now(),
+	}, nil
+}
+
+func TestServer_createMessageSender(t *testing.T) {
+	tests := map[string]struct {
+		sender     sms.MessageSender
+		wantStatus int
+		wantError  string
+	}{
+		"Message created": {
+			sender:     fakeSender{},
+			wantStatus: http.StatusCreated,
+		},
+
+		"Message refused": {
+			sender: fakeSender{err: &sms.ProviderError{
+				Kind:       sms.KindValidation,
+				StatusCode: http.StatusUnprocessableEntity,
+				Errors:     []sms.MessageError{{Code: 10, Description: "originator is invalid", Parameter: "originator"}},
+			}},
+			wantStatus: http.StatusUnprocessableEntity,
+			wantError:  "originator is invalid",
+		},
+
+		"Provider unreachable": {
+			sender:     fakeSender{err: errors.New("connection refused")},
+			wantStatus: http.StatusInternalServerError,
+			wantError:  "Internal error (API request failed)",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := sms.NewServer(sms.Config{
+				Buffer:        10,
+				ReqTimeout:    5 * time.Second,
+				ThrottleRate:  10 * time.Millisecond,
+				MessageClient: tc.sender,
+			})
+			srv.Run()
+
+			r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(`{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`))
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			if w.Code != tc.wantStatus {
+				t.Errorf("Status code was %d; want %d", w.Code, tc.wantStatus)
+			}
+
+			var smsRes sms.Response
+			if err := json.NewDecoder(w.Body).Decode(&smsRes); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+			if smsRes.Error != tc.wantError {
+				t.Errorf("Error was %q; want %q", smsRes.Error, tc.wantError)
+			}
+		})
+	}
+}
+
+func TestServer_messageAttempts(t *testing.T) {
+	tests := map[string]struct {
+		sender      sms.MessageSender
+		byID        string
+		wantStatus  int
+		wantOutcome string
+	}{
+		"Created by request id": {
+			sender:      fakeSender{},
+			wantStatus:  http.StatusOK,
+			wantOutcome: "created",
+		},
+
+		"Created by provider id": {
+			sender:      fakeSender{},
+			byID:        "fake",
+			wantStatus:  http.StatusOK,
+			wantOutcome: "created",
+		},
+
+		"Provider unreachable": {
+			sender:      fakeSender{err: errors.New("connection refused")},
+			wantStatus:  http.StatusOK,
+			wantOutcome: "failed",
+		},
+
+		"Unknown message": {
+			sender:     fakeSender{},
+			byID:       "unknown",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := sms.NewServer(sms.Config{
+				Buffer:        10,
+				ReqTimeout:    5 * time.Second,
+				ThrottleRate:  10 * time.Millisecond,
+				AdminKey:      "admin_key",
+				MessageClient: tc.sender,
+			})
+			srv.Run()
+
+			r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(`{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`))
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			id := w.Header().Get("X-Request-Id")
+			if id == "" {
+				t.Fatal("Response has no request id")
+			}
+			if tc.byID != "" {
+				id = tc.byID
+			}
+
+			r = httptest.NewRequest(http.MethodGet, "/messages/"+id+"/attempts", nil)
+			r.Header.Set("Authorization", "AdminKey admin_key")
+			w = httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			if w.Code != tc.wantStatus {
+				t.Fatalf("Status code was %d; want %d", w.Code, tc.wantStatus)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			var list sms.AttemptList
+			if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+			if len(list.Data) != 1 {
+				t.Fatalf("Got %d attempts; want 1", len(list.Data))
+			}
+			if a := list.Data[0]; a.Outcome != tc.wantOutcome || a.Role != "primary" {
+				t.Errorf("Attempt was %+v; want primary attempt with outcome %q", a, tc.wantOutcome)
+			}
+		})
+	}
+}
+
+func TestServer_messageAttemptsRedaction(t *testing.T) {
+	// Nothing listens on the address once the listener is closed
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	ln.Close()
+
+	srv := sms.NewServer(sms.Config{
+		Buffer:       10,
+		ReqTimeout:   5 * time.Second,
+		ThrottleRate: 10 * time.Millisecond,
+		AdminKey:     "admin_key",
+		MessageClient: sms.NewClient(sms.Options{
+			AccessKey: "live_SECRETKEY",
+			BaseURL:   "http://" + ln.Addr().String(),
+			Timeout:   time.Second,
+		}),
+	})
+	srv.Run()
+
+	r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(`{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`))
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	id := w.Header().Get("X-Request-Id")
+
+	r = httptest.NewRequest(http.MethodGet, "/messages/"+id+"/attempts", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Attempts without admin key: status code was %d; want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/messages/"+id+"/attempts", nil)
+	r.Header.Set("Authorization", "AdminKey admin_key")
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if strings.Contains(w.Body.String(), "SECRETKEY") {
+		t.Fatalf("Attempts %s show the access key", w.Body.String())
+	}
+
+	var list sms.AttemptList
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode json response body: %v", err)
+	}
+	if len(list.Data) != 1 || list.Data[0].Error != "could not connect to the provider" {
+		t.Errorf("Attempts were %+v; want one that could not connect", list.Data)
+	}
+}
+
+func TestServer_messageAttemptsCapture(t *testing.T) {
+	testServer := sms.NewTestServer(t, "test_key")
+	defer testServer.Close()
+
+	tests := map[string]struct {
+		captureTTL   time.Duration
+		accessKey    string
+		wantExchange bool
+	}{
+		"Failed attempt in debug mode": {
+			captureTTL:   time.Minute,
+			accessKey:    "wrong_key",
+			wantExchange: true,
+		},
+
+		"Created attempt in debug mode": {
+			captureTTL: time.Minute,
+			accessKey:  "test_key",
+		},
+
+		"Failed attempt without debug mode": {
+			accessKey: "wrong_key",
+		},
+
+		"Failed attempt with expired capture": {
+			captureTTL: time.Nanosecond,
+			accessKey:  "wrong_key",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := sms.NewServer(sms.Config{
+				Buffer:          10,
+				ReqTimeout:      5 * time.Second,
+				ThrottleRate:    10 * time.Millisecond,
+				DebugCaptureTTL: tc.captureTTL,
+				AdminKey:        "admin_key",
+				MessageClient: sms.NewClient(sms.Options{
+					AccessKey: tc.accessKey,
+					BaseURL:   testServer.URL,
+					Timeout:   10 * time.Second,
+				}),
+			})
+			srv.Run()
+
+			r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(`{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`))
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			r = httptest.NewRequest(http.MethodGet, "/messages/"+w.Header().Get("X-Request-Id")+"/attempts", nil)
+			r.Header.Set("Authorization", "AdminKey admin_key")
+			w = httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			var list sms.AttemptList
+			if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+			if len(list.Data) != 1 {
+				t.Fatalf("Got %d attempts; want 1", len(list.Data))
+			}
+
+			ex := list.Data[0].Exchange
+			if (ex != nil) != tc.wantExchange {
+				t.Fatalf("Exchange was %+v; want exchange %t", ex, tc.wantExchange)
+			}
+			if ex == nil {
+				return
+			}
+			if !strings.Contains(ex.Request, "Authorization: [redacted]") || strings.Contains(ex.Request, tc.accessKey) {
+				t.Errorf("Captured request %q does not redact the access key", ex.Request)
+			}
+			if !strings.Contains(ex.Request, "originator=MessageBird") {
+				t.Errorf("Captured request %q has no body", ex.Request)
+			}
+			if !strings.Contains(ex.Response, "incorrect access_key") {
+				t.Errorf("Captured response %q has no body", ex.Response)
+			}
+		})
+	}
+}
+
+func TestServer_viewMessage(t *testing.T) {
+	testServer := sms.NewTestServer(t, "test_key")
+	defer testServer.Close()
+
+	tests := map[string]struct {
+		sender     sms.MessageSender
+		id         string
+		method     string
+		wantStatus int
+		wantError  string
+	}{
+		"Delivered message": {
+			sender:     sms.NewClient(sms.Options{AccessKey: "test_key", BaseURL: testServer.URL, Timeout: 10 * time.Second}),
+			method:     http.MethodGet,
+			wantStatus: http.StatusOK,
+		},
+
+		"Unknown message": {
+			sender:     sms.NewClient(sms.Options{AccessKey: "test_key", BaseURL: testServer.URL, Timeout: 10 * time.Second}),
+			id:         "unknown",
+			method:     http.MethodGet,
+			wantStatus: http.StatusNotFound,
+			wantError:  "message not found",
+		},
+
+		"Invalid method": {
+			sender:     sms.NewClient(sms.Options{AccessKey: "test_key", BaseURL: testServer.URL, Timeout: 10 * time.Second}),
+			method:     http.MethodPost,
+			wantStatus: http.StatusMethodNotAllowed,
+			wantError:  "Request not allowed (invalid HTTP method)",
+		},
+
+		"Provider without lookups": {
+			sender:     fakeSender{},
+			id:         "unknown",
+			method:     http.MethodGet,
+			wantStatus: http.StatusNotImplemented,
+			wantError:  "Not implemented (provider does not support status lookups)",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := sms.NewServer(sms.Config{
+				Buffer:        10,
+				ReqTimeout:    5 * time.Second,
+				ThrottleRate:  10 * time.Millisecond,
+				MessageClient: tc.sender,
+			})
+			srv.Run()
+
+			r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(`{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`))
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			var created sms.Response
+			if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+
+			id := created.Data.ID
+			if tc.id != "" {
+				id = tc.id
+			}
+
+			r = httptest.NewRequest(tc.method, "/messages/"+id, nil)
+			w = httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			if w.Code != tc.wantStatus {
+				t.Fatalf("Status code was %d; want %d", w.Code, tc.wantStatus)
+			}
+
+			var smsRes sms.Response
+			if err := json.NewDecoder(w.Body).Decode(&smsRes); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+			if smsRes.Error != tc.wantError {
+				t.Errorf("Error was %q; want %q", smsRes.Error, tc.wantError)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			// The message comes with the timeline of its sending
+			if smsRes.Data.Timeline == nil {
+				t.Error("Message had no timeline")
+			}
+			smsRes.Data.Timeline = nil
+
+			want := created.Data
+			want.Status = "delivered"
+			if smsRes.Data != want {
+				t.Errorf("Message was %+v; want %+v", smsRes.Data, want)
+			}
+		})
+	}
+}
+
+func TestServer_cancelMessage(t *testing.T) {
+	testServer := sms.NewTestServer(t, "test_key")
+	defer testServer.Close()
+
+	tests := map[string]struct {
+		sender     sms.MessageSender
+		id         string
+		wantStatus int
+		wantError  string
+	}{
+		"Scheduled message": {
+			sender:     sms.NewClient(sms.Options{AccessKey: "test_key", BaseURL: testServer.URL, Timeout: 10 * time.Second}),
+			wantStatus: http.StatusOK,
+		},
+
+		"Unknown message": {
+			sender:     sms.NewClient(sms.Options{AccessKey: "test_key", BaseURL: testServer.URL, Timeout: 10 * time.Second}),
+			id:         "unknown",
+			wantStatus: http.StatusNotFound,
+			wantError:  "message not found",
+		},
+
+		"Provider without cancelling": {
+			sender:     fakeSender{},
+			id:         "unknown",
+			wantStatus: http.StatusNotFound,
+			wantError:  "Not found (message is not queued)",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := sms.NewServer(sms.Config{
+				Buffer:        10,
+				ReqTimeout:    5 * time.Second,
+				ThrottleRate:  10 * time.Millisecond,
+				MessageClient: tc.sender,
+			})
+			srv.Run()
+
+			id := tc.id
+			if id == "" {
+				scheduledAt := time.Now().Add(time.Hour).Format(time.RFC3339)
+				r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(`{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message", "scheduled_at": "`+scheduledAt+`"}`))
+				w := httptest.NewRecorder()
+				srv.ServeHTTP(w, r)
+
+				var created sms.Response
+				if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+					t.Fatalf("Failed to decode json response body: %v", err)
+				}
+				id = created.Data.ID
+			}
+
+			r := httptest.NewRequest(http.MethodDelete, "/messages/"+id, nil)
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			if w.Code != tc.wantStatus {
+				t.Fatalf("Status code was %d; want %d", w.Code, tc.wantStatus)
+			}
+
+			var smsRes sms.Response
+			if err := json.NewDecoder(w.Body).Decode(&smsRes); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+			if smsRes.Error != tc.wantError {
+				t.Errorf("Error was %q; want %q", smsRes.Error, tc.wantError)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			if smsRes.Data.ID != id || smsRes.Data.Status != "cancelled" {
+				t.Errorf("Message was %+v; want %s cancelled", smsRes.Data, id)
+			}
+
+			// The provider no longer knows the message
+			r = httptest.NewRequest(http.MethodGet, "/messages/"+id, nil)
+			w = httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+			if w.Code != http.StatusNotFound {
+				t.Errorf("Status code after cancelling was %d; want %d", w.Code, http.StatusNotFound)
+			}
+		})
+	}
+
+	t.Run("Queued message", func(t *testing.T) {
+		clock := smstest.NewClock(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+		provider := smstest.NewProvider(clock)
+		srv := sms.NewServer(sms.Config{
+			Buffer:        10,
+			ReqTimeout:    time.Minute,
+			ThrottleRate:  time.Second,
+			MessageClient: provider,
+			Clock:         clock,
+		})
+		srv.Run()
+
+		done := make(chan *httptest.ResponseRecorder, 1)
+		go func() {
+			r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(`{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`))
+			r.Header.Set("X-Request-Id", "queued-1")
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+			done <- w
+		}()
+
+		// The request is waiting for the clock to tick, which it never does
+		var w *httptest.ResponseRecorder
+		for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+			r := httptest.NewRequest(http.MethodDelete, "/messages/queued-1", nil)
+			w = httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+			if w.Code != http.StatusNotFound || time.Now().After(deadline) {
+				break
+			}
+		}
+		if w.Code != http.StatusOK {
+			t.Fatalf("Status code was %d; want %d", w.Code, http.StatusOK)
+		}
+
+		created := <-done
+		if created.Code != http.StatusConflict {
+			t.Errorf("Status code of the cancelled request was %d; want %d", created.Code, http.StatusConflict)
+		}
+		if got := created.Header().Get("X-Request-Id"); got != "queued-1" {
+			t.Errorf("Request id was %q; want %q", got, "queued-1")
+		}
+
+		clock.Advance(time.Second)
+		if events := provider.Events(); len(events) != 0 {
+			t.Errorf("Provider got %d messages; want none", len(events))
+		}
+	})
+}
+
+func TestServer_listMessages(t *testing.T) {
+	testServer := sms.NewTestServer(t, "test_key")
+	defer testServer.Close()
+
+	srv := sms.NewServer(sms.Config{
+		Buffer:        10,
+		ReqTimeout:    5 * time.Second,
+		ThrottleRate:  10 * time.Millisecond,
+		MessageClient: sms.NewClient(sms.Options{AccessKey: "test_key", BaseURL: testServer.URL, Timeout: 10 * time.Second}),
+	})
+	srv.Run()
+
+	var ids []string
+	for _, message := range []string{"First", "Second", "Third"} {
+		r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(`{"recipient":31612345678, "originator": "MessageBird", "message": "`+message+`"}`))
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+
+		var created sms.Response
+		if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+			t.Fatalf("Failed to decode json response body: %v", err)
+		}
+		ids = append(ids, created.Data.ID)
+	}
+
+	tests := map[string]struct {
+		query      string
+		wantStatus int
+		wantIDs    []string
+		wantError  string
+	}{
+		"Default page": {
+			wantStatus: http.StatusOK,
+			wantIDs:    []string{ids[2], ids[1], ids[0]},
+		},
+
+		"Limited page": {
+			query:      "?limit=2",
+			wantStatus: http.StatusOK,
+			wantIDs:    []string{ids[2], ids[1]},
+		},
+
+		"Page with offset": {
+			query:      "?limit=2&offset=2",
+			wantStatus: http.StatusOK,
+			wantIDs:    []string{ids[0]},
+		},
+
+		"Offset past the end": {
+			query:      "?offset=10",
+			wantStatus: http.StatusOK,
+			wantIDs:    []string{},
+		},
+
+		"Invalid limit": {
+			query:      "?limit=0",
+			wantStatus: http.StatusBadRequest,
+			wantError:  "Bad request (limit must be between 1 and 500)",
+		},
+
+		"Invalid offset": {
+			query:      "?offset=-1",
+			wantStatus: http.StatusBadRequest,
+			wantError:  "Bad request (offset must not be negative)",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/messages"+tc.query, nil)
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			if w.Code != tc.wantStatus {
+				t.Fatalf("Status code was %d; want %d", w.Code, tc.wantStatus)
+			}
+
+			if tc.wantStatus != http.StatusOK {
+				var smsRes sms.Response
+				if err := json.NewDecoder(w.Body).Decode(&smsRes); err != nil {
+					t.Fatalf("Failed to decode json response body: %v", err)
+				}
+				if smsRes.Error != tc.wantError {
+					t.Errorf("Error was %q; want %q", smsRes.Error, tc.wantError)
+				}
+				return
+			}
+
+			var list sms.MessageList
+			if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+			if list.TotalCount != 3 {
+				t.Errorf("Total count was %d; want 3", list.TotalCount)
+			}
+			got := []string{}
+			for _, c := range list.Data {
+				got = append(got, c.ID)
+			}
+			if !reflect.DeepEqual(got, tc.wantIDs) {
+				t.Errorf("Listed ids were %v; want %v", got, tc.wantIDs)
+			}
+		})
+	}
+}
+
+func TestServer_createMessageFixedErrors(t *testing.T) {
+	srv := smstest.NewServer(t, sms.Config{})
+
+	tests := map[string]struct {
+		method  string
+		payload string
+		want    sms.Response
+	}{
+		"Invalid method": {
+			method: http.MethodPut,
+			want:   sms.Response{Error: "Request not allowed (invalid HTTP method)"},
+		},
+		"Invalid json": {
+			method:  http.MethodPost,
+			payload: `{"recipient":`,
+			want:    sms.Response{Error: "Bad request (invalid payload json structure)"},
+		},
+		"Missing originator": {
+			method:  http.MethodPost,
+			payload: `{"recipient":31612345678, "message": "This is a test message"}`,
+			want:    sms.Response{Error: "Missing parameter (originator value is not present)"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := srv.Do(tc.method, "/messages", tc.payload)
+
+			// The pre-marshaled bodies are those sendJSON would encode
+			var want strings.Builder
+			json.NewEncoder(&want).Encode(tc.want)
+			if got := w.Body.String(); got != want.String() {
+				t.Errorf("Body was %q; want %q", got, want.String())
+			}
+			if got := w.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type was %q; want application/json", got)
+			}
+		})
+	}
+}
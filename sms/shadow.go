@@ -0,0 +1,87 @@
+package sms
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+// shadowTraffic sends a sample of the traffic through a candidate provider
+// Its answers are only recorded, they never reach the callers
+type shadowTraffic struct {
+	client     MessageSender
+	percent    int
+	recipients *numberPool
+}
+
+// newShadowTraffic creates the shadow traffic of the candidate provider,
+// which is disabled unless test numbers are given, so that the real
+// recipients never get the copies
+func newShadowTraffic(client MessageSender, percent int, recipients []PhoneNumber) *shadowTraffic {
+	if client == nil || percent <= 0 {
+		return nil
+	}
+	if len(recipients) == 0 {
+		slog.Warn("Shadow traffic disabled", "error", "no test recipients configured")
+		return nil
+	}
+
+	return &shadowTraffic{
+		client:     client,
+		percent:    clampPercent(percent),
+		recipients: &numberPool{numbers: recipients},
+	}
+}
+
+// sample reports whether a message should be shadowed
+func (sh *shadowTraffic) sample() bool {
+	return sh != nil && rand.Intn(100) < sh.percent
+}
+
+// shadowMessage sends a copy of the request through the candidate provider
+// The attempt is recorded under the message with the shadow role
+// Scheduled and flash messages are not shadowed to candidates
+// unable to deliver them
+func (s *Server) shadowMessage(req *Request) {
+	if _, ok := s.shadow.client.(messageScheduler); req.ScheduledAt != nil && !ok {
+		return
+	}
+	if checkType(req, s.shadow.client) != "" || req.split {
+		return
+	}
+
+	ctx, cancel := s.withTimeout(context.Background(), s.reqTimeout)
+	defer cancel()
+
+	shadow := &Request{
+		ctx:         ctx,
+		id:          req.id,
+		Recipient:   s.shadow.recipients.pick(),
+		Originator:  req.Originator,
+		Message:     req.Message,
+		Priority:    req.Priority,
+		OTP:         req.OTP,
+		DeliverBy:   req.DeliverBy,
+		ScheduledAt: req.ScheduledAt,
+		Channel:     req.Channel,
+		Type:        req.Type,
+		UDH:         req.UDH,
+	}
+
+	start := s.clock.Now()
+	_, err := s.tryMessage(ctx, shadow, "shadow", s.shadow.client)
+	metrics.Add("shadow_latency_ms", int64(s.since(start)/time.Millisecond))
+
+	switch err.(type) {
+	case nil:
+		metrics.Add("shadow_accepted", 1)
+	case *ProviderError:
+		metrics.Add("shadow_refused", 1)
+	default:
+		metrics.Add("shadow_failed", 1)
+		slog.Warn("Shadow API request failed", "request", req, "error", err)
+		s.ops.notify(opsCanaryFailed, fmt.Sprintf("Canary provider %s failed: %s", providerName(s.shadow.client), attemptError(err)))
+	}
+}
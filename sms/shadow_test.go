@@ -0,0 +1,132 @@
+package sms_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+)
+
+// recordingSender passes the recipients it is asked to send to on a channel
+type recordingSender struct {
+	fakeSender
+	recipients chan sms.PhoneNumber
+}
+
+func (s recordingSender) CreateMessage(ctx context.Context, r *sms.Request) (sms.Result, error) {
+	s.recipients <- r.Recipient
+	return s.fakeSender.CreateMessage(ctx, r)
+}
+
+func TestServer_shadowTraffic(t *testing.T) {
+	tests := map[string]struct {
+		percent       int
+		recipients    []sms.PhoneNumber
+		err           error
+		wantShadow    bool
+		wantRecipient sms.PhoneNumber
+		wantOutcome   string
+	}{
+		"Shadowed to test number": {
+			percent:       100,
+			recipients:    []sms.PhoneNumber{"3197010000000"},
+			wantShadow:    true,
+			wantRecipient: "3197010000000",
+			wantOutcome:   "created",
+		},
+
+		"Refused by candidate": {
+			percent:       100,
+			recipients:    []sms.PhoneNumber{"3197010000000"},
+			err:           &sms.ProviderError{Kind: sms.KindValidation, StatusCode: http.StatusBadRequest},
+			wantShadow:    true,
+			wantRecipient: "3197010000000",
+			wantOutcome:   "refused",
+		},
+
+		"Not shadowed without test numbers": {
+			percent: 100,
+		},
+
+		"Not shadowed": {
+			percent: 0,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			candidate := recordingSender{fakeSender: fakeSender{err: tc.err}, recipients: make(chan sms.PhoneNumber, 1)}
+			srv := sms.NewServer(sms.Config{
+				Buffer:           10,
+				ReqTimeout:       5 * time.Second,
+				ThrottleRate:     10 * time.Millisecond,
+				AdminKey:         "admin_key",
+				MessageClient:    fakeSender{},
+				ShadowClient:     candidate,
+				ShadowPercent:    tc.percent,
+				ShadowRecipients: tc.recipients,
+			})
+			srv.Run()
+
+			r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(`{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`))
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			if w.Code != http.StatusCreated {
+				t.Fatalf("Status code was %d; want %d", w.Code, http.StatusCreated)
+			}
+			id := w.Header().Get("X-Request-Id")
+
+			select {
+			case recipient := <-candidate.recipients:
+				if !tc.wantShadow {
+					t.Fatalf("Message was shadowed to %s; want no shadow message", recipient)
+				}
+				if recipient != tc.wantRecipient {
+					t.Errorf("Shadow recipient was %s; want %s", recipient, tc.wantRecipient)
+				}
+			case <-time.After(100 * time.Millisecond):
+				if tc.wantShadow {
+					t.Fatal("Message was not shadowed")
+				}
+				return
+			}
+
+			// The shadow attempt is recorded once the candidate answered
+			deadline := time.Now().Add(time.Second)
+			for {
+				r = httptest.NewRequest(http.MethodGet, "/messages/"+id+"/attempts", nil)
+				r.Header.Set("Authorization", "AdminKey admin_key")
+				w = httptest.NewRecorder()
+				srv.ServeHTTP(w, r)
+
+				var list sms.AttemptList
+				if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+					t.Fatalf("Failed to decode json response body: %v", err)
+				}
+
+				var shadow *sms.Attempt
+				for i, a := range list.Data {
+					if a.Role == "shadow" {
+						shadow = &list.Data[i]
+					}
+				}
+				if shadow != nil {
+					if shadow.Outcome != tc.wantOutcome {
+						t.Errorf("Shadow outcome was %q; want %q", shadow.Outcome, tc.wantOutcome)
+					}
+					return
+				}
+				if time.Now().After(deadline) {
+					t.Fatal("Shadow attempt was not recorded")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
@@ -0,0 +1,727 @@
+package sms
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+)
+
+// SMPP 3.4 command ids
+const (
+	smppGenericNack         = 0x80000000
+	smppBindTransceiver     = 0x00000009
+	smppBindTransceiverResp = 0x80000009
+	smppSubmitSM            = 0x00000004
+	smppSubmitSMResp        = 0x80000004
+	smppDeliverSM           = 0x00000005
+	smppDeliverSMResp       = 0x80000005
+	smppUnbind              = 0x00000006
+	smppUnbindResp          = 0x80000006
+	smppEnquireLink         = 0x00000015
+	smppEnquireLinkResp     = 0x80000015
+)
+
+// SMPP 3.4 command statuses used to classify the errors
+const (
+	smppStatusOK            = 0x00
+	smppStatusInvMsgLen     = 0x01
+	smppStatusInvCmdID      = 0x03
+	smppStatusInvBindStatus = 0x04
+	smppStatusInvSrcAdr     = 0x0A
+	smppStatusInvDstAdr     = 0x0B
+	smppStatusBindFail      = 0x0D
+	smppStatusInvPaswd      = 0x0E
+	smppStatusInvSysID      = 0x0F
+	smppStatusThrottled     = 0x58
+)
+
+// SMPP 3.4 message parameters
+const (
+	smppInterfaceVersion = 0x34
+	smppTONInternational = 0x01
+	smppTONAlphanumeric  = 0x05
+	smppNPIISDN          = 0x01
+	smppESMReceipt       = 0x04
+	smppESMUDHI          = 0x40
+	smppCodingDefault    = 0x00
+	smppCodingBinary     = 0x04
+	smppCodingUCS2       = 0x08
+	smppCodingFlash      = 0x10
+	smppReceiptFinal     = 0x01
+	smppTagReceiptID     = 0x001E
+	smppHeaderLength     = 16
+	smppMaxPDULength     = 64 * 1024
+)
+
+const (
+	defaultSMPPTimeout      = 10 * time.Second
+	defaultSMPPEnquireEvery = 30 * time.Second
+)
+
+// smppReceiptStatuses maps the final states of the SMPP delivery receipts
+// to the message statuses reported by messagebird
+var smppReceiptStatuses = map[string]string{
+	"ENROUTE": "buffered",
+	"ACCEPTD": "sent",
+	"DELIVRD": "delivered",
+	"EXPIRED": "expired",
+	"DELETED": "delivery_failed",
+	"UNDELIV": "delivery_failed",
+	"REJECTD": "delivery_failed",
+}
+
+// errSMPPClosed is returned for the commands in flight when the bind is lost
+var errSMPPClosed = errors.New("SMPP bind closed")
+
+// receiptSource is implemented by the senders receiving the delivery
+// reports of their messages themselves, instead of through /webhooks/dlr
+type receiptSource interface {
+	onReceipt(func(id string, recipient PhoneNumber, status string, at time.Time))
+}
+
+// smppPDU is a SMPP protocol data unit, with its header fields and raw body
+type smppPDU struct {
+	command uint32
+	status  uint32
+	seq     uint32
+	body    []byte
+}
+
+// readSMPPPDU reads the next PDU sent over the connection
+func readSMPPPDU(r io.Reader) (smppPDU, error) {
+	var header [smppHeaderLength]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return smppPDU{}, err
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length < smppHeaderLength || length > smppMaxPDULength {
+		return smppPDU{}, fmt.Errorf("invalid SMPP command length %d", length)
+	}
+
+	pdu := smppPDU{
+		command: binary.BigEndian.Uint32(header[4:8]),
+		status:  binary.BigEndian.Uint32(header[8:12]),
+		seq:     binary.BigEndian.Uint32(header[12:16]),
+		body:    make([]byte, length-smppHeaderLength),
+	}
+	if _, err := io.ReadFull(r, pdu.body); err != nil {
+		return smppPDU{}, err
+	}
+
+	return pdu, nil
+}
+
+// encode returns the PDU as sent over the wire
+func (p smppPDU) encode() []byte {
+	b := make([]byte, smppHeaderLength, smppHeaderLength+len(p.body))
+	binary.BigEndian.PutUint32(b[0:4], uint32(smppHeaderLength+len(p.body)))
+	binary.BigEndian.PutUint32(b[4:8], p.command)
+	binary.BigEndian.PutUint32(b[8:12], p.status)
+	binary.BigEndian.PutUint32(b[12:16], p.seq)
+	return append(b, p.body...)
+}
+
+// smppWriter builds the body of a PDU
+type smppWriter struct {
+	bytes.Buffer
+}
+
+func (w *smppWriter) cstring(s string) {
+	w.WriteString(s)
+	w.WriteByte(0)
+}
+
+// smppReader parses the body of a PDU
+// The first error is kept and makes the following reads return zero values
+type smppReader struct {
+	b   []byte
+	err error
+}
+
+func (r *smppReader) byte() byte {
+	if r.err != nil || len(r.b) == 0 {
+		r.fail()
+		return 0
+	}
+	c := r.b[0]
+	r.b = r.b[1:]
+	return c
+}
+
+func (r *smppReader) cstring() string {
+	if r.err != nil {
+		return ""
+	}
+	i := bytes.IndexByte(r.b, 0)
+	if i < 0 {
+		r.fail()
+		return ""
+	}
+	s := string(r.b[:i])
+	r.b = r.b[i+1:]
+	return s
+}
+
+func (r *smppReader) bytes(n int) []byte {
+	if r.err != nil || len(r.b) < n {
+		r.fail()
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *smppReader) fail() {
+	if r.err == nil {
+		r.err = errors.New("truncated SMPP body")
+	}
+}
+
+// smppMessage is the part of a submit_sm or deliver_sm body
+// used by the client
+type smppMessage struct {
+	source       string
+	destination  string
+	esmClass     byte
+	dataCoding   byte
+	shortMessage []byte
+	tags         map[uint16][]byte
+}
+
+// parseSMPPMessage reads the mandatory parameters of a submit_sm
+// or deliver_sm body, followed by its optional tagged parameters
+func parseSMPPMessage(body []byte) (smppMessage, error) {
+	r := &smppReader{b: body}
+	var m smppMessage
+
+	r.cstring() // service_type
+	r.byte()    // source_addr_ton
+	r.byte()    // source_addr_npi
+	m.source = r.cstring()
+	r.byte() // dest_addr_ton
+	r.byte() // dest_addr_npi
+	m.destination = r.cstring()
+	m.esmClass = r.byte()
+	r.byte()    // protocol_id
+	r.byte()    // priority_flag
+	r.cstring() // schedule_delivery_time
+	r.cstring() // validity_period
+	r.byte()    // registered_delivery
+	r.byte()    // replace_if_present_flag
+	m.dataCoding = r.byte()
+	r.byte() // sm_default_msg_id
+	m.shortMessage = r.bytes(int(r.byte()))
+
+	m.tags = make(map[uint16][]byte)
+	for r.err == nil && len(r.b) >= 4 {
+		tag := binary.BigEndian.Uint16(r.b[0:2])
+		length := int(binary.BigEndian.Uint16(r.b[2:4]))
+		r.b = r.b[4:]
+		m.tags[tag] = r.bytes(length)
+	}
+
+	return m, r.err
+}
+
+// SMPPClient sends messages over a SMPP 3.4 transceiver bind
+// to a carrier or aggregator SMSC
+// The account SID of the options is used as the SMPP system id,
+// the access key as its password and the base URL as the host:port of the SMSC
+// The bind is made on the first message and made again once lost
+type SMPPClient struct {
+	addr         string
+	systemID     string
+	password     string
+	timeout      time.Duration
+	enquireEvery time.Duration
+	dialer       *net.Dialer
+
+	mu       sync.Mutex
+	session  *smppSession
+	receipts func(id string, recipient PhoneNumber, status string, at time.Time)
+}
+
+// NewSMPPClient creates a new SMPP client from the given options
+func NewSMPPClient(opts Options) *SMPPClient {
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = defaultSMPPTimeout
+	}
+
+	dialer := &net.Dialer{Timeout: opts.DialTimeout, KeepAlive: 30 * time.Second}
+	if opts.LocalAddr != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: opts.LocalAddr}
+	}
+
+	return &SMPPClient{
+		addr:         opts.BaseURL,
+		systemID:     opts.AccountSID,
+		password:     opts.AccessKey,
+		timeout:      timeout,
+		enquireEvery: defaultSMPPEnquireEvery,
+		dialer:       dialer,
+	}
+}
+
+// Name identifies the provider of the client
+func (c *SMPPClient) Name() string {
+	return ProviderSMPP
+}
+
+// flashMessages marks SMPP as delivering flash messages
+func (c *SMPPClient) flashMessages() {}
+
+// binaryMessages marks SMPP as delivering binary messages
+func (c *SMPPClient) binaryMessages() {}
+
+// concatenateMessages marks SMPP as delivering the parts of split messages
+func (c *SMPPClient) concatenateMessages() {}
+
+// onReceipt sets the function the delivery receipts are handed to
+func (c *SMPPClient) onReceipt(f func(id string, recipient PhoneNumber, status string, at time.Time)) {
+	c.mu.Lock()
+	c.receipts = f
+	c.mu.Unlock()
+}
+
+// CreateMessage submits the message over the bind to the SMSC
+// The request is abandoned as soon as the given context is done
+func (c *SMPPClient) CreateMessage(ctx context.Context, r *Request) (Result, error) {
+	body, err := submitSMBody(r)
+	if err != nil {
+		return Result{}, err
+	}
+
+	session, err := c.bind(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+
+	resp, err := session.call(ctx, smppSubmitSM, body)
+	if err != nil {
+		return Result{}, fmt.Errorf("Could not submit message to SMSC %s; Error: %v", c.addr, err)
+	}
+	if resp.status != smppStatusOK {
+		return Result{}, smppError(resp.status)
+	}
+
+	rr := &smppReader{b: resp.body}
+	id := rr.cstring()
+	if rr.err != nil || id == "" {
+		return Result{}, &ContractError{StatusCode: http.StatusBadGateway, Body: resp.body, Reason: "submit_sm_resp has no message id"}
+	}
+
+	return Result{
+		StatusCode: http.StatusCreated,
+		ID:         id,
+		Recipient:  r.Recipient,
+		Originator: r.Originator,
+		Message:    r.Message,
+		Status:     "sent",
+		Created:    time.Now(),
+	}, nil
+}
+
+// Close unbinds from the SMSC
+func (c *SMPPClient) Close() error {
+	c.mu.Lock()
+	session := c.session
+	c.session = nil
+	c.mu.Unlock()
+
+	if session == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	_, err := session.call(ctx, smppUnbind, nil)
+	session.close(errSMPPClosed)
+
+	return err
+}
+
+// bind returns the current bind to the SMSC, making a new one when needed
+func (c *SMPPClient) bind(ctx context.Context) (*smppSession, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.session != nil && !c.session.closed() {
+		return c.session, nil
+	}
+
+	metrics.Add("smpp_binds", 1)
+	conn, err := c.dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		metrics.Add("smpp_bind_errors", 1)
+		return nil, fmt.Errorf("Could not connect to SMSC %s; Error: %v", c.addr, err)
+	}
+
+	session := newSMPPSession(conn, c.receipt)
+	go session.read()
+
+	var w smppWriter
+	w.cstring(c.systemID)
+	w.cstring(c.password)
+	w.cstring("") // system_type
+	w.WriteByte(smppInterfaceVersion)
+	w.WriteByte(0) // addr_ton
+	w.WriteByte(0) // addr_npi
+	w.cstring("")  // address_range
+
+	resp, err := session.call(ctx, smppBindTransceiver, w.Bytes())
+	if err == nil && resp.status != smppStatusOK {
+		err = smppError(resp.status)
+	}
+	if err != nil {
+		metrics.Add("smpp_bind_errors", 1)
+		session.close(errSMPPClosed)
+		if _, ok := err.(*ProviderError); ok {
+			return nil, err
+		}
+		return nil, fmt.Errorf("Could not bind to SMSC %s; Error: %v", c.addr, err)
+	}
+
+	go session.keepAlive(c.enquireEvery, c.timeout)
+	c.session = session
+
+	return session, nil
+}
+
+// receipt hands the delivery receipts received over the bind
+// to the function set through onReceipt
+func (c *SMPPClient) receipt(m smppMessage) {
+	c.mu.Lock()
+	f := c.receipts
+	c.mu.Unlock()
+
+	id, status, ok := parseSMPPReceipt(m)
+	if !ok {
+		metrics.Add("dlr_invalid", 1)
+		slog.Warn("Ignored invalid SMPP delivery receipt", "receipt", m.shortMessage)
+		return
+	}
+
+	recipient := canonicalNumber(m.source)
+	if !recipient.wellFormed() {
+		metrics.Add("dlr_invalid", 1)
+		slog.Warn("Ignored SMPP delivery receipt", "message_id", id, "recipient", m.source)
+		return
+	}
+
+	// The receipts are timed by their arrival, as the done date they carry
+	// is in the local time of the SMSC and only precise to the minute
+	if f != nil {
+		f(id, recipient, status, time.Now())
+	}
+}
+
+// smppSession is a bind to the SMSC
+// Commands are matched with their responses by sequence number
+type smppSession struct {
+	conn    net.Conn
+	receipt func(smppMessage)
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	seq     uint32
+	pending map[uint32]chan smppPDU
+	done    chan struct{}
+	err     error
+}
+
+func newSMPPSession(conn net.Conn, receipt func(smppMessage)) *smppSession {
+	return &smppSession{
+		conn:    conn,
+		receipt: receipt,
+		pending: make(map[uint32]chan smppPDU),
+		done:    make(chan struct{}),
+	}
+}
+
+// call sends a command and waits for its response
+func (s *smppSession) call(ctx context.Context, command uint32, body []byte) (smppPDU, error) {
+	s.mu.Lock()
+	if s.err != nil {
+		err := s.err
+		s.mu.Unlock()
+		return smppPDU{}, err
+	}
+	s.seq++
+	seq := s.seq
+	respCh := make(chan smppPDU, 1)
+	s.pending[seq] = respCh
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, seq)
+		s.mu.Unlock()
+	}()
+
+	if err := s.write(smppPDU{command: command, seq: seq, body: body}); err != nil {
+		s.close(err)
+		return smppPDU{}, err
+	}
+
+	select {
+	case resp := <-respCh:
+		if resp.command == smppGenericNack {
+			return smppPDU{}, fmt.Errorf("command %#08x was refused with status %#x", command, resp.status)
+		}
+		return resp, nil
+	case <-s.done:
+		return smppPDU{}, s.closeErr()
+	case <-ctx.Done():
+		return smppPDU{}, ctx.Err()
+	}
+}
+
+func (s *smppSession) write(p smppPDU) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	_, err := s.conn.Write(p.encode())
+	return err
+}
+
+// read handles the PDUs sent by the SMSC until the bind is lost
+func (s *smppSession) read() {
+	for {
+		pdu, err := readSMPPPDU(s.conn)
+		if err != nil {
+			s.close(err)
+			return
+		}
+
+		switch {
+		case pdu.command == smppDeliverSM:
+			m, err := parseSMPPMessage(pdu.body)
+			s.write(smppPDU{command: smppDeliverSMResp, seq: pdu.seq, body: []byte{0}})
+			if err == nil && m.esmClass&smppESMReceipt != 0 {
+				s.receipt(m)
+			}
+		case pdu.command == smppEnquireLink:
+			s.write(smppPDU{command: smppEnquireLinkResp, seq: pdu.seq})
+		case pdu.command == smppUnbind:
+			s.write(smppPDU{command: smppUnbindResp, seq: pdu.seq})
+			s.close(errSMPPClosed)
+			return
+		case pdu.command&smppGenericNack != 0:
+			s.mu.Lock()
+			respCh, ok := s.pending[pdu.seq]
+			s.mu.Unlock()
+			if ok {
+				respCh <- pdu
+			}
+		default:
+			s.write(smppPDU{command: smppGenericNack, status: smppStatusInvCmdID, seq: pdu.seq})
+		}
+	}
+}
+
+// keepAlive checks the bind with enquire_link commands
+// and closes it once the SMSC stops answering
+func (s *smppSession) keepAlive(every, timeout time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			_, err := s.call(ctx, smppEnquireLink, nil)
+			cancel()
+			if err != nil {
+				s.close(err)
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// close drops the bind, failing the commands in flight
+func (s *smppSession) close(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return
+	}
+	s.err = err
+	close(s.done)
+	s.conn.Close()
+	slog.Warn("SMPP bind closed", "error", err)
+}
+
+func (s *smppSession) closed() bool {
+	return s.closeErr() != nil
+}
+
+func (s *smppSession) closeErr() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// submitSMBody builds the submit_sm body of the request
+// Text outside of the GSM-7 alphabet is sent UCS2 encoded, and binary
+// messages and the parts of split messages carry their user data header
+// in front of their body
+func submitSMBody(r *Request) ([]byte, error) {
+	var esmClass, dataCoding byte
+	var shortMessage []byte
+
+	udh, err := hex.DecodeString(r.UDH)
+	if err != nil {
+		return nil, fmt.Errorf("Message udh is not hex encoded; Error: %v", err)
+	}
+	if len(udh) > 0 {
+		esmClass |= smppESMUDHI
+	}
+
+	switch r.Type {
+	case messageTypeBinary:
+		body, err := hex.DecodeString(r.Message)
+		if err != nil {
+			return nil, fmt.Errorf("Binary message is not hex encoded; Error: %v", err)
+		}
+		dataCoding = smppCodingBinary
+		shortMessage = append(udh, body...)
+	default:
+		var text []byte
+		dataCoding, text = encodeSMPPText(r.Message)
+		if r.Type == messageTypeFlash {
+			dataCoding |= smppCodingFlash
+		}
+		shortMessage = append(udh, text...)
+	}
+
+	if len(shortMessage) > 254 {
+		return nil, fmt.Errorf("Message of %d octets does not fit in a submit_sm", len(shortMessage))
+	}
+
+	sourceTON, sourceNPI := byte(smppTONAlphanumeric), byte(0)
+	if _, err := strconv.ParseUint(r.Originator, 10, 64); err == nil {
+		sourceTON, sourceNPI = smppTONInternational, smppNPIISDN
+	}
+
+	var validity string
+	if r.DeliverBy != nil {
+		validity = smppTime(*r.DeliverBy)
+	}
+
+	var w smppWriter
+	w.cstring("") // service_type
+	w.WriteByte(sourceTON)
+	w.WriteByte(sourceNPI)
+	w.cstring(r.Originator)
+	w.WriteByte(smppTONInternational)
+	w.WriteByte(smppNPIISDN)
+	w.cstring(r.Recipient.msisdn())
+	w.WriteByte(esmClass)
+	w.WriteByte(0) // protocol_id
+	w.WriteByte(0) // priority_flag
+	w.cstring("")  // schedule_delivery_time
+	w.cstring(validity)
+	w.WriteByte(smppReceiptFinal)
+	w.WriteByte(0) // replace_if_present_flag
+	w.WriteByte(dataCoding)
+	w.WriteByte(0) // sm_default_msg_id
+	w.WriteByte(byte(len(shortMessage)))
+	w.Write(shortMessage)
+
+	return w.Bytes(), nil
+}
+
+// encodeSMPPText returns the data coding and the octets of the text
+// GSM-7 text is sent in the default alphabet of the SMSC, one septet per octet
+func encodeSMPPText(text string) (byte, []byte) {
+	if septets, ok := gsmEncode(text); ok {
+		return smppCodingDefault, septets
+	}
+
+	units := utf16.Encode([]rune(text))
+	b := make([]byte, 2*len(units))
+	for i, u := range units {
+		binary.BigEndian.PutUint16(b[2*i:], u)
+	}
+
+	return smppCodingUCS2, b
+}
+
+// smppTime formats the time in the SMPP absolute time format, in UTC
+func smppTime(t time.Time) string {
+	return t.UTC().Format("060102150405") + "000+"
+}
+
+// parseSMPPReceipt reads the message id and status of the delivery receipt,
+// from its text in the format of the SMPP 3.4 appendix B
+// The receipted_message_id parameter wins over the id of the text
+func parseSMPPReceipt(m smppMessage) (string, string, bool) {
+	fields := make(map[string]string)
+	for _, field := range strings.Fields(string(m.shortMessage)) {
+		if i := strings.IndexByte(field, ':'); i > 0 {
+			fields[field[:i]] = field[i+1:]
+		}
+	}
+
+	id := fields["id"]
+	if tagged, ok := m.tags[smppTagReceiptID]; ok {
+		id = strings.TrimRight(string(tagged), "\x00")
+	}
+
+	status, ok := smppReceiptStatuses[fields["stat"]]
+	if id == "" || !ok {
+		return "", "", false
+	}
+
+	return id, status, true
+}
+
+// smppError classifies the status of a refused command
+func smppError(status uint32) *ProviderError {
+	e := &ProviderError{
+		Kind:       KindUnknown,
+		StatusCode: http.StatusBadGateway,
+		Errors: []MessageError{{
+			Code:        int(status),
+			Description: fmt.Sprintf("SMSC refused the command with status %#x", status),
+		}},
+	}
+
+	switch status {
+	case smppStatusBindFail, smppStatusInvPaswd, smppStatusInvSysID:
+		e.Kind, e.StatusCode = KindAuth, http.StatusUnauthorized
+	case smppStatusThrottled:
+		e.Kind, e.StatusCode = KindThrottled, http.StatusTooManyRequests
+	case smppStatusInvDstAdr:
+		e.Kind, e.StatusCode = KindValidation, http.StatusUnprocessableEntity
+		e.Errors[0].Parameter = "recipient"
+	case smppStatusInvSrcAdr:
+		e.Kind, e.StatusCode = KindValidation, http.StatusUnprocessableEntity
+		e.Errors[0].Parameter = "originator"
+	case smppStatusInvMsgLen:
+		e.Kind, e.StatusCode = KindValidation, http.StatusUnprocessableEntity
+		e.Errors[0].Parameter = "message"
+	}
+
+	return e
+}
@@ -0,0 +1,161 @@
+package sms_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+)
+
+func TestSMPPClient(t *testing.T) {
+	smsc := sms.NewSMPPTestServer(t, "flysms", "secret")
+	defer smsc.Close()
+
+	tests := map[string]struct {
+		password      string
+		payload       string
+		wantStatus    int
+		wantSubmitted string
+	}{
+		"Text message": {
+			password:      "secret",
+			payload:       `{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`,
+			wantStatus:    http.StatusCreated,
+			wantSubmitted: "This is a test message",
+		},
+
+		"Unicode message": {
+			password:      "secret",
+			payload:       `{"recipient":31612345678, "originator": "MessageBird", "message": "Zażółć"}`,
+			wantStatus:    http.StatusCreated,
+			wantSubmitted: "\x00Z\x00a\x01\x7c\x00\xf3\x01\x42\x01\x07",
+		},
+
+		"Binary message": {
+			password:      "secret",
+			payload:       `{"recipient":31612345678, "originator": "MessageBird", "message": "cafe", "type": "binary", "udh": "050003cc0201"}`,
+			wantStatus:    http.StatusCreated,
+			wantSubmitted: "\x05\x00\x03\xcc\x02\x01\xca\xfe",
+		},
+
+		"Invalid destination": {
+			password:   "secret",
+			payload:    `{"recipient":99912345678, "originator": "MessageBird", "message": "This is a test message"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+
+		"Wrong password": {
+			password:   "wrong",
+			payload:    `{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`,
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			client := sms.NewSMPPClient(sms.Options{
+				BaseURL:    smsc.Addr,
+				AccountSID: "flysms",
+				AccessKey:  tc.password,
+				Timeout:    5 * time.Second,
+			})
+			defer client.Close()
+
+			srv := sms.NewServer(sms.Config{
+				Buffer:        10,
+				ReqTimeout:    5 * time.Second,
+				ThrottleRate:  10 * time.Millisecond,
+				MessageClient: client,
+			})
+			srv.Run()
+
+			r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(tc.payload))
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			if w.Code != tc.wantStatus {
+				t.Fatalf("Status code was %d; want %d", w.Code, tc.wantStatus)
+			}
+			if tc.wantSubmitted == "" {
+				return
+			}
+
+			submitted := smsc.Submitted()
+			if got := submitted[len(submitted)-1]; got != tc.wantSubmitted {
+				t.Errorf("SMSC got short message %q; want %q", got, tc.wantSubmitted)
+			}
+		})
+	}
+}
+
+func TestSMPPClient_receipts(t *testing.T) {
+	smsc := sms.NewSMPPTestServer(t, "flysms", "secret")
+	defer smsc.Close()
+
+	client := sms.NewSMPPClient(sms.Options{
+		BaseURL:    smsc.Addr,
+		AccountSID: "flysms",
+		AccessKey:  "secret",
+		Timeout:    5 * time.Second,
+	})
+	defer client.Close()
+
+	srv := sms.NewServer(sms.Config{
+		Buffer:        10,
+		ReqTimeout:    5 * time.Second,
+		ThrottleRate:  10 * time.Millisecond,
+		MessageClient: client,
+	})
+	srv.Run()
+
+	send := func() string {
+		r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(`{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`))
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Status code was %d; want %d", w.Code, http.StatusCreated)
+		}
+
+		var smsRes sms.Response
+		if err := json.NewDecoder(w.Body).Decode(&smsRes); err != nil {
+			t.Fatalf("Failed to decode json response body: %v", err)
+		}
+		return smsRes.Data.ID
+	}
+
+	waitStatus := func(id, want string) {
+		var got string
+		for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			r := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/messages/%s", id), nil)
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			var smsRes sms.Response
+			if err := json.NewDecoder(w.Body).Decode(&smsRes); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+			if got = smsRes.Data.Status; got == want {
+				return
+			}
+		}
+		t.Errorf("Status of message %s was %q; want %q", id, got, want)
+	}
+
+	id := send()
+	smsc.Receipt(id, "DELIVRD")
+	waitStatus(id, "delivered")
+
+	// The bind is made again once the SMSC drops it
+	smsc.Drop()
+	time.Sleep(50 * time.Millisecond)
+
+	id = send()
+	smsc.Receipt(id, "UNDELIV")
+	waitStatus(id, "delivery_failed")
+}
@@ -0,0 +1,112 @@
+package smstest
+
+import (
+	"encoding/json"
+	"math/rand"
+	"strings"
+
+	"github.com/iulianclita/flysms/sms"
+)
+
+// RecipientCase is a recipient along with whether the server accepts it
+type RecipientCase struct {
+	Name      string
+	Recipient sms.PhoneNumber
+	Valid     bool
+}
+
+// TextCase is an originator or message body
+// along with whether the server accepts it
+type TextCase struct {
+	Name  string
+	Value string
+	Valid bool
+}
+
+// Recipients returns recipients around the limits of the server
+func Recipients() []RecipientCase {
+	return []RecipientCase{
+		{"Shortest", "1234567", true},
+		{"Too short", "123456", false},
+		{"Longest", "123456789012345", true},
+		{"Too long", "1234567890123456", false},
+		{"Dutch mobile", "31612345678", true},
+		{"Plus sign", "+31612345678", true},
+		{"Leading zeros", "0031612345678", true},
+		{"Separators", "+31 (6) 1234-5678", true},
+		{"Zero", "0", false},
+		{"Zeros", "0000000", false},
+		{"Negative", "-31612345678", false},
+		{"Letters", "3161234567a", false},
+	}
+}
+
+// Originators returns originators around the limits of the server
+func Originators() []TextCase {
+	return []TextCase{
+		{"Empty", "", false},
+		{"Single character", "A", true},
+		{"Longest", strings.Repeat("A", sms.MaxOriginatorLength), true},
+		{"Too long", strings.Repeat("A", sms.MaxOriginatorLength+1), false},
+		{"Numeric", "31612345678", true},
+		{"Unicode", "Müller", true},
+		{"Unicode too long", strings.Repeat("ü", sms.MaxOriginatorLength/2+1), false},
+	}
+}
+
+// Messages returns message bodies around the limits of the server,
+// in GSM-7 with the characters of its extension table which take two septets,
+// and in UCS-2 with emoji which take two characters
+func Messages() []TextCase {
+	return []TextCase{
+		{"Empty", "", false},
+		{"Single character", "a", true},
+		{"Longest", strings.Repeat("a", sms.MaxMessageLength), true},
+		{"Too long", strings.Repeat("a", sms.MaxMessageLength+1), false},
+		{"Accents", strings.Repeat("é", sms.MaxMessageLength), true},
+		{"Accents too long", strings.Repeat("é", sms.MaxMessageLength+1), false},
+		{"Euro signs", strings.Repeat("€", sms.MaxMessageLength/2), true},
+		{"Euro signs too long", strings.Repeat("€", sms.MaxMessageLength/2+1), false},
+		{"Cyrillic", strings.Repeat("ж", sms.MaxUCS2MessageLength), true},
+		{"Cyrillic too long", strings.Repeat("ж", sms.MaxUCS2MessageLength+1), false},
+		{"Emoji", strings.Repeat("😀", sms.MaxUCS2MessageLength/2), true},
+		{"Emoji too long", strings.Repeat("😀", sms.MaxUCS2MessageLength/2+1), false},
+		{"GSM extension", strings.Repeat("{}[]~|^\\", sms.MaxMessageLength/16), true},
+		{"GSM extension too long", strings.Repeat("{}[]~|^\\", sms.MaxMessageLength/16) + "€", false},
+		{"Whitespace", " \n\t\r", true},
+	}
+}
+
+// Payload is a message creation request made of generated parameters
+type Payload struct {
+	Name  string
+	Body  string
+	Valid bool
+}
+
+// Payloads combines n random recipients, originators and messages
+// Each payload is valid when all of its parameters are
+func Payloads(rnd *rand.Rand, n int) []Payload {
+	recipients, originators, messages := Recipients(), Originators(), Messages()
+
+	payloads := make([]Payload, 0, n)
+	for i := 0; i < n; i++ {
+		recp := recipients[rnd.Intn(len(recipients))]
+		orig := originators[rnd.Intn(len(originators))]
+		msg := messages[rnd.Intn(len(messages))]
+
+		body, _ := json.Marshal(sms.Request{
+			Recipient:  recp.Recipient,
+			Originator: orig.Value,
+			Message:    msg.Value,
+		})
+
+		payloads = append(payloads, Payload{
+			Name:  recp.Name + " recipient, " + orig.Name + " originator, " + msg.Name + " message",
+			Body:  string(body),
+			Valid: recp.Valid && orig.Valid && msg.Valid,
+		})
+	}
+
+	return payloads
+}
@@ -0,0 +1,184 @@
+// Package smstest provides helpers for the integration tests of applications
+// embedding the flysms server
+// It runs the server against a fake provider recording the messages it is
+// handed, with a clock that only moves when the test advances it
+package smstest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+)
+
+// Clock is an sms.Clock only moving forward when advanced
+type Clock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*ticker
+	timers  []*timer
+}
+
+// NewClock creates a clock stopped at the given time
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns the current time of the clock
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// NewTicker creates a ticker ticking as the clock is advanced
+func (c *Clock) NewTicker(d time.Duration) sms.Ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &ticker{c: make(chan time.Time, 1), period: d, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// AfterFunc schedules the function to run once the clock is advanced
+// past the given duration
+func (c *Clock) AfterFunc(d time.Duration, f func()) sms.Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &timer{at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the clock forward, ticking the tickers
+// and running the functions that are due
+// Like real tickers, a ticker drops the ticks nobody is waiting for
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+
+	for _, t := range c.tickers {
+		if t.stopped() {
+			continue
+		}
+		for !t.next.After(now) {
+			select {
+			case t.c <- t.next:
+			default:
+			}
+			t.next = t.next.Add(t.period)
+		}
+	}
+
+	var due []*timer
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if !t.at.After(now) {
+			due = append(due, t)
+		} else if !t.stopped() {
+			pending = append(pending, t)
+		}
+	}
+	c.timers = pending
+	c.mu.Unlock()
+
+	for _, t := range due {
+		if t.Stop() {
+			t.f()
+		}
+	}
+}
+
+// pendingTicks reports whether a tick is waiting to be received
+func (c *Clock) pendingTicks() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, t := range c.tickers {
+		if len(t.c) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// WaitTimers waits until at least n functions are scheduled on the clock,
+// which tells that the server started waiting for them
+// The test fails if it takes longer than a second
+func (c *Clock) WaitTimers(t testing.TB, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		c.mu.Lock()
+		scheduled := 0
+		for _, t := range c.timers {
+			if !t.stopped() {
+				scheduled++
+			}
+		}
+		c.mu.Unlock()
+
+		if scheduled >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Got %d scheduled timers; want %d", scheduled, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+type ticker struct {
+	mu     sync.Mutex
+	c      chan time.Time
+	period time.Duration
+	next   time.Time
+	done   bool
+}
+
+func (t *ticker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *ticker) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.done = true
+}
+
+func (t *ticker) stopped() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.done
+}
+
+type timer struct {
+	mu   sync.Mutex
+	at   time.Time
+	f    func()
+	done bool
+}
+
+func (t *timer) Stop() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stopped := !t.done
+	t.done = true
+	return stopped
+}
+
+func (t *timer) stopped() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.done
+}
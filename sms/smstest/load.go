@@ -0,0 +1,200 @@
+package smstest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+)
+
+// LoadOptions describes the requests fired by Load
+// Requests are sent by Concurrency callers at once, InvalidPercent of them
+// are refused by the validation and HighPriorityPercent are high priority
+// Timeout is the real time allowed for every request to be answered
+type LoadOptions struct {
+	Concurrency         int
+	Requests            int
+	InvalidPercent      int
+	HighPriorityPercent int
+	Timeout             time.Duration
+}
+
+// LoadReport counts the answers to the requests fired by Load
+type LoadReport struct {
+	Statuses   map[int]int
+	Lost       int
+	Misrouted  int
+	Unexpected int
+}
+
+type loadRequest struct {
+	id        string
+	recipient sms.PhoneNumber
+	message   string
+	body      string
+	valid     bool
+}
+
+// Load fires a mix of concurrent message requests at the server, ticking
+// its clock while they wait, and checks every request gets its own answer
+// The test fails for the requests left unanswered, the answers carrying
+// another message than the one requested, and, with the fake provider,
+// the messages created without reaching it
+func (s *Server) Load(t testing.TB, opts LoadOptions) LoadReport {
+	t.Helper()
+
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 10 * time.Second
+	}
+
+	reqs := make(chan loadRequest)
+	go func() {
+		defer close(reqs)
+		for i := 0; i < opts.Requests; i++ {
+			reqs <- newLoadRequest(i, opts)
+		}
+	}()
+
+	stop := make(chan struct{})
+	if s.Clock != nil {
+		go s.pumpClock(stop)
+	}
+
+	var mu sync.Mutex
+	report := LoadReport{Statuses: make(map[int]int)}
+	created := make(map[string]loadRequest)
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for lr := range reqs {
+				w, ok := s.loadOne(lr, opts.Timeout)
+
+				mu.Lock()
+				switch {
+				case !ok:
+					report.Lost++
+				case w.Header().Get("X-Request-Id") != "" && w.Header().Get("X-Request-Id") != lr.id:
+					report.Misrouted++
+				default:
+					report.Statuses[w.Code]++
+					if w.Code == http.StatusCreated && !lr.valid || w.Code == http.StatusUnprocessableEntity && lr.valid {
+						report.Unexpected++
+					}
+
+					var res sms.Response
+					if err := json.NewDecoder(w.Body).Decode(&res); err == nil && res.Success {
+						if res.Data.Message != lr.message || res.Data.Recipient != lr.recipient {
+							report.Misrouted++
+						}
+						created[res.Data.ID] = lr
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(stop)
+
+	if s.Provider != nil {
+		sent := make(map[string]bool)
+		for _, ev := range s.Provider.Events() {
+			if lr, ok := created[ev.Result.ID]; ok && ev.Request.Message == lr.message {
+				sent[ev.Result.ID] = true
+			}
+		}
+		for id, lr := range created {
+			if !sent[id] {
+				t.Errorf("Message %q was created as %s without reaching the provider", lr.message, id)
+			}
+		}
+	}
+
+	if report.Lost > 0 {
+		t.Errorf("%d of %d requests were not answered within %v", report.Lost, opts.Requests, opts.Timeout)
+	}
+	if report.Misrouted > 0 {
+		t.Errorf("%d of %d requests got the answer of another request", report.Misrouted, opts.Requests)
+	}
+	if report.Unexpected > 0 {
+		t.Errorf("%d of %d requests were not validated as expected", report.Unexpected, opts.Requests)
+	}
+
+	return report
+}
+
+// newLoadRequest builds the i-th request of the load
+// Every request has its own message so that answers can be told apart
+func newLoadRequest(i int, opts LoadOptions) loadRequest {
+	lr := loadRequest{
+		id:        fmt.Sprintf("load-%d", i),
+		recipient: sms.PhoneNumber(fmt.Sprintf("+316%08d", i)),
+		message:   fmt.Sprintf("Load test message %d", i),
+		valid:     i%100 >= opts.InvalidPercent,
+	}
+
+	req := sms.Request{
+		Recipient:  lr.recipient,
+		Originator: "MessageBird",
+		Message:    lr.message,
+	}
+	if !lr.valid {
+		req.Originator = ""
+	}
+	if (i*7)%100 < opts.HighPriorityPercent {
+		req.Priority = "high"
+	}
+
+	body, _ := json.Marshal(&req)
+	lr.body = string(body)
+
+	return lr
+}
+
+// loadOne serves the request and reports false
+// if it was not answered within the timeout
+func (s *Server) loadOne(lr loadRequest, timeout time.Duration) (*httptest.ResponseRecorder, bool) {
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(lr.body))
+		r.Header.Set("X-Request-Id", lr.id)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		done <- w
+	}()
+
+	select {
+	case w := <-done:
+		return w, true
+	case <-time.After(timeout):
+		return nil, false
+	}
+}
+
+// pumpClock ticks the clock by the throttle rate whenever
+// the previous tick was taken, until stopped
+func (s *Server) pumpClock(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(100 * time.Microsecond):
+			if !s.Clock.pendingTicks() {
+				s.Clock.Advance(s.throttleRate)
+			}
+		}
+	}
+}
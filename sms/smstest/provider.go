@@ -0,0 +1,112 @@
+package smstest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+)
+
+// Event is a message handed to the fake provider and what it answered
+type Event struct {
+	Time    time.Time
+	Request sms.Request
+	Result  sms.Result
+	Err     error
+}
+
+// Provider is a fake SMS provider answering from memory
+// It accepts every message unless told to fail
+type Provider struct {
+	mu     sync.Mutex
+	clock  sms.Clock
+	err    error
+	seq    int
+	events []Event
+	notify chan struct{}
+}
+
+// NewProvider creates a fake provider dating its messages with the clock
+func NewProvider(clock sms.Clock) *Provider {
+	return &Provider{
+		clock:  clock,
+		notify: make(chan struct{}),
+	}
+}
+
+// Name identifies the fake provider in the message attempts
+func (p *Provider) Name() string {
+	return "smstest"
+}
+
+// CreateMessage records the message and accepts it,
+// or refuses it with the error set by FailWith
+func (p *Provider) CreateMessage(ctx context.Context, r *sms.Request) (sms.Result, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ev := Event{Time: p.clock.Now(), Request: *r, Err: p.err}
+	if p.err == nil {
+		p.seq++
+		ev.Result = sms.Result{
+			StatusCode: http.StatusCreated,
+			ID:         fmt.Sprintf("smstest-%d", p.seq),
+			Recipient:  r.Recipient,
+			Originator: r.Originator,
+			Message:    r.Message,
+			Status:     "sent",
+			Created:    ev.Time,
+		}
+	}
+
+	p.events = append(p.events, ev)
+	close(p.notify)
+	p.notify = make(chan struct{})
+
+	return ev.Result, ev.Err
+}
+
+// FailWith makes the provider answer the next messages with the error,
+// typically an *sms.ProviderError; a nil error makes it accept them again
+func (p *Provider) FailWith(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.err = err
+}
+
+// Events returns the messages handed to the provider so far
+func (p *Provider) Events() []Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]Event(nil), p.events...)
+}
+
+// WaitEvents waits until the provider was handed n messages and returns them
+// The test fails if it takes longer than a second
+func (p *Provider) WaitEvents(t testing.TB, n int) []Event {
+	t.Helper()
+
+	timeout := time.After(time.Second)
+	for {
+		p.mu.Lock()
+		events := append([]Event(nil), p.events...)
+		notify := p.notify
+		p.mu.Unlock()
+
+		if len(events) >= n {
+			return events
+		}
+
+		select {
+		case <-notify:
+		case <-timeout:
+			t.Fatalf("Provider got %d messages; want %d", len(events), n)
+		}
+	}
+}
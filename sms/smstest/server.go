@@ -0,0 +1,123 @@
+package smstest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+)
+
+// Server is a running flysms server wired to a fake provider and clock
+// Clock and Provider are nil when the config has its own
+type Server struct {
+	*sms.Server
+	Clock        *Clock
+	Provider     *Provider
+	throttleRate time.Duration
+}
+
+// NewServer starts a server from the given config
+// The config gets a fake clock and a fake provider as message client,
+// unless it has its own, along with a small buffer and short timeouts
+func NewServer(t testing.TB, cfg sms.Config) *Server {
+	t.Helper()
+
+	var clock *Clock
+	switch c := cfg.Clock.(type) {
+	case nil:
+		clock = NewClock(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+		cfg.Clock = clock
+	case *Clock:
+		clock = c
+	}
+
+	var provider *Provider
+	if cfg.MessageClient == nil {
+		provider = NewProvider(cfg.Clock)
+		cfg.MessageClient = provider
+	}
+	if cfg.Buffer == 0 {
+		cfg.Buffer = 10
+	}
+	if cfg.ReqTimeout == 0 {
+		cfg.ReqTimeout = 5 * time.Second
+	}
+	if cfg.ThrottleRate == 0 {
+		cfg.ThrottleRate = time.Second
+	}
+
+	srv := sms.NewServer(cfg)
+	srv.Run()
+
+	return &Server{
+		Server:       srv,
+		Clock:        clock,
+		Provider:     provider,
+		throttleRate: cfg.ThrottleRate,
+	}
+}
+
+// Do serves the HTTP request and returns the recorded response
+// Messages wait for the throttle, so creating one blocks until
+// the clock is advanced from another goroutine; see Send
+func (s *Server) Do(method, path, body string) *httptest.ResponseRecorder {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+
+	req := httptest.NewRequest(method, path, r)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+
+	return w
+}
+
+// Go serves the HTTP request in the background
+// The recorded response is delivered on the returned channel
+func (s *Server) Go(method, path, body string) <-chan *httptest.ResponseRecorder {
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		done <- s.Do(method, path, body)
+	}()
+
+	return done
+}
+
+// Send posts the message payload to /messages and ticks the clock,
+// advancing it by the throttle rate, until the server answers
+// The test fails if the server does not answer within a second
+func (s *Server) Send(t testing.TB, payload string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	return s.SendRequest(t, http.MethodPost, "/messages", payload)
+}
+
+// SendRequest serves the HTTP request like Send, for the endpoints
+// queueing messages other than /messages
+func (s *Server) SendRequest(t testing.TB, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	done := s.Go(method, path, body)
+	timeout := time.After(time.Second)
+	var advanced time.Time
+	for {
+		select {
+		case w := <-done:
+			return w
+		case <-timeout:
+			t.Fatal("Server did not answer the message")
+		case <-time.After(time.Millisecond):
+			// Every tick sends a queued message, which is given
+			// some time to be answered before ticking again
+			if s.Clock != nil && !s.Clock.pendingTicks() && time.Since(advanced) > 20*time.Millisecond {
+				s.Clock.Advance(s.throttleRate)
+				advanced = time.Now()
+			}
+		}
+	}
+}
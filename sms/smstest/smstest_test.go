@@ -0,0 +1,179 @@
+package smstest_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/smstest"
+)
+
+func TestServer_Send(t *testing.T) {
+	tests := map[string]struct {
+		payload    string
+		err        error
+		wantStatus int
+		wantEvents int
+		wantError  string
+	}{
+		"Message created": {
+			payload:    `{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`,
+			wantStatus: http.StatusCreated,
+			wantEvents: 1,
+		},
+
+		"Message refused by the provider": {
+			payload: `{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`,
+			err: &sms.ProviderError{
+				Kind:       sms.KindValidation,
+				StatusCode: http.StatusUnprocessableEntity,
+				Errors:     []sms.MessageError{{Description: "originator is invalid", Parameter: "originator"}},
+			},
+			wantStatus: http.StatusUnprocessableEntity,
+			wantEvents: 1,
+			wantError:  "originator is invalid",
+		},
+
+		"Message refused by the server": {
+			payload:    `{"recipient":31612345678, "originator": "MessageBird"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantError:  "Missing parameter (message value is not present)",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := smstest.NewServer(t, sms.Config{})
+			srv.Provider.FailWith(tc.err)
+
+			w := srv.Send(t, tc.payload)
+			if w.Code != tc.wantStatus {
+				t.Fatalf("Status code was %d; want %d", w.Code, tc.wantStatus)
+			}
+
+			var smsRes sms.Response
+			if err := json.NewDecoder(w.Body).Decode(&smsRes); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+			if smsRes.Error != tc.wantError {
+				t.Errorf("Error was %q; want %q", smsRes.Error, tc.wantError)
+			}
+
+			events := srv.Provider.Events()
+			if len(events) != tc.wantEvents {
+				t.Fatalf("Provider got %d messages; want %d", len(events), tc.wantEvents)
+			}
+			if tc.wantEvents == 0 {
+				return
+			}
+
+			ev := events[0]
+			if ev.Request.Recipient != "31612345678" || ev.Err != tc.err {
+				t.Errorf("Provider event was %+v", ev)
+			}
+			if tc.err == nil && smsRes.Data.ID != ev.Result.ID {
+				t.Errorf("Message id was %q; want %q", smsRes.Data.ID, ev.Result.ID)
+			}
+		})
+	}
+}
+
+func TestClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := smstest.NewClock(start)
+
+	ticker := clock.NewTicker(time.Second)
+	fired := make(chan struct{})
+	clock.AfterFunc(3*time.Second, func() { close(fired) })
+	stopped := clock.AfterFunc(time.Second, func() { t.Error("Stopped timer fired") })
+	if !stopped.Stop() {
+		t.Error("Timer was not stopped")
+	}
+
+	clock.WaitTimers(t, 1)
+	clock.Advance(2 * time.Second)
+
+	if got := clock.Now(); !got.Equal(start.Add(2 * time.Second)) {
+		t.Errorf("Time was %v; want %v", got, start.Add(2*time.Second))
+	}
+
+	select {
+	case tick := <-ticker.C():
+		if !tick.Equal(start.Add(time.Second)) {
+			t.Errorf("Tick was at %v; want %v", tick, start.Add(time.Second))
+		}
+	default:
+		t.Error("Ticker did not tick")
+	}
+
+	select {
+	case <-fired:
+		t.Fatal("Timer fired too early")
+	default:
+	}
+
+	clock.Advance(time.Second)
+	select {
+	case <-fired:
+	default:
+		t.Error("Timer did not fire")
+	}
+}
+
+func TestPayloads(t *testing.T) {
+	payload := func(recipient sms.PhoneNumber, originator, message string) string {
+		return fmt.Sprintf(`{"recipient":%q, "originator": %q, "message": %q}`, recipient, originator, message)
+	}
+
+	var payloads []smstest.Payload
+	for _, tc := range smstest.Recipients() {
+		payloads = append(payloads, smstest.Payload{Name: tc.Name + " recipient", Body: payload(tc.Recipient, "MessageBird", "Hi"), Valid: tc.Valid})
+	}
+	for _, tc := range smstest.Originators() {
+		payloads = append(payloads, smstest.Payload{Name: tc.Name + " originator", Body: payload("31612345678", tc.Value, "Hi"), Valid: tc.Valid})
+	}
+	for _, tc := range smstest.Messages() {
+		payloads = append(payloads, smstest.Payload{Name: tc.Name + " message", Body: payload("31612345678", "MessageBird", tc.Value), Valid: tc.Valid})
+	}
+	payloads = append(payloads, smstest.Payloads(rand.New(rand.NewSource(1)), 20)...)
+
+	srv := smstest.NewServer(t, sms.Config{})
+
+	for _, p := range payloads {
+		t.Run(p.Name, func(t *testing.T) {
+			want := http.StatusUnprocessableEntity
+			if p.Valid {
+				want = http.StatusCreated
+			}
+
+			if w := srv.Send(t, p.Body); w.Code != want {
+				t.Errorf("Status code was %d; want %d; Body: %s", w.Code, want, w.Body)
+			}
+		})
+	}
+}
+
+func TestServer_Load(t *testing.T) {
+	srv := smstest.NewServer(t, sms.Config{Buffer: 50, ReqTimeout: time.Hour})
+
+	report := srv.Load(t, smstest.LoadOptions{
+		Concurrency:         20,
+		Requests:            200,
+		InvalidPercent:      10,
+		HighPriorityPercent: 20,
+	})
+
+	if got := report.Statuses[http.StatusCreated]; got != 180 {
+		t.Errorf("Created %d messages; want 180", got)
+	}
+	if got := report.Statuses[http.StatusUnprocessableEntity]; got != 20 {
+		t.Errorf("Refused %d messages; want 20", got)
+	}
+	if got := len(srv.Provider.Events()); got != 180 {
+		t.Errorf("Provider got %d messages; want 180", got)
+	}
+}
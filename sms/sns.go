@@ -0,0 +1,347 @@
+package sms
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	snsAPIVersion  = "2010-03-31"
+	snsService     = "sns"
+	sigV4Algorithm = "AWS4-HMAC-SHA256"
+)
+
+// sns error codes that are not classified by the status code alone
+const (
+	snsCodeThrottling = "Throttling"
+	snsCodeThrottled  = "Throttled"
+)
+
+// SNSPublishResponse is the API mapping for a message published through sns
+type SNSPublishResponse struct {
+	MessageID string `xml:"PublishResult>MessageId"`
+	RequestID string `xml:"ResponseMetadata>RequestId"`
+}
+
+// SNSError is the API response for a failed publish action
+type SNSError struct {
+	Type    string `xml:"Error>Type"`
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+// AWSCredentials are the keys signing the requests to AWS
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// LoadAWSCredentials looks up the credentials the way the AWS SDKs do,
+// first in the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+// environment variables and then in the shared credentials file, using the
+// profile named by AWS_PROFILE or the default one
+func LoadAWSCredentials() (AWSCredentials, error) {
+	creds := AWSCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.AccessKeyID != "" && creds.SecretAccessKey != "" {
+		return creds, nil
+	}
+
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return AWSCredentials{}, fmt.Errorf("Could not find AWS credentials; Error: %v", err)
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+
+	return loadSharedCredentials(path, profile)
+}
+
+// loadSharedCredentials reads a profile of an AWS shared credentials file
+func loadSharedCredentials(path, profile string) (AWSCredentials, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return AWSCredentials{}, fmt.Errorf("Could not find AWS credentials; Error: %v", err)
+	}
+	defer f.Close()
+
+	var creds AWSCredentials
+	var section string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section != profile {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(parts[1])
+		switch strings.TrimSpace(parts[0]) {
+		case "aws_access_key_id":
+			creds.AccessKeyID = value
+		case "aws_secret_access_key":
+			creds.SecretAccessKey = value
+		case "aws_session_token":
+			creds.SessionToken = value
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return AWSCredentials{}, fmt.Errorf("Could not read AWS credentials file %s; Error: %v", path, err)
+	}
+
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return AWSCredentials{}, fmt.Errorf("No AWS credentials for profile %q in %s", profile, path)
+	}
+
+	return creds, nil
+}
+
+// SNSClient sends text messages through the AWS SNS Publish API
+type SNSClient struct {
+	creds      AWSCredentials
+	region     string
+	baseURL    string
+	maxBody    int64
+	httpClient *http.Client
+}
+
+// NewSNSClient creates a new sns client from the given options and credentials
+// The region of the options is required, the base URL defaults to the
+// regional sns endpoint
+func NewSNSClient(opts Options, creds AWSCredentials) (*SNSClient, error) {
+	region := opts.Region
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		return nil, errors.New("The sns provider needs an AWS region")
+	}
+
+	baseURL := opts.BaseURL
+	if baseURL == "" {
+		baseURL = fmt.Sprintf("https://sns.%s.amazonaws.com", region)
+	}
+
+	maxBody := opts.MaxResponseBytes
+	if maxBody <= 0 {
+		maxBody = defaultMaxResponseBytes
+	}
+
+	return &SNSClient{
+		creds:   creds,
+		region:  region,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		maxBody: maxBody,
+		httpClient: &http.Client{
+			Timeout:   opts.Timeout,
+			Transport: newTransport(opts),
+		},
+	}, nil
+}
+
+// Name identifies the provider of the client
+func (c *SNSClient) Name() string {
+	return ProviderSNS
+}
+
+// CreateMessage publishes the message to the recipient through sns
+// The request is abandoned as soon as the given context is done
+func (c *SNSClient) CreateMessage(ctx context.Context, r *Request) (Result, error) {
+	v := url.Values{}
+	v.Set("Action", "Publish")
+	v.Set("Version", snsAPIVersion)
+	v.Set("PhoneNumber", r.Recipient.e164())
+	v.Set("Message", r.Message)
+	setSNSAttribute(v, 1, "AWS.SNS.SMS.SenderID", r.Originator)
+	// Promotional messages may be dropped in favor of cheaper routes
+	smsType := "Promotional"
+	if r.OTP || r.Priority == priorityHigh {
+		smsType = "Transactional"
+	}
+	setSNSAttribute(v, 2, "AWS.SNS.SMS.SMSType", smsType)
+
+	endpoint := c.baseURL + "/"
+	payload := v.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(payload))
+	if err != nil {
+		return Result{}, fmt.Errorf("Could not create POST request for url %s; Error: %v", endpoint, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	signRequest(req, []byte(payload), c.creds, c.region, snsService, time.Now())
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return Result{}, newRequestError(req, false, err)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, c.maxBody+1))
+	if err != nil {
+		return Result{}, newRequestError(req, true, err)
+	}
+	if int64(len(body)) > c.maxBody {
+		return Result{}, &ContractError{StatusCode: res.StatusCode, Reason: fmt.Sprintf("response body is larger than %d bytes", c.maxBody)}
+	}
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		var serr SNSError
+		if err := xml.Unmarshal(body, &serr); err != nil || serr.Message == "" {
+			return Result{}, &ContractError{StatusCode: res.StatusCode, Body: body, Reason: "error response has no message"}
+		}
+
+		me := MessageError{Description: serr.Message}
+		if strings.Contains(serr.Message, "PhoneNumber") {
+			me.Parameter = "recipient"
+		}
+
+		kind := statusKind(res.StatusCode)
+		if serr.Code == snsCodeThrottling || serr.Code == snsCodeThrottled {
+			kind = KindThrottled
+		}
+
+		return Result{}, &ProviderError{
+			Kind:       kind,
+			StatusCode: res.StatusCode,
+			Errors:     []MessageError{me},
+		}
+	}
+
+	var msg SNSPublishResponse
+	if err := xml.Unmarshal(body, &msg); err != nil {
+		return Result{}, &ContractError{StatusCode: res.StatusCode, Body: body, Reason: fmt.Sprintf("invalid publish XML: %v", err)}
+	}
+	if msg.MessageID == "" {
+		return Result{}, &ContractError{StatusCode: res.StatusCode, Body: body, Reason: "published message has no id"}
+	}
+
+	// sns does not echo the message back
+	return Result{
+		StatusCode: res.StatusCode,
+		ID:         msg.MessageID,
+		Recipient:  r.Recipient,
+		Originator: r.Originator,
+		Message:    r.Message,
+		Status:     "accepted",
+		Created:    time.Now(),
+	}, nil
+}
+
+// setSNSAttribute adds a string message attribute to the publish form
+func setSNSAttribute(v url.Values, n int, name, value string) {
+	prefix := fmt.Sprintf("MessageAttributes.entry.%d.", n)
+	v.Set(prefix+"Name", name)
+	v.Set(prefix+"Value.DataType", "String")
+	v.Set(prefix+"Value.StringValue", value)
+}
+
+// signRequest adds the AWS signature version 4 headers to the request
+// The host, the content type and the amz headers are signed
+func signRequest(req *http.Request, payload []byte, creds AWSCredentials, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "content-type" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	payloadHash := sha256.Sum256(payload)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", day, region, service)
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		amzDate,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,88 @@
+package sms_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+)
+
+func TestSNSClient_CreateMessage(t *testing.T) {
+
+	testServer := sms.NewSNSTestServer(t, "AKID")
+	defer testServer.Close()
+
+	tests := map[string]struct {
+		payload    string
+		accessKey  string
+		wantStatus int
+		wantError  string
+	}{
+		"Published SMS": {
+			payload:    `{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`,
+			accessKey:  "AKID",
+			wantStatus: http.StatusOK,
+		},
+
+		"Invalid access key": {
+			payload:    `{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`,
+			accessKey:  "FAKE",
+			wantStatus: http.StatusForbidden,
+			wantError:  "The security token included in the request is invalid.",
+		},
+
+		"Invalid recipient": {
+			payload:    `{"recipient":9991234567, "originator": "MessageBird", "message": "This is a test message"}`,
+			accessKey:  "AKID",
+			wantStatus: http.StatusBadRequest,
+			wantError:  "Invalid parameter: PhoneNumber Reason: +9991234567 is not valid to publish to",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			sender, err := sms.NewSender(sms.Options{
+				Provider:  sms.ProviderSNS,
+				BaseURL:   testServer.URL,
+				AccessKey: tc.accessKey,
+				SecretKey: "secret",
+				Region:    "eu-west-1",
+				Timeout:   10 * time.Second,
+			})
+			if err != nil {
+				t.Fatalf("Could not create sender: %v", err)
+			}
+
+			srv := sms.NewServer(sms.Config{
+				Buffer:        10,
+				ReqTimeout:    5 * time.Second,
+				ThrottleRate:  10 * time.Millisecond,
+				MessageClient: sender,
+			})
+			srv.Run()
+
+			r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(tc.payload))
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			if w.Code != tc.wantStatus {
+				t.Errorf("Status code was %d; want %d", w.Code, tc.wantStatus)
+			}
+
+			var smsRes sms.Response
+			if err := json.NewDecoder(w.Body).Decode(&smsRes); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+			if smsRes.Error != tc.wantError {
+				t.Errorf("Error was %q; want %q", smsRes.Error, tc.wantError)
+			}
+			if tc.wantStatus == http.StatusOK && smsRes.Data.Recipient != "31612345678" {
+				t.Errorf("Recipient was %s; want %s", smsRes.Data.Recipient, "31612345678")
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package sms
+
+import (
+	"fmt"
+	"log/slog"
+	"math/rand"
+)
+
+// statusPartiallySent is the status of the split messages of which only
+// the first parts were sent
+const statusPartiallySent = "partially_sent"
+
+// Lengths of the parts of concatenated messages, which leave room
+// for the user data header numbering them
+const (
+	MaxPartLength     = 153
+	MaxUCS2PartLength = 67
+)
+
+// concatenatedSender is implemented by the senders able to deliver the parts
+// of split messages with their user data header, so that phones show them
+// as a single message
+type concatenatedSender interface {
+	concatenateMessages()
+}
+
+// splittable reports whether the message of the request can be split
+// in parts when it is too long
+// Splitting is opt-in through Config.MaxMessageParts, rolled out through
+// the FeatureAutoSplit flag, and only applies to text messages
+func (s *Server) splittable(req *Request) bool {
+	if s.maxParts < 2 || req.Channel != channelSMS || req.Type == messageTypeBinary {
+		return false
+	}
+	if !s.featureEnabled(FeatureAutoSplit, req) {
+		return false
+	}
+
+	_, ok := s.messageClient.(concatenatedSender)
+	return ok
+}
+
+// splitMessage splits the text in the parts of a concatenated message
+// GSM-7 text is split in parts of 153 septets, never separating an escaped
+// character from its escape, and UCS-2 text in parts of 67 characters,
+// never separating the two halves of a surrogate pair
+func splitMessage(text string) []string {
+	limit, runeLength := MaxPartLength, gsmRuneLength
+	if _, ok := gsmLength(text); !ok {
+		limit, runeLength = MaxUCS2PartLength, ucs2RuneLength
+	}
+
+	var parts []string
+	start, n := 0, 0
+	for i, r := range text {
+		l := runeLength(r)
+		if n+l > limit {
+			parts = append(parts, text[start:i])
+			start, n = i, 0
+		}
+		n += l
+	}
+
+	return append(parts, text[start:])
+}
+
+// ucs2RuneLength returns the number of UCS-2 characters of the character
+func ucs2RuneLength(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+
+	return 1
+}
+
+// sendParts sends the request, one part after the other when its message
+// was split, each part carrying the concatenation header numbering it
+// The result is the one of the first part, for the whole message
+// Once the first part is sent, the failure of a following one results in
+// a partial success with the statusPartiallySent status, and PartsSent
+// telling how many parts were sent, so that the caller does not send again
+// what was already delivered
+func (s *Server) sendParts(req *Request) (Result, error) {
+	if !req.split {
+		return s.sendMessage(req)
+	}
+
+	parts := splitMessage(req.Message)
+	ref := rand.Intn(256)
+	var first Result
+	for i, text := range parts {
+		part := *req
+		part.split = false
+		part.Message = text
+		part.UDH = fmt.Sprintf("050003%02x%02x%02x", ref, len(parts), i+1)
+
+		res, err := s.sendMessage(&part)
+		if err != nil && i == 0 {
+			return Result{}, err
+		}
+		if err != nil {
+			metrics.Add("partial_messages", 1)
+			slog.Error("Failed sending a part of the message", "request", req, "part", i+1, "parts", len(parts), "error", err)
+			first.Status = statusPartiallySent
+			first.PartsSent = i
+			break
+		}
+		if i == 0 {
+			first = res
+		}
+	}
+
+	first.Message = req.Message
+	first.Parts = len(parts)
+
+	return first, nil
+}
@@ -0,0 +1,205 @@
+package sms_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/fixtures"
+)
+
+func TestServer_splitMessages(t *testing.T) {
+	tests := map[string]struct {
+		maxParts   int
+		features   map[string]int
+		fake       bool
+		failPart   int
+		message    string
+		wantStatus int
+		wantError  string
+		wantParts  []string
+		wantSent   int
+		wantCoding string
+	}{
+		"Single message": {
+			maxParts:   3,
+			message:    strings.Repeat("a", sms.MaxMessageLength),
+			wantStatus: http.StatusCreated,
+			wantParts:  []string{strings.Repeat("a", sms.MaxMessageLength)},
+		},
+
+		"GSM-7 message": {
+			maxParts:   3,
+			message:    strings.Repeat("a", sms.MaxMessageLength+1),
+			wantStatus: http.StatusCreated,
+			wantParts:  []string{strings.Repeat("a", sms.MaxPartLength), strings.Repeat("a", sms.MaxMessageLength+1-sms.MaxPartLength)},
+			wantCoding: "plain",
+		},
+
+		"Escaped character kept in one part": {
+			maxParts:   3,
+			message:    strings.Repeat("a", sms.MaxPartLength-1) + "€" + strings.Repeat("a", 10),
+			wantStatus: http.StatusCreated,
+			wantParts:  []string{strings.Repeat("a", sms.MaxPartLength-1), "€" + strings.Repeat("a", 10)},
+			wantCoding: "plain",
+		},
+
+		"UCS-2 message": {
+			maxParts:   3,
+			message:    strings.Repeat("ж", sms.MaxUCS2MessageLength+1),
+			wantStatus: http.StatusCreated,
+			wantParts:  []string{strings.Repeat("ж", sms.MaxUCS2PartLength), strings.Repeat("ж", sms.MaxUCS2MessageLength+1-sms.MaxUCS2PartLength)},
+			wantCoding: "unicode",
+		},
+
+		"Surrogate pair kept in one part": {
+			maxParts:   3,
+			message:    strings.Repeat("ж", sms.MaxUCS2PartLength-1) + "😀" + strings.Repeat("ж", 10),
+			wantStatus: http.StatusCreated,
+			wantParts:  []string{strings.Repeat("ж", sms.MaxUCS2PartLength-1), "😀" + strings.Repeat("ж", 10)},
+			wantCoding: "unicode",
+		},
+
+		"Second part failed": {
+			maxParts:   3,
+			failPart:   2,
+			message:    strings.Repeat("a", sms.MaxMessageLength+1),
+			wantStatus: http.StatusCreated,
+			wantParts:  []string{strings.Repeat("a", sms.MaxPartLength), strings.Repeat("a", sms.MaxMessageLength+1-sms.MaxPartLength)},
+			wantSent:   1,
+			wantCoding: "plain",
+		},
+
+		"Too many parts": {
+			maxParts:   3,
+			message:    strings.Repeat("a", 3*sms.MaxPartLength+1),
+			wantStatus: http.StatusUnprocessableEntity,
+			wantError:  "Invalid parameter (message value is to long for 3 parts)",
+		},
+
+		"Splitting disabled": {
+			message:    strings.Repeat("a", sms.MaxMessageLength+1),
+			wantStatus: http.StatusUnprocessableEntity,
+			wantError:  "Invalid parameter (message value is to long)",
+		},
+
+		"Splitting not rolled out": {
+			maxParts:   3,
+			features:   map[string]int{sms.FeatureAutoSplit: 0},
+			message:    strings.Repeat("a", sms.MaxMessageLength+1),
+			wantStatus: http.StatusUnprocessableEntity,
+			wantError:  "Invalid parameter (message value is to long)",
+		},
+
+		"Provider without concatenated messages": {
+			maxParts:   3,
+			fake:       true,
+			message:    strings.Repeat("a", sms.MaxMessageLength+1),
+			wantStatus: http.StatusUnprocessableEntity,
+			wantError:  "Invalid parameter (message value is to long)",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var mu sync.Mutex
+			var forms []map[string][]string
+			provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if err := r.ParseForm(); err != nil {
+					t.Errorf("Could not parse form; Error: %v", err)
+				}
+				mu.Lock()
+				forms = append(forms, r.PostForm)
+				n := len(forms)
+				mu.Unlock()
+				if n == tc.failPart {
+					w.WriteHeader(http.StatusInternalServerError)
+					w.Write([]byte(`{"errors":[{"code":1,"description":"Internal error","parameter":null}]}`))
+					return
+				}
+				fixtures.MessageCreated.ServeHTTP(w, r)
+			}))
+			defer provider.Close()
+
+			var sender sms.MessageSender = sms.NewClient(sms.Options{BaseURL: provider.URL, Timeout: 10 * time.Second})
+			if tc.fake {
+				sender = fakeSender{}
+			}
+
+			srv := sms.NewServer(sms.Config{
+				Buffer:          10,
+				ReqTimeout:      5 * time.Second,
+				ThrottleRate:    10 * time.Millisecond,
+				MaxMessageParts: tc.maxParts,
+				Features:        tc.features,
+				MessageClient:   sender,
+			})
+			srv.Run()
+
+			payload := fmt.Sprintf(`{"recipient":%d, "originator": %q, "message": %q}`, fixtures.Recipient, fixtures.Originator, tc.message)
+			r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(payload))
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			if w.Code != tc.wantStatus {
+				t.Fatalf("Status code was %d; want %d", w.Code, tc.wantStatus)
+			}
+
+			var smsRes sms.Response
+			if err := json.NewDecoder(w.Body).Decode(&smsRes); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+			if smsRes.Error != tc.wantError {
+				t.Errorf("Error was %q; want %q", smsRes.Error, tc.wantError)
+			}
+			if tc.wantStatus != http.StatusCreated {
+				return
+			}
+
+			wantParts := len(tc.wantParts)
+			if wantParts == 1 {
+				wantParts = 0
+			}
+			if smsRes.Data.Parts != wantParts {
+				t.Errorf("Parts were %d; want %d", smsRes.Data.Parts, wantParts)
+			}
+			if smsRes.Data.PartsSent != tc.wantSent {
+				t.Errorf("Parts sent were %d; want %d", smsRes.Data.PartsSent, tc.wantSent)
+			}
+			if tc.wantSent > 0 && smsRes.Data.Status != "partially_sent" {
+				t.Errorf("Status was %q; want %q", smsRes.Data.Status, "partially_sent")
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(forms) != len(tc.wantParts) {
+				t.Fatalf("Provider got %d messages; want %d", len(forms), len(tc.wantParts))
+			}
+			for i, form := range forms {
+				if got := strings.Join(form["body"], ","); got != tc.wantParts[i] {
+					t.Errorf("Part %d was %q; want %q", i+1, got, tc.wantParts[i])
+				}
+				if got := strings.Join(form["datacoding"], ","); got != tc.wantCoding {
+					t.Errorf("Part %d data coding was %q; want %q", i+1, got, tc.wantCoding)
+				}
+
+				udh := strings.Join(form["typeDetails[udh]"], ",")
+				if len(tc.wantParts) == 1 {
+					if udh != "" {
+						t.Errorf("Single message has udh %q", udh)
+					}
+					continue
+				}
+				if wantSuffix := fmt.Sprintf("%02x%02x", len(tc.wantParts), i+1); !strings.HasPrefix(udh, "050003") || !strings.HasSuffix(udh, wantSuffix) {
+					t.Errorf("Part %d udh was %q; want a concatenation header ending with %s", i+1, udh, wantSuffix)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package sms
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// MessageList is the HTTP response listing a page of messages
+type MessageList struct {
+	Success    bool      `json:"success"`
+	Data       []Content `json:"data"`
+	Limit      int       `json:"limit"`
+	Offset     int       `json:"offset"`
+	TotalCount int       `json:"total_count"`
+}
+
+// messagePage is a page of listed messages along with the total
+// number of messages
+type messagePage struct {
+	results []Result
+	total   int
+}
+
+// messageViewer is implemented by the senders able to look up
+// the current state of a message they created
+type messageViewer interface {
+	viewMessage(ctx context.Context, id string) (Result, error)
+}
+
+// messageLister is implemented by the senders able to list
+// the most recent messages they created
+type messageLister interface {
+	listMessages(ctx context.Context, limit, offset int) ([]Result, int, error)
+}
+
+// messageCanceller is implemented by the senders able to cancel
+// a message they were given, as long as it was not sent yet
+type messageCanceller interface {
+	cancelMessage(ctx context.Context, id string) error
+}
+
+// viewMessage is the HTTP handler answering GET /messages/{id} with
+// the current state of the message as reported by the provider, or by
+// the delivery reports when the provider does not support lookups,
+// along with the timeline of its sending when it is still known
+func (s *Server) viewMessage() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var res Response
+		id := pathParam(r, 1)
+
+		viewer, ok := s.messageClient.(messageViewer)
+		if !ok {
+			// The delivery reports are all there is to know then
+			if content, ok := s.deliveries.get(id); ok {
+				content.Timeline = s.deliveries.timeline(id)
+				res = Response{
+					statusCode: http.StatusOK,
+					Success:    true,
+					Data:       content,
+				}
+				sendCacheable(w, r, res.statusCode, &res)
+				return
+			}
+
+			res = Response{
+				statusCode: http.StatusNotImplemented,
+				Error:      "Not implemented (provider does not support status lookups)",
+			}
+			sendResponse(w, res)
+			return
+		}
+
+		ctx, cancel := s.withTimeout(r.Context(), s.reqTimeout)
+		defer cancel()
+
+		result, err := s.lookups.lookup(w, "message "+id, func() (interface{}, error) {
+			return viewer.viewMessage(ctx, id)
+		})
+		if err != nil {
+			sendResponse(w, lookupError(err, "message "+id))
+			return
+		}
+
+		res = Response{
+			statusCode: http.StatusOK,
+			Success:    true,
+			Data:       s.content(result.(Result)),
+		}
+		res.Data.Timeline = s.deliveries.timeline(id)
+		sendCacheable(w, r, res.statusCode, &res)
+	}
+}
+
+// cancelMessage is the HTTP handler answering DELETE /messages/{id}
+// A message still waiting in the queue is dropped from it, while any other
+// message is deleted through the provider, which only stops it from being
+// sent if it is scheduled
+func (s *Server) cancelMessage() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var res Response
+		id := pathParam(r, 1)
+
+		if req, ok := s.takeQueued(id); ok {
+			metrics.Add("cancelled", 1)
+			slog.Info("Cancelled queued request", "request", req)
+			req.resCh <- Response{
+				statusCode: http.StatusConflict,
+				Error:      "Request cancelled (message was deleted before sending)",
+			}
+
+			res = Response{
+				statusCode: http.StatusOK,
+				Success:    true,
+				Data: Content{
+					ID:         id,
+					Recipient:  req.Recipient,
+					Originator: req.Originator,
+					Message:    req.Message,
+					Status:     "cancelled",
+				},
+			}
+			s.audit(auditCancelled, res.Data)
+			sendResponse(w, res)
+			return
+		}
+
+		canceller, ok := s.messageClient.(messageCanceller)
+		if !ok {
+			res = Response{
+				statusCode: http.StatusNotFound,
+				Error:      "Not found (message is not queued)",
+			}
+			sendResponse(w, res)
+			return
+		}
+
+		ctx, cancel := s.withTimeout(r.Context(), s.reqTimeout)
+		defer cancel()
+
+		if err := canceller.cancelMessage(ctx, id); err != nil {
+			sendResponse(w, lookupError(err, "message "+id))
+			return
+		}
+		s.lookups.forget("message " + id)
+
+		metrics.Add("cancelled", 1)
+		res = Response{
+			statusCode: http.StatusOK,
+			Success:    true,
+			Data:       Content{ID: id, Status: "cancelled"},
+		}
+		s.audit(auditCancelled, res.Data)
+		sendResponse(w, res)
+	}
+}
+
+// listMessages is the HTTP handler answering GET /messages?limit=&offset=
+// with a page of the most recent messages, as reported by the provider
+func (s *Server) listMessages() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var res Response
+		q := r.URL.Query()
+
+		limit := defaultPageLimit
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxPageLimit {
+				res = Response{
+					statusCode: http.StatusBadRequest,
+					Error:      fmt.Sprintf("Bad request (limit must be between 1 and %d)", maxPageLimit),
+				}
+				sendResponse(w, res)
+				return
+			}
+			limit = n
+		}
+
+		offset := 0
+		if v := q.Get("offset"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				res = Response{
+					statusCode: http.StatusBadRequest,
+					Error:      "Bad request (offset must not be negative)",
+				}
+				sendResponse(w, res)
+				return
+			}
+			offset = n
+		}
+
+		lister, ok := s.messageClient.(messageLister)
+		if !ok {
+			res = Response{
+				statusCode: http.StatusNotImplemented,
+				Error:      "Not implemented (provider does not support listing messages)",
+			}
+			sendResponse(w, res)
+			return
+		}
+
+		ctx, cancel := s.withTimeout(r.Context(), s.reqTimeout)
+		defer cancel()
+
+		v, err := s.lookups.lookup(w, fmt.Sprintf("message list %d %d", limit, offset), func() (interface{}, error) {
+			results, total, err := lister.listMessages(ctx, limit, offset)
+			return messagePage{results, total}, err
+		})
+		if err != nil {
+			sendResponse(w, lookupError(err, "message list"))
+			return
+		}
+		results, total := v.(messagePage).results, v.(messagePage).total
+
+		list := MessageList{
+			Success:    true,
+			Data:       make([]Content, 0, len(results)),
+			Limit:      limit,
+			Offset:     offset,
+			TotalCount: total,
+		}
+		for _, result := range results {
+			list.Data = append(list.Data, s.content(result))
+		}
+
+		sendCacheable(w, r, http.StatusOK, list)
+	}
+}
+
+// lookupError turns the error of a provider lookup into a response
+func lookupError(err error, what string) Response {
+	switch e := err.(type) {
+	case *ProviderError:
+		metrics.Add("provider_errors_"+e.Kind.String(), 1)
+		return Response{
+			statusCode: e.StatusCode,
+			Error:      e.Description(),
+		}
+	case *ContractError:
+		metrics.Add("contract_violations", 1)
+		slog.Error("Unexpected API response", "lookup", what, "error", e)
+		return Response{
+			statusCode: http.StatusBadGateway,
+			Error:      "Bad gateway (provider contract violation)",
+		}
+	}
+
+	slog.Error("Failed looking up SMS through API", "lookup", what, "error", err)
+	return Response{
+		statusCode: http.StatusInternalServerError,
+		Error:      "Internal error (API request failed)",
+	}
+}
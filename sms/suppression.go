@@ -0,0 +1,95 @@
+package sms
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// MaxSuppressionChecks is the number of recipients checked at most
+// by a request of the suppression check endpoint
+const MaxSuppressionChecks = 1000
+
+// Statuses of the checked recipients
+// Invalid recipients are refused by the validation of the messages,
+// suppressed ones were recently confirmed invalid by the provider and
+// blocked ones reached the recipient limits for now
+const (
+	RecipientOK         = "ok"
+	RecipientInvalid    = "invalid"
+	RecipientSuppressed = "suppressed"
+	RecipientBlocked    = "blocked"
+)
+
+// SuppressionCheckRequest is the payload of the suppression check endpoint
+type SuppressionCheckRequest struct {
+	Recipients []PhoneNumber `json:"recipients"`
+}
+
+// RecipientCheck is the status of a checked recipient and the reason
+// a message sent to it would be refused
+type RecipientCheck struct {
+	Recipient PhoneNumber `json:"recipient"`
+	Status    string      `json:"status"`
+	Reason    string      `json:"reason,omitempty"`
+}
+
+// SuppressionCheck is the HTTP response of the suppression check endpoint,
+// with the recipients in the order they were given
+type SuppressionCheck struct {
+	Success bool             `json:"success"`
+	Data    []RecipientCheck `json:"data"`
+}
+
+// checkRecipient tells whether a message to the recipient would be
+// accepted, without counting towards its limits
+func (s *Server) checkRecipient(recipient PhoneNumber) RecipientCheck {
+	c := RecipientCheck{Recipient: recipient, Status: RecipientOK}
+	if problem := recipientProblem(recipient); problem != "" {
+		c.Status, c.Reason = RecipientInvalid, problem
+		return c
+	}
+	if status, ok := s.numbers.get(recipient); ok && status == numberInvalid {
+		c.Status, c.Reason = RecipientSuppressed, "recipient was recently confirmed invalid"
+		return c
+	}
+	if rl, ok := s.rcptLimiter.limited(recipient.msisdn(), s.clock.Now()); ok {
+		c.Status = RecipientBlocked
+		c.Reason = fmt.Sprintf("at most %d messages per %s for recipient", rl.Count, rl.Window)
+	}
+
+	return c
+}
+
+// checkSuppressions is the HTTP handler checking a list of recipients,
+// so that campaign tools can leave out those a batch would fail for
+func (s *Server) checkSuppressions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req SuppressionCheckRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			res := Response{
+				statusCode: http.StatusBadRequest,
+				Error:      "Bad request (invalid payload json structure)",
+			}
+			sendResponse(w, res)
+			return
+		}
+
+		if len(req.Recipients) == 0 || len(req.Recipients) > MaxSuppressionChecks {
+			res := Response{
+				statusCode: http.StatusUnprocessableEntity,
+				Error:      fmt.Sprintf("Invalid parameter (recipients value must list between 1 and %d numbers)", MaxSuppressionChecks),
+			}
+			sendResponse(w, res)
+			return
+		}
+
+		checks := make([]RecipientCheck, len(req.Recipients))
+		for i, recipient := range req.Recipients {
+			checks[i] = s.checkRecipient(recipient)
+			metrics.Add("suppression_checks_"+checks[i].Status, 1)
+		}
+
+		sendJSON(w, http.StatusOK, SuppressionCheck{Success: true, Data: checks})
+	}
+}
@@ -0,0 +1,92 @@
+package sms_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+)
+
+func TestServer_checkSuppressions(t *testing.T) {
+	testServer := sms.NewTestServer(t, "server_key")
+	defer testServer.Close()
+
+	srv := sms.NewServer(sms.Config{
+		Buffer:          10,
+		ReqTimeout:      5 * time.Second,
+		ThrottleRate:    50 * time.Millisecond,
+		NumberCacheTTL:  time.Minute,
+		RecipientLimits: []sms.RateLimit{{Count: 1, Window: time.Minute}},
+		MessageClient: sms.NewClient(sms.Options{
+			BaseURL:   testServer.URL,
+			AccessKey: "server_key",
+			Timeout:   10 * time.Second,
+		}),
+	})
+	srv.Run()
+
+	// The first recipient reaches its limit and the provider refuses the second
+	for _, recipient := range []string{"31612345678", "9991234567"} {
+		r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(`{"recipient":`+recipient+`, "originator": "MessageBird", "message": "This is a test message"}`))
+		srv.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	tests := map[string]struct {
+		payload    string
+		wantStatus int
+		want       []sms.RecipientCheck
+	}{
+		"Recipients checked": {
+			payload:    `{"recipients": ["+31 6 1234 5678", 9991234567, "not a number", "+31687654321"]}`,
+			wantStatus: http.StatusOK,
+			want: []sms.RecipientCheck{
+				{Recipient: "+31612345678", Status: sms.RecipientBlocked, Reason: "at most 1 messages per 1m0s for recipient"},
+				{Recipient: "9991234567", Status: sms.RecipientSuppressed, Reason: "recipient was recently confirmed invalid"},
+				{Recipient: "not a number", Status: sms.RecipientInvalid, Reason: "recipient value is not a phone number"},
+				{Recipient: "+31687654321", Status: sms.RecipientOK},
+			},
+		},
+
+		"No recipients": {
+			payload:    `{"recipients": []}`,
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+
+		"Invalid payload": {
+			payload:    `{"recipients": "31612345678"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/suppressions/check", strings.NewReader(tc.payload))
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			if w.Code != tc.wantStatus {
+				t.Fatalf("Status code was %d; want %d", w.Code, tc.wantStatus)
+			}
+			if tc.want == nil {
+				return
+			}
+
+			var res sms.SuppressionCheck
+			if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+			if len(res.Data) != len(tc.want) {
+				t.Fatalf("Got %d checks; want %d", len(res.Data), len(tc.want))
+			}
+			for i, want := range tc.want {
+				if res.Data[i] != want {
+					t.Errorf("Check %d was %+v; want %+v", i, res.Data[i], want)
+				}
+			}
+		})
+	}
+}
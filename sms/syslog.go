@@ -0,0 +1,406 @@
+package sms
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultAlertOriginator = "flysms"
+	defaultAlertTemplate   = "[{{.SeverityName}}] {{with .Host}}{{.}} {{end}}{{with .App}}{{.}}: {{end}}{{.Text}}"
+	maxSyslogLineBytes     = 8 * 1024
+	maxSyslogInFlight      = 100
+)
+
+// Syslog severities, from the most to the least severe
+var syslogSeverities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// syslogNotice is the severity of the lines which carry none
+const syslogNotice = 5
+
+// Alert is a syslog message or a plain line received by the syslog
+// listeners, and the data of the alert route templates
+type Alert struct {
+	Facility int
+	Severity int
+	Host     string
+	App      string
+	Text     string
+	Received time.Time
+}
+
+// SeverityName returns the name of the severity of the alert, such as "crit"
+func (a Alert) SeverityName() string {
+	return syslogSeverities[a.Severity]
+}
+
+// AlertRoute sends the alerts it matches to its recipients, or through
+// the escalation policy of the given name until someone acknowledges them
+// Empty fields match any alert; Severity is the least severe one matched,
+// such as "err" for err, crit, alert and emerg, and Pattern a regular
+// expression matched against the text of the alert
+// Template is a text/template executed with the Alert to build the message
+type AlertRoute struct {
+	Severity   string        `json:"severity"`
+	Host       string        `json:"host"`
+	App        string        `json:"app"`
+	Pattern    string        `json:"pattern"`
+	Recipients []PhoneNumber `json:"recipients"`
+	Escalation string        `json:"escalation,omitempty"`
+	Originator string        `json:"originator"`
+	Template   string        `json:"template"`
+}
+
+// alertRoute is an alert route ready to match alerts
+type alertRoute struct {
+	AlertRoute
+	severity int
+	pattern  *regexp.Regexp
+	tmpl     *template.Template
+}
+
+// compileAlertRoutes checks the alert routes against the escalation
+// policies and prepares them
+func compileAlertRoutes(routes []AlertRoute, escalations *escalationStore) ([]alertRoute, error) {
+	compiled := make([]alertRoute, 0, len(routes))
+	for i, route := range routes {
+		r := alertRoute{AlertRoute: route, severity: len(syslogSeverities) - 1}
+		if r.Originator == "" {
+			r.Originator = defaultAlertOriginator
+		}
+		if len(r.Recipients) == 0 && r.Escalation == "" {
+			return nil, fmt.Errorf("Alert route %d has no recipients", i)
+		}
+		if _, ok := escalations.policy(r.Escalation); r.Escalation != "" && !ok {
+			return nil, fmt.Errorf("Alert route %d has unknown escalation policy %q", i, r.Escalation)
+		}
+
+		if r.Severity != "" {
+			r.severity = severityOf(r.Severity)
+			if r.severity < 0 {
+				return nil, fmt.Errorf("Alert route %d has invalid severity %q", i, r.Severity)
+			}
+		}
+
+		if r.Pattern != "" {
+			pattern, err := regexp.Compile(r.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("Alert route %d has invalid pattern; Error: %v", i, err)
+			}
+			r.pattern = pattern
+		}
+
+		text := r.Template
+		if text == "" {
+			text = defaultAlertTemplate
+		}
+		tmpl, err := template.New(fmt.Sprintf("route%d", i)).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("Alert route %d has invalid template; Error: %v", i, err)
+		}
+		r.tmpl = tmpl
+
+		compiled = append(compiled, r)
+	}
+
+	return compiled, nil
+}
+
+// matches reports whether the route matches the alert
+func (r *alertRoute) matches(a Alert) bool {
+	return a.Severity <= r.severity &&
+		(r.Host == "" || strings.EqualFold(r.Host, a.Host)) &&
+		(r.App == "" || r.App == a.App) &&
+		(r.pattern == nil || r.pattern.MatchString(a.Text))
+}
+
+// severityOf returns the severity of the name, -1 when it is none
+func severityOf(name string) int {
+	for i, n := range syslogSeverities {
+		if strings.EqualFold(n, name) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// ListenAndServeSyslog listens for alerts on the udp or tcp address
+// and sends them as messages; see ServeSyslog
+func (s *Server) ListenAndServeSyslog(network, addr string) error {
+	if strings.HasPrefix(network, "udp") {
+		c, err := net.ListenPacket(network, addr)
+		if err != nil {
+			return err
+		}
+		return s.ServeSyslogPacket(c)
+	}
+
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+
+	return s.ServeSyslog(l)
+}
+
+// ServeSyslog accepts syslog streams on the listener, turning the alerts
+// of monitoring systems into messages
+// Alerts are syslog messages (RFC 5424 or RFC 3164) or plain lines of text,
+// which are taken as notices, either newline separated or octet counted
+// (RFC 6587), and are sent to the recipients of the first of Config.AlertRoutes
+// they match, through the same validation and queue as the messages of
+// /messages; alerts too long for a message are cut
+// There is neither authentication nor encryption, so the listener
+// is meant for trusted networks only
+func (s *Server) ServeSyslog(l net.Listener) error {
+	defer l.Close()
+
+	routes, err := compileAlertRoutes(s.alertRoutes, s.escalations)
+	if err != nil {
+		return err
+	}
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveSyslogConn(conn, routes)
+	}
+}
+
+// ServeSyslogPacket reads syslog datagrams, one alert each,
+// from the connection; see ServeSyslog
+// Alerts coming faster than they can be sent are dropped
+func (s *Server) ServeSyslogPacket(c net.PacketConn) error {
+	defer c.Close()
+
+	routes, err := compileAlertRoutes(s.alertRoutes, s.escalations)
+	if err != nil {
+		return err
+	}
+
+	inFlight := make(chan struct{}, maxSyslogInFlight)
+	buf := make([]byte, maxSyslogLineBytes)
+	for {
+		n, addr, err := c.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case inFlight <- struct{}{}:
+		default:
+			metrics.Add("alerts_dropped", 1)
+			continue
+		}
+
+		line := string(buf[:n])
+		go func() {
+			defer func() { <-inFlight }()
+			s.handleAlert(routes, addr.String(), line)
+		}()
+	}
+}
+
+// serveSyslogConn reads the alerts of a syslog stream
+func (s *Server) serveSyslogConn(conn net.Conn, routes []alertRoute) {
+	defer conn.Close()
+
+	br := bufio.NewReaderSize(conn, maxSyslogLineBytes)
+	for {
+		line, err := readSyslogFrame(br)
+		if err != nil {
+			if err != io.EOF {
+				slog.Error("Could not read syslog stream", "remote", conn.RemoteAddr().String(), "error", err)
+			}
+			return
+		}
+		s.handleAlert(routes, conn.RemoteAddr().String(), line)
+	}
+}
+
+// readSyslogFrame reads the next frame of a syslog stream, which is
+// either octet counted ("<length> <message>") or ends with a newline
+func readSyslogFrame(br *bufio.Reader) (string, error) {
+	for {
+		if _, err := br.Peek(1); err != nil {
+			return "", err
+		}
+
+		if octetCounted(br) {
+			count, err := br.ReadString(' ')
+			if err != nil {
+				return "", err
+			}
+			n, err := strconv.Atoi(strings.TrimSuffix(count, " "))
+			if err != nil || n > maxSyslogLineBytes {
+				return "", fmt.Errorf("invalid frame length %q", count)
+			}
+			frame := make([]byte, n)
+			if _, err := io.ReadFull(br, frame); err != nil {
+				return "", err
+			}
+			return string(frame), nil
+		}
+
+		line, err := br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			return "", fmt.Errorf("line longer than %d bytes", maxSyslogLineBytes)
+		}
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return "", err
+		}
+		if line = bytes.TrimRight(line, "\r\n\x00"); len(line) > 0 {
+			return string(line), nil
+		}
+	}
+}
+
+// octetCounted reports whether the next frame of the stream starts with
+// its length, such as "57 <34>1 ...", rather than being a plain line
+// which happens to start with a number
+func octetCounted(br *bufio.Reader) bool {
+	b, _ := br.Peek(br.Buffered())
+	i := 0
+	for i < len(b) && i < 6 && b[i] >= '0' && b[i] <= '9' {
+		i++
+	}
+
+	return i > 0 && b[0] != '0' && i+1 < len(b) && b[i] == ' ' && b[i+1] == '<'
+}
+
+// handleAlert sends the alert of the line to the recipients of its route
+func (s *Server) handleAlert(routes []alertRoute, remoteAddr, line string) {
+	alert := parseAlert(line, s.clock.Now())
+
+	var route *alertRoute
+	for i := range routes {
+		if routes[i].matches(alert) {
+			route = &routes[i]
+			break
+		}
+	}
+	if route == nil {
+		metrics.Add("alerts_unrouted", 1)
+		return
+	}
+
+	var text strings.Builder
+	if err := route.tmpl.Execute(&text, alert); err != nil {
+		metrics.Add("alerts_failed", 1)
+		slog.Error("Could not execute alert template", "error", err)
+		return
+	}
+
+	s.dispatchAlert(context.Background(), remoteAddr, route.Recipients, route.Escalation, route.Originator, text.String())
+}
+
+// parseAlert parses a syslog message, RFC 5424 or RFC 3164, or a plain line
+func parseAlert(line string, received time.Time) Alert {
+	alert := Alert{Facility: 1, Severity: syslogNotice, Received: received}
+
+	rest, ok := syslogPriority(line, &alert)
+	if !ok {
+		alert.Text = strings.TrimSpace(line)
+		return alert
+	}
+
+	if strings.HasPrefix(rest, "1 ") {
+		// TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+		fields := strings.SplitN(rest[2:], " ", 6)
+		for len(fields) < 6 {
+			fields = append(fields, "")
+		}
+		alert.Host = syslogField(fields[1])
+		alert.App = syslogField(fields[2])
+		alert.Text = strings.TrimPrefix(strings.TrimSpace(skipStructuredData(fields[5])), "\ufeff")
+		return alert
+	}
+
+	// TIMESTAMP HOSTNAME TAG: MSG, the timestamp and hostname being
+	// optional for the senders which leave them to relays
+	if len(rest) > len(time.Stamp) {
+		if _, err := time.Parse(time.Stamp, rest[:len(time.Stamp)]); err == nil {
+			rest = strings.TrimLeft(rest[len(time.Stamp):], " ")
+			if i := strings.IndexByte(rest, ' '); i > 0 {
+				alert.Host, rest = rest[:i], rest[i+1:]
+			}
+		}
+	}
+	if i := strings.Index(rest, ": "); i > 0 && !strings.ContainsAny(rest[:i], " ") {
+		alert.App, rest = rest[:i], rest[i+2:]
+		if j := strings.IndexByte(alert.App, '['); j > 0 {
+			alert.App = alert.App[:j]
+		}
+	}
+	alert.Text = strings.TrimSpace(rest)
+
+	return alert
+}
+
+// syslogPriority sets the facility and severity of the alert from the
+// priority the line starts with, such as <34>, and returns what follows it
+func syslogPriority(line string, alert *Alert) (string, bool) {
+	end := strings.IndexByte(line, '>')
+	if !strings.HasPrefix(line, "<") || end < 2 || end > 4 {
+		return "", false
+	}
+
+	pri, err := strconv.Atoi(line[1:end])
+	if err != nil || pri > 191 {
+		return "", false
+	}
+	alert.Facility, alert.Severity = pri/8, pri%8
+
+	return line[end+1:], true
+}
+
+// syslogField returns the value of an RFC 5424 header field,
+// empty for the nil value "-"
+func syslogField(field string) string {
+	if field == "-" {
+		return ""
+	}
+	return field
+}
+
+// skipStructuredData returns what follows the RFC 5424 structured data,
+// either the nil value "-" or elements such as [id key="value"]
+func skipStructuredData(s string) string {
+	if strings.HasPrefix(s, "-") {
+		return s[1:]
+	}
+	if !strings.HasPrefix(s, "[") {
+		return s
+	}
+
+	inValue, escaped := false, false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '"':
+			inValue = !inValue
+		case c == ']' && !inValue:
+			if i+1 == len(s) || s[i+1] != '[' {
+				return s[i+1:]
+			}
+		}
+	}
+
+	return ""
+}
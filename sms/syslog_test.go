@@ -0,0 +1,162 @@
+package sms_test
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/fixtures"
+)
+
+func TestServer_ServeSyslog(t *testing.T) {
+	routes := []sms.AlertRoute{
+		{Severity: "crit", Host: "db1", Recipients: []sms.PhoneNumber{"31611111111", "31622222222"}},
+		{Pattern: "^disk", Recipients: []sms.PhoneNumber{"31633333333"}, Originator: "Ops", Template: "{{.SeverityName}}: {{.Text}}"},
+		{Severity: "err", Recipients: []sms.PhoneNumber{"31644444444"}},
+	}
+
+	tests := map[string]struct {
+		network      string
+		lines        string
+		wantMessages []string
+	}{
+		"RFC 5424 message": {
+			network: "tcp",
+			lines:   `<10>1 2024-05-01T10:00:00Z db1 postgres 123 - [meta x="a\]b"] replication stopped` + "\n",
+			wantMessages: []string{
+				"31611111111 flysms: [crit] db1 postgres: replication stopped",
+				"31622222222 flysms: [crit] db1 postgres: replication stopped",
+			},
+		},
+
+		"RFC 3164 message": {
+			network:      "tcp",
+			lines:        "<11>May  1 10:00:00 web2 nginx[42]: upstream timed out\n",
+			wantMessages: []string{"31644444444 flysms: [err] web2 nginx: upstream timed out"},
+		},
+
+		"Octet counted message": {
+			network:      "tcp",
+			lines:        "37 <11>1 - web2 nginx - - - 502 returned",
+			wantMessages: []string{"31644444444 flysms: [err] web2 nginx: 502 returned"},
+		},
+
+		"Plain line": {
+			network:      "tcp",
+			lines:        "\r\ndisk full on web3\r\n",
+			wantMessages: []string{"31633333333 Ops: notice: disk full on web3"},
+		},
+
+		"Datagram": {
+			network:      "udp",
+			lines:        "<9>May  1 10:00:00 db1 kernel: out of memory",
+			wantMessages: []string{"31611111111 flysms: [alert] db1 kernel: out of memory", "31622222222 flysms: [alert] db1 kernel: out of memory"},
+		},
+
+		"Unrouted message": {
+			network: "tcp",
+			lines:   "<14>1 2024-05-01T10:00:00Z web2 cron - - - job done\n",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var mu sync.Mutex
+			var messages []string
+			provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				messages = append(messages, fmt.Sprintf("%s %s: %s", r.FormValue("recipients"), r.FormValue("originator"), r.FormValue("body")))
+				mu.Unlock()
+				fixtures.MessageCreated.ServeHTTP(w, r)
+			}))
+			defer provider.Close()
+
+			srv := sms.NewServer(sms.Config{
+				Buffer:        10,
+				ReqTimeout:    5 * time.Second,
+				ThrottleRate:  10 * time.Millisecond,
+				AlertRoutes:   routes,
+				MessageClient: sms.NewClient(sms.Options{BaseURL: provider.URL, Timeout: 10 * time.Second}),
+			})
+			srv.Run()
+
+			var addr string
+			if tc.network == "udp" {
+				c, err := net.ListenPacket("udp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatalf("Could not listen for alerts; Error: %v", err)
+				}
+				go srv.ServeSyslogPacket(c)
+				defer c.Close()
+				addr = c.LocalAddr().String()
+			} else {
+				l, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatalf("Could not listen for alerts; Error: %v", err)
+				}
+				go srv.ServeSyslog(l)
+				defer l.Close()
+				addr = l.Addr().String()
+			}
+
+			conn, err := net.Dial(tc.network, addr)
+			if err != nil {
+				t.Fatalf("Could not connect to the syslog listener; Error: %v", err)
+			}
+			if _, err := conn.Write([]byte(tc.lines)); err != nil {
+				t.Fatalf("Could not send alert; Error: %v", err)
+			}
+			conn.Close()
+
+			// Alerts matching no route send nothing, which is waited for briefly
+			wait := 2 * time.Second
+			if len(tc.wantMessages) == 0 {
+				wait = 100 * time.Millisecond
+			}
+
+			var got []string
+			for deadline := time.Now().Add(wait); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+				mu.Lock()
+				got = append(got[:0], messages...)
+				mu.Unlock()
+				if len(tc.wantMessages) > 0 && len(got) >= len(tc.wantMessages) {
+					break
+				}
+			}
+
+			if len(got) != len(tc.wantMessages) || len(got) > 0 && !reflect.DeepEqual(got, tc.wantMessages) {
+				t.Errorf("Provider got %q; want %q", got, tc.wantMessages)
+			}
+		})
+	}
+}
+
+func TestServer_ServeSyslog_invalidRoutes(t *testing.T) {
+	tests := map[string]sms.AlertRoute{
+		"No recipients":    {Severity: "err"},
+		"Unknown severity": {Severity: "fatal", Recipients: []sms.PhoneNumber{"31612345678"}},
+		"Invalid pattern":  {Pattern: "(", Recipients: []sms.PhoneNumber{"31612345678"}},
+		"Invalid template": {Template: "{{.Text", Recipients: []sms.PhoneNumber{"31612345678"}},
+	}
+
+	for name, route := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := sms.NewServer(sms.Config{AlertRoutes: []sms.AlertRoute{route}})
+
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Could not listen for alerts; Error: %v", err)
+			}
+			if err := srv.ServeSyslog(l); err == nil || !strings.HasPrefix(err.Error(), "Alert route 0") {
+				t.Errorf("Serving syslog failed with %v; want an invalid route error", err)
+			}
+		})
+	}
+}
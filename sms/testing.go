@@ -1,14 +1,20 @@
 package sms
 
 import (
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/iulianclita/flysms/sms/fixtures"
 )
 
 const keyHeaderName = "AccessKey"
@@ -19,10 +25,121 @@ const keyHeaderName = "AccessKey"
 // The server would normally need to treat also the error cases when the payload
 // contains invalid input. This test server is oversimplified also because of the fact
 // that the application does input validation before hiting the API.
+// Recipients starting with 999 are rejected as invalid, mimicking messagebird
+// answering with error code 9 for numbers that cannot be delivered to.
 // Parameter accessKey is what is considered by the test server to be the right access key
 func NewTestServer(t *testing.T, accessKey string) *httptest.Server {
 	t.Helper()
 
+	return httptest.NewServer(testHandler(t, accessKey))
+}
+
+// NewTLSTestServer starts a new development server serving HTTPS
+// It behaves like the server started by NewTestServer
+// The certificate of the server is available through its Certificate method
+func NewTLSTestServer(t *testing.T, accessKey string) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewTLSServer(testHandler(t, accessKey))
+}
+
+// TestServerOptions simulates the behaviour of a busy provider
+// Every response is delayed by Latency, and requests beyond RequestsPerSecond
+// in the same second are answered 429, as messagebird does when throttling
+type TestServerOptions struct {
+	Latency           time.Duration
+	RequestsPerSecond int
+}
+
+// NewTestServerWith starts a development server like NewTestServer,
+// simulating provider latency and throttling as given by the options
+func NewTestServerWith(t *testing.T, accessKey string, opts TestServerOptions) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewServer(simulatedHandler(testHandler(t, accessKey), opts))
+}
+
+// simulatedHandler delays and throttles the requests before the handler
+// gets them
+func simulatedHandler(h http.Handler, opts TestServerOptions) http.Handler {
+	var mu sync.Mutex
+	var window time.Time
+	var count int
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if opts.RequestsPerSecond > 0 {
+			mu.Lock()
+			now := time.Now().Truncate(time.Second)
+			if !now.Equal(window) {
+				window, count = now, 0
+			}
+			count++
+			throttled := count > opts.RequestsPerSecond
+			mu.Unlock()
+
+			if throttled {
+				fixtures.Throttled.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		if opts.Latency > 0 {
+			select {
+			case <-time.After(opts.Latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// NewFixtureServer starts a server answering with the captured provider
+// responses, in the given order, one per request
+// Once they are all used, the last one is repeated
+func NewFixtureServer(t *testing.T, responses ...fixtures.Fixture) *httptest.Server {
+	t.Helper()
+
+	if len(responses) == 0 {
+		t.Fatal("No fixtures to serve")
+	}
+
+	var mu sync.Mutex
+	next := 0
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		f := responses[next]
+		if next < len(responses)-1 {
+			next++
+		}
+		mu.Unlock()
+
+		f.ServeHTTP(w, r)
+	}))
+}
+
+// testHandler mimics the messagebird API for the development servers
+// Created messages are kept so that they can be viewed and listed, newest
+// first, as delivered, until they are deleted
+func testHandler(t *testing.T, accessKey string) http.Handler {
+	var mu sync.Mutex
+	created := make(map[string]MessageCreated)
+	var order []string
+
+	delivered := func(msg MessageCreated) MessageCreated {
+		msg.Recipients.TotalDeliveredCount = 1
+		msg.Recipients.Items = []MessageItem{
+			{
+				Recipient:      msg.Recipients.Items[0].Recipient,
+				Status:         "delivered",
+				StatusDateTime: time.Now(),
+			},
+		}
+		return msg
+	}
+
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		errCodes := make(map[int]MessageError)
 		var errRes MessageErrors
@@ -77,20 +194,145 @@ func NewTestServer(t *testing.T, accessKey string) *httptest.Server {
 			return
 		}
 
+		if r.URL.Path == "/balance" {
+			w.Header().Set("Content-Type", "application/json")
+			balance := Balance{Payment: "prepaid", Type: "credits", Amount: 9.2}
+			if err := json.NewEncoder(w).Encode(&balance); err != nil {
+				t.Fatalf("Could not encode value %#v; Error: %v", balance, err)
+			}
+			return
+		}
+
+		if r.Method == http.MethodGet && r.URL.Path == "/messages" {
+			limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+			offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+
+			mu.Lock()
+			page := MessagePage{Offset: offset, Limit: limit, TotalCount: len(order), Items: []MessageCreated{}}
+			for i := len(order) - 1 - offset; i >= 0 && len(page.Items) < limit; i-- {
+				page.Items = append(page.Items, delivered(created[order[i]]))
+			}
+			page.Count = len(page.Items)
+			mu.Unlock()
+
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(&page); err != nil {
+				t.Fatalf("Could not encode value %#v; Error: %v", page, err)
+			}
+			return
+		}
+
+		if r.Method == http.MethodGet || r.Method == http.MethodDelete {
+			id := strings.TrimPrefix(r.URL.Path, "/messages/")
+			mu.Lock()
+			msg, ok := created[id]
+			if ok && r.Method == http.MethodDelete {
+				delete(created, id)
+				for i := range order {
+					if order[i] == id {
+						order = append(order[:i], order[i+1:]...)
+						break
+					}
+				}
+			}
+			mu.Unlock()
+
+			w.Header().Set("Accept", "application/json")
+			w.Header().Set("Content-Type", "application/json")
+			if !ok {
+				errRes.Errors = append(errRes.Errors, MessageError{
+					Code:        errCodeNotFound,
+					Description: "message not found",
+					Parameter:   "id",
+				})
+				w.WriteHeader(http.StatusNotFound)
+				if err := json.NewEncoder(w).Encode(&errRes); err != nil {
+					t.Fatalf("Could not encode value %#v; Error: %v", errRes, err)
+				}
+				return
+			}
+
+			if r.Method == http.MethodDelete {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			msg = delivered(msg)
+			if err := json.NewEncoder(w).Encode(&msg); err != nil {
+				t.Fatalf("Could not encode value %#v; Error: %v", msg, err)
+			}
+			return
+		}
+
+		if r.URL.Path == "/v1/send" {
+			var msg ConversationMessage
+			if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+				t.Fatalf("Could not decode conversation message; Error: %v", err)
+			}
+
+			sent := ConversationSent{ID: fmt.Sprintf("%d", time.Now().UnixNano()), Status: "accepted"}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			if err := json.NewEncoder(w).Encode(&sent); err != nil {
+				t.Fatalf("Could not encode value %#v; Error: %v", sent, err)
+			}
+			return
+		}
+
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Fatalf("Could not read compressed request body; Error: %v", err)
+			}
+			r.Body = ioutil.NopCloser(zr)
+		}
+
 		if err := r.ParseForm(); err != nil {
 			t.Fatalf("Could not parse incoming form request %#v; Error: %v", r, err)
 		}
 
-		recp, err := strconv.ParseInt(r.FormValue("recipients"), 10, 64)
-		if err != nil {
-			t.Fatalf("Could not convert recipients to int64 %s; Error: %v", r.FormValue("recipients"), err)
+		recp := PhoneNumber(r.FormValue("recipients"))
+		if !recp.wellFormed() {
+			t.Fatalf("Could not convert recipients to a phone number %s", r.FormValue("recipients"))
+		}
+
+		if strings.HasPrefix(r.FormValue("recipients"), "999") {
+			errRes.Errors = append(errRes.Errors, MessageError{
+				Code:        errCodeRecipients,
+				Description: "no (correct) recipients found",
+				Parameter:   "recipient",
+			})
+
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Header().Set("Accept", "application/json")
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(&errRes); err != nil {
+				t.Fatalf("Could not encode value %#v; Error: %v", errRes, err)
+			}
+
+			return
+		}
+
+		status := "sent"
+		if r.URL.Path == "/voicemessages" {
+			status = "calling"
+		}
+		var scheduled *time.Time
+		if v := r.FormValue("scheduledDatetime"); v != "" {
+			at, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				t.Fatalf("Could not parse scheduledDatetime %s; Error: %v", v, err)
+			}
+			status = "scheduled"
+			scheduled = &at
 		}
 
 		okRes := MessageCreated{
-			ID:              fmt.Sprintf("%d", time.Now().UnixNano()),
-			Originator:      r.FormValue("originator"),
-			Body:            r.FormValue("body"),
-			CreatedDateTime: time.Now(),
+			ID:                fmt.Sprintf("%d", time.Now().UnixNano()),
+			Originator:        r.FormValue("originator"),
+			Body:              r.FormValue("body"),
+			CreatedDateTime:   time.Now(),
+			ScheduledDateTime: scheduled,
 			Recipients: MessageRecipients{
 				TotalSentCount:           1,
 				TotalDeliveredCount:      0,
@@ -98,13 +340,18 @@ func NewTestServer(t *testing.T, accessKey string) *httptest.Server {
 				Items: []MessageItem{
 					{
 						Recipient:      recp,
-						Status:         "sent",
+						Status:         status,
 						StatusDateTime: time.Now(),
 					},
 				},
 			},
 		}
 
+		mu.Lock()
+		created[okRes.ID] = okRes
+		order = append(order, okRes.ID)
+		mu.Unlock()
+
 		w.WriteHeader(http.StatusCreated)
 		w.Header().Set("Accept", "application/json")
 		w.Header().Set("Content-Type", "application/json")
@@ -115,6 +362,281 @@ func NewTestServer(t *testing.T, accessKey string) *httptest.Server {
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/messages", fn)
+	mux.HandleFunc("/messages/", fn)
+	mux.HandleFunc("/balance", fn)
+	mux.HandleFunc("/voicemessages", fn)
+	mux.HandleFunc("/v1/send", fn)
+
+	return mux
+}
+
+// NewTwilioTestServer starts a new development server mimicking the twilio
+// create message API for the given account and auth token
+// Recipients starting with +999 are rejected as invalid phone numbers
+func NewTwilioTestServer(t *testing.T, accountSID, authToken string) *httptest.Server {
+	t.Helper()
+
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		sendError := func(statusCode, code int, message string) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(statusCode)
+			errRes := TwilioError{Code: code, Message: message, Status: statusCode}
+			if err := json.NewEncoder(w).Encode(&errRes); err != nil {
+				t.Fatalf("Could not encode value %#v; Error: %v", errRes, err)
+			}
+		}
+
+		sid, token, ok := r.BasicAuth()
+		if !ok || sid != accountSID || token != authToken {
+			sendError(http.StatusUnauthorized, 20003, "Authenticate")
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("Could not parse incoming form request %#v; Error: %v", r, err)
+		}
+
+		if strings.HasPrefix(r.FormValue("To"), "+999") {
+			sendError(http.StatusBadRequest, twilioCodeInvalidTo, fmt.Sprintf("The 'To' number %s is not a valid phone number.", r.FormValue("To")))
+			return
+		}
+
+		okRes := TwilioMessage{
+			SID:         fmt.Sprintf("SM%d", time.Now().UnixNano()),
+			To:          r.FormValue("To"),
+			From:        r.FormValue("From"),
+			Body:        r.FormValue("Body"),
+			Status:      "queued",
+			DateCreated: time.Now().Format(time.RFC1123Z),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(&okRes); err != nil {
+			t.Fatalf("Could not encode value %#v; Error: %v", okRes, err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(fmt.Sprintf("/2010-04-01/Accounts/%s/Messages.json", accountSID), fn)
+
+	return httptest.NewServer(mux)
+}
+
+// NewSNSTestServer starts a new development server mimicking the sns
+// Publish API for the given access key id
+// Recipients starting with +999 are rejected as invalid phone numbers
+func NewSNSTestServer(t *testing.T, accessKeyID string) *httptest.Server {
+	t.Helper()
+
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		sendError := func(statusCode int, code, message string) {
+			w.Header().Set("Content-Type", "text/xml")
+			w.WriteHeader(statusCode)
+			fmt.Fprintf(w, `<ErrorResponse><Error><Type>Sender</Type><Code>%s</Code><Message>%s</Message></Error><RequestId>test</RequestId></ErrorResponse>`, code, message)
+		}
+
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, sigV4Algorithm+" Credential="+accessKeyID+"/") || r.Header.Get("X-Amz-Date") == "" {
+			sendError(http.StatusForbidden, "InvalidClientTokenId", "The security token included in the request is invalid.")
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("Could not parse incoming form request %#v; Error: %v", r, err)
+		}
+
+		if r.FormValue("Action") != "Publish" {
+			sendError(http.StatusBadRequest, "InvalidAction", "Could not find operation "+r.FormValue("Action"))
+			return
+		}
+
+		if strings.HasPrefix(r.FormValue("PhoneNumber"), "+999") {
+			sendError(http.StatusBadRequest, "InvalidParameter", "Invalid parameter: PhoneNumber Reason: "+r.FormValue("PhoneNumber")+" is not valid to publish to")
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprintf(w, `<PublishResponse><PublishResult><MessageId>%d</MessageId></PublishResult><ResponseMetadata><RequestId>test</RequestId></ResponseMetadata></PublishResponse>`, time.Now().UnixNano())
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", fn)
 
 	return httptest.NewServer(mux)
 }
+
+// SMPPTestServer mimics a SMSC accepting transceiver binds
+// for the given system id and password
+// Destinations starting with 999 are refused as invalid addresses
+type SMPPTestServer struct {
+	Addr     string
+	t        *testing.T
+	listener net.Listener
+	systemID string
+	password string
+
+	mu      sync.Mutex
+	conns   map[net.Conn]bool
+	seq     uint32
+	msgs    map[string]string
+	submits []string
+}
+
+// NewSMPPTestServer starts a new SMSC listening on the loopback interface
+func NewSMPPTestServer(t *testing.T, systemID, password string) *SMPPTestServer {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen for SMPP binds; Error: %v", err)
+	}
+
+	s := &SMPPTestServer{
+		Addr:     l.Addr().String(),
+		t:        t,
+		listener: l,
+		systemID: systemID,
+		password: password,
+		conns:    make(map[net.Conn]bool),
+		msgs:     make(map[string]string),
+	}
+	go s.accept()
+
+	return s
+}
+
+// Receipt sends a delivery receipt with the given final state,
+// such as DELIVRD or UNDELIV, for a submitted message to the binds
+func (s *SMPPTestServer) Receipt(id, stat string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	destination, ok := s.msgs[id]
+	if !ok {
+		s.t.Errorf("Could not send a receipt for unknown SMPP message %s", id)
+		return
+	}
+
+	text := fmt.Sprintf("id:%s sub:001 dlvrd:001 submit date:%s done date:%s stat:%s err:000 text:", id,
+		time.Now().UTC().Format("0601021504"), time.Now().UTC().Format("0601021504"), stat)
+
+	var w smppWriter
+	w.cstring("")
+	w.WriteByte(smppTONInternational)
+	w.WriteByte(smppNPIISDN)
+	w.cstring(destination)
+	w.WriteByte(smppTONAlphanumeric)
+	w.WriteByte(0)
+	w.cstring("")
+	w.WriteByte(smppESMReceipt)
+	w.Write(make([]byte, 2))
+	w.cstring("")
+	w.cstring("")
+	w.Write(make([]byte, 4))
+	w.WriteByte(byte(len(text)))
+	w.WriteString(text)
+
+	for conn := range s.conns {
+		s.seq++
+		conn.Write(smppPDU{command: smppDeliverSM, seq: s.seq, body: w.Bytes()}.encode())
+	}
+}
+
+// Submitted returns the short messages submitted so far, in order
+func (s *SMPPTestServer) Submitted() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.submits...)
+}
+
+// Drop closes the binds, as a SMSC going away would
+func (s *SMPPTestServer) Drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.conns {
+		conn.Close()
+		delete(s.conns, conn)
+	}
+}
+
+// Close stops listening and closes the binds
+func (s *SMPPTestServer) Close() {
+	s.listener.Close()
+	s.Drop()
+}
+
+func (s *SMPPTestServer) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.serve(conn)
+	}
+}
+
+// serve answers the commands of a bind until it is closed
+func (s *SMPPTestServer) serve(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	bound := false
+	for {
+		pdu, err := readSMPPPDU(conn)
+		if err != nil {
+			return
+		}
+
+		resp := smppPDU{command: pdu.command | smppGenericNack, seq: pdu.seq}
+		switch pdu.command {
+		case smppBindTransceiver:
+			r := &smppReader{b: pdu.body}
+			if r.cstring() != s.systemID || r.cstring() != s.password {
+				resp.status = smppStatusInvPaswd
+				conn.Write(resp.encode())
+				return
+			}
+			bound = true
+			s.mu.Lock()
+			s.conns[conn] = true
+			s.mu.Unlock()
+			resp.body = []byte("flysms\x00")
+		case smppSubmitSM:
+			m, err := parseSMPPMessage(pdu.body)
+			switch {
+			case !bound:
+				resp.status = smppStatusInvBindStatus
+			case err != nil:
+				resp.status = smppStatusInvMsgLen
+			case strings.HasPrefix(m.destination, "999"):
+				resp.status = smppStatusInvDstAdr
+			default:
+				s.mu.Lock()
+				id := fmt.Sprintf("smsc%d", len(s.msgs)+1)
+				s.msgs[id] = m.destination
+				s.submits = append(s.submits, string(m.shortMessage))
+				s.mu.Unlock()
+				resp.body = append([]byte(id), 0)
+			}
+		case smppUnbind:
+			conn.Write(resp.encode())
+			return
+		case smppEnquireLink:
+		case smppDeliverSMResp:
+			continue
+		default:
+			resp = smppPDU{command: smppGenericNack, status: smppStatusInvCmdID, seq: pdu.seq}
+		}
+
+		s.mu.Lock()
+		conn.Write(resp.encode())
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,50 @@
+package sms
+
+import "time"
+
+// Timeline is when a message went through the stages of the pipeline,
+// from its validation to the reply to the caller, the later stages in
+// milliseconds since the validation
+// It tells whether a slow send waited in the queue, for a worker
+// or for the provider
+type Timeline struct {
+	Validated           time.Time `json:"validated"`
+	EnqueuedMS          int64     `json:"enqueued_ms"`
+	DispatchedMS        int64     `json:"dispatched_ms"`
+	ProviderRespondedMS int64     `json:"provider_responded_ms"`
+	RepliedMS           int64     `json:"replied_ms"`
+}
+
+// timeline returns the timeline of the request replied at the time
+func (req *Request) timeline(replied time.Time) *Timeline {
+	since := func(t time.Time) int64 {
+		return int64(t.Sub(req.validated) / time.Millisecond)
+	}
+
+	return &Timeline{
+		Validated:           req.validated.UTC(),
+		EnqueuedMS:          since(req.queued),
+		DispatchedMS:        since(req.dispatched),
+		ProviderRespondedMS: since(req.responded),
+		RepliedMS:           since(replied),
+	}
+}
+
+// setTimeline keeps the timeline with the record of the message
+func (d *deliveryStore) setTimeline(id string, tl *Timeline) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if m, ok := d.msgs[id]; ok {
+		m.timeline = tl
+		d.msgs[id] = m
+	}
+}
+
+// timeline returns the timeline of the message, nil when unknown
+func (d *deliveryStore) timeline(id string) *Timeline {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.msgs[id].timeline
+}
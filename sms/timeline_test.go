@@ -0,0 +1,43 @@
+package sms_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/smstest"
+)
+
+func TestServer_messageTimeline(t *testing.T) {
+	srv := smstest.NewServer(t, sms.Config{})
+	validated := srv.Clock.Now()
+
+	w := srv.Send(t, `{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`)
+	var created sms.Response
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to decode json response body: %v", err)
+	}
+	if created.Data.Timeline != nil {
+		t.Errorf("Created message had timeline %+v; want none before the reply", created.Data.Timeline)
+	}
+
+	w = srv.Do(http.MethodGet, "/messages/"+created.Data.ID, "")
+	var res sms.Response
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatalf("Failed to decode json response body: %v", err)
+	}
+
+	// The message waited a throttling tick in the queue, and the
+	// fake provider answers at once
+	tl := res.Data.Timeline
+	if tl == nil {
+		t.Fatal("Message had no timeline")
+	}
+	tick := int64(time.Second / time.Millisecond)
+	if !tl.Validated.Equal(validated) || tl.EnqueuedMS != 0 || tl.DispatchedMS != tick ||
+		tl.ProviderRespondedMS != tick || tl.RepliedMS != tick {
+		t.Errorf("Timeline was %+v; want validated at %s and dispatched after %dms", *tl, validated, tick)
+	}
+}
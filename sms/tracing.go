@@ -0,0 +1,400 @@
+package sms
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of spans
+const (
+	SpanInternal = "internal"
+	SpanServer   = "server"
+	SpanClient   = "client"
+)
+
+const (
+	// spanBuffer is the number of finished spans waiting to be exported
+	// Spans are dropped rather than slowing down the traffic
+	spanBuffer = 1000
+	// spanBatch is the number of spans exported at most at once
+	spanBatch = 100
+	// traceService is the service name of the spans
+	traceService = "flysms"
+)
+
+// Span is a timed operation of a trace, such as handling a HTTP
+// request, waiting in the queue or calling the provider
+// IDs are hex encoded, the ParentID being empty for the root spans
+type Span struct {
+	TraceID    string
+	SpanID     string
+	ParentID   string
+	Name       string
+	Kind       string
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]string
+	Error      string
+}
+
+// SpanExporter ships the finished spans to a tracing backend
+// Spans are exported in batches from a single goroutine
+type SpanExporter interface {
+	ExportSpans(spans []Span) error
+}
+
+// spanContext identifies a span, and the trace it belongs to,
+// across the queue and over the wire
+type spanContext struct {
+	traceID string
+	spanID  string
+}
+
+func (sc spanContext) valid() bool {
+	return sc.traceID != ""
+}
+
+// traceparent renders the span context as a W3C traceparent header
+func (sc spanContext) traceparent() string {
+	return "00-" + sc.traceID + "-" + sc.spanID + "-01"
+}
+
+// parseTraceparent reads the span context of a W3C traceparent header,
+// which is not valid when the header is malformed
+func parseTraceparent(h string) spanContext {
+	if len(h) < 55 || h[2] != '-' || h[35] != '-' || h[52] != '-' || h[:2] == "ff" {
+		return spanContext{}
+	}
+	// Later versions may append fields, version 00 may not
+	if len(h) > 55 && (h[:2] == "00" || h[55] != '-') {
+		return spanContext{}
+	}
+
+	sc := spanContext{traceID: h[3:35], spanID: h[36:52]}
+	if !lowerHex(h[:2]) || !lowerHex(h[53:55]) || !validID(sc.traceID) || !validID(sc.spanID) {
+		return spanContext{}
+	}
+
+	return sc
+}
+
+// lowerHex reports whether the string is made of lower case hex digits
+func lowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if (s[i] < '0' || s[i] > '9') && (s[i] < 'a' || s[i] > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validID reports whether the id is hex encoded and not made of zeros
+func validID(id string) bool {
+	return lowerHex(id) && strings.Trim(id, "0") != ""
+}
+
+type spanKey struct{}
+
+// withSpan returns a context carrying the span context, if valid
+func withSpan(ctx context.Context, sc spanContext) context.Context {
+	if !sc.valid() {
+		return ctx
+	}
+
+	return context.WithValue(ctx, spanKey{}, sc)
+}
+
+// spanFromContext returns the span context carried by the context
+func spanFromContext(ctx context.Context) spanContext {
+	sc, _ := ctx.Value(spanKey{}).(spanContext)
+	return sc
+}
+
+// tracer records the spans of the server and hands them over
+// to the exporter
+// A nil tracer records nothing
+type tracer struct {
+	exporter SpanExporter
+	clock    Clock
+	spanCh   chan Span
+}
+
+func newTracer(exporter SpanExporter, clock Clock) *tracer {
+	if exporter == nil {
+		return nil
+	}
+
+	return &tracer{exporter: exporter, clock: clock, spanCh: make(chan Span, spanBuffer)}
+}
+
+// span is a span being recorded
+type span struct {
+	t *tracer
+	Span
+}
+
+// start begins a span of the trace of the parent, or of a new trace
+// when the parent is not valid
+func (t *tracer) start(parent spanContext, name, kind string) *span {
+	if t == nil {
+		return nil
+	}
+
+	s := &span{t: t, Span: Span{
+		TraceID:    parent.traceID,
+		SpanID:     randomHex(8),
+		ParentID:   parent.spanID,
+		Name:       name,
+		Kind:       kind,
+		Start:      t.clock.Now(),
+		Attributes: make(map[string]string),
+	}}
+	if s.TraceID == "" {
+		s.TraceID = randomHex(16)
+	}
+
+	return s
+}
+
+// context returns the span context of the span, not valid for a nil span
+func (s *span) context() spanContext {
+	if s == nil {
+		return spanContext{}
+	}
+
+	return spanContext{traceID: s.TraceID, spanID: s.SpanID}
+}
+
+// set adds an attribute to the span
+func (s *span) set(key, value string) {
+	if s == nil {
+		return
+	}
+	s.Attributes[key] = value
+}
+
+// fail marks the span as failed
+func (s *span) fail(err string) {
+	if s == nil {
+		return
+	}
+	s.Error = err
+}
+
+// end finishes the span and queues it for export
+func (s *span) end() {
+	if s == nil {
+		return
+	}
+	s.End = s.t.clock.Now()
+
+	select {
+	case s.t.spanCh <- s.Span:
+	default:
+		metrics.Add("spans_dropped", 1)
+	}
+}
+
+// run exports the finished spans, in batches of those waiting
+func (t *tracer) run() {
+	if t == nil {
+		return
+	}
+
+	for sp := range t.spanCh {
+		batch := []Span{sp}
+	drain:
+		for len(batch) < spanBatch {
+			select {
+			case sp := <-t.spanCh:
+				batch = append(batch, sp)
+			default:
+				break drain
+			}
+		}
+
+		if err := t.exporter.ExportSpans(batch); err != nil {
+			metrics.Add("span_export_errors", 1)
+			slog.Error("Could not export spans", "spans", len(batch), "error", err)
+			continue
+		}
+		metrics.Add("spans_exported", int64(len(batch)))
+	}
+}
+
+// traced records the requests of the handler as server spans, continuing
+// the trace of the traceparent header of the caller if there is one
+func (s *Server) traced(h http.HandlerFunc) http.HandlerFunc {
+	if s.tracer == nil {
+		return h
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		sp := s.tracer.start(parseTraceparent(r.Header.Get("traceparent")), r.Method+" "+r.URL.Path, SpanServer)
+		defer sp.end()
+		sp.set("http.method", r.Method)
+		sp.set("http.target", r.URL.Path)
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h(sw, r.WithContext(withSpan(r.Context(), sp.context())))
+
+		sp.set("http.status_code", strconv.Itoa(sw.status))
+		if sw.status >= http.StatusInternalServerError {
+			sp.fail(http.StatusText(sw.status))
+		}
+	}
+}
+
+// statusWriter remembers the status code of the response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// tracingTransport propagates the span of the requests whose context
+// carries one in their traceparent header
+type tracingTransport struct {
+	next http.RoundTripper
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	sc := spanFromContext(req.Context())
+	if !sc.valid() {
+		return t.next.RoundTrip(req)
+	}
+
+	// Round trippers must not modify the request they are given
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set("traceparent", sc.traceparent())
+
+	return t.next.RoundTrip(r)
+}
+
+// randomHex returns n random bytes, hex encoded
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("Could not generate random id; Error: %v", err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// otlpExporter posts the spans to an OpenTelemetry collector,
+// in the JSON encoding of OTLP over HTTP
+type otlpExporter struct {
+	url        string
+	httpClient *http.Client
+}
+
+// NewOTLPExporter creates an exporter posting the spans to the OTLP/HTTP
+// traces endpoint of an OpenTelemetry collector, such as
+// http://localhost:4318/v1/traces
+func NewOTLPExporter(url string, timeout time.Duration) SpanExporter {
+	return &otlpExporter{url: url, httpClient: &http.Client{Timeout: timeout}}
+}
+
+// OTLP encodings of the span kinds and of the error status
+var otlpKinds = map[string]int{SpanInternal: 1, SpanServer: 2, SpanClient: 3}
+
+const otlpStatusError = 2
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+func (e *otlpExporter) ExportSpans(spans []Span) error {
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, sp := range spans {
+		o := otlpSpan{
+			TraceID:           sp.TraceID,
+			SpanID:            sp.SpanID,
+			ParentSpanID:      sp.ParentID,
+			Name:              sp.Name,
+			Kind:              otlpKinds[sp.Kind],
+			StartTimeUnixNano: strconv.FormatInt(sp.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(sp.End.UnixNano(), 10),
+		}
+		for k, v := range sp.Attributes {
+			o.Attributes = append(o.Attributes, otlpAttribute{Key: k, Value: otlpValue{StringValue: v}})
+		}
+		if sp.Error != "" {
+			o.Status = otlpStatus{Code: otlpStatusError, Message: sp.Error}
+		}
+		encoded = append(encoded, o)
+	}
+
+	payload := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []otlpAttribute{{Key: "service.name", Value: otlpValue{StringValue: traceService}}},
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": traceService},
+				"spans": encoded,
+			}},
+		}},
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	res, err := e.httpClient.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("collector answered with status %d", res.StatusCode)
+	}
+
+	return nil
+}
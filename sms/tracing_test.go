@@ -0,0 +1,122 @@
+package sms_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/smstest"
+)
+
+// spanRecorder collects the exported spans
+type spanRecorder chan sms.Span
+
+func (r spanRecorder) ExportSpans(spans []sms.Span) error {
+	for _, sp := range spans {
+		r <- sp
+	}
+	return nil
+}
+
+func TestServer_tracing(t *testing.T) {
+	testServer := sms.NewTestServer(t, "server_key")
+	defer testServer.Close()
+
+	// The provider records the trace headers it is sent
+	traceparents := make(chan string, 1)
+	target, _ := url.Parse(testServer.URL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparents <- r.Header.Get("traceparent")
+		proxy.ServeHTTP(w, r)
+	}))
+	defer provider.Close()
+
+	spans := make(spanRecorder, 10)
+	srv := smstest.NewServer(t, sms.Config{
+		MessageClient: sms.NewClient(sms.Options{AccessKey: "server_key", BaseURL: provider.URL, Timeout: 10 * time.Second}),
+		SpanExporter:  spans,
+	})
+
+	const traceID, parentID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(`{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`))
+	r.Header.Set("traceparent", "00-"+traceID+"-"+parentID+"-01")
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		srv.ServeHTTP(w, r)
+		close(done)
+	}()
+
+	got := map[string]sms.Span{}
+	timeout := time.After(time.Second)
+	for len(got) < 3 {
+		select {
+		case sp := <-spans:
+			got[sp.Kind] = sp
+		case <-timeout:
+			t.Fatalf("Spans were %v; want a server, queue and provider span", got)
+		case <-time.After(20 * time.Millisecond):
+			srv.Clock.Advance(time.Second)
+		}
+	}
+	<-done
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Status code was %d; want %d", w.Code, http.StatusCreated)
+	}
+
+	server, queue, client := got[sms.SpanServer], got[sms.SpanInternal], got[sms.SpanClient]
+	for _, sp := range []sms.Span{server, queue, client} {
+		if sp.TraceID != traceID {
+			t.Errorf("Span %q was of trace %s; want %s", sp.Name, sp.TraceID, traceID)
+		}
+	}
+	if server.Name != "POST /messages" || server.ParentID != parentID || server.Attributes["http.status_code"] != "201" {
+		t.Errorf("Server span was %+v; want POST /messages answered 201, child of the caller", server)
+	}
+	if queue.Name != "queue" || queue.ParentID != server.SpanID {
+		t.Errorf("Queue span was %+v; want a child of the server span", queue)
+	}
+	if client.Name != "provider primary" || client.ParentID != server.SpanID || client.Error != "" {
+		t.Errorf("Provider span was %+v; want a successful child of the server span", client)
+	}
+
+	if tp, want := <-traceparents, "00-"+traceID+"-"+client.SpanID+"-01"; tp != want {
+		t.Errorf("Provider got traceparent %q; want %q", tp, want)
+	}
+}
+
+func TestServer_tracingProviderError(t *testing.T) {
+	spans := make(spanRecorder, 10)
+	srv := smstest.NewServer(t, sms.Config{
+		MessageClient: fakeSender{err: &sms.ContractError{
+			StatusCode: http.StatusOK,
+			Body:       []byte(`{"recipient":31612345678,"token":"SECRET"}`),
+			Reason:     "missing message id",
+		}},
+		SpanExporter: spans,
+	})
+
+	srv.Send(t, `{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`)
+
+	var client sms.Span
+	timeout := time.After(time.Second)
+	for client.Kind != sms.SpanClient {
+		select {
+		case client = <-spans:
+		case <-timeout:
+			t.Fatal("No provider span was exported")
+		case <-time.After(20 * time.Millisecond):
+			srv.Clock.Advance(time.Second)
+		}
+	}
+
+	if want := "contract violation: missing message id"; client.Error != want {
+		t.Errorf("Provider span error was %q; want %q", client.Error, want)
+	}
+}
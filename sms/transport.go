@@ -0,0 +1,334 @@
+package sms
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// newTransport builds the HTTP transport used to reach the provider
+// The exchanges are captured for the requests asking for it (see withCapture)
+func newTransport(opts Options) http.RoundTripper {
+	dialer := &net.Dialer{
+		Timeout:       opts.DialTimeout,
+		KeepAlive:     30 * time.Second,
+		FallbackDelay: opts.DialFallbackDelay,
+	}
+	if opts.LocalAddr != nil {
+		// Only the addresses of the same family as the local one can be dialled
+		dialer.LocalAddr = &net.TCPAddr{IP: opts.LocalAddr}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.ResponseHeaderTimeout = opts.HeaderTimeout
+	if opts.WarmConnections > http.DefaultMaxIdleConnsPerHost {
+		transport.MaxIdleConnsPerHost = opts.WarmConnections
+	}
+
+	// Custom dialers and TLS configurations turn HTTP/2 off unless forced,
+	// while an empty TLSNextProto keeps it off whatever the provider offers
+	transport.ForceAttemptHTTP2 = !opts.DisableHTTP2
+	if opts.DisableHTTP2 {
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+
+	if opts.RootCAs != nil || len(opts.PinnedKeys) > 0 {
+		transport.TLSClientConfig = &tls.Config{
+			RootCAs: opts.RootCAs,
+		}
+	}
+
+	if len(opts.PinnedKeys) > 0 {
+		transport.TLSClientConfig.VerifyPeerCertificate = verifyPinnedKeys(opts.PinnedKeys)
+	}
+
+	if opts.DNSCacheTTL > 0 {
+		clock := opts.Clock
+		if clock == nil {
+			clock = realClock{}
+		}
+		resolver := newCachingResolver(opts.DNSCacheTTL, net.DefaultResolver, clock)
+		transport.DialContext = resolver.dialContext(dialer.DialContext, opts.DialFallbackDelay)
+	}
+
+	// The headers are set before the capture so that it shows them
+	captured := &capturingTransport{next: &meteredTransport{next: transport}}
+	return &tracingTransport{next: newHeaderTransport(captured, opts)}
+}
+
+// meteredTransport counts the connections reused and opened to reach
+// the provider, and the requests by HTTP version, so that connection
+// churn shows in the metrics
+type meteredTransport struct {
+	next http.RoundTripper
+}
+
+func (t *meteredTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				metrics.Add("provider_conns_reused", 1)
+			} else {
+				metrics.Add("provider_conns_opened", 1)
+			}
+		},
+	}
+
+	res, err := t.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	if err != nil {
+		return nil, err
+	}
+	metrics.Add(fmt.Sprintf("provider_requests_http%d", res.ProtoMajor), 1)
+
+	return res, nil
+}
+
+// Stale DNS answers are used for that long at most after their TTL
+const maxDNSStaleness = time.Hour
+
+// defaultFallbackDelay is the head start given to every resolved address
+// when Options.DialFallbackDelay is not set, as net.Dialer does
+const defaultFallbackDelay = 300 * time.Millisecond
+
+// cachingResolver resolves host names and keeps the answers for a TTL
+// When a fresh lookup fails the last known answer is used instead for up
+// to maxDNSStaleness, so that a DNS hiccup does not fail every message
+// being sent
+type cachingResolver struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	resolver hostResolver
+	clock    Clock
+	entries  map[string]dnsEntry
+}
+
+// hostResolver looks up the addresses of a host, as net.Resolver does
+type hostResolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// dialFunc dials an address, as net.Dialer.DialContext does
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+type dnsEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+func newCachingResolver(ttl time.Duration, resolver hostResolver, clock Clock) *cachingResolver {
+	return &cachingResolver{
+		ttl:      ttl,
+		resolver: resolver,
+		clock:    clock,
+		entries:  make(map[string]dnsEntry),
+	}
+}
+
+// lookup returns the addresses of the host, from cache when possible
+func (r *cachingResolver) lookup(ctx context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	e, ok := r.entries[host]
+	r.mu.Unlock()
+
+	if ok && r.clock.Now().Before(e.expires) {
+		metrics.Add("dns_cache_hits", 1)
+		return e.addrs, nil
+	}
+
+	metrics.Add("dns_lookups", 1)
+	addrs, err := r.resolver.LookupHost(ctx, host)
+	if err != nil {
+		metrics.Add("dns_errors", 1)
+		if ok && r.clock.Now().Sub(e.expires) < maxDNSStaleness {
+			metrics.Add("dns_stale_answers", 1)
+			return e.addrs, nil
+		}
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.entries[host] = dnsEntry{addrs: addrs, expires: r.clock.Now().Add(r.ttl)}
+	r.mu.Unlock()
+
+	return addrs, nil
+}
+
+// dialContext dials the resolved addresses of the host, giving each
+// a head start of the fallback delay over the next (see dialFirst)
+func (r *cachingResolver) dialContext(dial dialFunc, delay time.Duration) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		if net.ParseIP(host) != nil {
+			return dial(ctx, network, addr)
+		}
+
+		addrs, err := r.lookup(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		return dialFirst(ctx, dial, network, port, addrs, delay)
+	}
+}
+
+type dialResult struct {
+	conn net.Conn
+	err  error
+}
+
+// dialFirst races the addresses as the dialer does with the IPv4 and IPv6
+// addresses of a host, and returns the first connection established
+// The next address is dialed when the previous ones did not connect within
+// the delay, or right away when they failed, so that a dead address does
+// not cost a whole dial timeout
+func dialFirst(ctx context.Context, dial dialFunc, network, port string, addrs []string, delay time.Duration) (net.Conn, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("no address to dial")
+	}
+	if delay <= 0 {
+		delay = defaultFallbackDelay
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan dialResult, len(addrs))
+	started := 0
+	start := func() {
+		a := net.JoinHostPort(addrs[started], port)
+		started++
+		go func() {
+			conn, err := dial(ctx, network, a)
+			results <- dialResult{conn: conn, err: err}
+		}()
+	}
+
+	// Every attempt gets a timer of its own, as resetting one that may
+	// have fired without being drained would start the next attempt early
+	timer := time.NewTimer(delay)
+	defer func() { timer.Stop() }()
+	start()
+
+	var err error
+	for failed := 0; failed < len(addrs); {
+		select {
+		case res := <-results:
+			if res.err == nil {
+				// Close the connections of the other dials still running
+				go func(pending int) {
+					for i := 0; i < pending; i++ {
+						if res := <-results; res.conn != nil {
+							res.conn.Close()
+						}
+					}
+				}(started - failed - 1)
+				return res.conn, nil
+			}
+			failed++
+			err = res.err
+		case <-timer.C:
+		}
+
+		if started < len(addrs) {
+			timer.Stop()
+			timer = time.NewTimer(delay)
+			start()
+		}
+	}
+
+	return nil, err
+}
+
+// InterfaceAddr returns the first address of the named network interface,
+// to be used as Options.LocalAddr when the egress path is chosen by interface
+// IPv4 addresses are preferred as they are the ones providers allowlist the most
+func InterfaceAddr(name string) (net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("Could not find network interface %s; Error: %v", name, err)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("Could not read the addresses of network interface %s; Error: %v", name, err)
+	}
+
+	var found net.IP
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipnet.IP.To4() != nil {
+			return ipnet.IP, nil
+		}
+		if found == nil {
+			found = ipnet.IP
+		}
+	}
+
+	if found == nil {
+		return nil, fmt.Errorf("Could not find any address on network interface %s", name)
+	}
+
+	return found, nil
+}
+
+// LoadCAFile reads a PEM encoded CA bundle to be used as Options.RootCAs
+func LoadCAFile(path string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read CA bundle %s; Error: %v", path, err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("Could not find any certificate in CA bundle %s", path)
+	}
+
+	return pool, nil
+}
+
+// SPKIHash returns the pin of a certificate public key
+// It is the base64 encoded SHA-256 hash of its SubjectPublicKeyInfo
+func SPKIHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// verifyPinnedKeys accepts a verified certificate chain only when
+// one of its certificates carries one of the pinned public keys
+func verifyPinnedKeys(pins []string) func([][]byte, [][]*x509.Certificate) error {
+	pinned := make(map[string]bool, len(pins))
+	for _, pin := range pins {
+		pinned[pin] = true
+	}
+
+	return func(_ [][]byte, chains [][]*x509.Certificate) error {
+		for _, chain := range chains {
+			for _, cert := range chain {
+				if pinned[SPKIHash(cert)] {
+					return nil
+				}
+			}
+		}
+
+		metrics.Add("tls_pin_failures", 1)
+		return errors.New("no pinned public key found in the provider certificate chain")
+	}
+}
@@ -0,0 +1,148 @@
+package sms_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/smstest"
+)
+
+func TestDialFirst(t *testing.T) {
+	const delay = 50 * time.Millisecond
+
+	tests := map[string]struct {
+		first    func(ctx context.Context) error
+		minDelay time.Duration
+		maxDelay time.Duration
+	}{
+		"Dead first address": {
+			// The first address never answers, as when its packets are dropped
+			first: func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			minDelay: delay,
+			maxDelay: 20 * delay,
+		},
+
+		"Refused first address": {
+			first: func(ctx context.Context) error {
+				return errors.New("connection refused")
+			},
+			maxDelay: delay,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var mu sync.Mutex
+			var dialed []string
+			dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+				mu.Lock()
+				dialed = append(dialed, addr)
+				mu.Unlock()
+
+				if addr == "192.0.2.1:443" {
+					return nil, tc.first(ctx)
+				}
+				client, server := net.Pipe()
+				server.Close()
+				return client, nil
+			}
+
+			start := time.Now()
+			conn, err := sms.DialFirst(context.Background(), dial, "tcp", "443", []string{"192.0.2.1", "192.0.2.2"}, delay)
+			elapsed := time.Since(start)
+			if err != nil {
+				t.Fatalf("Could not dial: %v", err)
+			}
+			conn.Close()
+
+			mu.Lock()
+			defer mu.Unlock()
+			if want := []string{"192.0.2.1:443", "192.0.2.2:443"}; !reflect.DeepEqual(dialed, want) {
+				t.Errorf("Dialed %v; want %v", dialed, want)
+			}
+			if elapsed < tc.minDelay || elapsed >= tc.maxDelay {
+				t.Errorf("Connected after %v; want between %v and %v", elapsed, tc.minDelay, tc.maxDelay)
+			}
+		})
+	}
+}
+
+func TestDialFirst_allFailed(t *testing.T) {
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, errors.New("connection refused")
+	}
+
+	_, err := sms.DialFirst(context.Background(), dial, "tcp", "443", []string{"192.0.2.1", "192.0.2.2"}, time.Minute)
+	if err == nil || err.Error() != "connection refused" {
+		t.Errorf("Got error %v; want connection refused", err)
+	}
+}
+
+func TestCachingResolver_staleAnswers(t *testing.T) {
+	const ttl = time.Minute
+	addrs := []string{"192.0.2.1"}
+
+	tests := map[string]struct {
+		age       time.Duration
+		wantAddrs []string
+	}{
+		"Fresh answer": {
+			age:       ttl / 2,
+			wantAddrs: addrs,
+		},
+
+		"Stale answer": {
+			age:       ttl + sms.MaxDNSStaleness/2,
+			wantAddrs: addrs,
+		},
+
+		"Too stale answer": {
+			age: ttl + sms.MaxDNSStaleness,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			clock := smstest.NewClock(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+
+			// Only the first lookup succeeds, as if DNS went down afterwards
+			lookups := 0
+			lookup := func(ctx context.Context, host string) ([]string, error) {
+				lookups++
+				if lookups > 1 {
+					return nil, errors.New("no such host")
+				}
+				return addrs, nil
+			}
+			r := sms.NewCachingResolver(ttl, lookup, clock)
+
+			if _, err := r.Lookup(context.Background(), "rest.messagebird.com"); err != nil {
+				t.Fatalf("Could not resolve: %v", err)
+			}
+			clock.Advance(tc.age)
+
+			got, err := r.Lookup(context.Background(), "rest.messagebird.com")
+			if tc.wantAddrs == nil {
+				if err == nil {
+					t.Errorf("Got addresses %v; want the lookup error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Could not resolve: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.wantAddrs) {
+				t.Errorf("Got addresses %v; want %v", got, tc.wantAddrs)
+			}
+		})
+	}
+}
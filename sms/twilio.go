@@ -0,0 +1,184 @@
+package sms
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const defaultTwilioBaseURL = "https://api.twilio.com"
+
+// twilio error codes refusing the recipient
+const (
+	twilioCodeInvalidTo   = 21211
+	twilioCodeUnreachable = 21614
+)
+
+// TwilioMessage is the API mapping for a message created through twilio
+type TwilioMessage struct {
+	SID         string `json:"sid"`
+	To          string `json:"to"`
+	From        string `json:"from"`
+	Body        string `json:"body"`
+	Status      string `json:"status"`
+	DateCreated string `json:"date_created"`
+}
+
+// TwilioError is the API response for a failed create message action
+type TwilioError struct {
+	Code     int    `json:"code"`
+	Message  string `json:"message"`
+	MoreInfo string `json:"more_info"`
+	Status   int    `json:"status"`
+}
+
+// TwilioClient sends requests to the twilio SMS API
+// The access key of the options is used as the twilio auth token
+// Compressed requests are not supported by twilio (see NewSender)
+type TwilioClient struct {
+	accountSID  string
+	authToken   string
+	baseURL     string
+	lenient     bool
+	maxBody     int64
+	bodyTimeout time.Duration
+	httpClient  *http.Client
+}
+
+// NewTwilioClient creates a new twilio client from the given options
+func NewTwilioClient(opts Options) *TwilioClient {
+	baseURL := opts.BaseURL
+	if baseURL == "" {
+		baseURL = defaultTwilioBaseURL
+	}
+
+	maxBody := opts.MaxResponseBytes
+	if maxBody <= 0 {
+		maxBody = defaultMaxResponseBytes
+	}
+
+	return &TwilioClient{
+		accountSID:  opts.AccountSID,
+		authToken:   opts.AccessKey,
+		baseURL:     strings.TrimSuffix(baseURL, "/"),
+		lenient:     opts.Lenient,
+		maxBody:     maxBody,
+		bodyTimeout: opts.BodyReadTimeout,
+		httpClient: &http.Client{
+			Timeout:   opts.Timeout,
+			Transport: newTransport(opts),
+		},
+	}
+}
+
+// Name identifies the provider of the client
+func (c *TwilioClient) Name() string {
+	return ProviderTwilio
+}
+
+// CreateMessage sends the message through twilio
+// The request is abandoned as soon as the given context is done
+func (c *TwilioClient) CreateMessage(ctx context.Context, r *Request) (Result, error) {
+	v := url.Values{}
+	v.Set("To", r.Recipient.e164())
+	v.Set("From", r.Originator)
+	v.Set("Body", r.Message)
+	if r.DeliverBy != nil {
+		if validity := time.Until(*r.DeliverBy) / time.Second; validity > 0 {
+			v.Set("ValidityPeriod", fmt.Sprintf("%d", validity))
+		}
+	}
+
+	endpoint := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json", c.baseURL, url.PathEscape(c.accountSID))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(v.Encode()))
+	if err != nil {
+		return Result{}, fmt.Errorf("Could not create POST request for url %s; Error: %v", endpoint, err)
+	}
+	req.SetBasicAuth(c.accountSID, c.authToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return Result{}, newRequestError(req, false, err)
+	}
+	defer res.Body.Close()
+
+	// The body gets its own deadline once the headers arrived
+	if c.bodyTimeout > 0 {
+		timer := time.AfterFunc(c.bodyTimeout, cancel)
+		defer timer.Stop()
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, c.maxBody+1))
+	if err != nil {
+		return Result{}, newRequestError(req, true, err)
+	}
+	if int64(len(body)) > c.maxBody {
+		return Result{}, &ContractError{StatusCode: res.StatusCode, Reason: fmt.Sprintf("response body is larger than %d bytes", c.maxBody)}
+	}
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		var terr TwilioError
+		if err := json.Unmarshal(body, &terr); err != nil || terr.Message == "" {
+			if !c.lenient {
+				return Result{}, &ContractError{StatusCode: res.StatusCode, Body: body, Reason: "error response has no message"}
+			}
+			terr.Message = fmt.Sprintf("Unknown provider error (status %d)", res.StatusCode)
+		}
+
+		me := MessageError{Code: terr.Code, Description: terr.Message}
+		if terr.Code == twilioCodeInvalidTo || terr.Code == twilioCodeUnreachable {
+			me.Parameter = "recipient"
+		}
+
+		return Result{}, &ProviderError{
+			Kind:       statusKind(res.StatusCode),
+			StatusCode: res.StatusCode,
+			Errors:     []MessageError{me},
+		}
+	}
+
+	var msg TwilioMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return Result{}, &ContractError{StatusCode: res.StatusCode, Body: body, Reason: fmt.Sprintf("invalid message JSON: %v", err)}
+	}
+	if msg.SID == "" {
+		return Result{}, &ContractError{StatusCode: res.StatusCode, Body: body, Reason: "created message has no sid"}
+	}
+	if msg.Status == "" {
+		if !c.lenient {
+			return Result{}, &ContractError{StatusCode: res.StatusCode, Body: body, Reason: "created message has no status"}
+		}
+		msg.Status = "unknown"
+	}
+
+	recipient := canonicalNumber(msg.To)
+	if !recipient.wellFormed() {
+		recipient = r.Recipient
+	}
+
+	created, err := time.Parse(time.RFC1123Z, msg.DateCreated)
+	if err != nil {
+		created = time.Now()
+	}
+
+	return Result{
+		StatusCode: res.StatusCode,
+		ID:         msg.SID,
+		Recipient:  recipient,
+		Originator: msg.From,
+		Message:    msg.Body,
+		Status:     msg.Status,
+		Created:    created,
+	}, nil
+}
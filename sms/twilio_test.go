@@ -0,0 +1,209 @@
+package sms_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+)
+
+func TestTwilioClient_CreateMessage(t *testing.T) {
+
+	testServer := sms.NewTwilioTestServer(t, "AC123", "auth_token")
+	defer testServer.Close()
+
+	tests := map[string]struct {
+		payload    string
+		authToken  string
+		wantStatus int
+		wantError  string
+	}{
+		"Created SMS": {
+			payload:    `{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`,
+			authToken:  "auth_token",
+			wantStatus: http.StatusCreated,
+		},
+
+		"Invalid auth token": {
+			payload:    `{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`,
+			authToken:  "fake_token",
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "Authenticate",
+		},
+
+		"Invalid recipient": {
+			payload:    `{"recipient":9991234567, "originator": "MessageBird", "message": "This is a test message"}`,
+			authToken:  "auth_token",
+			wantStatus: http.StatusBadRequest,
+			wantError:  "The 'To' number +9991234567 is not a valid phone number.",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			sender, err := sms.NewSender(sms.Options{
+				Provider:   sms.ProviderTwilio,
+				BaseURL:    testServer.URL,
+				AccountSID: "AC123",
+				AccessKey:  tc.authToken,
+				Timeout:    10 * time.Second,
+			})
+			if err != nil {
+				t.Fatalf("Could not create sender: %v", err)
+			}
+
+			srv := sms.NewServer(sms.Config{
+				Buffer:        10,
+				ReqTimeout:    5 * time.Second,
+				ThrottleRate:  10 * time.Millisecond,
+				MessageClient: sender,
+			})
+			srv.Run()
+
+			r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(tc.payload))
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			if w.Code != tc.wantStatus {
+				t.Errorf("Status code was %d; want %d", w.Code, tc.wantStatus)
+			}
+
+			var smsRes sms.Response
+			if err := json.NewDecoder(w.Body).Decode(&smsRes); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+			if smsRes.Error != tc.wantError {
+				t.Errorf("Error was %q; want %q", smsRes.Error, tc.wantError)
+			}
+			if tc.wantStatus == http.StatusCreated && smsRes.Data.Recipient != "31612345678" {
+				t.Errorf("Recipient was %s; want %s", smsRes.Data.Recipient, "31612345678")
+			}
+		})
+	}
+}
+
+func TestTwilioClient_options(t *testing.T) {
+	tests := map[string]struct {
+		handler    http.HandlerFunc
+		opts       sms.Options
+		wantStatus int
+		wantError  string
+	}{
+		"Error without message": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte(`{}`))
+			},
+			wantStatus: http.StatusBadGateway,
+			wantError:  "Bad gateway (provider contract violation)",
+		},
+
+		"Error without message in lenient mode": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte(`{}`))
+			},
+			opts:       sms.Options{Lenient: true},
+			wantStatus: http.StatusServiceUnavailable,
+			wantError:  "Unknown provider error (status 503)",
+		},
+
+		"Created message without status in lenient mode": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"sid":"SM1","to":"+31612345678","from":"MessageBird","body":"This is a test message"}`))
+			},
+			opts:       sms.Options{Lenient: true},
+			wantStatus: http.StatusCreated,
+		},
+
+		"Response body too slow": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"sid":`))
+				w.(http.Flusher).Flush()
+				select {
+				case <-time.After(3 * time.Second):
+				case <-r.Context().Done():
+				}
+			},
+			opts:       sms.Options{BodyReadTimeout: 200 * time.Millisecond},
+			wantStatus: http.StatusInternalServerError,
+			wantError:  "Internal error (API request failed)",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			provider := httptest.NewServer(tc.handler)
+			defer provider.Close()
+
+			tc.opts.Provider = sms.ProviderTwilio
+			tc.opts.BaseURL = provider.URL
+			tc.opts.AccountSID = "AC123"
+			tc.opts.Timeout = 10 * time.Second
+			sender, err := sms.NewSender(tc.opts)
+			if err != nil {
+				t.Fatalf("Could not create sender: %v", err)
+			}
+
+			srv := sms.NewServer(sms.Config{
+				Buffer:        10,
+				ReqTimeout:    5 * time.Second,
+				ThrottleRate:  10 * time.Millisecond,
+				MessageClient: sender,
+			})
+			srv.Run()
+
+			start := time.Now()
+			r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(`{"recipient":31612345678, "originator": "MessageBird", "message": "This is a test message"}`))
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			if w.Code != tc.wantStatus {
+				t.Errorf("Status code was %d; want %d", w.Code, tc.wantStatus)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("Request took %s; want less than 1s", elapsed)
+			}
+
+			var smsRes sms.Response
+			if err := json.NewDecoder(w.Body).Decode(&smsRes); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+			if smsRes.Error != tc.wantError {
+				t.Errorf("Error was %q; want %q", smsRes.Error, tc.wantError)
+			}
+		})
+	}
+}
+
+func TestNewSender(t *testing.T) {
+	tests := map[string]struct {
+		provider string
+		opts     sms.Options
+		wantErr  bool
+	}{
+		"Default provider":     {provider: ""},
+		"MessageBird provider": {provider: sms.ProviderMessageBird},
+		"Twilio provider":      {provider: sms.ProviderTwilio},
+		"Compressed twilio":    {provider: sms.ProviderTwilio, opts: sms.Options{Compress: true}, wantErr: true},
+		"SNS provider":         {provider: sms.ProviderSNS, opts: sms.Options{AccessKey: "AKID", SecretKey: "secret", Region: "eu-west-1"}},
+		"Unknown provider":     {provider: "carrier-pigeon", wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			opts := tc.opts
+			opts.Provider = tc.provider
+			_, err := sms.NewSender(opts)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("NewSender(%q) error = %v; want error %t", tc.provider, err, tc.wantErr)
+			}
+		})
+	}
+}
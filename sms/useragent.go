@@ -0,0 +1,114 @@
+package sms
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Version is the flysms release, sent to the providers in the User-Agent
+// It is set at build time with -ldflags "-X github.com/iulianclita/flysms/sms.Version=..."
+var Version = "dev"
+
+// DefaultUserAgent is the User-Agent of the provider requests unless
+// Options.UserAgent replaces it
+func DefaultUserAgent() string {
+	return "flysms/" + Version
+}
+
+// Headers of the provider responses reporting the API version in use
+// and its deprecation, such as Deprecation and Sunset of RFC 8594
+var (
+	apiVersionHeaders     = []string{"Api-Version", "X-Api-Version"}
+	apiDeprecationHeaders = []string{"Deprecation", "Sunset"}
+)
+
+// headerTransport sets the User-Agent and the API headers of the provider
+// on the outgoing requests, and reports the API version and deprecation
+// headers of the responses
+// Each API version is logged once so that it does not flood the logs,
+// the deprecations being kept as notices (see deprecationRegistry)
+type headerTransport struct {
+	next      http.RoundTripper
+	provider  string
+	userAgent string
+	headers   map[string]string
+
+	mu   sync.Mutex
+	seen map[string]string
+}
+
+func newHeaderTransport(next http.RoundTripper, opts Options) *headerTransport {
+	t := &headerTransport{
+		next:      next,
+		provider:  opts.Provider,
+		userAgent: opts.UserAgent,
+		headers:   opts.Headers,
+		seen:      make(map[string]string),
+	}
+	if t.provider == "" {
+		t.provider = ProviderMessageBird
+	}
+	if t.userAgent == "" {
+		t.userAgent = DefaultUserAgent()
+	}
+
+	return t
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// The request of the caller must not be modified
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	for name, value := range t.headers {
+		if req.Header.Get(name) == "" {
+			req.Header.Set(name, value)
+		}
+	}
+
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	t.observe(res)
+
+	return res, nil
+}
+
+// observe reports the API version and deprecation headers of a response,
+// and the endpoints gone from the provider
+func (t *headerTransport) observe(res *http.Response) {
+	for _, name := range apiVersionHeaders {
+		if v := res.Header.Get(name); v != "" {
+			if t.changed(name, v) {
+				slog.Info("Provider API version", "provider", t.provider, "header", name, "version", v)
+				metrics.Add("provider_api_version_changes", 1)
+			}
+			break
+		}
+	}
+
+	for _, name := range apiDeprecationHeaders {
+		if v := res.Header.Get(name); v != "" {
+			deprecations.record(t.provider, DeprecationHeader, name, v, time.Now())
+		}
+	}
+	if res.StatusCode == http.StatusGone {
+		deprecations.record(t.provider, DeprecationStatus, strconv.Itoa(res.StatusCode), res.Request.URL.Path, time.Now())
+	}
+}
+
+// changed records the value of the header, reporting whether it
+// differs from the last one seen
+func (t *headerTransport) changed(name, value string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.seen[name] == value {
+		return false
+	}
+	t.seen[name] = value
+
+	return true
+}
@@ -0,0 +1,92 @@
+package sms_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+	"github.com/iulianclita/flysms/sms/fixtures"
+)
+
+func TestClient_headers(t *testing.T) {
+	seen := make(chan http.Header, 1)
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- r.Header
+		w.Header().Set("Api-Version", "2")
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Sunset", "Wed, 01 Jan 2031 00:00:00 GMT")
+		fixtures.MessageCreated.ServeHTTP(w, r)
+	}))
+	defer testServer.Close()
+
+	tests := map[string]struct {
+		opts          sms.Options
+		wantUserAgent string
+		wantHeaders   map[string]string
+	}{
+		"Default user agent": {
+			wantUserAgent: "flysms/" + sms.Version,
+		},
+
+		"Configured user agent and API version": {
+			opts: sms.Options{
+				UserAgent: "acme-alerts/1.0",
+				Headers:   map[string]string{"Accept-Version": "v2"},
+			},
+			wantUserAgent: "acme-alerts/1.0",
+			wantHeaders:   map[string]string{"Accept-Version": "v2"},
+		},
+
+		"Headers of the client kept": {
+			opts: sms.Options{
+				Headers: map[string]string{"Authorization": "Bearer other"},
+			},
+			wantUserAgent: "flysms/" + sms.Version,
+			wantHeaders:   map[string]string{"Authorization": "AccessKey test_key"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			opts := tc.opts
+			opts.AccessKey = "test_key"
+			opts.BaseURL = testServer.URL
+			opts.Timeout = 10 * time.Second
+
+			srv := sms.NewServer(sms.Config{
+				Buffer:        10,
+				ReqTimeout:    5 * time.Second,
+				ThrottleRate:  50 * time.Millisecond,
+				MessageClient: sms.NewClient(opts),
+			})
+			srv.Run()
+
+			notices := counter("provider_deprecation_notices")
+			payload := fmt.Sprintf(`{"recipient":%d, "originator": %q, "message": "This is a test message"}`, fixtures.Recipient, fixtures.Originator)
+			r := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(payload))
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			if w.Code != http.StatusCreated {
+				t.Fatalf("Status code was %d; want %d", w.Code, http.StatusCreated)
+			}
+
+			h := <-seen
+			if got := h.Get("User-Agent"); got != tc.wantUserAgent {
+				t.Errorf("User-Agent was %q; want %q", got, tc.wantUserAgent)
+			}
+			for name, want := range tc.wantHeaders {
+				if got := h.Get(name); got != want {
+					t.Errorf("%s header was %q; want %q", name, got, want)
+				}
+			}
+			if got := counter("provider_deprecation_notices") - notices; got != 2 {
+				t.Errorf("Counted %d deprecation notices; want 2", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package sms
+
+import "context"
+
+// voiceSender is implemented by the senders able to deliver
+// messages as text-to-speech calls
+type voiceSender interface {
+	CreateVoiceMessage(ctx context.Context, r *Request) (Result, error)
+}
+
+// checkVoice validates the voice parameters of the request
+// and returns the reason they are invalid, if they are
+// Languages are lower case, as in en-gb
+func checkVoice(req *Request) string {
+	if req.Channel != channelVoice {
+		if req.Language != "" || req.Voice != "" {
+			return "language and voice values are only supported by voice messages"
+		}
+		return ""
+	}
+
+	if req.Voice != "" && req.Voice != "male" && req.Voice != "female" {
+		return "voice value is not supported"
+	}
+
+	if req.Language != "" && !validLanguage(req.Language) {
+		return "language value is not supported"
+	}
+
+	return ""
+}
+
+// validLanguage reports whether the language looks like en-gb
+func validLanguage(lang string) bool {
+	if len(lang) != 5 || lang[2] != '-' {
+		return false
+	}
+	for i, c := range lang {
+		if i != 2 && (c < 'a' || c > 'z') {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,119 @@
+package sms_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+)
+
+func TestServer_createVoiceMessage(t *testing.T) {
+	testServer := sms.NewTestServer(t, "test_key")
+	defer testServer.Close()
+
+	messageBird := sms.NewClient(sms.Options{AccessKey: "test_key", BaseURL: testServer.URL, Timeout: 10 * time.Second})
+
+	tests := map[string]struct {
+		sender     sms.MessageSender
+		path       string
+		message    string
+		params     string
+		wantStatus int
+		wantError  string
+	}{
+		"Voice message": {
+			sender:     messageBird,
+			path:       "/voice",
+			message:    "This is a test message",
+			params:     `, "language": "en-gb", "voice": "female"`,
+			wantStatus: http.StatusCreated,
+		},
+
+		"Voice message longer than a SMS": {
+			sender:     messageBird,
+			path:       "/voice",
+			message:    strings.Repeat("a", 500),
+			wantStatus: http.StatusCreated,
+		},
+
+		"Voice message too long": {
+			sender:     messageBird,
+			path:       "/voice",
+			message:    strings.Repeat("a", sms.MaxVoiceMessageLength+1),
+			wantStatus: http.StatusUnprocessableEntity,
+			wantError:  "Invalid parameter (message value is to long)",
+		},
+
+		"Unsupported voice": {
+			sender:     messageBird,
+			path:       "/voice",
+			message:    "This is a test message",
+			params:     `, "voice": "robot"`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantError:  "Invalid parameter (voice value is not supported)",
+		},
+
+		"Unsupported language": {
+			sender:     messageBird,
+			path:       "/voice",
+			message:    "This is a test message",
+			params:     `, "language": "English"`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantError:  "Invalid parameter (language value is not supported)",
+		},
+
+		"Voice parameters of a SMS": {
+			sender:     messageBird,
+			path:       "/messages",
+			message:    "This is a test message",
+			params:     `, "voice": "male"`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantError:  "Invalid parameter (language and voice values are only supported by voice messages)",
+		},
+
+		"Provider without voice": {
+			sender:     fakeSender{},
+			path:       "/voice",
+			message:    "This is a test message",
+			wantStatus: http.StatusNotImplemented,
+			wantError:  "Not implemented (provider does not support voice messages)",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := sms.NewServer(sms.Config{
+				Buffer:        10,
+				ReqTimeout:    5 * time.Second,
+				ThrottleRate:  10 * time.Millisecond,
+				MessageClient: tc.sender,
+			})
+			srv.Run()
+
+			payload := fmt.Sprintf(`{"recipient":31612345678, "originator": "MessageBird", "message": %q%s}`, tc.message, tc.params)
+			r := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(payload))
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			if w.Code != tc.wantStatus {
+				t.Fatalf("Status code was %d; want %d", w.Code, tc.wantStatus)
+			}
+
+			var smsRes sms.Response
+			if err := json.NewDecoder(w.Body).Decode(&smsRes); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+			if smsRes.Error != tc.wantError {
+				t.Errorf("Error was %q; want %q", smsRes.Error, tc.wantError)
+			}
+			if tc.wantStatus == http.StatusCreated && (smsRes.Data.Status != "calling" || smsRes.Data.Message != tc.message) {
+				t.Errorf("Message was %+v; want a call reading %q", smsRes.Data, tc.message)
+			}
+		})
+	}
+}
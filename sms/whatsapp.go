@@ -0,0 +1,98 @@
+package sms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const defaultConversationsURL = "https://conversations.messagebird.com/v1"
+
+// whatsAppSender is implemented by the senders able to deliver
+// messages over WhatsApp
+type whatsAppSender interface {
+	CreateWhatsAppMessage(ctx context.Context, r *Request) (Result, error)
+}
+
+// ConversationMessage is the API mapping for a message
+// sent through the messagebird conversations API
+type ConversationMessage struct {
+	To      string              `json:"to"`
+	From    string              `json:"from"`
+	Type    string              `json:"type"`
+	Content ConversationContent `json:"content"`
+}
+
+// ConversationContent is the content of a text conversation message
+type ConversationContent struct {
+	Text string `json:"text"`
+}
+
+// ConversationSent is the API mapping for a message accepted
+// by the conversations API
+type ConversationSent struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// CreateWhatsAppMessage sends the message over WhatsApp
+// through the messagebird conversations API
+func (c *Client) CreateWhatsAppMessage(ctx context.Context, r *Request) (Result, error) {
+	if c.waChannel == "" {
+		return Result{}, fmt.Errorf("No WhatsApp channel configured for the conversations API")
+	}
+
+	baseURL := c.convURL
+	if baseURL == "" {
+		baseURL = defaultConversationsURL
+	}
+	endpoint := strings.TrimSuffix(baseURL, "/") + "/send"
+
+	msg := ConversationMessage{
+		To:      r.Recipient.e164(),
+		From:    c.waChannel,
+		Type:    "text",
+		Content: ConversationContent{Text: r.Message},
+	}
+	payload, err := json.Marshal(&msg)
+	if err != nil {
+		return Result{}, fmt.Errorf("Could not encode conversation message %#v; Error: %v", msg, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return Result{}, fmt.Errorf("Could not create POST request for url %s; Error: %v", endpoint, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	statusCode, body, err := c.do(ctx, req)
+	if err != nil {
+		return Result{}, err
+	}
+
+	if statusCode < 200 || statusCode >= 300 {
+		return Result{}, c.decodeErrors(statusCode, body)
+	}
+
+	var sent ConversationSent
+	if err := json.Unmarshal(body, &sent); err != nil {
+		return Result{}, &ContractError{StatusCode: statusCode, Body: body, Reason: fmt.Sprintf("invalid conversation message JSON: %v", err)}
+	}
+	if sent.ID == "" {
+		return Result{}, &ContractError{StatusCode: statusCode, Body: body, Reason: "sent conversation message has no id"}
+	}
+
+	return Result{
+		StatusCode: statusCode,
+		ID:         sent.ID,
+		Recipient:  r.Recipient,
+		Originator: r.Originator,
+		Message:    r.Message,
+		Status:     sent.Status,
+		Created:    time.Now(),
+	}, nil
+}
@@ -0,0 +1,119 @@
+package sms_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iulianclita/flysms/sms"
+)
+
+func TestServer_createWhatsAppMessage(t *testing.T) {
+	testServer := sms.NewTestServer(t, "test_key")
+	defer testServer.Close()
+
+	conversations := sms.NewClient(sms.Options{
+		AccessKey:         "test_key",
+		BaseURL:           testServer.URL,
+		ConversationsURL:  testServer.URL + "/v1",
+		WhatsAppChannelID: "619747f69cf940a98fb443140ce9aed2",
+		Timeout:           10 * time.Second,
+	})
+
+	tests := map[string]struct {
+		sender     sms.MessageSender
+		path       string
+		channel    string
+		message    string
+		wantStatus int
+		wantError  string
+	}{
+		"WhatsApp message": {
+			sender:     conversations,
+			path:       "/messages",
+			channel:    "whatsapp",
+			message:    "This is a test message",
+			wantStatus: http.StatusAccepted,
+		},
+
+		"WhatsApp message longer than a SMS": {
+			sender:     conversations,
+			path:       "/messages",
+			channel:    "whatsapp",
+			message:    strings.Repeat("a", 1000),
+			wantStatus: http.StatusAccepted,
+		},
+
+		"No WhatsApp channel": {
+			sender:     sms.NewClient(sms.Options{AccessKey: "test_key", BaseURL: testServer.URL, Timeout: 10 * time.Second}),
+			path:       "/messages",
+			channel:    "whatsapp",
+			message:    "This is a test message",
+			wantStatus: http.StatusInternalServerError,
+			wantError:  "Internal error (API request failed)",
+		},
+
+		"Provider without WhatsApp": {
+			sender:     fakeSender{},
+			path:       "/messages",
+			channel:    "whatsapp",
+			message:    "This is a test message",
+			wantStatus: http.StatusNotImplemented,
+			wantError:  "Not implemented (provider does not support whatsapp messages)",
+		},
+
+		"Unknown channel": {
+			sender:     conversations,
+			path:       "/messages",
+			channel:    "pigeon",
+			message:    "This is a test message",
+			wantStatus: http.StatusUnprocessableEntity,
+			wantError:  "Invalid parameter (channel value is not supported)",
+		},
+
+		"Other channel than the endpoint": {
+			sender:     conversations,
+			path:       "/voice",
+			channel:    "whatsapp",
+			message:    "This is a test message",
+			wantStatus: http.StatusUnprocessableEntity,
+			wantError:  "Invalid parameter (channel value is not supported)",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := sms.NewServer(sms.Config{
+				Buffer:        10,
+				ReqTimeout:    5 * time.Second,
+				ThrottleRate:  10 * time.Millisecond,
+				MessageClient: tc.sender,
+			})
+			srv.Run()
+
+			payload := fmt.Sprintf(`{"recipient":31612345678, "originator": "MessageBird", "message": %q, "channel": %q}`, tc.message, tc.channel)
+			r := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(payload))
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			if w.Code != tc.wantStatus {
+				t.Fatalf("Status code was %d; want %d", w.Code, tc.wantStatus)
+			}
+
+			var smsRes sms.Response
+			if err := json.NewDecoder(w.Body).Decode(&smsRes); err != nil {
+				t.Fatalf("Failed to decode json response body: %v", err)
+			}
+			if smsRes.Error != tc.wantError {
+				t.Errorf("Error was %q; want %q", smsRes.Error, tc.wantError)
+			}
+			if tc.wantStatus == http.StatusAccepted && (smsRes.Data.ID == "" || smsRes.Data.Status != "accepted" || smsRes.Data.Message != tc.message) {
+				t.Errorf("Message was %+v; want %q accepted", smsRes.Data, tc.message)
+			}
+		})
+	}
+}
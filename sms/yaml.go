@@ -0,0 +1,479 @@
+package sms
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// unmarshalYAML decodes the YAML document into v, reading its fields by
+// their json tag as the JSON documents are
+// Only the subset of YAML needed by configuration files is understood:
+// block mappings and sequences, flow collections of scalars such as
+// [a, b] and {}, plain and quoted scalars, and comments
+// Anchors, tags, multi-line and block scalars are not
+func unmarshalYAML(data []byte, v interface{}) error {
+	lines, err := yamlLines(string(data))
+	if err != nil {
+		return err
+	}
+
+	node := &yamlNode{kind: yamlScalar, null: true}
+	if len(lines) > 0 {
+		p := &yamlParser{lines: lines}
+		if node, err = p.parseBlock(lines[0].indent); err != nil {
+			return err
+		}
+		if p.pos < len(lines) {
+			return fmt.Errorf("yaml: line %d: unexpected indentation", lines[p.pos].num)
+		}
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("yaml: cannot decode into %T", v)
+	}
+	return node.decode(rv.Elem())
+}
+
+type yamlKind int
+
+const (
+	yamlScalar yamlKind = iota
+	yamlMapping
+	yamlSequence
+)
+
+// yamlNode is a parsed YAML value
+// The mapping keys are kept in order of appearance
+type yamlNode struct {
+	kind   yamlKind
+	line   int
+	value  string
+	null   bool
+	keys   []string
+	values map[string]*yamlNode
+	items  []*yamlNode
+}
+
+// yamlLine is a line holding something other than a comment
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+// yamlLines splits the document into its lines, without the comments,
+// the blank lines and the document markers
+func yamlLines(doc string) ([]yamlLine, error) {
+	var lines []yamlLine
+	for i, text := range strings.Split(doc, "\n") {
+		text = strings.TrimRight(stripYAMLComment(text), " \r")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || trimmed == "---" || trimmed == "..." {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("yaml: line %d: tabs cannot be used for indentation", i+1)
+		}
+		lines = append(lines, yamlLine{num: i + 1, indent: len(text) - len(trimmed), text: trimmed})
+	}
+
+	return lines, nil
+}
+
+// stripYAMLComment cuts the line at the first # starting a comment,
+// that is one outside of quotes and at the start of the line or after a space
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+
+	return line
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// parseBlock parses the mapping or the sequence starting at the current line
+func (p *yamlParser) parseBlock(indent int) (*yamlNode, error) {
+	if isYAMLItem(p.lines[p.pos].text) {
+		return p.parseSequence(indent)
+	}
+	return p.parseMapping(indent)
+}
+
+func (p *yamlParser) parseSequence(indent int) (*yamlNode, error) {
+	node := &yamlNode{kind: yamlSequence, line: p.lines[p.pos].num}
+
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent || !isYAMLItem(l.text) {
+			return nil, fmt.Errorf("yaml: line %d: expected a sequence item", l.num)
+		}
+
+		rest := strings.TrimLeft(l.text[1:], " ")
+		var item *yamlNode
+		var err error
+		switch {
+		case rest == "":
+			p.pos++
+			item, err = p.parseNested(indent, l.num)
+		case isYAMLItem(rest) || isYAMLEntry(rest):
+			// The item is a block starting on the line of its dash, its
+			// column giving the indentation of the lines that follow
+			p.lines[p.pos] = yamlLine{num: l.num, indent: l.indent + len(l.text) - len(rest), text: rest}
+			item, err = p.parseBlock(p.lines[p.pos].indent)
+		default:
+			p.pos++
+			item, err = parseYAMLValue(rest, l.num)
+		}
+		if err != nil {
+			return nil, err
+		}
+		node.items = append(node.items, item)
+	}
+
+	return node, nil
+}
+
+func (p *yamlParser) parseMapping(indent int) (*yamlNode, error) {
+	node := &yamlNode{kind: yamlMapping, line: p.lines[p.pos].num, values: make(map[string]*yamlNode)}
+
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent {
+			return nil, fmt.Errorf("yaml: line %d: unexpected indentation", l.num)
+		}
+
+		key, rest, ok := splitYAMLEntry(l.text)
+		if !ok {
+			return nil, fmt.Errorf("yaml: line %d: expected a key: value entry", l.num)
+		}
+		k, err := parseYAMLValue(key, l.num)
+		if err != nil {
+			return nil, err
+		}
+		if k.kind != yamlScalar {
+			return nil, fmt.Errorf("yaml: line %d: keys must be scalars", l.num)
+		}
+		if _, ok := node.values[k.value]; ok {
+			return nil, fmt.Errorf("yaml: line %d: duplicate key %s", l.num, k.value)
+		}
+
+		p.pos++
+		var value *yamlNode
+		if rest == "" {
+			// A sequence may be written at the indentation of its key
+			if p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isYAMLItem(p.lines[p.pos].text) {
+				value, err = p.parseSequence(indent)
+			} else {
+				value, err = p.parseNested(indent, l.num)
+			}
+		} else {
+			value, err = parseYAMLValue(rest, l.num)
+		}
+		if err != nil {
+			return nil, err
+		}
+		node.keys = append(node.keys, k.value)
+		node.values[k.value] = value
+	}
+
+	return node, nil
+}
+
+// parseNested parses the block indented under the line, which is null
+// when there is none
+func (p *yamlParser) parseNested(indent, line int) (*yamlNode, error) {
+	if p.pos == len(p.lines) || p.lines[p.pos].indent <= indent {
+		return &yamlNode{kind: yamlScalar, line: line, null: true}, nil
+	}
+	return p.parseBlock(p.lines[p.pos].indent)
+}
+
+func isYAMLItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func isYAMLEntry(text string) bool {
+	_, _, ok := splitYAMLEntry(text)
+	return ok && text[0] != '[' && text[0] != '{'
+}
+
+// splitYAMLEntry splits key: value at the first colon outside of quotes
+// followed by a space or ending the line
+func splitYAMLEntry(text string) (key, value string, ok bool) {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && i == 0:
+			quote = c
+		case c == ':' && (i == len(text)-1 || text[i+1] == ' '):
+			return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), i > 0
+		}
+	}
+
+	return "", "", false
+}
+
+// parseYAMLValue parses a value written on a single line
+func parseYAMLValue(text string, line int) (*yamlNode, error) {
+	switch {
+	case strings.HasPrefix(text, "["):
+		if !strings.HasSuffix(text, "]") {
+			return nil, fmt.Errorf("yaml: line %d: unterminated flow sequence", line)
+		}
+		items, err := splitYAMLFlow(text[1:len(text)-1], line)
+		if err != nil {
+			return nil, err
+		}
+		node := &yamlNode{kind: yamlSequence, line: line}
+		for _, item := range items {
+			v, err := parseYAMLScalar(item, line)
+			if err != nil {
+				return nil, err
+			}
+			node.items = append(node.items, v)
+		}
+		return node, nil
+
+	case strings.HasPrefix(text, "{"):
+		if !strings.HasSuffix(text, "}") {
+			return nil, fmt.Errorf("yaml: line %d: unterminated flow mapping", line)
+		}
+		entries, err := splitYAMLFlow(text[1:len(text)-1], line)
+		if err != nil {
+			return nil, err
+		}
+		node := &yamlNode{kind: yamlMapping, line: line, values: make(map[string]*yamlNode)}
+		for _, entry := range entries {
+			key, value, ok := splitYAMLEntry(entry)
+			if !ok {
+				return nil, fmt.Errorf("yaml: line %d: expected a key: value entry", line)
+			}
+			k, err := parseYAMLScalar(key, line)
+			if err != nil {
+				return nil, err
+			}
+			v, err := parseYAMLScalar(value, line)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := node.values[k.value]; ok {
+				return nil, fmt.Errorf("yaml: line %d: duplicate key %s", line, k.value)
+			}
+			node.keys = append(node.keys, k.value)
+			node.values[k.value] = v
+		}
+		return node, nil
+	}
+
+	return parseYAMLScalar(text, line)
+}
+
+// splitYAMLFlow splits the inside of a flow collection at its commas
+func splitYAMLFlow(text string, line int) ([]string, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+
+	var parts []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			return nil, fmt.Errorf("yaml: line %d: nested flow collections are not supported", line)
+		case c == ',':
+			parts = append(parts, strings.TrimSpace(text[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(text[start:]); last != "" {
+		parts = append(parts, last)
+	}
+
+	return parts, nil
+}
+
+func parseYAMLScalar(text string, line int) (*yamlNode, error) {
+	node := &yamlNode{kind: yamlScalar, line: line}
+
+	switch {
+	case strings.HasPrefix(text, `"`):
+		v, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, fmt.Errorf("yaml: line %d: invalid quoted string %s", line, text)
+		}
+		node.value = v
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			return nil, fmt.Errorf("yaml: line %d: invalid quoted string %s", line, text)
+		}
+		node.value = strings.ReplaceAll(text[1:len(text)-1], "''", "'")
+	case text == "|" || text == ">" || strings.HasPrefix(text, "|") || strings.HasPrefix(text, ">"):
+		return nil, fmt.Errorf("yaml: line %d: block scalars are not supported", line)
+	case strings.HasPrefix(text, "&") || strings.HasPrefix(text, "*") || strings.HasPrefix(text, "!"):
+		return nil, fmt.Errorf("yaml: line %d: anchors, aliases and tags are not supported", line)
+	default:
+		node.value = text
+		node.null = text == "" || text == "~" || text == "null" || text == "Null" || text == "NULL"
+	}
+
+	return node, nil
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// decode stores the node into v, which must be settable
+// A null leaves v to its zero value, as JSON does
+func (n *yamlNode) decode(v reflect.Value) error {
+	if n.null {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return n.decode(v.Elem())
+	}
+
+	if n.kind == yamlScalar && reflect.PtrTo(v.Type()).Implements(textUnmarshalerType) {
+		if err := v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(n.value)); err != nil {
+			return fmt.Errorf("yaml: line %d: %v", n.line, err)
+		}
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		if n.kind != yamlScalar {
+			return n.mismatch(v)
+		}
+		v.SetString(n.value)
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(n.value)
+		if n.kind != yamlScalar || err != nil {
+			return n.mismatch(v)
+		}
+		v.SetBool(b)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(n.value, 10, v.Type().Bits())
+		if n.kind != yamlScalar || err != nil {
+			return n.mismatch(v)
+		}
+		v.SetInt(i)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(n.value, 10, v.Type().Bits())
+		if n.kind != yamlScalar || err != nil {
+			return n.mismatch(v)
+		}
+		v.SetUint(u)
+
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(n.value, v.Type().Bits())
+		if n.kind != yamlScalar || err != nil {
+			return n.mismatch(v)
+		}
+		v.SetFloat(f)
+
+	case reflect.Slice:
+		if n.kind != yamlSequence {
+			return n.mismatch(v)
+		}
+		s := reflect.MakeSlice(v.Type(), len(n.items), len(n.items))
+		for i, item := range n.items {
+			if err := item.decode(s.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+
+	case reflect.Map:
+		if n.kind != yamlMapping || v.Type().Key().Kind() != reflect.String {
+			return n.mismatch(v)
+		}
+		m := reflect.MakeMapWithSize(v.Type(), len(n.keys))
+		for _, key := range n.keys {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := n.values[key].decode(elem); err != nil {
+				return err
+			}
+			m.SetMapIndex(reflect.ValueOf(key).Convert(v.Type().Key()), elem)
+		}
+		v.Set(m)
+
+	case reflect.Struct:
+		if n.kind != yamlMapping {
+			return n.mismatch(v)
+		}
+		// The keys matching no field are ignored, as JSON does
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" || !field.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			if value, ok := n.values[name]; ok {
+				if err := value.decode(v.Field(i)); err != nil {
+					return err
+				}
+			}
+		}
+
+	default:
+		return n.mismatch(v)
+	}
+
+	return nil
+}
+
+func (n *yamlNode) mismatch(v reflect.Value) error {
+	what := map[yamlKind]string{yamlScalar: "value " + n.value, yamlMapping: "mapping", yamlSequence: "sequence"}[n.kind]
+	return fmt.Errorf("yaml: line %d: cannot decode %s into %s", n.line, what, v.Type())
+}